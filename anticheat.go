@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"meatgrinder/protocol"
+)
+
+const (
+	EventSpeedViolation = "speed_violation"
+	EventPlayerKicked   = "player_kicked"
+	EventInvalidMessage = "invalid_message"
+
+	// Допуск на погрешность float при нормализации на клиенте
+	DirectionTolerance = 0.01
+	// После стольких нарушений игрок отключается
+	MaxSpeedViolations = 10
+	// После стольких неразборчивых сообщений соединение закрывается
+	MaxInvalidMessages = 20
+)
+
+// Действия, которые клиент может прислать
+var actionTypes = map[string]bool{
+	"move":          true,
+	"move_to":       true,
+	"attack":        true,
+	"cancel_attack": true,
+	"sprint":        true,
+	"summon":        true,
+	"stealth":       true,
+}
+
+// validateAction отсеивает действия, которые applyAction не поймет.
+// Границы значений проверяются уже при применении.
+func validateAction(action PlayerAction) error {
+	if !actionTypes[action.ActionType] {
+		return fmt.Errorf("%w: unknown action %q", protocol.ErrMalformed, action.ActionType)
+	}
+	if action.AttackTarget < 0 {
+		return fmt.Errorf("%w: negative attack target %d", protocol.ErrMalformed, action.AttackTarget)
+	}
+	return nil
+}
+
+// invalidMessages считает сообщения соединения, которые сервер не понял
+type invalidMessages int
+
+// reject готовит ответ на непонятое сообщение. Второе значение true, если
+// их набралось слишком много и соединение пора закрыть.
+func (n *invalidMessages) reject(err error) (*protocol.Error, bool) {
+	*n++
+	metrics.Event(EventInvalidMessage)
+	return &protocol.Error{Code: protocol.ErrCodeInvalidMessage, Message: err.Error()}, *n >= MaxInvalidMessages
+}
+
+// validateDirection приводит присланное направление к длине не больше 1.
+// Второе значение false, если вектор был подозрительным: длиннее единицы
+// или вообще не число.
+func validateDirection(d Point) (Point, bool) {
+	if math.IsNaN(d.X) || math.IsNaN(d.Y) || math.IsInf(d.X, 0) || math.IsInf(d.Y, 0) {
+		return Point{}, false
+	}
+	length := math.Hypot(d.X, d.Y)
+	if length <= 1 {
+		return d, true
+	}
+	normalized := Point{X: d.X / length, Y: d.Y / length}
+	return normalized, length <= 1+DirectionTolerance
+}
+
+// maxStep - наибольшее расстояние, которое игрок может пройти за deltaTime
+func maxStep(player *PlayerState, deltaTime float64) float64 {
+	return balance().Classes[player.Class].MoveSpeed * moveSpeedMultiplier(player) * deltaTime
+}
+
+// checkDisplacement сверяет перемещение за тик со скоростью класса и
+// возвращает игрока на допустимое расстояние. Вызывается в горутине комнаты.
+func (r *Room) checkDisplacement(player *PlayerState, from Point, deltaTime float64) {
+	limit := maxStep(player, deltaTime) * (1 + DirectionTolerance)
+	dx, dy := player.Position.X-from.X, player.Position.Y-from.Y
+	dist := math.Hypot(dx, dy)
+	if dist <= limit {
+		return
+	}
+	player.Position = Point{X: from.X + dx/dist*limit, Y: from.Y + dy/dist*limit}
+	r.reportSpeedViolation(player, "displacement")
+}
+
+// rateLimited записывает, что игрок начал флудить. Записывается только
+// первое отброшенное сообщение за AbuseWindow: остальные считает метрика,
+// не занимая горутину комнаты.
+func (r *Room) rateLimited(playerID int) {
+	r.do(func() {
+		r.logEvent(r.clock.Now(), EventRateLimited, map[string]interface{}{
+			"player_id": playerID,
+		})
+	})
+}
+
+// kickFlooder записывает отключение игрока за флуд
+func (r *Room) kickFlooder(playerID int) {
+	r.do(func() {
+		r.logEvent(r.clock.Now(), EventPlayerKicked, map[string]interface{}{
+			"player_id": playerID,
+			"reason":    "flood",
+		})
+		r.log.Warn("Kicking player for flooding", "player_id", playerID)
+	})
+}
+
+// kickInvalid записывает отключение игрока, приславшего слишком много
+// непонятных сообщений
+func (r *Room) kickInvalid(playerID int) {
+	r.do(func() {
+		r.logEvent(r.clock.Now(), EventPlayerKicked, map[string]interface{}{
+			"player_id": playerID,
+			"reason":    "invalid_messages",
+		})
+		r.log.Warn("Kicking player for invalid messages", "player_id", playerID)
+	})
+}
+
+// reportSpeedViolation записывает нарушение и отключает игрока, если их
+// набралось слишком много. Боты не наказываются. Вызывается в горутине комнаты.
+func (r *Room) reportSpeedViolation(player *PlayerState, reason string) {
+	if player.Bot {
+		return
+	}
+	r.speedViolations[player.ID]++
+	count := r.speedViolations[player.ID]
+	r.logEvent(r.clock.Now(), EventSpeedViolation, map[string]interface{}{
+		"player_id": player.ID,
+		"reason":    reason,
+		"count":     count,
+	})
+	r.log.Warn("Speed violation", "player_id", player.ID, "reason", reason, "total", count)
+
+	if count < MaxSpeedViolations {
+		return
+	}
+	if client, ok := r.playerConnections[player.ID]; ok {
+		r.logEvent(r.clock.Now(), EventPlayerKicked, map[string]interface{}{
+			"player_id": player.ID,
+			"reason":    "speedhack",
+		})
+		r.log.Warn("Kicking player for speedhack", "player_id", player.ID)
+		// Игрока удалит горутина чтения, когда соединение закроется
+		client.Close()
+	}
+}
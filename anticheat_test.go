@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"meatgrinder/protocol"
+)
+
+// FuzzAction прогоняет данные сообщения action тем же путем, что и
+// serveClient: разбор, проверка и применение в комнате. Ни на одном шаге
+// не должно быть паники, а игрок должен остаться в пределах мира.
+func FuzzAction(f *testing.F) {
+	for _, seed := range []string{
+		`{"action_type":"move","direction":{"x":1,"y":0}}`,
+		`{"action_type":"move","direction":{"x":1e308,"y":-1e308}}`,
+		`{"action_type":"move_to","target":{"x":-1e308,"y":1e308}}`,
+		`{"action_type":"attack","attack_target":1}`,
+		`{"action_type":"attack","attack_target":-5}`,
+		`{"seq":18446744073709551615,"action_type":"sprint","sprint":true}`,
+		`{"action_type":"summon"}`,
+		`{"action_type":"stealth"}`,
+		`{"action_type":"cancel_attack"}`,
+		`{"action_type":"fly"}`,
+		`{"action_type":7}`,
+		`[]`,
+		`null`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var action PlayerAction
+		err := protocol.Message{Type: protocol.MsgAction, Data: data}.Decode(&action)
+		if err == nil {
+			err = validateAction(action)
+		}
+		if err != nil {
+			if !errors.Is(err, protocol.ErrMalformed) {
+				t.Fatalf("rejected with %v, want ErrMalformed", err)
+			}
+			return
+		}
+
+		r, clock := newTestRoom(testConfig())
+		id := r.spawnPlayer("fuzz")
+		r.enqueueAction(id, action)
+		advance(r, clock, 2)
+		player := r.worldState.Players[id]
+		pos := player.Position
+		if math.IsNaN(pos.X) || math.IsNaN(pos.Y) ||
+			pos.X < 0 || pos.Y < 0 || pos.X > r.cfg.WorldWidth || pos.Y > r.cfg.WorldHeight {
+			t.Fatalf("player moved to %v", pos)
+		}
+	})
+}
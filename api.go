@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Только для чтения: внешние панели, оверлеи турниров и чат-боты получают
+// состояние матчей по HTTP на -http-addr, не зная игрового протокола.
+//
+//	GET /api/rooms                - список комнат
+//	GET /api/rooms/{room}         - матч комнаты и игроки по очкам
+//	GET /api/rooms/{room}/events  - события лога, фильтры см. EventQuery
+//	GET /api/leaderboard          - таблица лидеров по профилям (-profiles)
+const (
+	DefaultAPIEventLimit = 100
+	MaxAPIEventLimit     = 1000
+)
+
+// APIMatch - матч комнаты для внешних инструментов
+type APIMatch struct {
+	Room      string      `json:"room"`
+	Tick      uint64      `json:"tick"`
+	Mode      string      `json:"mode"`
+	Phase     string      `json:"phase"`
+	Remaining float64     `json:"remaining"`
+	HUD       *ModeHUD    `json:"hud,omitempty"`
+	Players   []APIPlayer `json:"players"`
+}
+
+// APIPlayer - игрок без координат и прочего, что нужно только клиенту
+type APIPlayer struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Class      string  `json:"class"`
+	Team       int     `json:"team,omitempty"`
+	Score      float64 `json:"score"`
+	Kills      int     `json:"kills"`
+	Deaths     int     `json:"deaths"`
+	Level      int     `json:"level"`
+	Health     float64 `json:"health"`
+	Bot        bool    `json:"bot"`
+	Eliminated bool    `json:"eliminated,omitempty"`
+}
+
+func (r *Room) apiMatch() APIMatch {
+	var match APIMatch
+	r.do(func() {
+		match = APIMatch{
+			Room:      r.name,
+			Tick:      r.tick,
+			Mode:      r.mode.Name(),
+			Phase:     r.worldState.Match.Phase,
+			Remaining: r.worldState.Match.Remaining,
+			HUD:       r.worldState.Match.HUD,
+			Players:   make([]APIPlayer, 0, len(r.worldState.Players)),
+		}
+		for _, id := range sortedIDs(r.worldState.Players) {
+			p := r.worldState.Players[id]
+			match.Players = append(match.Players, APIPlayer{
+				ID:         p.ID,
+				Name:       p.Name,
+				Class:      ClassNames[p.Class],
+				Team:       p.Team,
+				Score:      p.Score,
+				Kills:      p.Kills,
+				Deaths:     p.Deaths,
+				Level:      p.Level,
+				Health:     p.Health,
+				Bot:        p.Bot,
+				Eliminated: p.Eliminated,
+			})
+		}
+		sort.SliceStable(match.Players, func(i, j int) bool {
+			return match.Players[i].Score > match.Players[j].Score
+		})
+	})
+	return match
+}
+
+// EventQuery - фильтр лога событий. Параметры запроса: since и until
+// (RFC 3339), player (ID участника события), type (типы через запятую), limit.
+type EventQuery struct {
+	Since    time.Time // Строго позже
+	Until    time.Time // Не позже, нулевое - без ограничения
+	PlayerID int       // 0 - любой игрок
+	Types    map[string]bool
+	Limit    int
+}
+
+// eventPlayerFields - поля события, в которых может быть игрок
+var eventPlayerFields = []string{"player_id", "attacker_id", "target_id", "killer_id"}
+
+// matches проверяет тип и игрока, время отсекает queryEvents
+func (q EventQuery) matches(entry LogEntry) bool {
+	if len(q.Types) > 0 && !q.Types[entry.EventType] {
+		return false
+	}
+	if q.PlayerID == 0 {
+		return true
+	}
+	for _, field := range eventPlayerFields {
+		if id, ok := entry.Data[field].(int); ok && id == q.PlayerID {
+			return true
+		}
+	}
+	return false
+}
+
+// queryEvents возвращает первые q.Limit подходящих записей лога
+func (r *Room) queryEvents(q EventQuery) []LogEntry {
+	events := make([]LogEntry, 0)
+	r.do(func() {
+		// Лог упорядочен по времени, ищем первую запись позже since
+		start := sort.Search(len(r.logEntries), func(i int) bool {
+			return r.logEntries[i].Timestamp.After(q.Since)
+		})
+		for _, entry := range r.logEntries[start:] {
+			if len(events) >= q.Limit || (!q.Until.IsZero() && entry.Timestamp.After(q.Until)) {
+				break
+			}
+			if q.matches(entry) {
+				events = append(events, entry)
+			}
+		}
+	})
+	return events
+}
+
+// parseEventQuery разбирает параметры запроса к логу
+func parseEventQuery(values url.Values) (EventQuery, error) {
+	q := EventQuery{Limit: DefaultAPIEventLimit}
+	var err error
+	if v := values.Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return q, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if v := values.Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return q, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	if v := values.Get("player"); v != "" {
+		if q.PlayerID, err = strconv.Atoi(v); err != nil || q.PlayerID <= 0 {
+			return q, fmt.Errorf("invalid player %q", v)
+		}
+	}
+	if v := values.Get("type"); v != "" {
+		q.Types = make(map[string]bool)
+		for _, eventType := range strings.Split(v, ",") {
+			q.Types[strings.TrimSpace(eventType)] = true
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > MaxAPIEventLimit {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return q, nil
+}
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/rooms", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.roomList())
+	})
+	mux.HandleFunc("GET /api/rooms/{room}", func(w http.ResponseWriter, r *http.Request) {
+		room, err := s.findRoom(r.PathValue("room"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, room.apiMatch())
+	})
+	mux.HandleFunc("GET /api/rooms/{room}/events", func(w http.ResponseWriter, r *http.Request) {
+		room, err := s.findRoom(r.PathValue("room"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		q, err := parseEventQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, room.queryEvents(q))
+	})
+	mux.HandleFunc("GET /api/leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		board, err := s.leaderboard()
+		if errors.Is(err, errProfilesDisabled) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, board)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
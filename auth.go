@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"meatgrinder/protocol"
+)
+
+// После стольких неверных паролей соединение закрывается
+const MaxAuthFailures = 3
+
+// Identity - кем сервер считает вошедшего игрока
+type Identity struct {
+	Name string
+}
+
+// Authenticator проверяет запрос на вход. Сюда же подключаются будущие
+// аккаунты: по req.Token можно найти игрока и вернуть его имя.
+type Authenticator interface {
+	Authenticate(req protocol.JoinRoom) (Identity, error)
+}
+
+// authenticate проверяет версию протокола клиента, а затем пропускает
+// запрос через Authenticator сервера
+func (s *Server) authenticate(req protocol.JoinRoom) (Identity, error) {
+	if err := protocol.CheckVersion(req.Version); err != nil {
+		var mismatch *protocol.Error
+		errors.As(err, &mismatch)
+		if s.cfg.DownloadURL != "" {
+			mismatch.Download = s.cfg.DownloadURL
+			mismatch.Message += ", download a new client at " + s.cfg.DownloadURL
+		}
+		return Identity{}, mismatch
+	}
+	return s.auth.Authenticate(req)
+}
+
+// passwordAuth пускает всех, кто знает общий пароль сервера. Пустой
+// пароль - сервер открыт. Токены пока не проверяются.
+type passwordAuth struct {
+	password string
+}
+
+func (a passwordAuth) Authenticate(req protocol.JoinRoom) (Identity, error) {
+	if a.password != "" && subtle.ConstantTimeCompare([]byte(req.Password), []byte(a.password)) != 1 {
+		if req.Password == "" {
+			return Identity{}, &protocol.Error{Code: protocol.ErrCodePasswordRequired, Message: "server requires a password"}
+		}
+		return Identity{}, &protocol.Error{Code: protocol.ErrCodeBadPassword, Message: "wrong password"}
+	}
+	return Identity{Name: req.Name}, nil
+}
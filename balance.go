@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"meatgrinder/protocol"
+)
+
+// ClassStat - характеристики класса
+type ClassStat struct {
+	MoveSpeed    float64 `json:"move_speed"`
+	AttackSpeed  float64 `json:"attack_speed"`
+	AttackDamage float64 `json:"attack_damage"`
+
+	Resource      string  `json:"resource"`       // Мана или выносливость
+	MaxResource   float64 `json:"max_resource"`   // Запас ресурса
+	ResourceRegen float64 `json:"resource_regen"` // Восстановление в секунду
+	AttackCost    float64 `json:"attack_cost"`    // Сколько ресурса тратит атака
+	SprintCost    float64 `json:"sprint_cost"`    // Сколько ресурса в секунду тратит рывок
+
+	Armor       float64 `json:"armor"`        // Защита от физического урона, см. ArmorScale
+	MagicResist float64 `json:"magic_resist"` // Защита от магического урона
+}
+
+// Balance - числа, которые можно поменять без пересборки. После создания не
+// меняется: перезагрузка подменяет его целиком, поэтому комнаты читают
+// его без блокировок.
+type Balance struct {
+	Classes  map[int]ClassStat
+	Attacks  map[int]AttackSpec
+	Bots     BotTuning
+	Humanize BotHumanize
+}
+
+var currentBalance atomic.Pointer[Balance]
+
+func init() {
+	currentBalance.Store(DefaultBalance())
+}
+
+// balance возвращает действующий баланс
+func balance() *Balance {
+	return currentBalance.Load()
+}
+
+// DefaultBalance - баланс, собранный в игру
+func DefaultBalance() *Balance {
+	b := &Balance{
+		Classes:  make(map[int]ClassStat, len(ClassStats)),
+		Attacks:  make(map[int]AttackSpec, len(ClassAttacks)),
+		Bots:     DefaultBotTuning,
+		Humanize: DefaultBotHumanize,
+	}
+	for class, stat := range ClassStats {
+		b.Classes[class] = stat
+	}
+	for class, spec := range ClassAttacks {
+		b.Attacks[class] = spec
+	}
+	return b
+}
+
+// balanceFile - формат файла баланса. Классы задаются по именам, все поля
+// необязательны: отсутствующие берутся из DefaultBalance.
+//
+//	{
+//	  "classes": {"Mage": {"attack_damage": 25, "armor": 20}},
+//	  "attacks": {"Warrior": {"range": 60, "armor_penetration": 0.5}},
+//	  "bots": {"aggro_radius": 300, "damage_weight": 2},
+//	  "humanize": {"names": false, "aim_error": 0}
+//	}
+type balanceFile struct {
+	Classes  map[string]json.RawMessage `json:"classes"`
+	Attacks  map[string]json.RawMessage `json:"attacks"`
+	Bots     json.RawMessage            `json:"bots"`
+	Humanize json.RawMessage            `json:"humanize"`
+	// Устарело: устойчивость классов теперь задают armor и magic_resist
+	ResistanceMultiplier *float64 `json:"resistance_multiplier"`
+}
+
+// LoadBalance читает файл баланса поверх значений по умолчанию
+func LoadBalance(path string) (*Balance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file balanceFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	b := DefaultBalance()
+	for name, raw := range file.Classes {
+		class, ok := classByName(name)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown class %q", path, name)
+		}
+		stat := b.Classes[class]
+		if err := json.Unmarshal(raw, &stat); err != nil {
+			return nil, fmt.Errorf("%s: class %s: %w", path, name, err)
+		}
+		if stat.MoveSpeed <= 0 || stat.AttackDamage < 0 || stat.MaxResource < 0 || stat.Armor < 0 || stat.MagicResist < 0 {
+			return nil, fmt.Errorf("%s: class %s: invalid stats", path, name)
+		}
+		b.Classes[class] = stat
+	}
+	for name, raw := range file.Attacks {
+		class, ok := classByName(name)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown class %q", path, name)
+		}
+		spec := b.Attacks[class]
+		if err := json.Unmarshal(raw, &spec); err != nil {
+			return nil, fmt.Errorf("%s: attack of %s: %w", path, name, err)
+		}
+		if spec.Range <= 0 || spec.SplashRadius < 0 || spec.ProjectileSpeed < 0 {
+			return nil, fmt.Errorf("%s: attack of %s: invalid range", path, name)
+		}
+		if spec.DamageType < PhysicalDamage || spec.DamageType > TrueDamage {
+			return nil, fmt.Errorf("%s: attack of %s: unknown damage type %d", path, name, spec.DamageType)
+		}
+		if spec.ArmorPenetration < 0 || spec.ArmorPenetration > 1 {
+			return nil, fmt.Errorf("%s: attack of %s: armor penetration must be between 0 and 1", path, name)
+		}
+		b.Attacks[class] = spec
+	}
+	if file.ResistanceMultiplier != nil {
+		return nil, fmt.Errorf("%s: resistance_multiplier is replaced by class armor and magic_resist", path)
+	}
+	if file.Bots != nil {
+		if err := json.Unmarshal(file.Bots, &b.Bots); err != nil {
+			return nil, fmt.Errorf("%s: bots: %w", path, err)
+		}
+		if err := b.Bots.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if file.Humanize != nil {
+		if err := json.Unmarshal(file.Humanize, &b.Humanize); err != nil {
+			return nil, fmt.Errorf("%s: humanize: %w", path, err)
+		}
+		if err := b.Humanize.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return b, nil
+}
+
+// rules - то, что из баланса нужно знать клиенту
+func (b *Balance) rules() protocol.Rules {
+	rules := protocol.Rules{
+		Classes: make(map[int]protocol.ClassRules, len(b.Classes)),
+	}
+	for class, stat := range b.Classes {
+		spec := b.Attacks[class]
+		rules.Classes[class] = protocol.ClassRules{
+			Name:             ClassNames[class],
+			MoveSpeed:        stat.MoveSpeed,
+			AttackSpeed:      stat.AttackSpeed,
+			AttackDamage:     stat.AttackDamage,
+			Resource:         stat.Resource,
+			MaxResource:      stat.MaxResource,
+			ResourceRegen:    stat.ResourceRegen,
+			AttackCost:       stat.AttackCost,
+			SprintCost:       stat.SprintCost,
+			Armor:            stat.Armor,
+			MagicResist:      stat.MagicResist,
+			Attack:           spec.Name,
+			AttackRange:      spec.Range,
+			SplashRadius:     spec.SplashRadius,
+			ProjectileSpeed:  spec.ProjectileSpeed,
+			DamageType:       spec.DamageType,
+			ArmorPenetration: spec.ArmorPenetration,
+		}
+	}
+	return rules
+}
+
+func classByName(name string) (int, bool) {
+	for class, className := range ClassNames {
+		if className == name {
+			return class, true
+		}
+	}
+	return 0, false
+}
+
+// reloadBalance перечитывает файл баланса. При ошибке остается прежний.
+func (s *Server) reloadBalance() error {
+	if s.cfg.BalancePath == "" {
+		return fmt.Errorf("no balance file configured")
+	}
+	b, err := LoadBalance(s.cfg.BalancePath)
+	if err != nil {
+		return err
+	}
+	currentBalance.Store(b)
+	simLog.Info("Balance loaded", "path", s.cfg.BalancePath)
+
+	// Уже подключенные клиенты получают новые правила сразу
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, room := range s.rooms {
+		room.pushRules(b.rules())
+	}
+	return nil
+}
+
+// watchBalanceReload перечитывает баланс по SIGHUP
+func (s *Server) watchBalanceReload() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := s.reloadBalance(); err != nil {
+			simLog.Error("Error reloading balance", "err", err)
+		}
+	}
+}
+
+// handleBalanceReload - POST /admin/reload-balance
+func (s *Server) handleBalanceReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminAuthorized(w, r) {
+		return
+	}
+	if err := s.reloadBalance(); err != nil {
+		simLog.Error("Error reloading balance", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Учет трафика и предел исходящего трафика на клиента (-client-bandwidth).
+// Каждое соединение считает отправленные и принятые байты; они видны в
+// метриках и по GET /admin/rooms/{room}/clients. Отправка клиенту тратит
+// его запас байт, который пополняется со скоростью предела и копится не
+// больше чем на секунду. Пока запас ушел в минус, снимки состояния этому
+// клиенту пропускаются, а события и изменения обзора идут как обычно:
+// снимки полные, так что следующий дошедший ничего не теряет. Он несет
+// число пропущенных в skipped, и клиент не считает их потерей. Снимок по
+// resync предел не проверяет, иначе отставший клиент не догнал бы мир.
+
+// bandwidthLimit - запас байт клиента. Тратится из горутины записи,
+// проверяется из горутины рассылки.
+type bandwidthLimit struct {
+	mu     sync.Mutex
+	rate   float64 // Байт в секунду
+	tokens float64
+	last   time.Time
+}
+
+// newBandwidthLimit возвращает nil, если предела нет. kib - КиБ в секунду.
+func newBandwidthLimit(kib int, now time.Time) *bandwidthLimit {
+	if kib <= 0 {
+		return nil
+	}
+	rate := float64(kib) * 1024
+	return &bandwidthLimit{rate: rate, tokens: rate, last: now}
+}
+
+// refill пополняет запас за прошедшее время. Вызывается под mu.
+func (l *bandwidthLimit) refill(now time.Time) {
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+func (l *bandwidthLimit) spend(bytes int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(now)
+	l.tokens -= float64(bytes)
+}
+
+// allow сообщает, что клиенту можно отправить еще один снимок
+func (l *bandwidthLimit) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(now)
+	return l.tokens >= 0
+}
+
+// ClientBandwidth - трафик одного клиента комнаты
+type ClientBandwidth struct {
+	PlayerID      int     `json:"player_id"`
+	Name          string  `json:"name"`
+	Connected     float64 `json:"connected_seconds"`
+	BytesSent     uint64  `json:"bytes_sent"`
+	BytesReceived uint64  `json:"bytes_received"`
+	SendRate      float64 `json:"send_rate"` // Байт в секунду в среднем за соединение
+	Throttled     uint64  `json:"snapshots_throttled"`
+	LimitKiB      int     `json:"limit_kib,omitempty"` // Предел, КиБ в секунду, 0 - нет
+	RTTMS         float64 `json:"rtt_ms"`
+}
+
+// clientBandwidth - трафик клиентов комнаты по ID
+func (r *Room) clientBandwidth() []ClientBandwidth {
+	now := time.Now()
+	var clients []ClientBandwidth
+	r.do(func() {
+		for _, id := range sortedIDs(r.playerConnections) {
+			client := r.playerConnections[id]
+			stat := ClientBandwidth{
+				PlayerID:      id,
+				Connected:     now.Sub(client.connectedAt).Seconds(),
+				BytesSent:     client.bytesSent.Load(),
+				BytesReceived: client.bytesReceived.Load(),
+				Throttled:     client.throttled.Load(),
+				RTTMS:         float64(client.latency().Microseconds()) / 1000,
+			}
+			if player, ok := r.worldState.Players[id]; ok {
+				stat.Name = player.Name
+			}
+			if stat.Connected > 0 {
+				stat.SendRate = float64(stat.BytesSent) / stat.Connected
+			}
+			if client.limit != nil {
+				stat.LimitKiB = r.cfg.ClientBandwidth
+			}
+			clients = append(clients, stat)
+		}
+	})
+	return clients
+}
+
+// handleClients - GET /admin/rooms/{room}/clients
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAuthorized(w, r) {
+		return
+	}
+	room, err := s.findRoom(r.PathValue("room"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	clients := room.clientBandwidth()
+	if clients == nil {
+		clients = []ClientBandwidth{}
+	}
+	writeJSON(w, clients)
+}
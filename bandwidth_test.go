@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// Клиент сверх предела трафика узнает о пропущенных снимках из skipped
+// следующего, а снимок по resync уходит мимо предела
+func TestThrottledSnapshotsSkipped(t *testing.T) {
+	r, _ := newTestRoom(testConfig())
+	snapshot, err := r.snapshotState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	client := &clientConnection{stateReady: make(chan struct{}, 1), limit: newBandwidthLimit(1, now)}
+	client.limit.spend(4096, now)
+	broadcast := func(frame stateFrame) *WorldState {
+		t.Helper()
+		frame.client = client
+		r.sendBroadcast(broadcastJob{snapshot: snapshot, frames: []stateFrame{frame}})
+		b := client.takeState()
+		if b == nil {
+			return nil
+		}
+		msg, err := protocol.NewDecoder(bytes.NewReader(b)).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state WorldState
+		if err := msg.Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		return &state
+	}
+
+	for i := 0; i < 2; i++ {
+		if broadcast(stateFrame{}) != nil {
+			t.Fatal("state sent over the bandwidth cap")
+		}
+	}
+	if state := broadcast(stateFrame{resync: true}); state == nil || state.Skipped != 2 {
+		t.Fatalf("resync state %+v", state)
+	}
+	if broadcast(stateFrame{}) != nil {
+		t.Fatal("state sent over the bandwidth cap")
+	}
+	client.limit.tokens = client.limit.rate
+	if state := broadcast(stateFrame{}); state == nil || state.Skipped != 1 {
+		t.Fatalf("state after the cap %+v", state)
+	}
+	if state := broadcast(stateFrame{}); state == nil || state.Skipped != 0 {
+		t.Fatalf("next state %+v", state)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	if newBandwidthLimit(0, time.Now()) != nil {
+		t.Fatal("limit without a cap")
+	}
+	start := time.Now()
+	l := newBandwidthLimit(1, start)
+	// Запас на секунду сразу после подключения
+	if !l.allow(start) {
+		t.Fatal("fresh limit does not allow a snapshot")
+	}
+	l.spend(1024, start)
+	if !l.allow(start) {
+		t.Fatal("spending exactly the reserve is over the cap")
+	}
+	l.spend(512, start)
+	if l.allow(start) {
+		t.Fatal("allowed after overspending")
+	}
+	// Минус 512 байт восполняется за полсекунды при 1 КиБ/с
+	if l.allow(start.Add(400 * time.Millisecond)) {
+		t.Fatal("allowed before the refill")
+	}
+	if !l.allow(start.Add(500 * time.Millisecond)) {
+		t.Fatal("not allowed after the refill")
+	}
+	// Запас копится не больше чем на секунду
+	l.allow(start.Add(time.Hour))
+	if l.tokens != l.rate {
+		t.Fatalf("reserve %v after an idle hour, want %v", l.tokens, l.rate)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// BotTuning - как боты выбирают цель. Задается в разделе "bots" файла
+// баланса.
+type BotTuning struct {
+	AggroRadius     float64 `json:"aggro_radius"`      // Дальше бот замечает только тех, кто его бил
+	DamageWeight    float64 `json:"damage_weight"`     // Угроза за единицу полученного урона
+	DistanceWeight  float64 `json:"distance_weight"`   // Прибавка вплотную, к краю радиуса падает до нуля
+	LowHealthWeight float64 `json:"low_health_weight"` // Прибавка за почти мертвую цель
+	ThreatDecay     float64 `json:"threat_decay"`      // Доля угрозы от урона, остающаяся через секунду
+	SwitchMargin    float64 `json:"switch_margin"`     // На сколько новая цель должна быть опаснее текущей
+}
+
+var DefaultBotTuning = BotTuning{
+	AggroRadius:     400,
+	DamageWeight:    1,
+	DistanceWeight:  20,
+	LowHealthWeight: 15,
+	ThreatDecay:     0.8,
+	SwitchMargin:    5,
+}
+
+// Угроза меньше этой забывается
+const MinThreat = 0.5
+
+// BotHumanize - чем боты похожи на живых игроков. Задается в разделе
+// "humanize" файла баланса, нулевое значение выключает свою часть.
+type BotHumanize struct {
+	Names         bool    `json:"names"`          // Имена вроде игровых ников вместо "Bot 5"
+	ReactionMin   float64 `json:"reaction_min"`   // Задержка реакции перед решением, секунды: от
+	ReactionMax   float64 `json:"reaction_max"`   // и до
+	AimError      float64 `json:"aim_error"`      // Наибольшее отклонение снаряда, радианы
+	TargetMistake float64 `json:"target_mistake"` // Вероятность выбрать не лучшую цель
+}
+
+var DefaultBotHumanize = BotHumanize{
+	Names:         true,
+	ReactionMin:   0.15,
+	ReactionMax:   0.45,
+	AimError:      0.15,
+	TargetMistake: 0.1,
+}
+
+func (h BotHumanize) validate() error {
+	if h.ReactionMin < 0 || h.ReactionMax < h.ReactionMin {
+		return fmt.Errorf("invalid bot reaction time %g-%g", h.ReactionMin, h.ReactionMax)
+	}
+	if h.AimError < 0 || h.AimError > math.Pi {
+		return fmt.Errorf("invalid bot aim error %g", h.AimError)
+	}
+	if h.TargetMistake < 0 || h.TargetMistake > 1 {
+		return fmt.Errorf("invalid bot target mistake %g", h.TargetMistake)
+	}
+	return nil
+}
+
+// botReaction - через сколько бот решит снова: обычный шаг решений плюс
+// случайная задержка реакции. Вызывается в горутине комнаты.
+func (r *Room) botReaction() time.Duration {
+	h := balance().Humanize
+	delay := 1.0/BotUpdateRate + h.ReactionMin + r.rng.Float64()*(h.ReactionMax-h.ReactionMin)
+	return time.Duration(delay * float64(time.Second))
+}
+
+// botAim отклоняет точку прицела бота на случайный угол до AimError.
+// Вызывается в горутине комнаты.
+func (r *Room) botAim(attacker *PlayerState, aim Point) Point {
+	maxError := balance().Humanize.AimError
+	if !attacker.Bot || maxError == 0 {
+		return aim
+	}
+	angle := (r.rng.Float64()*2 - 1) * maxError
+	dx, dy := aim.X-attacker.Position.X, aim.Y-attacker.Position.Y
+	sin, cos := math.Sincos(angle)
+	return Point{X: attacker.Position.X + dx*cos - dy*sin, Y: attacker.Position.Y + dx*sin + dy*cos}
+}
+
+func (t BotTuning) validate() error {
+	if t.AggroRadius <= 0 || t.DamageWeight < 0 || t.DistanceWeight < 0 || t.LowHealthWeight < 0 || t.SwitchMargin < 0 {
+		return fmt.Errorf("invalid bot tuning")
+	}
+	if t.ThreatDecay <= 0 || t.ThreatDecay > 1 {
+		return fmt.Errorf("invalid threat decay %g", t.ThreatDecay)
+	}
+	return nil
+}
+
+// Bot - состояние бота сверх игрока: кого он преследует и кто его бил.
+// Сам игрок бот не меняет: решения уходят действиями PlayerAction в ту же
+// очередь ввода, что у людей, и проходят те же проверки в applyAction.
+type Bot struct {
+	NextDecision time.Time
+	Brain        BotBrain
+	Focus        int             // Кого бот преследует, 0 - бродит
+	Approach     float64         // Бот сближается с Focus до этого расстояния, 0 - не сближается
+	Path         *botPath        // Путь в обход стен, nil - идет напрямую
+	Threat       map[int]float64 // ID игрока -> угроза от его урона
+}
+
+// BotBrain - поведение бота в бою. Угрозу и выбор цели updateBot считает
+// для всех ботов одинаково, а brain решает, как держаться у цели: задает
+// bot.Approach или направление движения и рывок.
+type BotBrain interface {
+	// Engage вызывается раз в 1/BotUpdateRate секунд, пока у бота есть
+	// цель на расстоянии dist. Вызывается в горутине комнаты.
+	Engage(r *Room, bot *Bot, player, target *PlayerState, dist float64)
+}
+
+// botBrains - поведение ботов по классам
+var botBrains = map[int]BotBrain{
+	WarriorClass: rushBrain{},
+	MageClass:    kiteBrain{},
+}
+
+const (
+	RushStickFactor   = 0.8  // Воин подходит к цели на эту долю радиуса атаки
+	RushSprintFactor  = 3    // Дальше стольких радиусов атаки воин бежит рывком
+	KiteKeepFactor    = 0.85 // Маг подходит к цели на эту долю радиуса атаки
+	KiteRetreatFactor = 0.5  // Подошедшего ближе этой доли маг отступает
+)
+
+// rushBrain - воин: рывком сокращает дистанцию и не отстает от цели
+type rushBrain struct{}
+
+func (rushBrain) Engage(r *Room, bot *Bot, player, target *PlayerState, dist float64) {
+	attackRange := balance().Attacks[player.Class].Range
+	bot.Approach = attackRange * RushStickFactor
+	r.botAct(player, PlayerAction{ActionType: "sprint", Sprint: dist > attackRange*RushSprintFactor})
+}
+
+// kiteBrain - маг: держится у края радиуса атаки и отступает, когда к нему
+// подходят. Мана нужна на атаки, поэтому без рывка.
+type kiteBrain struct{}
+
+func (kiteBrain) Engage(r *Room, bot *Bot, player, target *PlayerState, dist float64) {
+	attackRange := balance().Attacks[player.Class].Range
+	switch {
+	case dist > attackRange:
+		bot.Approach = attackRange * KiteKeepFactor
+	case dist < attackRange*KiteRetreatFactor:
+		away := direction(target.Position, player.Position)
+		retreat := Point{X: player.Position.X + away.X*attackRange, Y: player.Position.Y + away.Y*attackRange}
+		// В стену не пятимся, а уходим вбок
+		if away != (Point{}) && r.walkable(player.Position, retreat) && r.insideWorld(retreat) {
+			r.botMove(player, away)
+			return
+		}
+		strafe(r, player, target)
+	default:
+		strafe(r, player, target)
+	}
+}
+
+// strafe ведет бота боком к цели, чтобы он не стоял на месте
+func strafe(r *Room, player, target *PlayerState) {
+	to := direction(player.Position, target.Position)
+	side := 1.0
+	if r.rng.Float64() < 0.5 {
+		side = -1
+	}
+	r.botMove(player, Point{X: -to.Y * side, Y: to.X * side})
+}
+
+// botAct ставит действие бота в его очередь ввода. Вызывается в горутине
+// комнаты.
+func (r *Room) botAct(player *PlayerState, action PlayerAction) {
+	r.enqueueAction(player.ID, action)
+}
+
+// botMove - действие move бота
+func (r *Room) botMove(player *PlayerState, direction Point) {
+	r.botAct(player, PlayerAction{ActionType: "move", Direction: direction})
+}
+
+// updateBots ведет всех ботов комнаты. Вызывается в горутине комнаты до
+// применения ввода.
+func (r *Room) updateBots(deltaTime float64, now time.Time) {
+	combat := r.worldState.Match.Phase == MatchActive
+	for _, id := range sortedIDs(r.bots) {
+		player, ok := r.worldState.Players[id]
+		if !ok {
+			continue
+		}
+		r.updateBot(r.bots[id], player, deltaTime, now)
+		// Маг зовет прислужников, как только может
+		if combat && player.Target != 0 && player.Class == MageClass {
+			r.botAct(player, PlayerAction{ActionType: "summon"})
+		}
+	}
+}
+
+// addThreat запоминает, кто ранил бота. Вызывается в горутине комнаты.
+func (r *Room) addThreat(victim *PlayerState, attackerID int, damage float64) {
+	bot, ok := r.bots[victim.ID]
+	if !ok || attackerID == 0 || attackerID == victim.ID {
+		return
+	}
+	if bot.Threat == nil {
+		bot.Threat = make(map[int]float64)
+	}
+	bot.Threat[attackerID] += damage * balance().Bots.DamageWeight
+}
+
+// forgetThreat забывает погибшего: у погибшего бота таблица угрозы
+// очищается, а другие боты перестают мстить ему
+func (r *Room) forgetThreat(id int) {
+	if bot, ok := r.bots[id]; ok {
+		bot.Focus = 0
+		bot.Approach = 0
+		bot.Path = nil
+		bot.Threat = nil
+	}
+	for _, bot := range r.bots {
+		delete(bot.Threat, id)
+	}
+}
+
+// updateBot ведет бота: угроза, выбор цели и погоня. Вызывается в
+// горутине комнаты.
+func (r *Room) updateBot(bot *Bot, player *PlayerState, deltaTime float64, now time.Time) {
+	tuning := balance().Bots
+	decay := math.Pow(tuning.ThreatDecay, deltaTime)
+	for id, threat := range bot.Threat {
+		if threat *= decay; threat < MinThreat {
+			delete(bot.Threat, id)
+		} else {
+			bot.Threat[id] = threat
+		}
+	}
+
+	// Решение меняется с частотой BotUpdateRate и задержкой реакции, а
+	// бежит к цели бот каждый тик
+	if !now.Before(bot.NextDecision) {
+		bot.NextDecision = now.Add(r.botReaction())
+		r.decideBot(bot, player, tuning)
+	}
+	if bot.Approach <= 0 {
+		return
+	}
+	target, ok := r.worldState.Players[bot.Focus]
+	if !ok || target.Eliminated || hiddenFrom(player, target) {
+		return
+	}
+	if math.Hypot(target.Position.X-player.Position.X, target.Position.Y-player.Position.Y) > bot.Approach {
+		r.steerBot(bot, player, target.Position, now)
+	} else {
+		r.botMove(player, Point{})
+	}
+}
+
+// decideBot выбирает цель и передает ее brain, а без цели бот бродит.
+// С плагином все решает он.
+func (r *Room) decideBot(bot *Bot, player *PlayerState, tuning BotTuning) {
+	if r.cfg.BotPlugin.active() {
+		bot.Focus, bot.Approach = 0, 0
+		r.controlBot(bot, player)
+		return
+	}
+	focus := r.pickFocus(bot, player, tuning)
+	if focus != bot.Focus && focus != 0 {
+		r.botLog.Debug("Bot picked target", "player_id", player.ID, "target_id", focus, "threat", bot.Threat[focus])
+	}
+	bot.Focus = focus
+	bot.Approach = 0
+	r.botAct(player, PlayerAction{ActionType: "sprint", Sprint: false})
+	target, ok := r.worldState.Players[focus]
+	if !ok {
+		// Никого рядом, бродим
+		angle := r.rng.Float64() * 2 * math.Pi
+		r.botMove(player, Point{X: math.Cos(angle), Y: math.Sin(angle)})
+		return
+	}
+
+	dist := math.Hypot(target.Position.X-player.Position.X, target.Position.Y-player.Position.Y)
+	bot.Brain.Engage(r, bot, player, target, dist)
+	if withinLeash(player, target) {
+		r.botAct(player, PlayerAction{ActionType: "attack", AttackTarget: focus})
+	}
+}
+
+// pickFocus возвращает самого угрожающего противника. Текущая цель
+// меняется, только если другая опаснее на SwitchMargin, чтобы бот не
+// метался между равными.
+func (r *Room) pickFocus(bot *Bot, self *PlayerState, tuning BotTuning) int {
+	bestID, bestScore := 0, math.Inf(-1)
+	currentScore := math.Inf(-1)
+	var candidates []int
+	for _, id := range sortedIDs(r.worldState.Players) {
+		target := r.worldState.Players[id]
+		if id == self.ID || target.Eliminated || (target.Team != 0 && target.Team == self.Team) || hiddenFrom(self, target) {
+			continue
+		}
+		dist := math.Hypot(self.Position.X-target.Position.X, self.Position.Y-target.Position.Y)
+		threat := bot.Threat[id]
+		if dist > tuning.AggroRadius && threat == 0 {
+			continue
+		}
+		// Здоровье от 0 до 100; за радиусом прибавка за близость уходит в минус
+		score := threat +
+			tuning.DistanceWeight*(1-dist/tuning.AggroRadius) +
+			tuning.LowHealthWeight*(1-target.Health/PlayerMaxHealth)
+		candidates = append(candidates, id)
+		if id == bot.Focus {
+			currentScore = score
+		}
+		if score > bestScore {
+			bestID, bestScore = id, score
+		}
+	}
+	if bestID != bot.Focus && bestScore < currentScore+tuning.SwitchMargin {
+		return bot.Focus
+	}
+	// Как и человек, бот иногда бросается не на ту цель
+	if bestID != bot.Focus && len(candidates) > 1 && r.rng.Float64() < balance().Humanize.TargetMistake {
+		return candidates[r.rng.Intn(len(candidates))]
+	}
+	return bestID
+}
@@ -0,0 +1,69 @@
+// Package botapi - что видит и что может сделать бот, логика которого
+// подключена к серверу плагином.
+//
+// Плагин - пакет main, собранный с -buildmode=plugin той же версией Go и
+// с той же версией этого пакета, что и сервер. Он экспортирует функцию
+//
+//	func NewBrain() botapi.Brain
+//
+// которую сервер вызывает один раз при запуске с -bot-plugin.
+package botapi
+
+import "context"
+
+// Point - точка или направление в координатах мира
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Player - игрок глазами бота
+type Player struct {
+	ID       int
+	Name     string
+	Class    string // "Warrior" или "Mage"
+	Team     int    // 0 - каждый сам за себя
+	Bot      bool
+	Position Point
+	Health   float64 // От 0 до 100
+	Resource float64 // Мана или выносливость
+	Level    int
+	Target   int // Кого атакует, 0 - никого
+}
+
+// Rect - стена карты
+type Rect struct {
+	X, Y, Width, Height float64
+}
+
+// World - то, что бот знает в момент решения. Это копия: изменения в ней
+// на комнату не влияют.
+type World struct {
+	Tick        uint64
+	Width       float64
+	Height      float64
+	Self        Player
+	Enemies     []Player        // Живые противники по порядку ID
+	Allies      []Player        // Живые союзники, без самого бота
+	Obstacles   []Rect          // Стены, через них не пройти и не видно
+	Threat      map[int]float64 // ID игрока -> угроза от урона, который он нанес боту
+	AttackRange float64         // Радиус атаки бота
+}
+
+// Command - решение бота. Нулевая Command - стоять и не атаковать.
+type Command struct {
+	Move   Point  // Направление движения, длиннее 1 - нормализуется
+	MoveTo *Point // Идти в точку по прямой, важнее Move
+	Attack int    // ID цели, 0 - прекратить атаку
+	Sprint bool
+}
+
+// Brain - логика ботов. Decide вызывается для каждого бота несколько раз в
+// секунду и должна укладываться в лимит сервера (-bot-timeout): срок хода
+// стоит в ctx. Decide, который не вернулся к сроку, сервер считает
+// зависшим и больше плагин не вызывает, поэтому долгие расчеты должны
+// следить за ctx.Done() и бросать работу. Вызовы для разных ботов и комнат
+// могут идти одновременно.
+type Brain interface {
+	Decide(ctx context.Context, bot int, world World) Command
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"plugin"
+	"sync/atomic"
+	"time"
+
+	"meatgrinder/botapi"
+)
+
+// Сколько ходов подряд плагин может упасть, прежде чем боты вернутся к
+// встроенной логике. Ход, не уложившийся в лимит, отключает плагин сразу:
+// каждый такой ход оставляет висеть горутину.
+const BotPluginMaxFailures = 10
+
+const DefaultBotTimeout = 2 * time.Millisecond
+
+var errBotTimeout = errors.New("bot decision timed out")
+
+// botPlugin - логика ботов из Go-плагина (-bot-plugin), см. пакет botapi.
+// Плагин работает в процессе сервера: от паник и зависаний комнаты защищены
+// лимитом времени на ход, от намеренно вредного кода - нет.
+// Первое же зависание отключает плагин.
+type botPlugin struct {
+	path     string
+	brain    botapi.Brain
+	timeout  time.Duration
+	failures atomic.Int32 // Неудачи подряд во всех комнатах
+	disabled atomic.Bool
+}
+
+func loadBotPlugin(path string, timeout time.Duration) (*botPlugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("NewBrain")
+	if err != nil {
+		return nil, err
+	}
+	newBrain, ok := sym.(func() botapi.Brain)
+	if !ok {
+		return nil, fmt.Errorf("%s: NewBrain is %T, want func() botapi.Brain", path, sym)
+	}
+	brain := newBrain()
+	if brain == nil {
+		return nil, fmt.Errorf("%s: NewBrain returned nil", path)
+	}
+	return &botPlugin{path: path, brain: brain, timeout: timeout}, nil
+}
+
+// active сообщает, управляет ли плагин ботами
+func (p *botPlugin) active() bool {
+	return p != nil && !p.disabled.Load()
+}
+
+// decide вызывает плагин с лимитом времени. Срок хода передается плагину
+// в ctx. Зависший вызов бросается, его горутина закончится сама, когда
+// плагин заметит отмену или вернет управление.
+func (p *botPlugin) decide(id int, world botapi.World) (botapi.Command, error) {
+	type result struct {
+		cmd botapi.Command
+		err error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- result{err: fmt.Errorf("panic: %v", v)}
+			}
+		}()
+		done <- result{cmd: p.brain.Decide(ctx, id, world)}
+	}()
+	select {
+	case res := <-done:
+		return res.cmd, res.err
+	case <-ctx.Done():
+		return botapi.Command{}, errBotTimeout
+	}
+}
+
+// controlBot отдает ход бота плагину. Вызывается в горутине комнаты.
+func (r *Room) controlBot(bot *Bot, player *PlayerState) {
+	p := r.cfg.BotPlugin
+	cmd, err := p.decide(player.ID, r.botWorld(bot, player))
+	if errors.Is(err, errBotTimeout) {
+		if p.disabled.CompareAndSwap(false, true) {
+			r.botLog.Error("Bot plugin hung, switching to built-in bots", "path", p.path, "player_id", player.ID, "timeout", p.timeout)
+		}
+		return
+	}
+	if err != nil {
+		r.botLog.Warn("Bot plugin failed, turn skipped", "player_id", player.ID, "err", err)
+		if p.failures.Add(1) >= BotPluginMaxFailures && p.disabled.CompareAndSwap(false, true) {
+			r.botLog.Error("Bot plugin keeps failing, switching to built-in bots", "path", p.path)
+		}
+		return
+	}
+	p.failures.Store(0)
+
+	// Команда встает в очередь ввода и проходит те же проверки, что и
+	// действия игроков
+	r.botAct(player, PlayerAction{ActionType: "move", Direction: Point(cmd.Move)})
+	if cmd.MoveTo != nil {
+		r.botAct(player, PlayerAction{ActionType: "move_to", Target: Point(*cmd.MoveTo)})
+	}
+	if cmd.Attack != 0 {
+		r.botAct(player, PlayerAction{ActionType: "attack", AttackTarget: cmd.Attack})
+	} else {
+		r.botAct(player, PlayerAction{ActionType: "cancel_attack"})
+	}
+	r.botAct(player, PlayerAction{ActionType: "sprint", Sprint: cmd.Sprint})
+}
+
+// botWorld собирает копию мира для плагина. Вызывается в горутине комнаты.
+func (r *Room) botWorld(bot *Bot, self *PlayerState) botapi.World {
+	world := botapi.World{
+		Tick:        r.tick,
+		Width:       r.cfg.WorldWidth,
+		Height:      r.cfg.WorldHeight,
+		Self:        botPlayer(self),
+		Threat:      maps.Clone(bot.Threat),
+		AttackRange: balance().Attacks[self.Class].Range,
+	}
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		if id == self.ID || player.Eliminated || hiddenFrom(self, player) {
+			continue
+		}
+		if player.Team != 0 && player.Team == self.Team {
+			world.Allies = append(world.Allies, botPlayer(player))
+		} else {
+			world.Enemies = append(world.Enemies, botPlayer(player))
+		}
+	}
+	for _, obstacle := range r.obstacles() {
+		world.Obstacles = append(world.Obstacles, botapi.Rect(obstacle))
+	}
+	return world
+}
+
+func botPlayer(p *PlayerState) botapi.Player {
+	return botapi.Player{
+		ID:       p.ID,
+		Name:     p.Name,
+		Class:    ClassNames[p.Class],
+		Team:     p.Team,
+		Bot:      p.Bot,
+		Position: botapi.Point(p.Position),
+		Health:   p.Health,
+		Resource: p.Resource,
+		Level:    p.Level,
+		Target:   p.Target,
+	}
+}
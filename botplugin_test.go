@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"meatgrinder/botapi"
+)
+
+// hangingBrain не возвращается, пока ход не отменят
+type hangingBrain struct {
+	canceled chan struct{}
+}
+
+func (b hangingBrain) Decide(ctx context.Context, bot int, world botapi.World) botapi.Command {
+	<-ctx.Done()
+	close(b.canceled)
+	return botapi.Command{}
+}
+
+// panickingBrain падает на каждом ходу
+type panickingBrain struct{}
+
+func (panickingBrain) Decide(ctx context.Context, bot int, world botapi.World) botapi.Command {
+	panic("boom")
+}
+
+func pluginTestRoom() (*Room, *PlayerState) {
+	cfg := Config{WorldWidth: DefaultWorldWidth, WorldHeight: DefaultWorldHeight, TickRate: TickRate, Mode: GameModeFFA}
+	r := newRoom("plugin", cfg, &idAllocator{}, NewManualClock(time.Unix(0, 0)), newRNG(1))
+	player := &PlayerState{ID: 1, Name: "bot", Bot: true, Health: PlayerMaxHealth, Level: 1}
+	r.worldState.Players[player.ID] = player
+	r.bots[player.ID] = &Bot{}
+	return r, player
+}
+
+// Зависший ход отменяется через ctx и сразу отключает плагин
+func TestBotPluginHangDisables(t *testing.T) {
+	brain := hangingBrain{canceled: make(chan struct{})}
+	p := &botPlugin{path: "hang.so", brain: brain, timeout: time.Millisecond}
+	r, player := pluginTestRoom()
+	r.cfg.BotPlugin = p
+
+	r.controlBot(r.bots[player.ID], player)
+	if p.active() {
+		t.Fatal("plugin still active after a hung decision")
+	}
+	select {
+	case <-brain.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("hung decision did not see its context canceled")
+	}
+}
+
+// Паники прощаются, пока их меньше BotPluginMaxFailures подряд
+func TestBotPluginPanicsDisableAfterLimit(t *testing.T) {
+	p := &botPlugin{path: "panic.so", brain: panickingBrain{}, timeout: time.Second}
+	r, player := pluginTestRoom()
+	r.cfg.BotPlugin = p
+
+	for i := 1; i < BotPluginMaxFailures; i++ {
+		r.controlBot(r.bots[player.ID], player)
+		if !p.active() {
+			t.Fatalf("plugin disabled after %d panics", i)
+		}
+	}
+	r.controlBot(r.bots[player.ID], player)
+	if p.active() {
+		t.Fatalf("plugin still active after %d panics", BotPluginMaxFailures)
+	}
+}
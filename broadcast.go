@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// Сколько снимков может ждать горутину рассылки. Если она не успевает,
+// симуляция ждет ее, а не копит снимки.
+const BroadcastQueueSize = 2
+
+// stateSnapshot - состояние комнаты на момент рассылки, уже в JSON. Каждая
+// сущность кодируется один раз в горутине комнаты, а состояние для игрока
+// собирается из готовых кусков в горутине рассылки: симуляция не ждет
+// сериализации, а рассылка не видит мир посреди тика.
+type stateSnapshot struct {
+	base        map[string]json.RawMessage // Поля WorldState, кроме сущностей
+	players     map[int]json.RawMessage
+	concealed   map[int]json.RawMessage // Игроки с флагом concealed, только с -reveal-distance
+	items       map[int]json.RawMessage
+	projectiles map[int]json.RawMessage
+	minions     map[int]json.RawMessage
+}
+
+// stateView - что из снимка видит игрок. nil - все.
+type stateView struct {
+	players     []int
+	items       []int
+	projectiles []int
+	minions     []int
+	concealed   map[int]bool // Игроки, которые уходят с флагом concealed
+	visible     map[int]bool // Те же players для проверки событий
+}
+
+// sees сообщает, что игрок видит всех из ids
+func (v *stateView) sees(ids []int) bool {
+	for _, id := range ids {
+		if !v.visible[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// outboxMessage - событие тика. Событие, которое выдает, где стоят игроки
+// about, получают только те, кто всех их видит: иначе урон по невидимому
+// показал бы его место. Остальным уходит hidden, если он есть.
+type outboxMessage struct {
+	data   []byte
+	about  []int
+	hidden []byte // То же событие без позиций
+}
+
+// stateFrame - рассылка одному клиенту: сообщения об обзоре и его вид
+// на снимок
+type stateFrame struct {
+	client     *clientConnection
+	view       *stateView
+	visibility [][]byte
+	checksum   uint64 // Контрольная сумма вида, 0 - не в этой рассылке
+	resync     bool   // Полный снимок по запросу клиента, идет мимо предела трафика
+}
+
+// broadcastJob - снимок, события тика и кадры для клиентов. Не меняется
+// после передачи горутине рассылки.
+type broadcastJob struct {
+	snapshot *stateSnapshot
+	outbox   []outboxMessage
+	frames   []stateFrame
+}
+
+// queueBroadcast передает рассылку горутине рассылки. Вызывается в горутине
+// комнаты.
+func (r *Room) queueBroadcast(job broadcastJob) {
+	select {
+	case r.broadcasts <- job:
+	case <-r.stop:
+	}
+}
+
+// runBroadcasts собирает и рассылает сообщения по порядку снимков
+func (r *Room) runBroadcasts() {
+	for {
+		select {
+		case job := <-r.broadcasts:
+			r.sendBroadcast(job)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Room) sendBroadcast(job broadcastJob) {
+	// Без ограничения обзора состояние у всех одно и собирается один раз
+	var shared []byte
+	now := time.Now()
+	for _, frame := range job.frames {
+		// Клиенту сверх предела трафика уходят только события, а пропуск
+		// он узнает из skipped следующего снимка и не примет за потерю
+		if !frame.resync && !frame.client.allowState(now) {
+			frame.client.skippedStates++
+			frame.send(job.outbox, nil)
+			continue
+		}
+		skipped := frame.client.skippedStates
+		frame.client.skippedStates = 0
+		state := shared
+		if frame.view != nil || skipped != 0 || shared == nil {
+			var err error
+			if state, err = job.snapshot.encode(frame.view, frame.checksum, skipped); err != nil {
+				r.log.Error("Error encoding state", "err", err)
+				continue
+			}
+			if frame.view == nil && skipped == 0 {
+				shared = state
+			}
+		}
+		frame.send(job.outbox, state)
+	}
+}
+
+// snapshotState кодирует текущее состояние. Вызывается в горутине комнаты.
+func (r *Room) snapshotState() (*stateSnapshot, error) {
+	s := &stateSnapshot{}
+	var err error
+	if s.players, err = encodeEntities(r.worldState.Players); err != nil {
+		return nil, err
+	}
+	if r.cfg.RevealDistance > 0 {
+		concealed := make(map[int]PlayerState, len(r.worldState.Players))
+		for id, player := range r.worldState.Players {
+			concealed[id] = concealedState(player)
+		}
+		if s.concealed, err = encodeEntities(concealed); err != nil {
+			return nil, err
+		}
+	}
+	if s.items, err = encodeEntities(r.worldState.Items); err != nil {
+		return nil, err
+	}
+	if s.projectiles, err = encodeEntities(r.worldState.Projectiles); err != nil {
+		return nil, err
+	}
+	if s.minions, err = encodeEntities(r.worldState.Minions); err != nil {
+		return nil, err
+	}
+
+	// Остальные поля берем как есть, чтобы новые поля WorldState не
+	// приходилось перечислять здесь
+	rest := r.worldState
+	rest.Players, rest.Items, rest.Projectiles, rest.Minions = nil, nil, nil, nil
+	raw, err := json.Marshal(rest)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &s.base); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// concealedState - игрок для врага за -reveal-distance: только то, без чего
+// его не нарисовать. Имя, здоровье, уровень и остальное не уходят вовсе,
+// иначе их прочтет любой измененный клиент.
+func concealedState(player *PlayerState) PlayerState {
+	return PlayerState{
+		ID:              player.ID,
+		Class:           player.Class,
+		Position:        player.Position,
+		MovingDirection: player.MovingDirection,
+		Team:            player.Team,
+		Concealed:       true,
+	}
+}
+
+func encodeEntities[T any](entities map[int]T) (map[int]json.RawMessage, error) {
+	encoded := make(map[int]json.RawMessage, len(entities))
+	for id, entity := range entities {
+		raw, err := json.Marshal(entity)
+		if err != nil {
+			return nil, err
+		}
+		encoded[id] = raw
+	}
+	return encoded, nil
+}
+
+// encode собирает сообщение state для view. Вызывается в горутине рассылки.
+func (s *stateSnapshot) encode(view *stateView, checksum, skipped uint64) ([]byte, error) {
+	fields := make(map[string]json.RawMessage, len(s.base)+6)
+	for name, raw := range s.base {
+		fields[name] = raw
+	}
+	if checksum != 0 {
+		raw, err := json.Marshal(checksum)
+		if err != nil {
+			return nil, err
+		}
+		fields["checksum"] = raw
+	}
+	if skipped != 0 {
+		raw, err := json.Marshal(skipped)
+		if err != nil {
+			return nil, err
+		}
+		fields["skipped"] = raw
+	}
+	set := func(name string, entities map[int]json.RawMessage, ids []int, omitEmpty bool) error {
+		if view != nil {
+			visible := make(map[int]json.RawMessage, len(ids))
+			for _, id := range ids {
+				visible[id] = entities[id]
+			}
+			entities = visible
+		}
+		if omitEmpty && len(entities) == 0 {
+			delete(fields, name)
+			return nil
+		}
+		raw, err := json.Marshal(entities)
+		if err != nil {
+			return err
+		}
+		fields[name] = raw
+		return nil
+	}
+	var players, items, projectiles, minions []int
+	playerEntities := s.players
+	if view != nil {
+		players, items, projectiles, minions = view.players, view.items, view.projectiles, view.minions
+		if len(view.concealed) > 0 {
+			playerEntities = make(map[int]json.RawMessage, len(players))
+			for _, id := range players {
+				playerEntities[id] = s.players[id]
+				if view.concealed[id] {
+					playerEntities[id] = s.concealed[id]
+				}
+			}
+		}
+	}
+	if err := set("players", playerEntities, players, false); err != nil {
+		return nil, err
+	}
+	if err := set("items", s.items, items, false); err != nil {
+		return nil, err
+	}
+	if err := set("projectiles", s.projectiles, projectiles, true); err != nil {
+		return nil, err
+	}
+	if err := set("minions", s.minions, minions, true); err != nil {
+		return nil, err
+	}
+	return protocol.Marshal(protocol.MsgState, fields)
+}
+
+// send отправляет кадр клиенту: сначала события тика, которые ему видны,
+// затем изменения обзора, затем состояние, если оно есть
+func (f stateFrame) send(outbox []outboxMessage, state []byte) {
+	for _, msg := range outbox {
+		data := msg.data
+		if f.view != nil && !f.view.sees(msg.about) {
+			if msg.hidden == nil {
+				continue
+			}
+			data = msg.hidden
+		}
+		f.client.enqueue(data)
+	}
+	for _, msg := range f.visibility {
+		f.client.enqueue(msg)
+	}
+	if state != nil {
+		f.client.enqueueState(state)
+	}
+}
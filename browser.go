@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"time"
+
+	"meatgrinder/protocol"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const (
+	RoomListRefreshInterval = 3 * time.Second
+	roomListTop             = 80 // Координата Y первой строки списка
+	roomListRowHeight       = 20
+)
+
+// roomBrowser - экран выбора комнаты перед входом в игру
+type roomBrowser struct {
+	active      bool
+	rooms       []protocol.RoomInfo
+	selected    int
+	err         string
+	lastRefresh time.Time
+	queued      time.Time            // Когда встали в очередь подбора, ноль - не в очереди
+	queue       protocol.QueueStatus // Ответ сервера на вход в очередь
+	party       partyBrowser
+}
+
+func (g *Game) requestRoomList() {
+	g.browser.lastRefresh = time.Now()
+	if err := protocol.NewEncoder(g.clientConn).Encode(protocol.MsgListRooms, struct{}{}); err != nil {
+		clientLog.Error("Error requesting room list", "err", err)
+	}
+}
+
+func (g *Game) joinRoom(name string, create bool) {
+	joinType := protocol.MsgJoinRoom
+	if create {
+		joinType = protocol.MsgCreateRoom
+	}
+	req := protocol.JoinRoom{Room: name, Name: g.cfg.Name, Password: g.cfg.Password, Version: protocol.Version, ReconnectToken: g.reconnectToken}
+	if create {
+		req.WorldWidth, req.WorldHeight = g.cfg.RoomWidth, g.cfg.RoomHeight
+	}
+	if err := protocol.NewEncoder(g.clientConn).Encode(joinType, req); err != nil {
+		clientLog.Error("Error joining room", "err", err)
+	}
+}
+
+// updateRoomBrowser обрабатывает ввод на экране выбора комнаты. Вызывается в игровом цикле.
+func (g *Game) updateRoomBrowser() {
+	b := &g.browser
+	if time.Since(b.lastRefresh) >= RoomListRefreshInterval || inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		g.requestRoomList()
+	}
+	if g.updateParty() {
+		return
+	}
+	if inpututil.IsKeyJustPressed(QueueKey) {
+		g.toggleQueue()
+	}
+	if len(b.rooms) == 0 {
+		return
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		b.selected = (b.selected + len(b.rooms) - 1) % len(b.rooms)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		b.selected = (b.selected + 1) % len(b.rooms)
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		_, y := ebiten.CursorPosition()
+		row := (y - roomListTop) / roomListRowHeight
+		if y < roomListTop || row >= len(b.rooms) {
+			return
+		}
+		b.selected = row
+		g.joinRoom(b.rooms[row].Name, false)
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.joinRoom(b.rooms[b.selected].Name, false)
+	}
+}
+
+func (g *Game) drawRoomBrowser(screen *ebiten.Image) {
+	b := g.browser
+	drawCentered(screen, tr("SELECT A ROOM"), 20)
+	drawCentered(screen, tr("Up/Down - select, Enter/click - join, R - refresh, Q - find a match"), 40)
+	drawCentered(screen, tr("P - create/leave party, I - invite by name, J - join by code"), 55)
+	drawText(screen, fmt.Sprintf("%-24s %8s %5s %6s %7s %9s  %s", tr("Room"), tr("Players"), tr("Bots"), tr("Mode"), tr("Rating"), tr("Size"), tr("Phase")), 100, roomListTop-roomListRowHeight)
+
+	if len(b.rooms) == 0 {
+		drawText(screen, tr("Loading room list..."), 100, roomListTop)
+	}
+	for i, room := range b.rooms {
+		y := roomListTop + i*roomListRowHeight
+		if i == b.selected {
+			ebitenutil.DrawRect(screen, 95, float64(y), 580, roomListRowHeight, color.RGBA{255, 255, 255, 40})
+		}
+		rating := "-"
+		if room.Rating > 0 {
+			rating = strconv.Itoa(room.Rating)
+		}
+		size := "-"
+		if room.WorldWidth > 0 {
+			size = fmt.Sprintf("%gx%g", room.WorldWidth, room.WorldHeight)
+		}
+		line := fmt.Sprintf("%-24s %8d %5d %6s %7s %9s  %s", room.Name, room.Players, room.Bots, room.Mode, rating, size, room.Phase)
+		drawText(screen, line, 100, y+2)
+	}
+
+	g.drawParty(screen)
+	if !b.queued.IsZero() {
+		line := trf("Searching for a match: %.0f s", time.Since(b.queued).Seconds())
+		if b.queue.MatchSize > 0 {
+			line += trf(", %d/%d in queue, rating %d", b.queue.Queued, b.queue.MatchSize, b.queue.Rating)
+		}
+		drawText(screen, line, 100, ScreenHeight-60)
+	}
+	if b.err != "" {
+		drawText(screen, trf("Error: %s", b.err), 100, ScreenHeight-40)
+	}
+}
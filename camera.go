@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+const (
+	EdgeScrollMargin = 20    // Ширина полосы у края окна, которая двигает камеру
+	EdgeScrollSpeed  = 600.0 // Скорость прокрутки, пикселей в секунду
+	WorldGridStep    = 100   // Шаг сетки на полу, чтобы было видно движение
+)
+
+// camera - видимая часть мира. X, Y - мировые координаты левого верхнего угла окна.
+type camera struct {
+	X, Y float64
+}
+
+func (c camera) toScreen(p Point) Point {
+	return Point{X: p.X - c.X, Y: p.Y - c.Y}
+}
+
+func (c camera) toWorld(x, y int) Point {
+	return Point{X: float64(x) + c.X, Y: float64(y) + c.Y}
+}
+
+// clamp не дает камере уйти за край мира. Если мир меньше окна, он
+// выводится по центру.
+func (c *camera) clamp(worldWidth, worldHeight float64) {
+	clampAxis := func(v, world, screen float64) float64 {
+		if world <= screen {
+			return (world - screen) / 2
+		}
+		return math.Max(0, math.Min(v, world-screen))
+	}
+	c.X = clampAxis(c.X, worldWidth, ScreenWidth)
+	c.Y = clampAxis(c.Y, worldHeight, ScreenHeight)
+}
+
+// updateCamera держит своего игрока в центре экрана, а без игрока
+// (наблюдатель) двигает камеру курсором у края окна или стрелками.
+// Вызывается в игровом цикле.
+func (g *Game) updateCamera() {
+	if player, ok := g.worldState.Players[g.playerID]; ok {
+		pos := g.playerPositions[player.ID]
+		g.camera.X = pos.X - ScreenWidth/2
+		g.camera.Y = pos.Y - ScreenHeight/2
+	} else {
+		var scroll Point
+		x, y := ebiten.CursorPosition()
+		if x < EdgeScrollMargin || ebiten.IsKeyPressed(ebiten.KeyLeft) {
+			scroll.X -= 1
+		}
+		if x > ScreenWidth-EdgeScrollMargin || ebiten.IsKeyPressed(ebiten.KeyRight) {
+			scroll.X += 1
+		}
+		if y < EdgeScrollMargin || ebiten.IsKeyPressed(ebiten.KeyUp) {
+			scroll.Y -= 1
+		}
+		if y > ScreenHeight-EdgeScrollMargin || ebiten.IsKeyPressed(ebiten.KeyDown) {
+			scroll.Y += 1
+		}
+		dt := 1.0 / float64(ebiten.TPS())
+		g.camera.X += scroll.X * EdgeScrollSpeed * dt
+		g.camera.Y += scroll.Y * EdgeScrollSpeed * dt
+	}
+	g.camera.clamp(g.worldWidth, g.worldHeight)
+}
+
+// drawWorldBounds рисует сетку пола и границу мира
+func (g *Game) drawWorldBounds(screen *ebiten.Image) {
+	gridColor := color.RGBA{255, 255, 255, 16}
+	for x := 0.0; x <= g.worldWidth; x += WorldGridStep {
+		sx := x - g.camera.X
+		ebitenutil.DrawLine(screen, sx, -g.camera.Y, sx, g.worldHeight-g.camera.Y, gridColor)
+	}
+	for y := 0.0; y <= g.worldHeight; y += WorldGridStep {
+		sy := y - g.camera.Y
+		ebitenutil.DrawLine(screen, -g.camera.X, sy, g.worldWidth-g.camera.X, sy, gridColor)
+	}
+
+	borderColor := color.RGBA{200, 60, 60, 255}
+	left, top := -g.camera.X, -g.camera.Y
+	right, bottom := g.worldWidth-g.camera.X, g.worldHeight-g.camera.Y
+	ebitenutil.DrawLine(screen, left, top, right, top, borderColor)
+	ebitenutil.DrawLine(screen, right, top, right, bottom, borderColor)
+	ebitenutil.DrawLine(screen, right, bottom, left, bottom, borderColor)
+	ebitenutil.DrawLine(screen, left, bottom, left, top, borderColor)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Запись протокола для разбора багов (-capture-dir). Каждое соединение
+// пишется в свой файл: по строке на сообщение - время, направление (<
+// от клиента, > клиенту) и само сообщение как есть, без вырезаний. Когда
+// файл дорастает до CaptureFileSize, он становится .1 (прежний .1
+// пропадает) и запись начинается заново, так что на соединение уходит не
+// больше двух файлов, а в них - последние минуты перед рассинхронизацией
+// или падением.
+const (
+	CaptureFileSize = 16 << 20
+	CaptureIn       = '<'
+	CaptureOut      = '>'
+)
+
+// captureFile - кольцевой файл записи одного соединения. Читает и пишет
+// соединение из разных горутин, поэтому все под mu.
+type captureFile struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int
+	pending [2][]byte // Недописанные строки по направлениям: чтение и запись приходят кусками
+	failed  bool      // После ошибки записи файл больше не трогаем
+}
+
+// captureConn - соединение, которое пишет все, что через него прошло
+type captureConn struct {
+	net.Conn
+	capture *captureFile
+}
+
+// unreliableCaptureConn - то же для транспорта с отправкой без гарантии
+// доставки: обертка не должна прятать WriteUnreliable от writeState
+type unreliableCaptureConn struct {
+	captureConn
+}
+
+// capture оборачивает принятое соединение записью. Если файл не открылся,
+// соединение работает без записи. id - номер соединения с запуска сервера.
+func (s *Server) capture(conn net.Conn, id uint64) net.Conn {
+	now := time.Now().UTC()
+	path := filepath.Join(s.cfg.CaptureDir, fmt.Sprintf("%s-%d.log", now.Format("20060102-150405"), id))
+	capture, err := openCapture(path)
+	if err != nil {
+		netLog.Warn("Error opening protocol capture", "path", path, "err", err)
+		return conn
+	}
+	capture.line('#', []byte(fmt.Sprintf("remote=%s transport=%s", conn.RemoteAddr(), s.cfg.Transport)), now)
+	netLog.Debug("Capturing connection", "remote", conn.RemoteAddr().String(), "path", path)
+	c := captureConn{Conn: conn, capture: capture}
+	if _, ok := conn.(unreliableWriter); ok {
+		return &unreliableCaptureConn{c}
+	}
+	return &c
+}
+
+func openCapture(path string) (*captureFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &captureFile{path: path, file: file}, nil
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.capture.record(CaptureIn, b[:n])
+	return n, err
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.capture.record(CaptureOut, b[:n])
+	return n, err
+}
+
+func (c *captureConn) Close() error {
+	err := c.Conn.Close()
+	c.capture.close()
+	return err
+}
+
+func (c *unreliableCaptureConn) WriteUnreliable(b []byte) error {
+	err := c.Conn.(unreliableWriter).WriteUnreliable(b)
+	if err == nil {
+		c.capture.record(CaptureOut, b)
+	}
+	return err
+}
+
+// record дописывает прошедшие байты и пишет каждую законченную строку
+func (f *captureFile) record(dir byte, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return
+	}
+	i := 0
+	if dir == CaptureOut {
+		i = 1
+	}
+	now := time.Now().UTC()
+	f.pending[i] = append(f.pending[i], b...)
+	for {
+		end := bytes.IndexByte(f.pending[i], '\n')
+		if end < 0 {
+			break
+		}
+		f.line(dir, f.pending[i][:end], now)
+		f.pending[i] = f.pending[i][end+1:]
+	}
+	// Строку длиннее предела сообщения протокола собирать незачем
+	if len(f.pending[i]) > CaptureFileSize {
+		f.pending[i] = nil
+	}
+}
+
+// line пишет одну строку записи и при переполнении начинает файл заново.
+// Вызывается под mu, кроме заголовка в capture.
+func (f *captureFile) line(dir byte, msg []byte, now time.Time) {
+	if f.failed {
+		return
+	}
+	buf := make([]byte, 0, len(msg)+40)
+	buf = now.AppendFormat(buf, time.RFC3339Nano)
+	buf = append(buf, ' ', dir, ' ')
+	buf = append(buf, msg...)
+	buf = append(buf, '\n')
+	if f.size+len(buf) > CaptureFileSize && f.size > 0 {
+		f.rotate()
+	}
+	if f.failed {
+		return
+	}
+	n, err := f.file.Write(buf)
+	f.size += n
+	if err != nil {
+		f.fail(err)
+	}
+}
+
+// rotate переносит полный файл в .1 и открывает пустой
+func (f *captureFile) rotate() {
+	f.file.Close()
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		f.fail(err)
+		return
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		f.fail(err)
+		return
+	}
+	f.file, f.size = file, 0
+}
+
+func (f *captureFile) fail(err error) {
+	netLog.Warn("Protocol capture stopped", "path", f.path, "err", err)
+	f.failed = true
+}
+
+// close дописывает оборванные строки и закрывает файл. Соединение могут
+// закрыть несколько раз.
+func (f *captureFile) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return
+	}
+	now := time.Now().UTC()
+	for i, dir := range []byte{CaptureIn, CaptureOut} {
+		if len(f.pending[i]) > 0 {
+			f.line(dir, f.pending[i], now)
+		}
+	}
+	f.line('#', []byte("closed"), now)
+	f.file.Close()
+	f.file = nil
+}
@@ -0,0 +1,430 @@
+// Команда loadtest нагружает сервер безголовыми клиентами, которые говорят
+// на настоящем протоколе: бегают в случайных направлениях и бьют ближайших
+// соседей. Раз в -report печатает, как часто приходят снимки, сколько
+// трафика идет на клиента и, если задан -metrics, сколько сервер тратит
+// на тик.
+//
+//	go run ./cmd/loadtest -clients 100 -duration 1m -metrics http://localhost:9090/metrics
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// Поля снимка, нужные скриптованному клиенту
+type worldState struct {
+	Tick    uint64                  `json:"tick"`
+	Players map[string]*playerState `json:"players"`
+}
+
+type playerState struct {
+	ID         int            `json:"id"`
+	Class      int            `json:"class"`
+	Position   protocol.Point `json:"position"`
+	Target     int            `json:"target"`
+	Team       int            `json:"team"`
+	Eliminated bool           `json:"eliminated"`
+}
+
+// action повторяет PlayerAction сервера
+type action struct {
+	Seq          uint64         `json:"seq"`
+	ActionType   string         `json:"action_type"`
+	AttackTarget int            `json:"attack_target,omitempty"`
+	Direction    protocol.Point `json:"direction"`
+}
+
+type options struct {
+	addr       string
+	room       string
+	password   string
+	clients    int
+	ramp       time.Duration
+	duration   time.Duration
+	actionRate float64
+	report     time.Duration
+	metricsURL string
+}
+
+// stats - счетчики всех клиентов за окно отчета
+type stats struct {
+	mu        sync.Mutex
+	states    int
+	gaps      []time.Duration // Интервалы между снимками у каждого клиента
+	firstTick uint64
+	lastTick  uint64
+	actions   int
+	errors    int
+
+	connected atomic.Int64
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+}
+
+func (s *stats) state(tick uint64, gap time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states++
+	if gap > 0 {
+		s.gaps = append(s.gaps, gap)
+	}
+	if s.firstTick == 0 || tick < s.firstTick {
+		s.firstTick = tick
+	}
+	s.lastTick = max(s.lastTick, tick)
+}
+
+func (s *stats) action() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions++
+}
+
+func (s *stats) fail() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors++
+}
+
+// countingConn считает байты в обе стороны
+type countingConn struct {
+	net.Conn
+	stats *stats
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.addr, "addr", "localhost:8080", "server address (tcp transport)")
+	flag.StringVar(&opts.room, "room", "main", "room the clients join")
+	flag.StringVar(&opts.password, "password", "", "server password")
+	flag.IntVar(&opts.clients, "clients", 50, "number of simulated clients")
+	flag.DurationVar(&opts.ramp, "ramp", 5*time.Second, "time over which the clients connect")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "how long to run after the ramp (0 = until interrupted)")
+	flag.Float64Var(&opts.actionRate, "action-rate", 5, "actions per second sent by each client")
+	flag.DurationVar(&opts.report, "report", 5*time.Second, "report interval")
+	flag.StringVar(&opts.metricsURL, "metrics", "", "server /metrics URL for tick durations, e.g. http://localhost:9090/metrics")
+	flag.Parse()
+	if opts.clients <= 0 || opts.actionRate <= 0 || opts.report <= 0 {
+		log.Fatal("-clients, -action-rate and -report must be positive")
+	}
+
+	s := &stats{}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	go func() {
+		for i := 0; i < opts.clients; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(opts.ramp / time.Duration(opts.clients)):
+			}
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				runClient(n, opts, s, stop)
+			}(i)
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	var deadline <-chan time.Time
+	if opts.duration > 0 {
+		deadline = time.After(opts.ramp + opts.duration)
+	}
+	ticker := time.NewTicker(opts.report)
+	defer ticker.Stop()
+
+	r := newReporter(opts, s)
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+			r.print()
+		case <-deadline:
+			running = false
+		case <-interrupt:
+			running = false
+		}
+	}
+	close(stop)
+	r.print()
+	wg.Wait()
+}
+
+// runClient подключается и играет, пока не закроют stop
+func runClient(n int, opts options, s *stats, stop <-chan struct{}) {
+	raw, err := net.Dial("tcp", opts.addr)
+	if err != nil {
+		log.Printf("Client %d: %v", n, err)
+		s.fail()
+		return
+	}
+	conn := countingConn{Conn: raw, stats: s}
+	defer conn.Close()
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+
+	encoder := protocol.NewEncoder(conn)
+	name := fmt.Sprintf("load-%d", n)
+	if err := encoder.Encode(protocol.MsgJoinRoom, protocol.JoinRoom{Room: opts.room, Name: name, Password: opts.password, Version: protocol.Version}); err != nil {
+		s.fail()
+		return
+	}
+	decoder := protocol.NewDecoder(conn)
+	msg, err := decoder.Next()
+	if err != nil || msg.Type != protocol.MsgInit {
+		log.Printf("Client %d: no init: %v %s", n, err, msg.Data)
+		s.fail()
+		return
+	}
+	var init protocol.Init
+	if err := msg.Decode(&init); err != nil {
+		s.fail()
+		return
+	}
+	s.connected.Add(1)
+	defer s.connected.Add(-1)
+
+	c := &scriptedClient{id: init.PlayerID, rules: init.Rules, rng: rand.New(rand.NewSource(int64(n)))}
+	states := make(chan worldState, 1)
+	go func() {
+		defer close(states)
+		var last time.Time
+		for {
+			msg, err := decoder.Next()
+			if err != nil {
+				return
+			}
+			if msg.Type != protocol.MsgState {
+				continue
+			}
+			var state worldState
+			if err := msg.Decode(&state); err != nil {
+				continue
+			}
+			now := time.Now()
+			var gap time.Duration
+			if !last.IsZero() {
+				gap = now.Sub(last)
+			}
+			last = now
+			s.state(state.Tick, gap)
+			// Нужен только последний снимок
+			select {
+			case <-states:
+			default:
+			}
+			states <- state
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.actionRate))
+	defer ticker.Stop()
+	var state worldState
+	for {
+		select {
+		case next, ok := <-states:
+			if !ok {
+				select {
+				case <-stop:
+				default:
+					log.Printf("Client %d: disconnected", n)
+					s.fail()
+				}
+				return
+			}
+			state = next
+		case <-ticker.C:
+			a := c.decide(state)
+			if err := encoder.Encode(protocol.MsgAction, a); err != nil {
+				return
+			}
+			s.action()
+		}
+	}
+}
+
+// scriptedClient бьет ближайшего в радиусе атаки, иначе бегает
+type scriptedClient struct {
+	id        int
+	rules     protocol.Rules
+	rng       *rand.Rand
+	seq       uint64
+	direction protocol.Point
+	turnAt    time.Time
+}
+
+func (c *scriptedClient) decide(state worldState) action {
+	c.seq++
+	self := state.Players[strconv.Itoa(c.id)]
+	if self != nil && !self.Eliminated {
+		attackRange := c.rules.Classes[self.Class].AttackRange
+		target, dist := 0, math.Inf(1)
+		for _, other := range state.Players {
+			if other.ID == c.id || other.Eliminated || (other.Team != 0 && other.Team == self.Team) {
+				continue
+			}
+			if d := math.Hypot(other.Position.X-self.Position.X, other.Position.Y-self.Position.Y); d < dist {
+				target, dist = other.ID, d
+			}
+		}
+		if target != 0 && dist <= attackRange && self.Target != target {
+			return action{Seq: c.seq, ActionType: "attack", AttackTarget: target}
+		}
+	}
+	if now := time.Now(); now.After(c.turnAt) {
+		angle := c.rng.Float64() * 2 * math.Pi
+		c.direction = protocol.Point{X: math.Cos(angle), Y: math.Sin(angle)}
+		c.turnAt = now.Add(time.Second + time.Duration(c.rng.Int63n(int64(2*time.Second))))
+	}
+	return action{Seq: c.seq, ActionType: "move", Direction: c.direction}
+}
+
+// reporter печатает отчет за окно с прошлого вызова
+type reporter struct {
+	opts     options
+	stats    *stats
+	last     time.Time
+	bytesIn  int64
+	bytesOut int64
+	server   serverMetrics
+}
+
+// serverMetrics - счетчики из /metrics сервера
+type serverMetrics struct {
+	tickSum   float64
+	tickCount float64
+	overruns  float64
+}
+
+func newReporter(opts options, s *stats) *reporter {
+	r := &reporter{opts: opts, stats: s, last: time.Now()}
+	r.server, _ = scrapeMetrics(opts.metricsURL)
+	return r
+}
+
+func (r *reporter) print() {
+	now := time.Now()
+	elapsed := now.Sub(r.last).Seconds()
+	r.last = now
+
+	s := r.stats
+	s.mu.Lock()
+	states, gaps, actions, errors := s.states, s.gaps, s.actions, s.errors
+	ticks := s.lastTick - s.firstTick
+	s.states, s.gaps, s.actions, s.errors = 0, nil, 0, 0
+	s.firstTick, s.lastTick = 0, 0
+	s.mu.Unlock()
+
+	bytesIn, bytesOut := s.bytesIn.Load(), s.bytesOut.Load()
+	in, out := float64(bytesIn-r.bytesIn), float64(bytesOut-r.bytesOut)
+	r.bytesIn, r.bytesOut = bytesIn, bytesOut
+
+	clients := s.connected.Load()
+	perClient := func(v float64) float64 {
+		if clients == 0 {
+			return 0
+		}
+		return v / elapsed / float64(clients)
+	}
+	line := fmt.Sprintf("clients=%d errors=%d states/s=%.0f actions/s=%.0f ticks/s=%.1f state_gap_p50=%s p99=%s max=%s in=%.1fKB/s (%.1fKB/s per client) out=%.1fKB/s",
+		clients, errors, float64(states)/elapsed, float64(actions)/elapsed, float64(ticks)/elapsed,
+		percentile(gaps, 0.5), percentile(gaps, 0.99), percentile(gaps, 1),
+		in/elapsed/1024, perClient(in)/1024, out/elapsed/1024)
+
+	if r.opts.metricsURL != "" {
+		server, err := scrapeMetrics(r.opts.metricsURL)
+		if err != nil {
+			line += " metrics_error=" + err.Error()
+		} else {
+			var avg float64
+			if n := server.tickCount - r.server.tickCount; n > 0 {
+				avg = (server.tickSum - r.server.tickSum) / n
+			}
+			line += fmt.Sprintf(" server_tick_avg=%s overruns=%.0f",
+				time.Duration(avg*float64(time.Second)).Round(time.Microsecond), server.overruns-r.server.overruns)
+			r.server = server
+		}
+	}
+	fmt.Println(line)
+}
+
+func percentile(gaps []time.Duration, p float64) time.Duration {
+	if len(gaps) == 0 {
+		return 0
+	}
+	slices.Sort(gaps)
+	i := int(math.Ceil(p*float64(len(gaps)))) - 1
+	return gaps[max(0, i)].Round(time.Millisecond)
+}
+
+// scrapeMetrics читает длительность тиков и перерасходы из текстового
+// формата Prometheus
+func scrapeMetrics(url string) (serverMetrics, error) {
+	var m serverMetrics
+	if url == "" {
+		return m, nil
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return m, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return m, fmt.Errorf("%s replied %s", url, resp.Status)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || strings.HasPrefix(name, "#") {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case name == "meatgrinder_tick_duration_seconds_sum":
+			m.tickSum = v
+		case name == "meatgrinder_tick_duration_seconds_count":
+			m.tickCount = v
+		case strings.HasPrefix(name, "meatgrinder_tick_overruns_total"):
+			m.overruns += v
+		}
+	}
+	return m, scanner.Err()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+
+	"meatgrinder/protocol"
+)
+
+const (
+	CombatLogSize  = 200 // Сколько строк хранит клиент
+	CombatLogRows  = 12  // Сколько строк видно в панели
+	CombatLogWidth = 360
+	combatLogRow   = 14
+)
+
+// combatLogLine - строка журнала боя
+type combatLogLine struct {
+	At   time.Time
+	Text string
+}
+
+// combatLog - панель журнала боя. scroll - на сколько строк панель
+// прокручена от последней, 0 - показываем новые.
+type combatLog struct {
+	lines  []combatLogLine
+	open   bool
+	scroll int
+}
+
+// CombatEvent - общие поля событий, попадающих в журнал
+type CombatEvent struct {
+	PlayerID   int     `json:"player_id"`
+	AttackerID int     `json:"attacker_id"`
+	TargetID   int     `json:"target_id"`
+	MinionID   int     `json:"minion_id"`
+	Attack     string  `json:"attack"`
+	Damage     float64 `json:"damage"`
+	DamageType int     `json:"damage_type"`
+	Blocked    float64 `json:"blocked"`
+	Crit       bool    `json:"crit"`
+	Name       string  `json:"name"`
+	KillerName string  `json:"killer_name"`
+	Level      int     `json:"level"`
+	Type       string  `json:"type"`
+	Action     string  `json:"action"`
+	Team       int     `json:"team"`
+	By         string  `json:"by"`
+}
+
+// addCombatLog записывает событие в журнал. Вызывается в игровом цикле.
+func (g *Game) addCombatLog(event protocol.Event, now time.Time) {
+	var e CombatEvent
+	if !decodeEvent(event, &e) {
+		return
+	}
+	var text string
+	switch event.Type {
+	case EventPlayerAttack:
+		attacker := g.logName(e.AttackerID, "")
+		if e.MinionID != 0 {
+			attacker = trf("%s's minion", attacker)
+		}
+		text = trf("%s hit %s with %s for %.0f", attacker, g.logName(e.TargetID, ""), e.Attack, e.Damage)
+		if e.Crit {
+			text += " " + tr("(crit)")
+		}
+		text += e.mitigation()
+	case EventSplashDamage:
+		text = trf("%s's %s splashed %s for %.0f", g.logName(e.AttackerID, ""), e.Attack, g.logName(e.TargetID, ""), e.Damage)
+		text += e.mitigation()
+	case EventPlayerDeath:
+		// Имена берем из события: игроки могут быть вне обзора
+		if e.KillerName != "" {
+			text = trf("%s killed %s", e.KillerName, e.Name)
+		} else {
+			text = trf("%s died", e.Name)
+		}
+	case EventPlayerRespawn:
+		text = trf("%s respawned", g.logName(e.PlayerID, ""))
+	case EventLevelUp:
+		text = trf("%s reached level %d", g.logName(e.PlayerID, ""), e.Level)
+	case EventItemPickedUp:
+		text = trf("%s picked up %s", g.logName(e.PlayerID, ""), e.Type)
+	case EventFlag:
+		text = trf("Team %d flag: %s", e.Team, e.Action)
+		if e.PlayerID != 0 {
+			text += trf(" by %s", g.logName(e.PlayerID, ""))
+		}
+	case EventPause:
+		text = trf("Match paused by %s", e.By)
+	case EventResume:
+		text = trf("Match resumed by %s", e.By)
+	case EventPlayerJoined:
+		text = trf("%s joined", g.logName(e.PlayerID, e.Name))
+	case EventPlayerLeft:
+		text = trf("%s left", g.logName(e.PlayerID, ""))
+	default:
+		return
+	}
+
+	g.combatLog.lines = append(g.combatLog.lines, combatLogLine{At: now, Text: text})
+	if len(g.combatLog.lines) > CombatLogSize {
+		g.combatLog.lines = g.combatLog.lines[len(g.combatLog.lines)-CombatLogSize:]
+	}
+	// Прокрученная панель остается на тех же строках
+	if g.combatLog.scroll > 0 {
+		g.combatLog.scroll = min(g.combatLog.scroll+1, g.maxCombatLogScroll())
+	}
+}
+
+// mitigation - приписка к строке урона: чистый урон или сколько сняла
+// защита цели
+func (e CombatEvent) mitigation() string {
+	if e.DamageType == TrueDamage {
+		return " " + tr("(true damage)")
+	}
+	if e.Blocked >= 0.5 {
+		return " " + trf("(%.0f blocked)", e.Blocked)
+	}
+	return ""
+}
+
+// logName - имя игрока для журнала. name - имя из самого события, если есть.
+func (g *Game) logName(id int, name string) string {
+	if name != "" {
+		return name
+	}
+	if id == 0 {
+		return "?"
+	}
+	if player, ok := g.worldState.Players[id]; ok {
+		return feedName(player.Name, player.Bot)
+	}
+	return fmt.Sprintf("#%d", id)
+}
+
+func (g *Game) maxCombatLogScroll() int {
+	return max(0, len(g.combatLog.lines)-CombatLogRows)
+}
+
+// updateCombatLog открывает панель и прокручивает ее колесом мыши или
+// PageUp/PageDown. Вызывается в игровом цикле.
+func (g *Game) updateCombatLog() {
+	if g.keys.JustPressed(InputCombatLog) {
+		g.combatLog.open = !g.combatLog.open
+		g.combatLog.scroll = 0
+	}
+	if !g.combatLog.open {
+		return
+	}
+	scroll := g.combatLog.scroll
+	if _, wheel := ebiten.Wheel(); wheel > 0 {
+		scroll++
+	} else if wheel < 0 {
+		scroll--
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageUp) {
+		scroll += CombatLogRows
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageDown) {
+		scroll -= CombatLogRows
+	}
+	g.combatLog.scroll = max(0, min(scroll, g.maxCombatLogScroll()))
+}
+
+// drawCombatLog рисует журнал слева над полосками здоровья, новые строки снизу
+func (g *Game) drawCombatLog(screen *ebiten.Image, hud hudLayout) {
+	if !g.combatLog.open {
+		return
+	}
+	const left = 10
+	bottom := hud.h - 60
+	top := bottom - (CombatLogRows+1)*combatLogRow
+	ebitenutil.DrawRect(screen, left, float64(top), CombatLogWidth, float64(bottom-top), color.RGBA{0, 0, 0, 160})
+
+	header := trf("Combat log (%s, wheel/PgUp/PgDn)", g.keys[InputCombatLog])
+	if g.combatLog.scroll > 0 {
+		header += fmt.Sprintf(" -%d", g.combatLog.scroll)
+	}
+	drawText(screen, header, left+4, top)
+
+	end := len(g.combatLog.lines) - g.combatLog.scroll
+	start := max(0, end-CombatLogRows)
+	for i, line := range g.combatLog.lines[start:end] {
+		text := line.At.Format("15:04:05") + " " + line.Text
+		drawText(screen, text, left+4, top+(i+1)*combatLogRow)
+	}
+}
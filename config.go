@@ -0,0 +1,230 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config - настройки запуска из флагов командной строки
+type Config struct {
+	Server     bool
+	HTTPAddr   string // Адрес HTTP-сервера с /metrics, пустой - выключен
+	MinPlayers int    // Сколько живых игроков нужно для начала матча
+	MaxRooms   int    // Ограничение на количество комнат на сервере
+	MatchSize  int    // Игроков в комнате из очереди подбора, 0 - очереди нет
+	Seed       int64  // Зерно RNG комнат, 0 - случайное
+
+	WorldWidth  float64 // Размер мира каждой комнаты
+	WorldHeight float64
+	MapPaths    []string   // JSON-файлы карт, пусто - пустой мир
+	Maps        []*GameMap // Пул карт по порядку ротации
+	Map         *GameMap   // Карта комнаты, nil без -map. Сначала первая из пула.
+	MapRotation string     // Как выбирается карта следующего матча, см. MapRotations
+
+	ProfilesPath   string  // База BoltDB с профилями игроков, пустой - не сохранять
+	Password       string  // Пароль для входа на сервер, пустой - сервер открыт
+	ViewRadius     float64 // Игроки дальше не попадают в состояние, 0 - видно всех
+	RevealDistance float64 // Имя и здоровье врага видно только ближе этого к своим, 0 - всегда
+	Transport      string  // tcp или udp, у клиента и сервера должен совпадать
+	Mode           string  // Режим игры во всех комнатах, см. GameModes
+	Zone           bool    // Сужающаяся зона в каждом матче
+	BalancePath    string  // JSON-файл баланса классов, перечитывается по SIGHUP
+	StateFile      string  // Сюда сервер сохраняет комнаты при остановке и отсюда поднимает их, пустой - не сохранять
+
+	MatchDuration time.Duration // Длительность матча
+	SuddenDeath   string        // Правило овертайма при ничьей, см. SuddenDeathRules
+
+	BotPluginPath string        // Go-плагин с логикой ботов, пустой - встроенная
+	BotPlugin     *botPlugin    // Загруженный плагин, nil без -bot-plugin
+	BotTimeout    time.Duration // Сколько плагин может думать над ходом одного бота
+
+	MasterAddr string // Адрес, на котором работать мастер-сервером вместо игрового
+	MasterURL  string // Мастер-сервер: сервер регистрируется на нем, клиент берет список серверов
+	ServerName string // Имя сервера в списке мастер-сервера
+	PublicAddr string // Адрес сервера для игроков. Мастер берет из него только порт, хост - тот, с которого пришел heartbeat
+
+	DownloadURL string // Где взять совместимый клиент, показывается клиентам старой версии
+
+	Webhooks []string // URL, на которые сервер шлет POST о начале и конце матча и других событиях
+
+	ExportCSV string // Каталог для CSV-выгрузки лога событий, пустой - не писать
+	ExportURL string // URL, куда POST-ом уходят пачки событий лога, пустой - не слать
+
+	CaptureDir string // Каталог записи протокола каждого соединения, пустой - не писать
+
+	LogLevel  string // Общий уровень логирования: debug, info, warn, error
+	LogLevels string // Уровни подсистем поверх общего, например "net=debug,bots=warn"
+	LogJSON   bool   // Писать лог в JSON, по строке на запись
+
+	TickRate          int  // Шагов симуляции в секунду
+	BroadcastRate     int  // Рассылок состояния в секунду, не больше TickRate
+	AdaptiveBroadcast bool // Реже рассылать состояние, если тики не укладываются в бюджет
+
+	AFKTimeout    time.Duration // Молчащий столько клиент помечается AFK
+	ClientTimeout time.Duration // Молчащий столько клиент отключается
+
+	DebugTicks int // Сводок тиков в журнале каждой комнаты, 0 - журнал выключен
+
+	ClientBandwidth int // Предел исходящего трафика на клиента, КиБ/с, 0 - без предела
+
+	// Клиент
+	Addr       string  // Адрес сервера по умолчанию в главном меню
+	Name       string  // Отображаемое имя игрока
+	Room       string  // В какую комнату войти, пустая - выбрать из списка
+	CreateRoom bool    // Создать комнату Room вместо входа в существующую
+	RoomWidth  float64 // Размер мира создаваемой комнаты, нули - как у сервера
+	RoomHeight float64
+}
+
+func parseConfig() Config {
+	cfg := Config{
+		Server: os.Getenv("SERVER") == "1",
+	}
+	flag.BoolVar(&cfg.Server, "server", cfg.Server, "run as server (same as SERVER=1)")
+	flag.StringVar(&cfg.HTTPAddr, "http-addr", "", "server HTTP address for /metrics, e.g. :9090 (disabled if empty)")
+	flag.IntVar(&cfg.MinPlayers, "min-players", 1, "human players required to start a match")
+	flag.IntVar(&cfg.MaxRooms, "max-rooms", 16, "maximum number of rooms hosted by the server")
+	flag.IntVar(&cfg.MatchSize, "match-size", 0, "server matchmaking queue: group queued players by rating into new rooms of this many players (0 = no queue)")
+	flag.Float64Var(&cfg.WorldWidth, "world-width", DefaultWorldWidth, "server world width in pixels")
+	flag.Float64Var(&cfg.WorldHeight, "world-height", DefaultWorldHeight, "server world height in pixels")
+	flag.Func("map", "server JSON map file with the world size and hazards (repeat for a pool of maps played in turn)", func(path string) error {
+		cfg.MapPaths = append(cfg.MapPaths, path)
+		return nil
+	})
+	flag.StringVar(&cfg.MapRotation, "map-rotation", MapRotationCycle, "server choice of the next map from the pool: cycle (in order) or vote (players vote after a match)")
+	flag.Int64Var(&cfg.Seed, "seed", 0, "random seed for room simulations (0 = random)")
+	flag.StringVar(&cfg.ProfilesPath, "profiles", "", "server BoltDB file with persistent player profiles (disabled if empty)")
+	flag.Float64Var(&cfg.ViewRadius, "view-radius", DefaultViewRadius, "server radius around a player in which other players are sent (0 = send everyone)")
+	flag.Float64Var(&cfg.RevealDistance, "reveal-distance", 0, "server distance from a player or a teammate within which enemy names and health are shown (0 = always shown)")
+	flag.IntVar(&cfg.TickRate, "tick-rate", TickRate, "server simulation steps per second")
+	flag.IntVar(&cfg.BroadcastRate, "broadcast-rate", 0, "server state broadcasts per second (0 = same as -tick-rate)")
+	flag.BoolVar(&cfg.AdaptiveBroadcast, "adaptive-broadcast", false, "server broadcasts state less often when ticks run over budget")
+	flag.DurationVar(&cfg.AFKTimeout, "afk-timeout", DefaultAFKTimeout, "server marks a player AFK after hearing nothing from their client this long")
+	flag.DurationVar(&cfg.ClientTimeout, "client-timeout", DefaultClientTimeout, "server disconnects a client after hearing nothing from it this long")
+	flag.StringVar(&cfg.Mode, "mode", GameModeFFA, "server game mode: ffa, elimination, koth or ctf")
+	flag.DurationVar(&cfg.MatchDuration, "match-duration", DefaultMatchDuration, "server length of a match")
+	flag.StringVar(&cfg.SuddenDeath, "sudden-death", SuddenDeathNoRespawn, "server overtime rule when a match ends tied: off, no-respawn or double-damage")
+	flag.BoolVar(&cfg.Zone, "zone", false, "server shrinks a safe zone during matches, players outside it take damage")
+	flag.StringVar(&cfg.BalancePath, "balance", "", "server JSON file overriding class stats and attacks, reloaded on SIGHUP")
+	flag.StringVar(&cfg.StateFile, "state-file", "", "server file where rooms are saved on SIGTERM/SIGINT and restored from on start, for restarts mid-match (disabled if empty)")
+	flag.StringVar(&cfg.BotPluginPath, "bot-plugin", "", "server Go plugin (.so) with custom bot logic, see package botapi (built-in bots if empty)")
+	flag.DurationVar(&cfg.BotTimeout, "bot-timeout", DefaultBotTimeout, "server time limit for one bot plugin decision")
+	flag.StringVar(&cfg.MasterAddr, "master-addr", "", "run a master server listing public game servers on this address, e.g. :8090")
+	flag.StringVar(&cfg.MasterURL, "master-url", "", "master server URL, e.g. http://master.example.com:8090: servers register there, clients list servers from it")
+	flag.StringVar(&cfg.ServerName, "server-name", "Meat Grinder", "server name shown in the master server list")
+	flag.StringVar(&cfg.DownloadURL, "download-url", "", "server URL shown to clients with an incompatible protocol version, where to download a new client")
+	flag.StringVar(&cfg.PublicAddr, "public-addr", "", "server address advertised to the master server; only its port is used, the host is the address the heartbeat comes from (default port 8080)")
+	flag.Func("webhook", "server URL to POST match events to (repeat for several URLs)", func(url string) error {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return fmt.Errorf("webhook must be an http(s) URL")
+		}
+		cfg.Webhooks = append(cfg.Webhooks, url)
+		return nil
+	})
+	flag.StringVar(&cfg.ExportCSV, "export-csv", "", "server directory to write the event log to as CSV for offline analysis (disabled if empty)")
+	flag.StringVar(&cfg.ExportURL, "export-url", "", "server URL to POST batches of event log records to as JSON lines, e.g. a ClickHouse insert query (disabled if empty)")
+	flag.StringVar(&cfg.CaptureDir, "capture-dir", "", "server directory to record every protocol message of each connection to, one rolling file per connection, for bug reports (disabled if empty)")
+	flag.StringVar(&cfg.Transport, "transport", TransportTCP, "network transport: tcp or udp (must match on client and server)")
+	flag.StringVar(&cfg.Password, "password", "", "server password: required to join on the server, sent when joining on the client")
+	flag.StringVar(&cfg.Addr, "addr", "localhost:8080", "server address prefilled in the main menu")
+	flag.StringVar(&cfg.Name, "name", "", "player display name")
+	flag.StringVar(&cfg.Room, "room", "", "room to join (pick from the room list if empty)")
+	flag.BoolVar(&cfg.CreateRoom, "create-room", false, "create the room given by -room instead of joining it")
+	flag.IntVar(&cfg.ClientBandwidth, "client-bandwidth", 0, "server outbound bandwidth cap per client in KiB/s: state snapshots over it are skipped, lowering that client's snapshot rate (0 = unlimited)")
+	flag.IntVar(&cfg.DebugTicks, "debug-ticks", 0, "server keeps a summary of this many last ticks per room for GET /admin/rooms/{room}/ticks (0 = disabled)")
+	flag.Func("room-size", "world size of the room made with -create-room, e.g. 2400x1800 (server default if empty)", func(size string) error {
+		if _, err := fmt.Sscanf(size, "%gx%g", &cfg.RoomWidth, &cfg.RoomHeight); err != nil {
+			return fmt.Errorf("room size must look like 2400x1800")
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.LogLevel, "log-level", "info", "log level: debug, info, warn or error")
+	flag.StringVar(&cfg.LogLevels, "log-levels", "", "per-subsystem log levels over -log-level, e.g. net=debug,bots=warn (subsystems: net, sim, bots, client)")
+	flag.BoolVar(&cfg.LogJSON, "log-json", false, "write logs as JSON lines")
+	flag.Parse()
+	if err := setupLogging(cfg.LogLevel, cfg.LogLevels, cfg.LogJSON); err != nil {
+		log.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, path := range cfg.MapPaths {
+		m, err := loadMap(path)
+		if err != nil {
+			log.Fatalf("Error loading map: %v", err)
+		}
+		if names[m.Name] {
+			log.Fatalf("Error loading map: %s: duplicate map name %q", path, m.Name)
+		}
+		names[m.Name] = true
+		// Размер из карты важнее флагов
+		m.resolve(cfg.WorldWidth, cfg.WorldHeight)
+		cfg.Maps = append(cfg.Maps, m)
+	}
+	if len(cfg.Maps) > 0 {
+		cfg.Map = cfg.Maps[0]
+		cfg.WorldWidth, cfg.WorldHeight = cfg.Map.Width, cfg.Map.Height
+	}
+	if !slices.Contains(MapRotations, cfg.MapRotation) {
+		log.Fatalf("Invalid map rotation %q", cfg.MapRotation)
+	}
+	if cfg.BotPluginPath != "" {
+		if cfg.BotTimeout <= 0 {
+			log.Fatalf("Invalid bot timeout %s", cfg.BotTimeout)
+		}
+		p, err := loadBotPlugin(cfg.BotPluginPath, cfg.BotTimeout)
+		if err != nil {
+			log.Fatalf("Error loading bot plugin: %v", err)
+		}
+		cfg.BotPlugin = p
+	}
+	if cfg.WorldWidth <= 0 || cfg.WorldHeight <= 0 {
+		log.Fatalf("Invalid world size %gx%g", cfg.WorldWidth, cfg.WorldHeight)
+	}
+	if cfg.TickRate <= 0 {
+		log.Fatalf("Invalid tick rate %d", cfg.TickRate)
+	}
+	if cfg.BroadcastRate == 0 {
+		cfg.BroadcastRate = cfg.TickRate
+	}
+	if cfg.BroadcastRate < 0 || cfg.BroadcastRate > cfg.TickRate {
+		log.Fatalf("Invalid broadcast rate %d: must be between 1 and the tick rate %d", cfg.BroadcastRate, cfg.TickRate)
+	}
+	if cfg.AFKTimeout <= PingInterval || cfg.ClientTimeout <= PingInterval {
+		log.Fatalf("Invalid timeouts: -afk-timeout and -client-timeout must be longer than the ping interval %s", PingInterval)
+	}
+	if cfg.DebugTicks < 0 {
+		log.Fatalf("Invalid tick log size %d", cfg.DebugTicks)
+	}
+	if cfg.ClientBandwidth < 0 {
+		log.Fatalf("Invalid client bandwidth %d", cfg.ClientBandwidth)
+	}
+	if cfg.Transport != TransportTCP && cfg.Transport != TransportUDP {
+		log.Fatalf("Invalid transport %q", cfg.Transport)
+	}
+	if !slices.Contains(GameModes, cfg.Mode) {
+		log.Fatalf("Invalid game mode %q", cfg.Mode)
+	}
+	if cfg.MatchDuration <= 0 {
+		log.Fatalf("Invalid match duration %s", cfg.MatchDuration)
+	}
+	if !slices.Contains(SuddenDeathRules, cfg.SuddenDeath) {
+		log.Fatalf("Invalid sudden death rule %q", cfg.SuddenDeath)
+	}
+	if cfg.ExportURL != "" && !strings.HasPrefix(cfg.ExportURL, "http://") && !strings.HasPrefix(cfg.ExportURL, "https://") {
+		log.Fatalf("Invalid export URL %q: must be an http(s) URL", cfg.ExportURL)
+	}
+	if cfg.MatchSize < 0 {
+		log.Fatalf("Invalid match size %d", cfg.MatchSize)
+	}
+	if cfg.ViewRadius < 0 {
+		log.Fatalf("Invalid view radius %g", cfg.ViewRadius)
+	}
+	if cfg.RevealDistance < 0 {
+		log.Fatalf("Invalid reveal distance %g", cfg.RevealDistance)
+	}
+	return cfg
+}
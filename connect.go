@@ -0,0 +1,259 @@
+package main
+
+import (
+	"image/color"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"meatgrinder/protocol"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const (
+	ConnectTimeout = 5 * time.Second
+	MaxAddressLen  = 64
+	MaxPasswordLen = 64
+)
+
+// Поля ввода главного меню
+const (
+	FieldAddress = iota
+	FieldName
+	FieldPassword
+	connectFields
+)
+
+// connectMenu - главное меню: адрес сервера, имя игрока и пароль сервера
+type connectMenu struct {
+	address    string
+	name       string
+	password   string
+	field      int
+	connecting bool
+	err        string
+}
+
+// updateConnectMenu: Tab - следующее поле, Enter - подключиться.
+// Вызывается в игровом цикле.
+func (g *Game) updateConnectMenu() {
+	m := &g.connect
+	if m.connecting {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) || inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		m.field = (m.field + 1) % connectFields
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		m.field = (m.field + connectFields - 1) % connectFields
+	}
+
+	text, limit := &m.address, MaxAddressLen
+	switch m.field {
+	case FieldName:
+		text, limit = &m.name, MaxNameLength
+	case FieldPassword:
+		text, limit = &m.password, MaxPasswordLen
+	}
+	for _, c := range ebiten.AppendInputChars(nil) {
+		if utf8.RuneCountInString(*text) < limit {
+			*text += string(c)
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) && len(*text) > 0 {
+		_, size := utf8.DecodeLastRuneInString(*text)
+		*text = (*text)[:len(*text)-size]
+	}
+
+	if inpututil.IsKeyJustPressed(PracticeKey) {
+		g.startPractice(g.cfg)
+		return
+	}
+	if inpututil.IsKeyJustPressed(HostKey) {
+		g.startHosting()
+		return
+	}
+	if inpututil.IsKeyJustPressed(ServerListKey) {
+		g.servers.active = true
+		g.refreshServerList()
+		return
+	}
+	if inpututil.IsKeyJustPressed(LeaderboardKey) {
+		g.leaderboard.active = true
+		g.refreshLeaderboard()
+		return
+	}
+	if inpututil.IsKeyJustPressed(EditorKey) {
+		g.openEditor()
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) && m.address != "" {
+		m.connecting = true
+		m.err = ""
+		go g.dial(m.address, m.name, m.password)
+	}
+}
+
+// dial подключается к серверу в фоне, чтобы окно не зависало
+func (g *Game) dial(address, name, password string) {
+	conn, err := dialTransport(g.cfg.Transport, address)
+	g.post(func() {
+		g.connect.connecting = false
+		if err != nil {
+			clientLog.Warn("Failed to connect to server", "addr", address, "err", err)
+			g.connect.err = err.Error()
+			return
+		}
+		clientLog.Info("Connected", "addr", address)
+		g.serverAddr = address
+		g.connected(conn, name, password)
+	})
+}
+
+// connected переходит к выбору комнаты на только что подключенном сервере.
+// Вызывается в игровом цикле.
+func (g *Game) connected(conn net.Conn, name, password string) {
+	g.clientConn = conn
+	g.cfg.Name = name
+	g.cfg.Password = password
+	g.resetWorld()
+	g.scene = lobbyScene{}
+
+	// Без явно указанной комнаты показываем список комнат
+	if g.cfg.Room != "" {
+		g.joinRoom(g.cfg.Room, g.cfg.CreateRoom)
+	} else {
+		g.browser.active = true
+		g.browser.err = ""
+		g.browser.queued = time.Time{}
+		g.requestRoomList()
+		g.enterLobby()
+	}
+	go g.clientReceive(conn)
+}
+
+// disconnected возвращает в главное меню после обрыва соединения, а
+// посреди игры на сервере сначала пробует переподключиться.
+// Вызывается в игровом цикле.
+func (g *Game) disconnected(conn net.Conn, err error) {
+	conn.Close()
+	if g.clientConn != conn {
+		return
+	}
+	g.clientConn = nil
+	if g.startReconnect(err) {
+		return
+	}
+	g.stopPractice()
+	g.stopHosting()
+	g.editor.testing = false
+	g.browser.active = false
+	g.scene = menuScene{}
+	g.connect.err = trf("Disconnected: %v", err)
+	g.resetWorld()
+}
+
+// rejected возвращает в главное меню, когда сервер не пустил игрока,
+// и ставит курсор в поле пароля. Вызывается в игровом цикле.
+func (g *Game) rejected(conn net.Conn, reason string) {
+	conn.Close()
+	if g.clientConn != conn {
+		return
+	}
+	g.clientConn = nil
+	g.stopHosting()
+	g.browser.active = false
+	g.scene = menuScene{}
+	g.connect.err = reason
+	g.connect.field = FieldPassword
+	g.resetWorld()
+}
+
+// incompatible возвращает в главное меню, когда версии протокола клиента
+// и сервера несовместимы. minVersion - сколько просит сервер, 0 - сервер
+// сам слишком старый. Вызывается в игровом цикле.
+func (g *Game) incompatible(conn net.Conn, minVersion int, download string) {
+	conn.Close()
+	if g.clientConn != conn {
+		return
+	}
+	g.clientConn = nil
+	g.stopHosting()
+	g.browser.active = false
+	g.scene = menuScene{}
+	if minVersion > 0 {
+		g.connect.err = trf("Client is too old: the server needs protocol %d, this client speaks %d", minVersion, protocol.Version)
+	} else {
+		g.connect.err = trf("Server is too old: this client needs protocol %d or newer", protocol.MinVersion)
+	}
+	if download != "" {
+		g.connect.err += ". " + trf("Download a new client: %s", download)
+	}
+	g.resetWorld()
+}
+
+// resetWorld забывает состояние прошлого подключения. Вызывается в игровом цикле.
+func (g *Game) resetWorld() {
+	g.playerID = -1
+	g.worldState = WorldState{
+		Players: make(map[int]*PlayerState),
+		Items:   make(map[int]*Item),
+	}
+	g.playerPositions = make(map[int]Point)
+	g.damageFlashes = make(map[int]time.Time)
+	g.healthBars = make(map[int]*healthBar)
+	g.anims = make(map[int]animState)
+	g.corpses = nil
+	g.vfx = nil
+	g.killFeed = nil
+	g.combatLog = combatLog{}
+	g.damageTaken = nil
+	g.matchSummary = nil
+	g.matchStats = nil
+	g.profile = nil
+	g.keyDirection = Point{}
+}
+
+func (g *Game) drawConnectMenu(screen *ebiten.Image) {
+	m := g.connect
+	drawText(screen, "MEAT GRINDER", ScreenWidth/2-36, 120)
+	for i, hint := range []string{
+		tr("Tab - next field, Enter - connect, Esc - settings"),
+		tr("F2 - practice offline, F3 - host game (password field sets the server password), F4 - public servers, F6 - leaderboard, F7 - map editor"),
+	} {
+		drawText(screen, hint, ScreenWidth/2-textWidth(hint)/2, 145+15*i)
+	}
+
+	fields := [connectFields][2]string{
+		FieldAddress:  {tr("Server"), m.address},
+		FieldName:     {tr("Name"), m.name},
+		FieldPassword: {tr("Password"), strings.Repeat("*", utf8.RuneCountInString(m.password))},
+	}
+	for i, field := range fields {
+		y := 190 + i*30
+		boxColor := color.RGBA{255, 255, 255, 30}
+		value := field[1]
+		if i == m.field {
+			boxColor = color.RGBA{255, 255, 255, 60}
+			// Мигающий курсор
+			if time.Now().UnixMilli()/500%2 == 0 {
+				value += "_"
+			}
+		}
+		drawText(screen, field[0], ScreenWidth/2-200, y+4)
+		ebitenutil.DrawRect(screen, ScreenWidth/2-130, float64(y), 330, 24, boxColor)
+		drawText(screen, value, ScreenWidth/2-124, y+4)
+	}
+
+	switch {
+	case m.connecting:
+		drawText(screen, trf("Connecting to %s...", m.address), ScreenWidth/2-130, 300)
+	case m.err != "":
+		drawText(screen, trf("Error: %s", m.err), ScreenWidth/2-200, 300)
+		drawText(screen, tr("Press Enter to retry"), ScreenWidth/2-130, 320)
+	}
+}
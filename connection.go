@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SendQueueSize  = 64              // Очередь надежных сообщений (init, события)
+	MaxStaleStates = TickRate * 5    // Сколько раз подряд состояние может быть перезаписано, не дойдя до клиента
+	WriteTimeout   = 5 * time.Second // Таймаут записи в сокет
+)
+
+// clientConnection владеет записью в сокет клиента. Сообщения пишет отдельная
+// горутина, поэтому медленный клиент не тормозит тик сервера.
+//
+// Надежные сообщения идут через очередь send. Состояние мира не копится:
+// хранится только последний снимок, и если клиент не успел забрать предыдущий,
+// он просто перезаписывается.
+type clientConnection struct {
+	playerID int
+	conn     net.Conn
+	send     chan []byte
+
+	stateMu     sync.Mutex
+	state       []byte
+	staleStates int
+	stateReady  chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	lastHeard atomic.Int64 // Когда клиент последний раз что-то прислал, UnixNano
+	rtt       atomic.Int64 // Задержка по последнему pong
+
+	connectedAt   time.Time
+	limit         *bandwidthLimit // nil - без предела трафика
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	throttled     atomic.Uint64 // Снимков, пропущенных из-за предела трафика
+	skippedStates uint64        // Пропущено с последнего отправленного снимка, меняется в горутине рассылки
+
+	visible map[int]bool // Игроки в обзоре на прошлом тике, меняется в горутине комнаты
+}
+
+// newClientConnection создает соединение игрока. bandwidth - предел
+// исходящего трафика в КиБ/с, 0 - без предела.
+func newClientConnection(conn net.Conn, playerID, bandwidth int) *clientConnection {
+	now := time.Now()
+	c := &clientConnection{
+		playerID:    playerID,
+		conn:        conn,
+		send:        make(chan []byte, SendQueueSize),
+		stateReady:  make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		connectedAt: now,
+		limit:       newBandwidthLimit(bandwidth, now),
+	}
+	c.heard(now)
+	go c.writeLoop()
+	return c
+}
+
+// heard отмечает, что от клиента пришло сообщение. Вызывается в горутине
+// чтения, см. watchConnections.
+func (c *clientConnection) heard(now time.Time) {
+	c.lastHeard.Store(now.UnixNano())
+}
+
+func (c *clientConnection) lastHeardAt() time.Time {
+	return time.Unix(0, c.lastHeard.Load())
+}
+
+// pong учитывает ответ на ping. Вызывается в горутине чтения.
+func (c *clientConnection) pong(sent int64, now time.Time) {
+	if rtt := now.Sub(time.Unix(0, sent)); rtt >= 0 && rtt < time.Minute {
+		c.rtt.Store(int64(rtt))
+	}
+}
+
+func (c *clientConnection) latency() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+// enqueue ставит надежное сообщение в очередь. Если очередь переполнена,
+// клиент не справляется и соединение закрывается.
+func (c *clientConnection) enqueue(b []byte) bool {
+	select {
+	case c.send <- b:
+		return true
+	case <-c.done:
+		return false
+	default:
+		netLog.Warn("Send queue full, disconnecting", "player_id", c.playerID)
+		c.Close()
+		return false
+	}
+}
+
+// enqueueState заменяет неотправленный снимок состояния новым
+func (c *clientConnection) enqueueState(b []byte) bool {
+	c.stateMu.Lock()
+	if c.state != nil {
+		c.staleStates++
+	} else {
+		c.staleStates = 0
+	}
+	c.state = b
+	stale := c.staleStates
+	c.stateMu.Unlock()
+
+	if stale > MaxStaleStates {
+		netLog.Warn("Player is not reading state updates, disconnecting", "player_id", c.playerID)
+		c.Close()
+		return false
+	}
+
+	select {
+	case c.stateReady <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (c *clientConnection) takeState() []byte {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	b := c.state
+	c.state = nil
+	return b
+}
+
+func (c *clientConnection) writeLoop() {
+	for {
+		// Надежные сообщения отправляем в первую очередь: init должен
+		// дойти раньше первого состояния
+		select {
+		case b := <-c.send:
+			if !c.write(b) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case b := <-c.send:
+			if !c.write(b) {
+				return
+			}
+		case <-c.stateReady:
+			if b := c.takeState(); b != nil && !c.writeState(b) {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *clientConnection) write(b []byte) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	if _, err := c.conn.Write(b); err != nil {
+		netLog.Debug("Error writing to player", "player_id", c.playerID, "err", err)
+		c.Close()
+		return false
+	}
+	c.sent(len(b))
+	return true
+}
+
+// writeState отправляет снимок состояния. Если транспорт умеет слать без
+// гарантии доставки, снимок не переотправляется: следующий придет через тик.
+func (c *clientConnection) writeState(b []byte) bool {
+	u, ok := c.conn.(unreliableWriter)
+	if !ok {
+		return c.write(b)
+	}
+	if err := u.WriteUnreliable(b); err != nil {
+		netLog.Debug("Error writing state to player", "player_id", c.playerID, "err", err)
+		c.Close()
+		return false
+	}
+	c.sent(len(b))
+	return true
+}
+
+// sent учитывает отправленное сообщение в трафике и запасе клиента
+func (c *clientConnection) sent(bytes int) {
+	c.bytesSent.Add(uint64(bytes))
+	if c.limit != nil {
+		c.limit.spend(bytes, time.Now())
+	}
+	metrics.MessageSent(c.playerID, bytes)
+}
+
+// received учитывает принятое. total - сколько всего байт разобрано из
+// соединения, вместе с сообщениями до входа в комнату.
+func (c *clientConnection) received(total int64) {
+	prev := c.bytesReceived.Swap(uint64(total))
+	metrics.BytesReceived(c.playerID, int(uint64(total)-prev))
+}
+
+// allowState сообщает, что клиенту можно отправить снимок состояния, и
+// считает пропущенные из-за предела трафика
+func (c *clientConnection) allowState(now time.Time) bool {
+	if c.limit == nil || c.limit.allow(now) {
+		return true
+	}
+	c.throttled.Add(1)
+	metrics.SnapshotThrottled(c.playerID)
+	return false
+}
+
+// Close закрывает соединение. Горутина чтения получит ошибку и удалит игрока.
+func (c *clientConnection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
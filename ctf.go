@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Захват флага: две команды, у каждой флаг на своей базе. Вражеский флаг
+// нужно донести до своей базы, пока свой стоит на месте.
+const (
+	GameModeCTF = "ctf"
+
+	TeamRed  = 1
+	TeamBlue = 2
+
+	CTFCapturesToWin     = 3
+	FlagRadius           = 12.0
+	FlagBaseInset        = 150.0 // Отступ баз от левого и правого края мира
+	FlagReturnTime       = 15.0  // Секунд, через которые брошенный флаг сам вернется на базу
+	FlagCarrierSpeedMult = 0.75  // Несущий флаг бегает медленнее
+
+	EventFlag = "flag"
+
+	// Что произошло с флагом, поле action события EventFlag
+	FlagPickup  = "pickup"
+	FlagDrop    = "drop"
+	FlagCapture = "capture"
+	FlagReturn  = "return"
+)
+
+var TeamColors = map[int]color.RGBA{
+	TeamRed:  {255, 140, 0, 255},
+	TeamBlue: {0, 200, 200, 255},
+}
+
+var TeamNames = map[int]string{
+	TeamRed:  "Orange",
+	TeamBlue: "Cyan",
+}
+
+// Flag - флаг команды Team. Лежит на базе, у несущего или там, где его бросили.
+type Flag struct {
+	Team      int       `json:"team"`
+	Position  Point     `json:"position"`
+	Home      Point     `json:"home"`
+	Carrier   int       `json:"carrier,omitempty"` // ID несущего, 0 - никто
+	DroppedAt time.Time `json:"-"`                 // Нулевое - на базе или несут
+}
+
+func (f *Flag) atHome() bool {
+	return f.Carrier == 0 && f.DroppedAt.IsZero()
+}
+
+func (f *Flag) returnHome() {
+	f.Position = f.Home
+	f.Carrier = 0
+	f.DroppedAt = time.Time{}
+}
+
+// ctfMode: очко команде за каждый донесенный до базы флаг. Игрокам очко
+// за захват идет в личный счет.
+type ctfMode struct {
+	scores map[int]int // Команда -> захваты
+}
+
+func newCTFMode() *ctfMode {
+	return &ctfMode{scores: make(map[int]int)}
+}
+
+func (m *ctfMode) Name() string { return GameModeCTF }
+
+// saveState и restoreState переносят счет захватов через горячий перезапуск
+func (m *ctfMode) saveState() ([]byte, error) {
+	return json.Marshal(m.scores)
+}
+
+func (m *ctfMode) restoreState(data []byte) error {
+	return json.Unmarshal(data, &m.scores)
+}
+
+func (m *ctfMode) Start(r *Room) {
+	m.scores = make(map[int]int)
+	r.balanceTeams()
+	// Игроки сброшены до раздачи команд: переставляем каждого к своим
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		player.Position = r.spawnPosition(player)
+	}
+	r.worldState.Flags = []*Flag{
+		{Team: TeamRed, Home: Point{X: FlagBaseInset, Y: r.cfg.WorldHeight / 2}},
+		{Team: TeamBlue, Home: Point{X: r.cfg.WorldWidth - FlagBaseInset, Y: r.cfg.WorldHeight / 2}},
+	}
+	for _, flag := range r.worldState.Flags {
+		flag.returnHome()
+	}
+}
+
+// assignTeams распределяет игроков без команды в меньшую, а игроков из
+// группы - к уже играющим товарищам. Новые игроки подключаются посреди
+// матча, поэтому вызывается каждый тик.
+func (m *ctfMode) assignTeams(r *Room) {
+	sizes := make(map[int]int)
+	for _, player := range r.worldState.Players {
+		sizes[player.Team]++
+	}
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		if player.Team != 0 {
+			continue
+		}
+		player.Team = TeamRed
+		if team := r.partyTeam(player); team != 0 {
+			player.Team = team
+		} else if sizes[TeamBlue] < sizes[TeamRed] {
+			player.Team = TeamBlue
+		}
+		sizes[player.Team]++
+		// Только что вошел и появился где придется - ставим к своей команде
+		player.Position = r.spawnPosition(player)
+	}
+}
+
+func (m *ctfMode) Update(r *Room, deltaTime float64, now time.Time) {
+	m.assignTeams(r)
+	for _, flag := range r.worldState.Flags {
+		if flag.Carrier != 0 {
+			carrier, ok := r.worldState.Players[flag.Carrier]
+			if !ok {
+				// Несущий вышел из игры: флаг остается там, где его видели последним
+				m.dropFlag(r, flag, nil, now)
+				continue
+			}
+			flag.Position = carrier.Position
+			continue
+		}
+		if !flag.DroppedAt.IsZero() && now.Sub(flag.DroppedAt).Seconds() >= FlagReturnTime {
+			m.flagEvent(r, flag, FlagReturn, 0, now)
+			flag.returnHome()
+		}
+	}
+
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		for _, flag := range r.worldState.Flags {
+			if flag.Carrier != 0 || !touchesFlag(player, flag.Position) {
+				continue
+			}
+			switch {
+			case flag.Team != player.Team && player.CarryingFlag == 0:
+				flag.Carrier = player.ID
+				flag.DroppedAt = time.Time{}
+				player.CarryingFlag = flag.Team
+				m.flagEvent(r, flag, FlagPickup, player.ID, now)
+			case flag.Team == player.Team && !flag.atHome():
+				// Свой брошенный флаг возвращается касанием
+				m.flagEvent(r, flag, FlagReturn, player.ID, now)
+				flag.returnHome()
+			case flag.Team == player.Team && player.CarryingFlag != 0:
+				m.capture(r, player, now)
+			}
+		}
+	}
+}
+
+func touchesFlag(player *PlayerState, position Point) bool {
+	return math.Hypot(player.Position.X-position.X, player.Position.Y-position.Y) <= PlayerRadius+FlagRadius
+}
+
+// capture засчитывает принесенный флаг: он возвращается на свою базу
+func (m *ctfMode) capture(r *Room, player *PlayerState, now time.Time) {
+	flag := r.flagOf(player.CarryingFlag)
+	if flag == nil {
+		player.CarryingFlag = 0
+		return
+	}
+	m.scores[player.Team]++
+	player.Score++
+	player.CarryingFlag = 0
+	m.flagEvent(r, flag, FlagCapture, player.ID, now)
+	flag.returnHome()
+	r.log.Info("Flag captured", "player_id", player.ID, "flag", TeamNames[flag.Team],
+		"red", m.scores[TeamRed], "blue", m.scores[TeamBlue])
+}
+
+// dropFlag бросает флаг на месте. carrier равен nil, если несущего уже нет.
+func (m *ctfMode) dropFlag(r *Room, flag *Flag, carrier *PlayerState, now time.Time) {
+	playerID := flag.Carrier
+	if carrier != nil {
+		flag.Position = carrier.Position
+		carrier.CarryingFlag = 0
+	}
+	flag.Carrier = 0
+	flag.DroppedAt = now
+	m.flagEvent(r, flag, FlagDrop, playerID, now)
+}
+
+func (m *ctfMode) flagEvent(r *Room, flag *Flag, action string, playerID int, now time.Time) {
+	r.logEvent(now, EventFlag, map[string]interface{}{
+		"action":    action,
+		"team":      flag.Team,
+		"player_id": playerID,
+		"position":  flag.Position,
+	})
+}
+
+func (m *ctfMode) OnDeath(r *Room, victim, killer *PlayerState, now time.Time) bool {
+	if victim.CarryingFlag != 0 {
+		if flag := r.flagOf(victim.CarryingFlag); flag != nil {
+			m.dropFlag(r, flag, victim, now)
+		}
+		victim.CarryingFlag = 0
+	}
+	return true
+}
+
+func (m *ctfMode) Finished(r *Room) bool {
+	return m.scores[TeamRed] >= CTFCapturesToWin || m.scores[TeamBlue] >= CTFCapturesToWin
+}
+
+func (m *ctfMode) HUD(r *Room) *ModeHUD {
+	hud := r.newModeHUD("Bring the enemy flag to your base, first to %d captures", CTFCapturesToWin)
+	hud.TeamScores = map[int]int{TeamRed: m.scores[TeamRed], TeamBlue: m.scores[TeamBlue]}
+	return hud
+}
+
+// flagOf возвращает флаг команды или nil, если флагов в режиме нет
+func (r *Room) flagOf(team int) *Flag {
+	for _, flag := range r.worldState.Flags {
+		if flag.Team == team {
+			return flag
+		}
+	}
+	return nil
+}
+
+// drawFlags рисует базы и флаги команд
+func (g *Game) drawFlags(screen *ebiten.Image) {
+	for _, flag := range g.worldState.Flags {
+		c := TeamColors[flag.Team]
+		home := g.camera.toScreen(flag.Home)
+		ebitenutil.DrawCircle(screen, home.X, home.Y, PlayerRadius+FlagRadius, color.RGBA{c.R, c.G, c.B, 40})
+		if flag.Carrier != 0 {
+			continue // Флаг рисуется над несущим, см. drawCarriedFlag
+		}
+		pos := g.camera.toScreen(flag.Position)
+		drawFlagShape(screen, pos.X, pos.Y, c)
+	}
+}
+
+// drawCarriedFlag рисует флаг над головой несущего
+func drawCarriedFlag(screen *ebiten.Image, player *PlayerState, playerPos Point) {
+	if player.CarryingFlag == 0 {
+		return
+	}
+	drawFlagShape(screen, playerPos.X+PlayerRadius, playerPos.Y-PlayerRadius, TeamColors[player.CarryingFlag])
+}
+
+func drawFlagShape(screen *ebiten.Image, x, y float64, c color.RGBA) {
+	ebitenutil.DrawLine(screen, x, y+FlagRadius, x, y-FlagRadius, color.RGBA{220, 220, 220, 255})
+	ebitenutil.DrawRect(screen, x, y-FlagRadius, FlagRadius, FlagRadius*0.7, c)
+}
+
+// drawTeamRing обводит игрока цветом его команды
+func drawTeamRing(screen *ebiten.Image, player *PlayerState, playerPos Point) {
+	c, ok := TeamColors[player.Team]
+	if !ok {
+		return
+	}
+	ebitenutil.DrawCircle(screen, playerPos.X, playerPos.Y, PlayerRadius+5, color.RGBA{c.R, c.G, c.B, 80})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	CritChance     = 0.1 // Вероятность критического удара
+	CritMultiplier = 1.5
+
+	ArmorScale              = 100.0 // Броня или сопротивление магии, вдвое уменьшающие урон
+	WarriorArmorPenetration = 0.3   // Удар воина не замечает 30% брони
+)
+
+// Hit - одно попадание. Модификаторы по очереди меняют Amount, пока
+// попадание проходит через конвейер урона.
+type Hit struct {
+	Attacker *PlayerState
+	Target   *PlayerState
+	Spec     AttackSpec
+	Distance float64 // От атакующего до основной цели
+	Splash   bool    // Задет взрывом, а не основная цель
+	Amount   float64
+	Blocked  float64 // Сколько урона сняла броня или сопротивление магии цели
+	Crit     bool    // У урона по области повторяет основную цель
+	Overtime string  // Правило овертайма матча, пустое - обычное время
+	Rand     *rand.Rand
+}
+
+// DamageModifier - шаг конвейера урона. Новые механики добавляются
+// новым модификатором, а не правкой performAttack.
+type DamageModifier interface {
+	Modify(hit *Hit)
+}
+
+// DamageModifierFunc позволяет использовать функцию как модификатор
+type DamageModifierFunc func(hit *Hit)
+
+func (f DamageModifierFunc) Modify(hit *Hit) {
+	f(hit)
+}
+
+// DefaultDamagePipeline - порядок модификаторов по умолчанию. Множители
+// перестановочны, но командные правила обнуляют урон и должны идти после
+// всего, что его увеличивает.
+func DefaultDamagePipeline() []DamageModifier {
+	return []DamageModifier{
+		DamageModifierFunc(buffModifier),
+		DamageModifierFunc(levelModifier),
+		DamageModifierFunc(rangeFalloff),
+		DamageModifierFunc(critModifier),
+		DamageModifierFunc(suddenDeathModifier),
+		DamageModifierFunc(resistanceModifier),
+		DamageModifierFunc(teamRules),
+	}
+}
+
+// calculateDamage прогоняет попадание через конвейер комнаты и возвращает
+// урон до щитов. Вызывается в горутине комнаты.
+func (r *Room) calculateDamage(hit *Hit) float64 {
+	hit.Amount = balance().Classes[hit.Attacker.Class].AttackDamage
+	hit.Rand = r.rng
+	hit.Overtime = r.worldState.Match.SuddenDeath
+	for _, modifier := range r.damage {
+		modifier.Modify(hit)
+	}
+	return math.Max(0, hit.Amount)
+}
+
+// buffModifier учитывает усиления урона атакующего
+func buffModifier(hit *Hit) {
+	hit.Amount *= damageMultiplier(hit.Attacker)
+}
+
+// levelModifier учитывает уровень атакующего
+func levelModifier(hit *Hit) {
+	hit.Amount *= levelDamageMultiplier(hit.Attacker)
+}
+
+// rangeFalloff линейно уменьшает урон дальше MaxDamageDistance,
+// но не ниже MinDamageMultiplier
+func rangeFalloff(hit *Hit) {
+	if hit.Distance <= MaxDamageDistance {
+		return
+	}
+	hit.Amount *= math.Max(MinDamageMultiplier,
+		1.0-((hit.Distance-MaxDamageDistance)/MaxDamageDistance)*(1.0-MinDamageMultiplier))
+}
+
+// critModifier бросает критический удар по основной цели
+func critModifier(hit *Hit) {
+	if !hit.Splash {
+		hit.Crit = hit.Rand.Float64() < CritChance
+	}
+	if hit.Crit {
+		hit.Amount *= CritMultiplier
+	}
+}
+
+// resistanceModifier учитывает броню и сопротивление магии цели
+func resistanceModifier(hit *Hit) {
+	before := hit.Amount
+	hit.Amount *= resistanceMultiplier(hit.Target, hit.Spec)
+	hit.Blocked = before - hit.Amount
+}
+
+// teamRules отключает урон по своим. Team 0 - без команды, такие игроки
+// бьют всех.
+func teamRules(hit *Hit) {
+	if hit.Attacker.Team != 0 && hit.Attacker.Team == hit.Target.Team {
+		hit.Amount = 0
+	}
+}
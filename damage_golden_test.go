@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.golden.json with the current results")
+
+// goldenHit - итог одного попадания в golden-файле. Числа округлены, чтобы
+// файл не зависел от последних битов вычислений.
+type goldenHit struct {
+	Name    string  `json:"name"`
+	Amount  float64 `json:"amount"`
+	Blocked float64 `json:"blocked,omitempty"`
+	Crit    bool    `json:"crit,omitempty"`
+}
+
+// damageCase - попадание, которое прогоняется через calculateDamage комнаты
+type damageCase struct {
+	name     string
+	attacker *PlayerState
+	target   *PlayerState
+	spec     *AttackSpec // nil - атака класса атакующего
+	distance float64
+	roll     *rand.Rand // nil - без крита
+	overtime string
+}
+
+func roundGolden(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// checkGolden сравнивает got с testdata/<name>.golden.json, а с -update
+// перезаписывает файл
+func checkGolden(t *testing.T, name string, got interface{}) {
+	t.Helper()
+	data, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(want, data) {
+		t.Errorf("results differ from %s, run go test -update if the change is intended:\n%s", path, data)
+	}
+}
+
+func runDamageCases(t *testing.T, name string, cases []damageCase) {
+	t.Helper()
+	r, _ := newTestRoom(testConfig())
+	var results []goldenHit
+	for _, c := range cases {
+		spec := balance().Attacks[c.attacker.Class]
+		if c.spec != nil {
+			spec = *c.spec
+		}
+		r.rng = noCritRoll
+		if c.roll != nil {
+			r.rng = c.roll
+		}
+		r.worldState.Match.SuddenDeath = c.overtime
+		hit := Hit{Attacker: c.attacker, Target: c.target, Spec: spec, Distance: c.distance}
+		amount := r.calculateDamage(&hit)
+		results = append(results, goldenHit{
+			Name:    c.name,
+			Amount:  roundGolden(amount),
+			Blocked: roundGolden(hit.Blocked),
+			Crit:    hit.Crit,
+		})
+	}
+	checkGolden(t, name, results)
+}
+
+func warrior() *PlayerState { return &PlayerState{Class: WarriorClass, Level: 1} }
+func mage() *PlayerState    { return &PlayerState{Class: MageClass, Level: 1} }
+
+func TestGoldenRangeFalloff(t *testing.T) {
+	var cases []damageCase
+	for _, d := range []struct {
+		name     string
+		distance float64
+	}{
+		{"point blank", 0},
+		{"full damage edge", MaxDamageDistance},
+		{"just past the edge", MaxDamageDistance + 0.001},
+		{"halfway", MaxDamageDistance * 1.5},
+		{"minimum edge", MaxDamageDistance * 2},
+		{"beyond minimum", MaxDamageDistance * 5},
+	} {
+		cases = append(cases, damageCase{name: d.name, attacker: warrior(), target: mage(), distance: d.distance})
+	}
+	runDamageCases(t, "falloff", cases)
+}
+
+func TestGoldenResistance(t *testing.T) {
+	slash := balance().Attacks[WarriorClass]
+	blunt, piercing, pure := slash, slash, slash
+	blunt.ArmorPenetration = 0
+	piercing.ArmorPenetration = 1
+	pure.DamageType = TrueDamage
+	runDamageCases(t, "resistance", []damageCase{
+		{name: "slash vs armor", attacker: warrior(), target: warrior()},
+		{name: "slash without penetration vs armor", attacker: warrior(), target: warrior(), spec: &blunt},
+		{name: "slash with full penetration vs armor", attacker: warrior(), target: warrior(), spec: &piercing},
+		{name: "slash vs magic resist", attacker: warrior(), target: mage()},
+		{name: "fireball vs magic resist", attacker: mage(), target: mage()},
+		{name: "fireball vs armor", attacker: mage(), target: warrior()},
+		{name: "true damage vs armor", attacker: warrior(), target: warrior(), spec: &pure},
+	})
+}
+
+func TestGoldenCrit(t *testing.T) {
+	boosted := mage()
+	boosted.Effects = []StatusEffect{{Type: EffectDamageBoost, Remaining: 1, Magnitude: 1.5}}
+	veteran := mage()
+	veteran.Level = 3
+	runDamageCases(t, "crit", []damageCase{
+		{name: "no crit", attacker: mage(), target: warrior()},
+		{name: "crit", attacker: mage(), target: warrior(), roll: critRoll},
+		{name: "crit vs magic resist", attacker: mage(), target: mage(), roll: critRoll},
+		{name: "crit with damage boost", attacker: boosted, target: warrior(), roll: critRoll},
+		{name: "crit at third level", attacker: veteran, target: warrior(), roll: critRoll},
+		{name: "crit at range", attacker: mage(), target: warrior(), roll: critRoll, distance: MaxDamageDistance * 1.5},
+		{name: "crit in double damage overtime", attacker: mage(), target: warrior(), roll: critRoll, overtime: SuddenDeathDoubleDamage},
+	})
+}
+
+func TestGoldenTeamRules(t *testing.T) {
+	teamed := func(p *PlayerState, team int) *PlayerState {
+		p.Team = team
+		return p
+	}
+	runDamageCases(t, "teams", []damageCase{
+		{name: "free for all", attacker: warrior(), target: mage()},
+		{name: "enemy team", attacker: teamed(warrior(), 1), target: teamed(mage(), 2)},
+		{name: "teammate", attacker: teamed(warrior(), 1), target: teamed(mage(), 1)},
+		{name: "teammate crit", attacker: teamed(mage(), 2), target: teamed(mage(), 2), roll: critRoll},
+		{name: "teammate in double damage overtime", attacker: teamed(warrior(), 1), target: teamed(warrior(), 1), overtime: SuddenDeathDoubleDamage},
+	})
+}
+
+// Огненный шар задевает всех в радиусе вокруг точки взрыва. Основная цель
+// получает урон один раз, задетые повторяют ее крит.
+func TestGoldenSplash(t *testing.T) {
+	type goldenSplash struct {
+		Name   string      `json:"name"`
+		Damage []goldenHit `json:"damage"`
+	}
+	var results []goldenSplash
+	for _, c := range []struct {
+		name string
+		roll *rand.Rand
+	}{
+		{"no crit", noCritRoll},
+		{"crit", critRoll},
+	} {
+		r, _ := newTestRoom(testConfig())
+		r.rng = c.roll
+		attacker := &PlayerState{ID: 1, Name: "mage", Class: MageClass, Level: 1, Health: PlayerMaxHealth, Position: Point{X: 300, Y: 500}}
+		players := []*PlayerState{
+			attacker,
+			{ID: 2, Name: "target", Class: WarriorClass, Level: 1, Position: Point{X: 500, Y: 500}},
+			{ID: 3, Name: "overlapping", Class: WarriorClass, Level: 1, Position: Point{X: 500, Y: 500}},
+			{ID: 4, Name: "near", Class: MageClass, Level: 1, Position: Point{X: 500 + DamageRadius/2, Y: 500}},
+			{ID: 5, Name: "edge", Class: MageClass, Level: 1, Position: Point{X: 500, Y: 500 + DamageRadius - 0.001}},
+			{ID: 6, Name: "outside", Class: MageClass, Level: 1, Position: Point{X: 500, Y: 500 + DamageRadius}},
+		}
+		for _, p := range players {
+			p.Health = PlayerMaxHealth
+			r.worldState.Players[p.ID] = p
+		}
+		target := players[1]
+		r.resolveHit(attacker, target, target.Position, 200, balance().Attacks[MageClass], r.clock.Now())
+
+		result := goldenSplash{Name: c.name}
+		for _, p := range players {
+			result.Damage = append(result.Damage, goldenHit{Name: p.Name, Amount: roundGolden(PlayerMaxHealth - p.Health)})
+		}
+		for _, entry := range r.logEntries {
+			if entry.EventType != EventPlayerAttack && entry.EventType != EventSplashDamage {
+				continue
+			}
+			for i, p := range players {
+				if entry.Data["target_id"] == p.ID {
+					result.Damage[i].Blocked = roundGolden(entry.Data["blocked"].(float64))
+					// У урона по области крит виден только по сумме
+					result.Damage[i].Crit, _ = entry.Data["crit"].(bool)
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	checkGolden(t, "splash", results)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// fixedSource - источник случайных чисел, который всегда возвращает одно
+// и то же. rand.Rand.Float64 от него равен v/2^63.
+type fixedSource int64
+
+func (s fixedSource) Int63() int64 { return int64(s) }
+func (s fixedSource) Seed(int64)   {}
+
+// Бросок ниже CritChance и выше него
+var (
+	critRoll   = rand.New(fixedSource(0))
+	noCritRoll = rand.New(fixedSource(1 << 62))
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// modifierCase - попадание до модификатора и то, каким оно должно стать
+type modifierCase struct {
+	name    string
+	hit     Hit
+	amount  float64
+	blocked float64
+	crit    bool
+}
+
+func checkModifier(t *testing.T, modify func(*Hit), cases []modifierCase) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hit := c.hit
+			if hit.Attacker == nil {
+				hit.Attacker = &PlayerState{Class: MageClass, Level: 1}
+			}
+			if hit.Target == nil {
+				hit.Target = &PlayerState{Class: MageClass, Level: 1}
+			}
+			if hit.Rand == nil {
+				hit.Rand = noCritRoll
+			}
+			modify(&hit)
+			if !approxEqual(hit.Amount, c.amount) {
+				t.Errorf("amount = %v, want %v", hit.Amount, c.amount)
+			}
+			if !approxEqual(hit.Blocked, c.blocked) {
+				t.Errorf("blocked = %v, want %v", hit.Blocked, c.blocked)
+			}
+			if hit.Crit != c.crit {
+				t.Errorf("crit = %v, want %v", hit.Crit, c.crit)
+			}
+		})
+	}
+}
+
+func TestRangeFalloff(t *testing.T) {
+	checkModifier(t, rangeFalloff, []modifierCase{
+		{name: "point blank", hit: Hit{Amount: 20, Distance: 0}, amount: 20},
+		{name: "full damage edge", hit: Hit{Amount: 20, Distance: MaxDamageDistance}, amount: 20},
+		{name: "halfway", hit: Hit{Amount: 20, Distance: MaxDamageDistance * 1.5}, amount: 20 * (1 - 0.5*(1-MinDamageMultiplier))},
+		{name: "minimum edge", hit: Hit{Amount: 20, Distance: MaxDamageDistance * 2}, amount: 20 * MinDamageMultiplier},
+		{name: "beyond minimum", hit: Hit{Amount: 20, Distance: MaxDamageDistance * 10}, amount: 20 * MinDamageMultiplier},
+	})
+}
+
+func TestResistanceModifier(t *testing.T) {
+	warrior := &PlayerState{Class: WarriorClass}
+	mage := &PlayerState{Class: MageClass}
+	armor := balance().Classes[WarriorClass].Armor
+	resist := balance().Classes[MageClass].MagicResist
+	halved := ArmorScale / (ArmorScale + armor)
+	pierced := ArmorScale / (ArmorScale + armor*(1-WarriorArmorPenetration))
+	checkModifier(t, resistanceModifier, []modifierCase{
+		{name: "physical vs armor", hit: Hit{Target: warrior, Spec: AttackSpec{DamageType: PhysicalDamage}, Amount: 20},
+			amount: 20 * halved, blocked: 20 * (1 - halved)},
+		{name: "physical with penetration", hit: Hit{Target: warrior, Spec: AttackSpec{DamageType: PhysicalDamage, ArmorPenetration: WarriorArmorPenetration}, Amount: 20},
+			amount: 20 * pierced, blocked: 20 * (1 - pierced)},
+		{name: "full penetration", hit: Hit{Target: warrior, Spec: AttackSpec{DamageType: PhysicalDamage, ArmorPenetration: 1}, Amount: 20},
+			amount: 20},
+		{name: "physical vs no armor", hit: Hit{Target: mage, Spec: AttackSpec{DamageType: PhysicalDamage}, Amount: 20},
+			amount: 20},
+		{name: "magical vs magic resist", hit: Hit{Target: mage, Spec: AttackSpec{DamageType: MagicalDamage}, Amount: 20},
+			amount: 20 * ArmorScale / (ArmorScale + resist), blocked: 20 * resist / (ArmorScale + resist)},
+		{name: "magical vs armor", hit: Hit{Target: warrior, Spec: AttackSpec{DamageType: MagicalDamage}, Amount: 20},
+			amount: 20},
+		{name: "true damage", hit: Hit{Target: warrior, Spec: AttackSpec{DamageType: TrueDamage}, Amount: 20},
+			amount: 20},
+	})
+}
+
+func TestBuffModifier(t *testing.T) {
+	boosted := &PlayerState{Effects: []StatusEffect{{Type: EffectDamageBoost, Remaining: 1, Magnitude: 1.5}}}
+	slowed := &PlayerState{Effects: []StatusEffect{{Type: EffectSlow, Remaining: 1, Magnitude: 0.5}}}
+	checkModifier(t, buffModifier, []modifierCase{
+		{name: "no effects", hit: Hit{Attacker: &PlayerState{}, Amount: 20}, amount: 20},
+		{name: "damage boost", hit: Hit{Attacker: boosted, Amount: 20}, amount: 30},
+		{name: "other effect", hit: Hit{Attacker: slowed, Amount: 20}, amount: 20},
+	})
+	checkModifier(t, levelModifier, []modifierCase{
+		{name: "first level", hit: Hit{Attacker: &PlayerState{Level: 1}, Amount: 20}, amount: 20},
+		{name: "unset level", hit: Hit{Attacker: &PlayerState{}, Amount: 20}, amount: 20},
+		{name: "third level", hit: Hit{Attacker: &PlayerState{Level: 3}, Amount: 20}, amount: 20 * (1 + 2*LevelDamageBonus)},
+	})
+}
+
+func TestSuddenDeathModifier(t *testing.T) {
+	checkModifier(t, suddenDeathModifier, []modifierCase{
+		{name: "regular time", hit: Hit{Amount: 20}, amount: 20},
+		{name: "double damage", hit: Hit{Amount: 20, Overtime: SuddenDeathDoubleDamage}, amount: 20 * SuddenDeathDamageMultiplier},
+		{name: "other overtime", hit: Hit{Amount: 20, Overtime: SuddenDeathNoRespawn}, amount: 20},
+	})
+}
+
+func TestCritModifier(t *testing.T) {
+	checkModifier(t, critModifier, []modifierCase{
+		{name: "crit", hit: Hit{Amount: 20, Rand: critRoll}, amount: 20 * CritMultiplier, crit: true},
+		{name: "no crit", hit: Hit{Amount: 20, Rand: noCritRoll}, amount: 20},
+		// Задетые взрывом повторяют бросок основной цели, а не бросают свой
+		{name: "splash of a crit", hit: Hit{Amount: 20, Splash: true, Crit: true, Rand: noCritRoll}, amount: 20 * CritMultiplier, crit: true},
+		{name: "splash of a miss", hit: Hit{Amount: 20, Splash: true, Rand: critRoll}, amount: 20},
+	})
+}
+
+func TestTeamRules(t *testing.T) {
+	checkModifier(t, teamRules, []modifierCase{
+		{name: "no teams", hit: Hit{Attacker: &PlayerState{}, Target: &PlayerState{}, Amount: 20}, amount: 20},
+		{name: "enemy", hit: Hit{Attacker: &PlayerState{Team: 1}, Target: &PlayerState{Team: 2}, Amount: 20}, amount: 20},
+		{name: "teammate", hit: Hit{Attacker: &PlayerState{Team: 1}, Target: &PlayerState{Team: 1}, Amount: 20}, amount: 0},
+		{name: "teamless target", hit: Hit{Attacker: &PlayerState{Team: 1}, Target: &PlayerState{}, Amount: 20}, amount: 20},
+	})
+}
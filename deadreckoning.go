@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Между снимками клиент сам двигает игроков по их направлению и скорости,
+// поэтому при заминке сети они не замирают. Когда снимок приходит,
+// расхождение с ним не убирается скачком, а сходит на нет за
+// DeadReckoningBlend.
+const (
+	DeadReckoningLimit      = 500 * time.Millisecond // Дольше без снимков игроки стоят
+	DeadReckoningBlend      = 150 * time.Millisecond
+	DeadReckoningMaxOffset  = 100.0                  // Большее расхождение (возрождение, рывок) не сглаживается
+	ConnectionUnstableAfter = 250 * time.Millisecond // Без снимков дольше этого показываем предупреждение
+)
+
+// correctReckoning запоминает, насколько нарисованные позиции разошлись с
+// новым снимком. Вызывается в игровом цикле до замены состояния.
+func (g *Game) correctReckoning(state WorldState) {
+	g.reckoning = make(map[int]Point, len(state.Players))
+	for id, player := range state.Players {
+		shown, ok := g.playerPositions[id]
+		if !ok {
+			continue
+		}
+		offset := Point{X: shown.X - player.Position.X, Y: shown.Y - player.Position.Y}
+		if math.Hypot(offset.X, offset.Y) <= DeadReckoningMaxOffset {
+			g.reckoning[id] = offset
+		}
+	}
+}
+
+// updateDeadReckoning продвигает игроков от последнего снимка, но не
+// дальше чем на DeadReckoningLimit. Отброшенных досчитывает
+// updateKnockbacks. Вызывается в игровом цикле.
+func (g *Game) updateDeadReckoning(now time.Time) {
+	elapsed := now.Sub(g.stateReceived)
+	ahead := min(elapsed, DeadReckoningLimit).Seconds()
+	if g.worldState.Paused {
+		ahead = 0
+	}
+	blend := math.Max(0, 1-elapsed.Seconds()/DeadReckoningBlend.Seconds())
+	for id, player := range g.worldState.Players {
+		if player.Knockback != nil || player.Eliminated {
+			continue
+		}
+		pos := player.Position
+		if dir := player.MovingDirection; dir.X != 0 || dir.Y != 0 {
+			speed := g.rules.Classes[player.Class].MoveSpeed * moveSpeedMultiplier(player)
+			pos.X += dir.X * speed * ahead
+			pos.Y += dir.Y * speed * ahead
+		}
+		if offset, ok := g.reckoning[id]; ok {
+			pos.X += offset.X * blend
+			pos.Y += offset.Y * blend
+		}
+		g.playerPositions[id] = Point{
+			X: math.Max(0, math.Min(pos.X, g.worldWidth)),
+			Y: math.Max(0, math.Min(pos.Y, g.worldHeight)),
+		}
+	}
+}
+
+// drawConnectionWarning предупреждает, что снимки давно не приходили
+func (g *Game) drawConnectionWarning(screen *ebiten.Image, hud hudLayout) {
+	if g.worldState.Seq == 0 || time.Since(g.stateReceived) < ConnectionUnstableAfter {
+		return
+	}
+	text := tr("Connection unstable")
+	x, y := hud.centerText(text), hud.h-HUDMargin-CooldownSlotHeight-HUDLine-30
+	ebitenutil.DrawRect(screen, float64(x-4), float64(y-2), float64(textWidth(text)+8), 18, color.RGBA{200, 140, 0, 180})
+	drawText(screen, text, x, y)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+
+	"meatgrinder/protocol"
+)
+
+// Сводка смерти: кто добил игрока и кто бил его последние
+// DeathRecapWindow. Клиент собирает ее сам из событий атак, поэтому урон
+// от горения и зоны, которого в событиях нет, в сводку не попадает.
+const (
+	DeathRecapWindow = 5 * time.Second
+	DeathRecapRows   = 5 // Источников в списке, остальные отбрасываются
+)
+
+// damageTaken - удар по своему игроку из события
+type damageTaken struct {
+	At     time.Time
+	Source string // Имя на момент удара: к смерти нападавший может уйти из обзора
+	Attack string
+	Amount float64
+	Crit   bool
+}
+
+// damageSource - урон одного источника за окно сводки
+type damageSource struct {
+	Source string
+	Attack string
+	Hits   int
+	Total  float64
+}
+
+// deathRecap - то, что показывает экран смерти
+type deathRecap struct {
+	killer      string         // Пусто, если игрок погиб сам
+	killingBlow *damageTaken   // Последний удар убийцы, nil - не видели
+	sources     []damageSource // По убыванию урона
+	total       float64
+}
+
+// trackDamageTaken запоминает удары по своему игроку. Вызывается в игровом
+// цикле.
+func (g *Game) trackDamageTaken(event protocol.Event, now time.Time) {
+	if event.Type != EventPlayerAttack && event.Type != EventSplashDamage {
+		return
+	}
+	var e CombatEvent
+	if !decodeEvent(event, &e) || e.TargetID != g.playerID {
+		return
+	}
+	source := g.logName(e.AttackerID, "")
+	if e.MinionID != 0 {
+		source = trf("%s's minion", source)
+	}
+	g.damageTaken = append(g.damageTaken, damageTaken{At: now, Source: source, Attack: e.Attack, Amount: e.Damage, Crit: e.Crit})
+	// Старше окна сводки удары уже не нужны
+	for len(g.damageTaken) > 0 && now.Sub(g.damageTaken[0].At) > DeathRecapWindow {
+		g.damageTaken = g.damageTaken[1:]
+	}
+}
+
+// newDeathRecap собирает сводку из ударов последних DeathRecapWindow.
+// Вызывается в игровом цикле.
+func (g *Game) newDeathRecap(death DeathEvent, now time.Time) deathRecap {
+	recap := deathRecap{}
+	if death.KillerName != "" {
+		recap.killer = feedName(death.KillerName, death.KillerBot)
+	}
+	bySource := make(map[string]*damageSource)
+	for _, hit := range g.damageTaken {
+		if now.Sub(hit.At) > DeathRecapWindow {
+			continue
+		}
+		if hit.Source == recap.killer {
+			recap.killingBlow = &hit
+		}
+		key := hit.Source + "\x00" + hit.Attack
+		source, ok := bySource[key]
+		if !ok {
+			source = &damageSource{Source: hit.Source, Attack: hit.Attack}
+			bySource[key] = source
+		}
+		source.Hits++
+		source.Total += hit.Amount
+		recap.total += hit.Amount
+	}
+	for _, source := range bySource {
+		recap.sources = append(recap.sources, *source)
+	}
+	sort.Slice(recap.sources, func(i, j int) bool {
+		if recap.sources[i].Total != recap.sources[j].Total {
+			return recap.sources[i].Total > recap.sources[j].Total
+		}
+		return recap.sources[i].Source < recap.sources[j].Source
+	})
+	if len(recap.sources) > DeathRecapRows {
+		recap.sources = recap.sources[:DeathRecapRows]
+	}
+	g.damageTaken = nil
+	return recap
+}
+
+// drawDeathRecap рисует сводку смерти и отсчет до возрождения
+func drawDeathRecap(screen *ebiten.Image, recap deathRecap, until time.Time) {
+	const width, row = 360, 16
+	top := ScreenHeight/2 - 60
+	height := 4*row + len(recap.sources)*row + 16
+	if len(recap.sources) == 0 {
+		height += row
+	}
+	ebitenutil.DrawRect(screen, 0, 0, ScreenWidth, ScreenHeight, color.RGBA{80, 0, 0, 100})
+	ebitenutil.DrawRect(screen, ScreenWidth/2-width/2, float64(top-8), width, float64(height), color.RGBA{0, 0, 0, 180})
+
+	drawCentered(screen, tr("YOU DIED"), top)
+	y := top + row
+	if recap.killer != "" {
+		text := trf("Killed by %s", recap.killer)
+		if blow := recap.killingBlow; blow != nil {
+			text += fmt.Sprintf(" (%s, %.0f)", blow.Attack, blow.Amount)
+			if blow.Crit {
+				text += " " + tr("(crit)")
+			}
+		}
+		drawCentered(screen, text, y)
+	}
+	y += row + 4
+
+	left := ScreenWidth/2 - width/2 + 10
+	drawText(screen, trf("Damage taken in the last %.0f s: %.0f", DeathRecapWindow.Seconds(), recap.total), left, y)
+	y += row
+	if len(recap.sources) == 0 {
+		drawText(screen, tr("  no hits seen"), left, y)
+		y += row
+	}
+	for _, source := range recap.sources {
+		line := fmt.Sprintf("  %-24s %-9s x%-2d %4.0f", source.Source, source.Attack, source.Hits, source.Total)
+		drawText(screen, line, left, y)
+		y += row
+	}
+
+	remaining := math.Ceil(time.Until(until).Seconds())
+	drawCentered(screen, trf("Back in %.0f", math.Max(0, remaining)), y+4)
+}
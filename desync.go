@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+
+	"meatgrinder/protocol"
+)
+
+// Раз в DesyncCheckInterval рассылок снимок несет контрольную сумму того,
+// что сервер положил в него для этого клиента. Клиент считает сумму по
+// принятому состоянию и при расхождении просит полный снимок, а в лог
+// пишет, насколько его предсказание разошлось с сервером.
+const (
+	DesyncCheckInterval = 30
+	EventResync         = "resync"
+)
+
+// entityChecksum - сумма одной сущности. kind различает игроков, предметы,
+// снаряды и прислужников с одинаковыми ID.
+func entityChecksum(kind byte, id int, p Point, health float64) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	h.Write([]byte{kind})
+	for _, v := range []uint64{uint64(id), math.Float64bits(p.X), math.Float64bits(p.Y), math.Float64bits(health)} {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// viewChecksum - сумма сущностей состояния, которые видит view (nil - все):
+// позиции всех сущностей и здоровье игроков. Здоровье скрытых от view
+// игроков не уходит клиенту и считается нулевым. Суммы сущностей
+// складываются по XOR, поэтому порядок обхода не важен.
+func (w *WorldState) viewChecksum(view *stateView) uint64 {
+	var sum uint64
+	add := func(kind byte, id int, p Point, health float64) {
+		sum ^= entityChecksum(kind, id, p, health)
+	}
+	players, items, projectiles, minions := sortedIDs(w.Players), sortedIDs(w.Items), sortedIDs(w.Projectiles), sortedIDs(w.Minions)
+	if view != nil {
+		players, items, projectiles, minions = view.players, view.items, view.projectiles, view.minions
+	}
+	for _, id := range players {
+		if p, ok := w.Players[id]; ok {
+			health := p.Health
+			if view != nil && view.concealed[id] {
+				health = 0
+			}
+			add('p', id, p.Position, health)
+		}
+	}
+	for _, id := range items {
+		if item, ok := w.Items[id]; ok {
+			add('i', id, item.Position, 0)
+		}
+	}
+	for _, id := range projectiles {
+		if projectile, ok := w.Projectiles[id]; ok {
+			add('s', id, projectile.Position, 0)
+		}
+	}
+	for _, id := range minions {
+		if minion, ok := w.Minions[id]; ok {
+			add('m', id, minion.Position, 0)
+		}
+	}
+	return sum
+}
+
+// desyncCheck сообщает, что рассылка с этим номером несет контрольную сумму
+func desyncCheck(seq uint64) bool {
+	return seq%DesyncCheckInterval == 0
+}
+
+// checkDesync сверяет контрольную сумму принятого снимка. Вызывается в
+// игровом цикле до замены состояния: playerPositions еще хранит
+// предсказанные позиции, и по ним в лог пишется, кто разошелся сильнее
+// всех. false - состояние разошлось с сервером.
+func (g *Game) checkDesync(state WorldState) bool {
+	if state.Checksum == 0 {
+		return true
+	}
+	local := state.viewChecksum(nil)
+	if local == state.Checksum {
+		return true
+	}
+	worstID, worst := 0, 0.0
+	for id, player := range state.Players {
+		predicted, ok := g.playerPositions[id]
+		if !ok {
+			continue
+		}
+		if d := math.Hypot(predicted.X-player.Position.X, predicted.Y-player.Position.Y); d > worst {
+			worstID, worst = id, d
+		}
+	}
+	clientLog.Warn("State desync", "tick", state.Tick, "checksum", state.Checksum, "local", local,
+		"players", len(state.Players), "worst_player", worstID, "worst_offset", math.Round(worst*10)/10)
+	return false
+}
+
+// logResync записывает в лог комнаты запрос полного снимка с причиной от
+// клиента
+func (r *Room) logResync(playerID int, req protocol.Resync) {
+	r.do(func() {
+		r.logEvent(r.clock.Now(), EventResync, map[string]interface{}{
+			"player_id": playerID,
+			"reason":    req.Reason,
+			"tick":      req.Tick,
+		})
+		r.log.Info("Resync requested", "player_id", playerID, "reason", req.Reason, "client_tick", req.Tick, "tick", r.tick)
+	})
+}
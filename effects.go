@@ -0,0 +1,194 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+)
+
+// Эффекты состояния
+const (
+	EffectSlow         = "slow"         // Magnitude - доля замедления (0.3 = -30% скорости)
+	EffectBurn         = "burn"         // Magnitude - урон в секунду за один стак
+	EffectShield       = "shield"       // Magnitude - сколько урона еще поглотит щит
+	EffectDamageBoost  = "damage_boost" // Magnitude - множитель урона
+	EventEffectApplied = "effect_applied"
+	EventEffectExpired = "effect_expired"
+)
+
+const (
+	MaxBurnStacks   = 3    // Горение складывается не более чем в 3 стака
+	MaxSlow         = 0.8  // Замедление не может отнять больше 80% скорости
+	MaxShield       = 50.0 // Максимальный запас щита
+	WarriorSlow     = 0.3  // Удар воина замедляет цель на 30%
+	WarriorSlowTime = 1.5
+	MageBurnDPS     = 3.0 // Огонь мага поджигает цель
+	MageBurnTime    = 3.0
+)
+
+var EffectColors = map[string]color.RGBA{
+	EffectSlow:        {0, 200, 255, 255},
+	EffectBurn:        {255, 120, 0, 255},
+	EffectShield:      {180, 180, 255, 255},
+	EffectDamageBoost: {255, 200, 0, 255},
+	EffectStealth:     {120, 120, 160, 255},
+}
+
+var EffectIcons = map[string]string{
+	EffectSlow:        "S",
+	EffectBurn:        "B",
+	EffectShield:      "O",
+	EffectDamageBoost: "D",
+	EffectStealth:     "I",
+}
+
+type StatusEffect struct {
+	Type      string  `json:"type"`
+	Remaining float64 `json:"remaining"` // Оставшееся время в секундах
+	Magnitude float64 `json:"magnitude"`
+	Stacks    int     `json:"stacks"`
+	SourceID  int     `json:"source_id"`
+}
+
+// applyEffect накладывает эффект на игрока с учетом правил стакания:
+//   - замедление: остается сильнейшее, длительность обновляется;
+//   - горение: до MaxBurnStacks стаков, длительность обновляется;
+//   - щит: запас складывается (не больше MaxShield);
+//   - усиление урона: берется больший множитель, длительность обновляется.
+func applyEffect(p *PlayerState, effect StatusEffect) {
+	if effect.Stacks == 0 {
+		effect.Stacks = 1
+	}
+	for i := range p.Effects {
+		existing := &p.Effects[i]
+		if existing.Type != effect.Type {
+			continue
+		}
+		existing.Remaining = math.Max(existing.Remaining, effect.Remaining)
+		existing.SourceID = effect.SourceID
+		switch effect.Type {
+		case EffectSlow:
+			existing.Magnitude = math.Min(MaxSlow, math.Max(existing.Magnitude, effect.Magnitude))
+		case EffectBurn:
+			existing.Stacks = min(MaxBurnStacks, existing.Stacks+effect.Stacks)
+			existing.Magnitude = math.Max(existing.Magnitude, effect.Magnitude)
+		case EffectShield:
+			existing.Magnitude = math.Min(MaxShield, existing.Magnitude+effect.Magnitude)
+		default:
+			existing.Magnitude = math.Max(existing.Magnitude, effect.Magnitude)
+		}
+		return
+	}
+
+	switch effect.Type {
+	case EffectSlow:
+		effect.Magnitude = math.Min(MaxSlow, effect.Magnitude)
+	case EffectShield:
+		effect.Magnitude = math.Min(MaxShield, effect.Magnitude)
+	}
+	p.Effects = append(p.Effects, effect)
+}
+
+func findEffect(p *PlayerState, effectType string) *StatusEffect {
+	for i := range p.Effects {
+		if p.Effects[i].Type == effectType {
+			return &p.Effects[i]
+		}
+	}
+	return nil
+}
+
+// moveSpeedMultiplier учитывает замедления и рывок
+func moveSpeedMultiplier(p *PlayerState) float64 {
+	multiplier := levelSpeedMultiplier(p)
+	if slow := findEffect(p, EffectSlow); slow != nil {
+		multiplier = 1.0 - slow.Magnitude
+	}
+	if sprintActive(p) {
+		multiplier *= SprintMultiplier
+	}
+	if p.CarryingFlag != 0 {
+		multiplier *= FlagCarrierSpeedMult
+	}
+	return multiplier
+}
+
+// damageMultiplier учитывает усиления урона
+func damageMultiplier(p *PlayerState) float64 {
+	if boost := findEffect(p, EffectDamageBoost); boost != nil {
+		return boost.Magnitude
+	}
+	return 1.0
+}
+
+// damagePlayer наносит урон с учетом щита и возвращает урон, дошедший до здоровья
+func damagePlayer(p *PlayerState, amount float64) float64 {
+	if shield := findEffect(p, EffectShield); shield != nil {
+		absorbed := math.Min(shield.Magnitude, amount)
+		shield.Magnitude -= absorbed
+		amount -= absorbed
+		if shield.Magnitude <= 0 {
+			shield.Remaining = 0
+		}
+	}
+	p.Health -= amount
+	if p.Health < 0 {
+		p.Health = 0
+	}
+	return amount
+}
+
+// applyOnHitEffect накладывает эффект атаки на основную цель
+func (r *Room) applyOnHitEffect(attacker, target *PlayerState, spec AttackSpec, now time.Time) {
+	if spec.OnHit.Type == "" {
+		return
+	}
+	effect := spec.OnHit
+	effect.SourceID = attacker.ID
+	r.addEffect(target, effect, now)
+}
+
+// addEffect накладывает эффект и пишет событие в лог. Вызывается в горутине комнаты.
+func (r *Room) addEffect(p *PlayerState, effect StatusEffect, now time.Time) {
+	applyEffect(p, effect)
+
+	r.logEvent(now, EventEffectApplied, map[string]interface{}{
+		"player_id": p.ID,
+		"effect":    effect.Type,
+		"source_id": effect.SourceID,
+		"duration":  effect.Remaining,
+	})
+}
+
+// tickEffects уменьшает длительность эффектов, наносит урон от горения
+// и снимает истекшие эффекты. Вызывается в горутине комнаты.
+func (r *Room) tickEffects(deltaTime float64, now time.Time) {
+	for _, player := range r.worldState.Players {
+		if len(player.Effects) == 0 {
+			continue
+		}
+
+		if burn := findEffect(player, EffectBurn); burn != nil {
+			amount := damagePlayer(player, burn.Magnitude*float64(burn.Stacks)*deltaTime)
+			recordDamage(r.worldState.Players[burn.SourceID], player, amount)
+			player.LastDamagedBy = burn.SourceID
+			r.addThreat(player, burn.SourceID, amount)
+		}
+
+		active := player.Effects[:0]
+		for _, effect := range player.Effects {
+			effect.Remaining -= deltaTime
+			if effect.Remaining > 0 {
+				active = append(active, effect)
+				continue
+			}
+
+			r.logEvent(now, EventEffectExpired, map[string]interface{}{
+				"player_id": player.ID,
+				"effect":    effect.Type,
+			})
+			simLog.Debug("Effect expired", "effect", effect.Type, "player_id", player.ID)
+		}
+		player.Effects = active
+	}
+}
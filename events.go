@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// DeathEvent - данные события player_died
+type DeathEvent struct {
+	PlayerID int    `json:"player_id"`
+	KillerID int    `json:"killer_id"`
+	Position *Point `json:"position"` // nil - игрок не виден
+	Name     string `json:"name"`
+	Class    int    `json:"class"`
+	Bot      bool   `json:"bot"`
+
+	// Пусто, если игрок погиб сам или убийцы уже нет
+	KillerName  string `json:"killer_name"`
+	KillerClass int    `json:"killer_class"`
+	KillerBot   bool   `json:"killer_bot"`
+}
+
+// LevelUpEvent - данные события level_up
+type LevelUpEvent struct {
+	PlayerID int `json:"player_id"`
+	Level    int `json:"level"`
+}
+
+// RespawnEvent - данные события player_respawned
+type RespawnEvent struct {
+	PlayerID int    `json:"player_id"`
+	Position *Point `json:"position"` // nil - игрок не виден
+}
+
+// handleEvent реагирует на событие сервера эффектами, звуками и записью в
+// журнал боя.
+// Вызывается в игровом цикле.
+func (g *Game) handleEvent(event protocol.Event, now time.Time) {
+	g.addCombatLog(event, now)
+	g.trackDamageTaken(event, now)
+	switch event.Type {
+	case EventPlayerDeath:
+		var death DeathEvent
+		if !decodeEvent(event, &death) {
+			return
+		}
+		g.addKill(death, now)
+		if death.PlayerID == g.playerID {
+			g.showDeathScreen(death, now)
+		}
+		if death.Position != nil {
+			g.corpses = append(g.corpses, corpse{Class: death.Class, Position: *death.Position, Died: now})
+			g.playAt(SoundDeath, *death.Position)
+		}
+	case EventLevelUp:
+		var levelUp LevelUpEvent
+		if !decodeEvent(event, &levelUp) || levelUp.PlayerID != g.playerID {
+			return
+		}
+		g.levelUpAt = now
+		if g.sound != nil {
+			g.sound.play(SoundRespawn, effectiveVolume(g.settings))
+		}
+	case EventPlayerRespawn:
+		var respawn RespawnEvent
+		if !decodeEvent(event, &respawn) {
+			return
+		}
+		if respawn.PlayerID == g.playerID && g.sound != nil {
+			g.sound.play(SoundRespawn, effectiveVolume(g.settings))
+		}
+	}
+}
+
+func decodeEvent(event protocol.Event, v interface{}) bool {
+	if err := json.Unmarshal(event.Data, v); err != nil {
+		clientLog.Error("Invalid event", "event", event.Type, "err", err)
+		return false
+	}
+	return true
+}
+
+// showDeathScreen переключает игру на экран смерти. Вызывается в игровом цикле.
+func (g *Game) showDeathScreen(death DeathEvent, now time.Time) {
+	if _, playing := g.scene.(playScene); !playing {
+		return
+	}
+	g.scene = deadScene{recap: g.newDeathRecap(death, now), until: now.Add(DeathScreenDuration)}
+
+	// Пока показан экран смерти, ввод не читается - останавливаемся
+	g.keyDirection = Point{}
+	g.sendActionToServer(PlayerAction{ActionType: "move"})
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Выгрузка лога событий для разбора матчей и настройки баланса. Записи
+// копятся пачками и уходят в CSV-файл (-export-csv) или POST-запросом с
+// JSON по строке на запись (-export-url), например в ClickHouse:
+//
+//	http://clickhouse:8123/?query=INSERT%20INTO%20events%20FORMAT%20JSONEachRow
+const (
+	ExportQueueSize     = 4096
+	ExportBatchSize     = 500
+	ExportFlushInterval = 2 * time.Second
+	ExportTimeout       = 10 * time.Second
+	ExportMaxBackoff    = 30 * time.Second
+)
+
+// ExportRecord - запись лога с комнатой. Data закодирована заранее, в
+// горутине комнаты.
+type ExportRecord struct {
+	Room      string          `json:"room"`
+	Tick      uint64          `json:"tick"`
+	Timestamp time.Time       `json:"timestamp"`
+	Event     string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// exportSink - куда уходят пачки записей
+type exportSink interface {
+	write(batch []ExportRecord) error
+	close() error
+}
+
+// eventExporter пишет записи в фоне. Пока приемник недоступен, пачка
+// повторяется с растущей паузой, а новые записи ждут в очереди; когда
+// очередь переполнена, записи теряются и считаются в метриках. Тики
+// комнат выгрузка не тормозит никогда.
+type eventExporter struct {
+	queue   chan ExportRecord
+	sinks   []exportSink
+	closing chan struct{} // Закрывается в Close: повторы прекращаются
+	done    chan struct{}
+}
+
+// newEventExporter возвращает nil, если выгрузка не настроена
+func newEventExporter(cfg Config) (*eventExporter, error) {
+	var sinks []exportSink
+	if cfg.ExportCSV != "" {
+		sink, err := newCSVSink(cfg.ExportCSV, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.ExportURL != "" {
+		sinks = append(sinks, &httpSink{url: cfg.ExportURL, client: &http.Client{Timeout: ExportTimeout}})
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	e := &eventExporter{
+		queue:   make(chan ExportRecord, ExportQueueSize),
+		sinks:   sinks,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// export ставит запись в очередь. Вызывается в горутине комнаты.
+func (e *eventExporter) export(room string, entry LogEntry) {
+	if e == nil {
+		return
+	}
+	data, err := json.Marshal(entry.Data)
+	if err != nil {
+		netLog.Error("Error encoding exported event", "event", entry.EventType, "err", err)
+		return
+	}
+	record := ExportRecord{Room: room, Tick: entry.Tick, Timestamp: entry.Timestamp, Event: entry.EventType, Data: data}
+	select {
+	case e.queue <- record:
+	default:
+		metrics.ExportDropped(1)
+	}
+}
+
+// Close дописывает очередь и закрывает приемники. Очередь не закрывается:
+// комнаты могут писать в лог и после остановки, такие записи теряются.
+func (e *eventExporter) Close() {
+	if e == nil {
+		return
+	}
+	close(e.closing)
+	<-e.done
+}
+
+func (e *eventExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(ExportFlushInterval)
+	defer ticker.Stop()
+	batch := make([]ExportRecord, 0, ExportBatchSize)
+	for {
+		select {
+		case record := <-e.queue:
+			if batch = append(batch, record); len(batch) < ExportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-e.closing:
+			e.drain(batch)
+			return
+		}
+		e.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// drain отправляет то, что уже в очереди, и закрывает приемники
+func (e *eventExporter) drain(batch []ExportRecord) {
+	for len(e.queue) > 0 {
+		if batch = append(batch, <-e.queue); len(batch) == ExportBatchSize {
+			e.flush(batch)
+			batch = batch[:0]
+		}
+	}
+	e.flush(batch)
+	for _, sink := range e.sinks {
+		if err := sink.close(); err != nil {
+			netLog.Error("Error closing event export", "err", err)
+		}
+	}
+}
+
+// flush отправляет пачку во все приемники, повторяя, пока не получится
+// или пока экспорт не закроют
+func (e *eventExporter) flush(batch []ExportRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	written := true
+	for _, sink := range e.sinks {
+		written = e.flushSink(sink, batch) && written
+	}
+	if written {
+		metrics.Exported(len(batch))
+	} else {
+		metrics.ExportDropped(len(batch))
+	}
+}
+
+func (e *eventExporter) flushSink(sink exportSink, batch []ExportRecord) bool {
+	backoff := time.Second
+	for {
+		err := sink.write(batch)
+		if err == nil {
+			return true
+		}
+		select {
+		case <-e.closing:
+			netLog.Error("Error exporting events, dropping batch", "records", len(batch), "err", err)
+			return false
+		default:
+		}
+		netLog.Warn("Error exporting events, retrying", "records", len(batch), "retry_in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-e.closing:
+		}
+		backoff = min(2*backoff, ExportMaxBackoff)
+	}
+}
+
+// csvSink пишет записи в файл events-<время запуска>.csv в каталоге
+type csvSink struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+func newCSVSink(dir string, now time.Time) (*csvSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "events-"+now.UTC().Format("20060102-150405")+".csv")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"room", "tick", "timestamp", "event", "data"})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	netLog.Info("Exporting events to CSV", "path", path)
+	return &csvSink{file: file, w: w}, nil
+}
+
+func (s *csvSink) write(batch []ExportRecord) error {
+	for _, record := range batch {
+		s.w.Write([]string{
+			record.Room,
+			strconv.FormatUint(record.Tick, 10),
+			record.Timestamp.UTC().Format(time.RFC3339Nano),
+			record.Event,
+			string(record.Data),
+		})
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) close() error {
+	return s.file.Close()
+}
+
+// httpSink отправляет пачку одним POST-запросом, по JSON-объекту на строку
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) write(batch []ExportRecord) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, record := range batch {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	resp, err := s.client.Post(s.url, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s replied %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) close() error {
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+
+	"meatgrinder/protocol"
+)
+
+// Опасные зоны карты
+const (
+	HazardLava  = "lava"  // Жжет, пока стоишь
+	HazardSwamp = "swamp" // Замедляет
+	HazardTrap  = "trap"  // Ранит и замедляет
+
+	// Замедление от зоны держится чуть дольше тика, чтобы не мигать
+	HazardSlowDuration = 0.25
+)
+
+// HazardDefaults - урон в секунду и замедление, если в карте они не заданы
+var HazardDefaults = map[string]Hazard{
+	HazardLava:  {Damage: 20},
+	HazardSwamp: {Slow: 0.4},
+	HazardTrap:  {Damage: 8, Slow: 0.5},
+}
+
+var HazardColors = map[string]color.RGBA{
+	HazardLava:  {255, 80, 0, 90},
+	HazardSwamp: {60, 140, 60, 90},
+	HazardTrap:  {160, 60, 200, 90},
+}
+
+// GameMap - карта из JSON-файла. Нулевые размеры - берутся из флагов.
+type GameMap struct {
+	Name      string       `json:"name"` // Пустое - имя файла без расширения
+	Width     float64      `json:"width"`
+	Height    float64      `json:"height"`
+	Hazards   []Hazard     `json:"hazards"`
+	Obstacles []Obstacle   `json:"obstacles"`
+	Spawns    []SpawnPoint `json:"spawns,omitempty"` // Точки возрождения, пусто - любое место
+	Items     []ItemSpot   `json:"items,omitempty"`  // Места предметов, пусто - любое место
+
+	Hash string `json:"-"` // Хеш того, что получает клиент, см. resolve
+}
+
+// SpawnPoint - точка возрождения. Точки с Team достаются только этой
+// команде, без нее - всем.
+type SpawnPoint struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Team int     `json:"team,omitempty"`
+}
+
+// ItemSpot - место на карте, где появляются предметы
+type ItemSpot struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Type string  `json:"type,omitempty"` // Какой предмет, см. ItemKinds. Пустое - любой.
+}
+
+// ItemKinds - названия предметов в файле карты
+var ItemKinds = map[string]int{
+	"health":       HealthPackItem,
+	"damage_boost": DamageBoostItem,
+	"shield":       ShieldItem,
+}
+
+// resolve подставляет размер мира из флагов, если карта его не задала, и
+// считает хеш карты
+func (m *GameMap) resolve(width, height float64) {
+	if m.Width <= 0 || m.Height <= 0 {
+		m.Width, m.Height = width, height
+	}
+	m.Hash = ""
+	m.Hash = mapHash(m.message())
+}
+
+// message - карта для клиента
+func (m *GameMap) message() protocol.MapData {
+	data := protocol.MapData{Name: m.Name, Hash: m.Hash, Width: m.Width, Height: m.Height}
+	for _, hazard := range m.Hazards {
+		data.Hazards = append(data.Hazards, hazard.message())
+	}
+	for _, obstacle := range m.Obstacles {
+		data.Obstacles = append(data.Obstacles, obstacle.message())
+	}
+	return data
+}
+
+func (m *GameMap) ref() *protocol.MapRef {
+	return &protocol.MapRef{Name: m.Name, Hash: m.Hash}
+}
+
+// mapHash - хеш карты без поля Hash. Его же считает клиент, проверяя
+// карту из кеша или от сервера.
+func mapHash(data protocol.MapData) string {
+	data.Hash = ""
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Hazard - прямоугольная опасная зона
+type Hazard struct {
+	Type   string  `json:"type"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Damage float64 `json:"damage,omitempty"` // Урон в секунду
+	Slow   float64 `json:"slow,omitempty"`   // Доля замедления, как у EffectSlow
+}
+
+func (h Hazard) contains(p Point) bool {
+	return p.X >= h.X && p.X <= h.X+h.Width && p.Y >= h.Y && p.Y <= h.Y+h.Height
+}
+
+func (h Hazard) message() protocol.Hazard {
+	return protocol.Hazard{Type: h.Type, X: h.X, Y: h.Y, Width: h.Width, Height: h.Height}
+}
+
+// loadMap читает карту и подставляет значения по умолчанию
+func loadMap(path string) (*GameMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m GameMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if m.Width < 0 || m.Height < 0 {
+		return nil, fmt.Errorf("%s: invalid size %gx%g", path, m.Width, m.Height)
+	}
+	for i := range m.Hazards {
+		hazard := &m.Hazards[i]
+		defaults, ok := HazardDefaults[hazard.Type]
+		if !ok {
+			return nil, fmt.Errorf("%s: hazard %d: unknown type %q", path, i, hazard.Type)
+		}
+		if hazard.Width <= 0 || hazard.Height <= 0 {
+			return nil, fmt.Errorf("%s: hazard %d: invalid size %gx%g", path, i, hazard.Width, hazard.Height)
+		}
+		if hazard.Damage == 0 && hazard.Slow == 0 {
+			hazard.Damage, hazard.Slow = defaults.Damage, defaults.Slow
+		}
+	}
+	for i, obstacle := range m.Obstacles {
+		if obstacle.Width <= 0 || obstacle.Height <= 0 {
+			return nil, fmt.Errorf("%s: obstacle %d: invalid size %gx%g", path, i, obstacle.Width, obstacle.Height)
+		}
+	}
+	for i, spawn := range m.Spawns {
+		if spawn.X < 0 || spawn.Y < 0 {
+			return nil, fmt.Errorf("%s: spawn %d: outside the world", path, i)
+		}
+		if _, ok := TeamNames[spawn.Team]; spawn.Team != 0 && !ok {
+			return nil, fmt.Errorf("%s: spawn %d: unknown team %d", path, i, spawn.Team)
+		}
+	}
+	for i, spot := range m.Items {
+		if spot.X < 0 || spot.Y < 0 {
+			return nil, fmt.Errorf("%s: item %d: outside the world", path, i)
+		}
+		if _, ok := ItemKinds[spot.Type]; spot.Type != "" && !ok {
+			return nil, fmt.Errorf("%s: item %d: unknown type %q", path, i, spot.Type)
+		}
+	}
+	return &m, nil
+}
+
+// itemSpot - где и какой предмет создать: свободное место из карты или
+// случайное место. false - все места карты заняты. Вызывается в горутине
+// комнаты.
+func (r *Room) itemSpot() (Point, int, bool) {
+	if r.cfg.Map == nil || len(r.cfg.Map.Items) == 0 {
+		itemType := r.rng.Intn(TotalItemTypes)
+		return r.randomPosition(), itemType, true
+	}
+	var free []ItemSpot
+	for _, spot := range r.cfg.Map.Items {
+		occupied := false
+		for _, item := range r.worldState.Items {
+			if item.Position == (Point{X: spot.X, Y: spot.Y}) {
+				occupied = true
+				break
+			}
+		}
+		if !occupied {
+			free = append(free, spot)
+		}
+	}
+	if len(free) == 0 {
+		return Point{}, 0, false
+	}
+	spot := free[r.rng.Intn(len(free))]
+	itemType, ok := ItemKinds[spot.Type]
+	if !ok {
+		itemType = r.rng.Intn(TotalItemTypes)
+	}
+	return Point{X: spot.X, Y: spot.Y}, itemType, true
+}
+
+// hazards возвращает опасные зоны карты комнаты
+func (r *Room) hazards() []Hazard {
+	if r.cfg.Map == nil {
+		return nil
+	}
+	return r.cfg.Map.Hazards
+}
+
+// applyHazards ранит и замедляет тех, кто стоит в опасных зонах.
+// Вызывается в горутине комнаты.
+func (r *Room) applyHazards(deltaTime float64) {
+	hazards := r.hazards()
+	if len(hazards) == 0 {
+		return
+	}
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		if player.Eliminated {
+			continue
+		}
+		for _, hazard := range hazards {
+			if !hazard.contains(player.Position) {
+				continue
+			}
+			if hazard.Damage > 0 {
+				recordDamage(nil, player, damagePlayer(player, hazard.Damage*deltaTime))
+			}
+			if hazard.Slow > 0 {
+				applyEffect(player, StatusEffect{Type: EffectSlow, Remaining: HazardSlowDuration, Magnitude: hazard.Slow})
+			}
+		}
+	}
+}
+
+func (g *Game) drawHazards(screen *ebiten.Image) {
+	for _, hazard := range g.hazards {
+		pos := g.camera.toScreen(Point{X: hazard.X, Y: hazard.Y})
+		ebitenutil.DrawRect(screen, pos.X, pos.Y, hazard.Width, hazard.Height, HazardColors[hazard.Type])
+	}
+}
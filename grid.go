@@ -0,0 +1,63 @@
+package main
+
+import "math"
+
+// Размер ячейки сетки. Порядка радиуса обзора, чтобы запрос задевал
+// всего несколько ячеек.
+const GridCellSize = 256
+
+type gridCell struct {
+	X, Y int
+}
+
+// spatialGrid раскладывает игроков по квадратным ячейкам, чтобы искать
+// соседей без перебора всех игроков комнаты
+type spatialGrid struct {
+	cellSize float64
+	cells    map[gridCell][]int
+}
+
+func newSpatialGrid(cellSize float64) *spatialGrid {
+	return &spatialGrid{
+		cellSize: cellSize,
+		cells:    make(map[gridCell][]int),
+	}
+}
+
+func (g *spatialGrid) cellAt(p Point) gridCell {
+	return gridCell{X: int(math.Floor(p.X / g.cellSize)), Y: int(math.Floor(p.Y / g.cellSize))}
+}
+
+// reset очищает сетку, сохраняя выделенные срезы
+func (g *spatialGrid) reset() {
+	for cell, ids := range g.cells {
+		g.cells[cell] = ids[:0]
+	}
+}
+
+func (g *spatialGrid) insert(id int, p Point) {
+	cell := g.cellAt(p)
+	g.cells[cell] = append(g.cells[cell], id)
+}
+
+// query вызывает fn для каждого игрока в ячейках, которые задевает круг.
+// Точное расстояние проверяет вызывающий.
+func (g *spatialGrid) query(center Point, radius float64, fn func(id int)) {
+	min := g.cellAt(Point{X: center.X - radius, Y: center.Y - radius})
+	max := g.cellAt(Point{X: center.X + radius, Y: center.Y + radius})
+	for x := min.X; x <= max.X; x++ {
+		for y := min.Y; y <= max.Y; y++ {
+			for _, id := range g.cells[gridCell{X: x, Y: y}] {
+				fn(id)
+			}
+		}
+	}
+}
+
+// rebuildGrid раскладывает игроков комнаты по сетке. Вызывается в горутине комнаты.
+func (r *Room) rebuildGrid() {
+	r.grid.reset()
+	for id, player := range r.worldState.Players {
+		r.grid.insert(id, player.Position)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+const (
+	HealthBarWidth  = 40 // Полоска над игроком
+	HealthBarHeight = 5
+	HealthBarLerp   = 300 * time.Millisecond // За столько полоска доезжает до нового здоровья
+)
+
+// Пороги цвета здоровья: выше первого - зеленая, выше второго - желтая,
+// ниже - красная
+const (
+	HealthHigh = 0.6
+	HealthLow  = 0.3
+)
+
+var (
+	healthColorHigh  = color.RGBA{60, 200, 60, 255}
+	healthColorMid   = color.RGBA{230, 200, 40, 255}
+	healthColorLow   = color.RGBA{220, 40, 40, 255}
+	healthColorTrail = color.RGBA{255, 230, 230, 220} // Только что потерянное здоровье
+	healthColorFlash = color.RGBA{255, 40, 40, 255}
+)
+
+// healthBar - здоровье, которое полоска показывает плавно: from - откуда
+// она едет, to - здоровье из последнего снимка
+type healthBar struct {
+	from, to float64
+	changed  time.Time
+}
+
+// shown - сколько здоровья полоска показывает сейчас
+func (b *healthBar) shown(now time.Time) float64 {
+	return lerp(b.from, b.to, float64(now.Sub(b.changed))/float64(HealthBarLerp))
+}
+
+// trackHealthBars запускает движение полосок, у которых изменилось
+// здоровье. Вызывается в игровом цикле до замены worldState.
+func (g *Game) trackHealthBars(state WorldState, now time.Time) {
+	for id, player := range state.Players {
+		bar, ok := g.healthBars[id]
+		if !ok {
+			g.healthBars[id] = &healthBar{from: player.Health, to: player.Health, changed: now}
+			continue
+		}
+		if player.Health != bar.to {
+			bar.from, bar.to, bar.changed = bar.shown(now), player.Health, now
+		}
+	}
+	for id := range g.healthBars {
+		if _, ok := state.Players[id]; !ok {
+			delete(g.healthBars, id)
+		}
+	}
+}
+
+// healthColor - цвет полоски по доле оставшегося здоровья
+func healthColor(fraction float64) color.RGBA {
+	switch {
+	case fraction > HealthHigh:
+		return healthColorHigh
+	case fraction > HealthLow:
+		return healthColorMid
+	default:
+		return healthColorLow
+	}
+}
+
+// drawHealthBar рисует здоровье игрока. Потерянное здоровье не пропадает
+// сразу, а тает светлым хвостом, лечение заполняет полоску плавно, а
+// после удара рамка вспыхивает красным.
+func (g *Game) drawHealthBar(screen *ebiten.Image, player *PlayerState, x, y, width, height float64, now time.Time) {
+	health := player.Health
+	shown := health
+	if bar, ok := g.healthBars[player.ID]; ok {
+		shown = bar.shown(now)
+	}
+	current := min(shown, health) / PlayerMaxHealth
+	ebitenutil.DrawRect(screen, x, y, width, height, barBackground)
+	if shown > health {
+		ebitenutil.DrawRect(screen, x, y, width*clamp01(shown/PlayerMaxHealth), height, healthColorTrail)
+	}
+	ebitenutil.DrawRect(screen, x, y, width*clamp01(current), height, healthColor(health/PlayerMaxHealth))
+
+	if at, ok := g.damageFlashes[player.ID]; ok && now.Sub(at) < DamageFlashTimeout {
+		// Цвета в ebiten с умноженной альфой, гасим все каналы сразу
+		fade := float64(now.Sub(at)) / float64(DamageFlashTimeout)
+		drawFrame(screen, x, y, width, height, lerpColor(healthColorFlash, color.RGBA{}, fade))
+	}
+}
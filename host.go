@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// HostKey запускает сервер внутри клиента, к нему могут подключиться друзья
+const HostKey = ebiten.KeyF3
+
+// startHosting запускает сервер с флагами клиента и паролем из меню, а сам
+// клиент подключается к нему через net.Pipe. Вызывается в игровом цикле.
+func (g *Game) startHosting() {
+	cfg := g.cfg
+	cfg.Password = g.connect.password
+	ln, err := listen(cfg.Transport, ServerAddr)
+	if err != nil {
+		clientLog.Warn("Failed to host game", "err", err)
+		g.connect.err = err.Error()
+		return
+	}
+	server := NewServer(cfg)
+	go server.serve(ln)
+
+	clientSide, serverSide := net.Pipe()
+	go server.handleClient(serverSide)
+	g.hosting = server
+	g.connect.err = ""
+	clientLog.Info("Hosting game", "addr", ln.Addr().String())
+	g.connected(clientSide, g.connect.name, g.connect.password)
+}
+
+// stopHosting останавливает сервер, запущенный из клиента. Вызывается в игровом цикле.
+func (g *Game) stopHosting() {
+	if g.hosting == nil {
+		return
+	}
+	g.hosting.Close()
+	g.hosting = nil
+}
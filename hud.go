@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// HUD - слой интерфейса поверх мира. Мир рисуется в координатах камеры, а
+// HUD - блоками у краев экрана: свои показатели слева внизу, перезарядки
+// по центру внизу, таймер и счет по центру вверху, пинг и FPS справа
+// вверху. Положения считаются от размера экрана, пришедшего в Draw, а не
+// от констант, поэтому блоки остаются у своих краев при любом размере окна.
+const (
+	HUDMargin = 10
+	HUDLine   = 16 // Строка отладочного шрифта с отступом
+
+	CooldownSlotWidth  = 60
+	CooldownSlotHeight = 36
+	CooldownSlotGap    = 6
+)
+
+// hudLayout - размер экрана, от которого считаются положения блоков HUD
+type hudLayout struct {
+	w, h int
+}
+
+func newHUDLayout(screen *ebiten.Image) hudLayout {
+	b := screen.Bounds()
+	return hudLayout{w: b.Dx(), h: b.Dy()}
+}
+
+// centerText - x, с которого строка text встанет по центру экрана
+func (l hudLayout) centerText(text string) int {
+	return l.w/2 - textWidth(text)/2
+}
+
+// drawHUD рисует HUD поверх мира. Вызывается из Draw сцен после drawWorld.
+func (g *Game) drawHUD(screen *ebiten.Image) {
+	now := time.Now()
+	hud := newHUDLayout(screen)
+	g.drawLevel(screen, hud)
+	g.drawResourceBars(screen, hud)
+	g.drawCooldowns(screen, hud, now)
+	g.drawMatchOverlay(screen, hud)
+	g.drawNetStats(screen, hud)
+	g.drawMinimap(screen, hud)
+	g.drawKillFeed(screen, hud, now)
+	g.drawCombatLog(screen, hud)
+	g.drawScoreboard(screen, hud)
+	g.drawPauseOverlay(screen, hud)
+	g.drawConnectionWarning(screen, hud)
+}
+
+// cooldownSlot - умение в нижней панели
+type cooldownSlot struct {
+	name     string
+	input    string  // Действие, клавишу которого подписываем
+	left     float64 // Секунд до готовности по последнему снимку
+	cooldown float64
+	cost     float64 // Сколько ресурса нужно
+}
+
+// drawCooldowns рисует ячейки умений: затемнение убывает вместе с
+// перезарядкой, без ресурса ячейка красная
+func (g *Game) drawCooldowns(screen *ebiten.Image, hud hudLayout, now time.Time) {
+	me, ok := g.worldState.Players[g.playerID]
+	if !ok {
+		return
+	}
+	stats := g.rules.Classes[me.Class]
+	slots := []cooldownSlot{{name: tr("Attack"), input: InputAttack, left: me.AttackCooldown, cooldown: 1 / PlayerAttackSpeed, cost: stats.AttackCost}}
+	if me.Class == MageClass {
+		slots = append(slots, cooldownSlot{name: tr("Summon"), input: InputSummon, left: me.SummonCooldown, cooldown: SummonCooldown, cost: SummonCost})
+	}
+	if me.Class == StealthClass {
+		slots = append(slots, cooldownSlot{name: tr("Stealth"), input: InputStealth, left: me.StealthCooldown, cooldown: StealthCooldown, cost: StealthCost})
+	}
+	// Между снимками перезарядка идет по часам клиента
+	elapsed := now.Sub(g.stateReceived).Seconds()
+	if g.worldState.Paused {
+		elapsed = 0
+	}
+
+	width := len(slots)*CooldownSlotWidth + (len(slots)-1)*CooldownSlotGap
+	x := hud.w/2 - width/2
+	y := hud.h - HUDMargin - CooldownSlotHeight
+	for _, slot := range slots {
+		left := math.Max(0, slot.left-elapsed)
+		background := color.RGBA{0, 0, 0, 160}
+		if me.Resource < slot.cost {
+			background = color.RGBA{120, 0, 0, 180}
+		}
+		ebitenutil.DrawRect(screen, float64(x), float64(y), CooldownSlotWidth, CooldownSlotHeight, background)
+		if left > 0 {
+			shade := CooldownSlotHeight * clamp01(left/slot.cooldown)
+			ebitenutil.DrawRect(screen, float64(x), float64(y)+CooldownSlotHeight-shade, CooldownSlotWidth, shade, color.RGBA{0, 0, 0, 160})
+			text := fmt.Sprintf("%.1f", left)
+			drawText(screen, text, x+CooldownSlotWidth/2-len(text)*3, y+CooldownSlotHeight/2-8)
+		} else {
+			drawFrame(screen, float64(x), float64(y), CooldownSlotWidth, CooldownSlotHeight, color.RGBA{200, 200, 200, 200})
+		}
+		drawText(screen, slot.name, x+2, y-2)
+		key := g.keys[slot.input].String()
+		drawText(screen, key, x+2, y+CooldownSlotHeight-14)
+		x += CooldownSlotWidth + CooldownSlotGap
+	}
+}
+
+// drawNetStats пишет пинг и FPS в правом верхнем углу, над миникартой
+func (g *Game) drawNetStats(screen *ebiten.Image, hud hudLayout) {
+	text := trf("FPS %.0f", ebiten.ActualFPS())
+	if me, ok := g.worldState.Players[g.playerID]; ok && g.practice == nil {
+		text = trf("Ping %d ms  %s", me.Ping, text)
+	}
+	drawText(screen, text, hud.w-HUDMargin-textWidth(text), HUDMargin-4)
+}
+
+// updateCooldowns пересчитывает перезарядки для HUD. Клиент не может
+// считать их сам: время атак записано по часам сервера. Вызывается в
+// горутине комнаты.
+func (r *Room) updateCooldowns(now time.Time) {
+	for _, player := range r.worldState.Players {
+		player.AttackCooldown = cooldownLeft(now, player.LastAttackTime, 1/PlayerAttackSpeed)
+		player.SummonCooldown = 0
+		if player.Class == MageClass {
+			player.SummonCooldown = cooldownLeft(now, player.LastSummonTime, SummonCooldown)
+		}
+		player.StealthCooldown = 0
+		if player.Class == StealthClass {
+			player.StealthCooldown = cooldownLeft(now, player.LastStealthTime, StealthCooldown)
+		}
+	}
+}
+
+func cooldownLeft(now, last time.Time, cooldown float64) float64 {
+	return math.Max(0, cooldown-now.Sub(last).Seconds())
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/opentype"
+)
+
+// Перевод интерфейса клиента. Ключ каталога - сама английская строка (для
+// строк с подстановками - формат), поэтому английскому каталог не нужен, а
+// строка без перевода показывается по-английски. Язык выбирается в
+// настройках. Логи и протокол не переводятся: текст, который собирает
+// сервер, как цель режима, приходит форматом с аргументами.
+const DefaultLocale = "en"
+
+// locales - языки в порядке переключения в настройках
+var locales = []string{"en", "ru"}
+
+// localeNames - название языка на нем самом
+var localeNames = map[string]string{
+	"en": "English",
+	"ru": "Русский",
+}
+
+var catalogs = map[string]map[string]string{
+	"ru": {
+		// Меню настроек
+		"SETTINGS": "НАСТРОЙКИ",
+		"Up/Down - select, Left/Right/Enter - change, Esc - close": "Вверх/вниз - выбор, влево/вправо/Enter - изменить, Esc - закрыть",
+		"Window size     < %dx%d >":                                "Размер окна     < %dx%d >",
+		"Fullscreen      %s":                                       "Полный экран    %s",
+		"VSync           %s":                                       "Верт. синхр.    %s",
+		"FPS cap         < %d >":                                   "Кадров/сек      < %d >",
+		"Volume          < %d%% >":                                 "Громкость       < %d%% >",
+		"Music volume    < %d%% >":                                 "Музыка          < %d%% >",
+		"Mute            %s":                                       "Без звука       %s",
+		"Language        < %s >":                                   "Язык            < %s >",
+		"Key bindings...":                                          "Клавиши...",
+		"Close":                                                    "Закрыть",
+		"on":                                                       "вкл",
+		"off":                                                      "выкл",
+		"KEY BINDINGS":                                             "КЛАВИШИ",
+		"Up/Down - select, Enter - rebind, F5 - defaults, F1/Esc - close": "Вверх/вниз - выбор, Enter - назначить, F5 - по умолчанию, F1/Esc - закрыть",
+		"press a key...": "нажмите клавишу...",
+		"Move up":        "Вверх",
+		"Move down":      "Вниз",
+		"Move left":      "Влево",
+		"Move right":     "Вправо",
+		"Move to cursor": "Идти к курсору",
+		"Sprint":         "Бег",
+		"Mute":           "Выключить звук",
+		"Volume down":    "Тише",
+		"Volume up":      "Громче",
+		"Combat log":     "Журнал боя",
+		"Pause":          "Пауза",
+
+		// Подключение, комнаты и серверы
+		"Tab - next field, Enter - connect, Esc - settings": "Tab - следующее поле, Enter - подключиться, Esc - настройки",
+		"F2 - practice offline, F3 - host game (password field sets the server password), F4 - public servers, F6 - leaderboard, F7 - map editor": "F2 - тренировка, F3 - своя игра (поле пароля задает пароль сервера), F4 - публичные серверы, F6 - лидеры, F7 - редактор карт",
+		"Server":               "Сервер",
+		"Name":                 "Имя",
+		"Password":             "Пароль",
+		"Connecting to %s...":  "Подключение к %s...",
+		"Disconnected: %v":     "Соединение разорвано: %v",
+		"Error: %s":            "Ошибка: %s",
+		"Press Enter to retry": "Enter - повторить",
+		"Client is too old: the server needs protocol %d, this client speaks %d": "Клиент устарел: серверу нужен протокол %d, у клиента %d",
+		"Server is too old: this client needs protocol %d or newer":              "Сервер устарел: клиенту нужен протокол %d или новее",
+		"Download a new client: %s":                                              "Новый клиент: %s",
+		"Joining room...":                                                        "Вход в комнату...",
+		"SELECT A ROOM":                                                          "ВЫБОР КОМНАТЫ",
+		"Up/Down - select, Enter/click - join, R - refresh, Q - find a match":    "Вверх/вниз - выбор, Enter/щелчок - войти, R - обновить, Q - подбор матча",
+		"Searching for a match: %.0f s":                                          "Подбор матча: %.0f с",
+		", %d/%d in queue, rating %d":                                            ", в очереди %d/%d, рейтинг %d",
+		"P - create/leave party, I - invite by name, J - join by code":           "P - создать группу/выйти, I - пригласить по имени, J - войти по коду",
+		"(leader)":     "(лидер)",
+		"Party %s: %s": "Группа %s: %s",
+		"Enter a name in the main menu to use parties": "Для групп введите имя в главном меню",
+		"%s invites you to party %s - A to accept":     "%s зовет вас в группу %s - A, чтобы принять",
+		"Invite player: %s_":                           "Пригласить игрока: %s_",
+		"Party code: %s_":                              "Код группы: %s_",
+		"Room":                                         "Комната",
+		"Players":                                      "Игроки",
+		"Bots":                                         "Боты",
+		"Mode":                                         "Режим",
+		"Phase":                                        "Фаза",
+		"Address":                                      "Адрес",
+		"Loading room list...":                         "Загрузка комнат...",
+		"PUBLIC SERVERS":                               "ПУБЛИЧНЫЕ СЕРВЕРЫ",
+		"Up/Down - select, Enter - connect, R - refresh, F4 - back": "Вверх/вниз - выбор, Enter - подключиться, R - обновить, F4 - назад",
+		"Loading server list...":                                    "Загрузка серверов...",
+		"No servers online":                                         "Нет серверов",
+		"no master server configured (-master-url)":                 "не задан главный сервер (-master-url)",
+		"%s uses %s, start the client with -transport %s":           "%s работает по %s, запустите клиент с -transport %s",
+
+		// Мир и HUD
+		"You":                                   "Вы",
+		"[BOT]":                                 "[БОТ]",
+		"[AFK]":                                 "[отошел]",
+		"Warrior":                               "Воин",
+		"Mage":                                  "Маг",
+		"Orange":                                "Оранжевые",
+		"Cyan":                                  "Бирюзовые",
+		"Attack":                                "Атака",
+		"Summon":                                "Призыв",
+		"Stealth":                               "Невидимость",
+		"FPS %.0f":                              "FPS %.0f",
+		"Ping %d ms  %s":                        "Пинг %d мс  %s",
+		"HP %d":                                 "ОЗ %d",
+		"mana":                                  "мана",
+		"stamina":                               "выносливость",
+		"Level %d":                              "Уровень %d",
+		"LEVEL UP! Level %d":                    "НОВЫЙ УРОВЕНЬ! Уровень %d",
+		"PAUSED":                                "ПАУЗА",
+		"Press %s to resume":                    "%s - продолжить",
+		"Connection unstable":                   "Связь нестабильна",
+		"OUTSIDE THE ZONE":                      "ВНЕ ЗОНЫ",
+		"Zone shrinks in %ds":                   "Зона сожмется через %d с",
+		"Zone shrinking: %ds":                   "Зона сжимается: %d с",
+		"HILL":                                  "ХОЛМ",
+		"YOU DIED":                              "ВЫ ПОГИБЛИ",
+		"Killed by %s":                          "Убийца: %s",
+		"Back in %.0f":                          "Возрождение через %.0f",
+		"Damage taken in the last %.0f s: %.0f": "Урон за последние %.0f с: %.0f",
+		"  no hits seen":                        "  ударов не видно",
+
+		// Матч и режимы
+		"Waiting for players...":                  "Ждем игроков...",
+		"Match starts in %d":                      "Матч начнется через %d",
+		"SUDDEN DEATH (%s) %s":                    "ВНЕЗАПНАЯ СМЕРТЬ (%s) %s",
+		"Score %d":                                "Счет %d",
+		", leader %s %d":                          ", лидер %s %d",
+		"Round %d, %d alive. ":                    "Раунд %d, живых %d. ",
+		"ELIMINATED - waiting for the next round": "ВЫБЫЛИ - ждем следующего раунда",
+		"MATCH OVER":                              "МАТЧ ОКОНЧЕН",
+		"Player":                                  "Игрок",
+		"Score":                                   "Счет",
+		"Kills":                                   "Убито",
+		"Deaths":                                  "Смертей",
+		"(You)":                                   "(вы)",
+		"Dealt":                                   "Нанес",
+		"LEADERBOARD":                             "ЛИДЕРЫ",
+		"Left/Right - category, R - refresh, F6 - back": "Влево/вправо - показатель, R - обновить, F6 - назад",
+		"Top kills":                       "Убийства",
+		"Best K/D":                        "Лучший У/С",
+		"Win rate":                        "Доля побед",
+		"K/D":                             "У/С",
+		"Matches":                         "Матчей",
+		"Wins":                            "Побед",
+		"Win %":                           "% поб",
+		"Rating":                          "Рейтинг",
+		"Size":                            "Размер",
+		"Vote for the next map":           "Голосование за следующую карту",
+		"(your vote)":                     "(ваш голос)",
+		"Loading leaderboard...":          "Загрузка таблицы лидеров...",
+		"No players yet":                  "Игроков пока нет",
+		"No players with %d+ matches yet": "Нет игроков с %d+ матчами",
+		"enter the server address first":  "сначала введите адрес сервера",
+		"Taken":                           "Получ",
+		"Healed":                          "Лечен",
+		"Scoreboard":                      "Таблица матча",
+		"Acc":                             "Точн",
+		"MVP: %s, %.0f damage":            "Лучший игрок: %s, урон %.0f",
+		"Back to lobby in %.0f":           "Возврат в лобби через %.0f",
+		"Career: %d/%d K/D, %d matches, rating %d": "Всего: %d/%d У/С, матчей %d, рейтинг %d",
+		", main %s": ", основной класс %s",
+
+		// Цели режимов, их присылает сервер
+		"First to %d kills": "Первым до %d убийств",
+		"Last one standing wins the round, first to %d rounds":    "Последний выживший берет раунд, первым до %d раундов",
+		"Hold the hill for %d seconds":                            "Удержите холм %d секунд",
+		"Bring the enemy flag to your base, first to %d captures": "Донесите вражеский флаг до своей базы, первым до %d захватов",
+
+		// Лента убийств и журнал боя
+		"%s died":                          "%s погиб",
+		"%s killed":                        "%s убил",
+		"%s killed %s":                     "%s убил %s",
+		"%s's minion":                      "прислужник %s",
+		"%s hit %s with %s for %.0f":       "%[1]s бьет %[2]s (%[3]s) на %.0f",
+		"(crit)":                           "(крит)",
+		"(true damage)":                    "(чистый урон)",
+		"(%.0f blocked)":                   "(%.0f поглощено)",
+		"%s's %s splashed %s for %.0f":     "%s: %s задел %s на %.0f",
+		"%s respawned":                     "%s возродился",
+		"%s reached level %d":              "%s достиг уровня %d",
+		"%s picked up %s":                  "%s подобрал %s",
+		"Team %d flag: %s":                 "Флаг команды %d: %s",
+		" by %s":                           ", игрок %s",
+		"Match paused by %s":               "Матч приостановил %s",
+		"Match resumed by %s":              "Матч продолжил %s",
+		"%s joined":                        "%s вошел",
+		"%s left":                          "%s вышел",
+		"Combat log (%s, wheel/PgUp/PgDn)": "Журнал боя (%s, колесо/PgUp/PgDn)",
+
+		// Редактор карт
+		"1-6 - tool, T - item type or spawn team, mouse - draw, right click - delete":         "1-6 - инструмент, T - тип предмета или команда точки, мышь - рисовать, правая кнопка - удалить",
+		"Arrows - scroll, Ctrl+arrows - world size, Ctrl+S - save, F5 - play-test, F7 - back": "Стрелки - прокрутка, Ctrl+стрелки - размер мира, Ctrl+S - сохранить, F5 - проверить в игре, F7 - назад",
+		"%s (%gx%g) - tool: %s": "%s (%gx%g) - инструмент: %s",
+		"item: %s":              "предмет: %s",
+		"team: %s":              "команда: %s",
+		"Any team":              "любая",
+		"New map %s":            "Новая карта %s",
+		"Saved to %s":           "Сохранено в %s",
+		"Wall":                  "Стена",
+		"Lava":                  "Лава",
+		"Swamp":                 "Болото",
+		"Trap":                  "Ловушка",
+		"Spawn point":           "Точка возрождения",
+		"Item spot":             "Место предмета",
+		"Any item":              "любой",
+		"Health Pack":           "Аптечка",
+		"Damage Boost":          "Усиление урона",
+		"Shield":                "Щит",
+		// Переподключение
+		"Connection lost, reconnecting to %s...": "Соединение потеряно, переподключение к %s...",
+		"Giving up in %d s":                      "Еще %d с",
+		"Backspace - cancel":                     "Backspace - отменить",
+	},
+}
+
+// locale - текущий язык интерфейса, меняется из настроек в игровом цикле
+var locale = DefaultLocale
+
+// setLocale переключает язык. Незнакомый язык заменяется английским.
+func setLocale(name string) {
+	if _, ok := localeNames[name]; !ok {
+		name = DefaultLocale
+	}
+	locale = name
+}
+
+// tr переводит строку интерфейса на текущий язык
+func tr(s string) string {
+	if translated, ok := catalogs[locale][s]; ok {
+		return translated
+	}
+	return s
+}
+
+// trf переводит формат и подставляет в него аргументы
+func trf(format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(format), args...)
+}
+
+// Отладочный шрифт Ebiten знает только ASCII. Остальное рисуем моноширинным
+// шрифтом Go той же ширины символа, чтобы не сбивалась разметка.
+const (
+	textAdvance  = 6  // Ширина символа отладочного шрифта
+	textFontSize = 10 // Размер, при котором у gomono та же ширина
+	textBaseline = 12 // От верха строки до базовой линии
+)
+
+var textFace = sync.OnceValue(func() font.Face {
+	parsed, err := opentype.Parse(gomono.TTF)
+	if err != nil {
+		clientLog.Error("Error loading UI font", "err", err)
+		return nil
+	}
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: textFontSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		clientLog.Error("Error loading UI font", "err", err)
+		return nil
+	}
+	return face
+})
+
+// drawText пишет строку интерфейса, x и y - левый верхний угол, как у
+// ebitenutil.DebugPrintAt
+func drawText(screen *ebiten.Image, s string, x, y int) {
+	face := textFace()
+	if isASCII(s) || face == nil {
+		ebitenutil.DebugPrintAt(screen, s, x, y)
+		return
+	}
+	text.Draw(screen, s, face, x, y+textBaseline, color.White)
+}
+
+// drawCentered пишет строку по центру окна по горизонтали
+func drawCentered(screen *ebiten.Image, s string, y int) {
+	drawText(screen, s, ScreenWidth/2-textWidth(s)/2, y)
+}
+
+// textWidth - ширина строки на экране
+func textWidth(s string) int {
+	return utf8.RuneCountInString(s) * textAdvance
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
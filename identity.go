@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Длина имени в символах
+const MaxNameLength = 16
+
+// sanitizeName чистит присланное клиентом имя: убирает управляющие и
+// невидимые символы, схлопывает пробелы и обрезает до MaxNameLength.
+// Если ничего не осталось, игрок получает имя по ID.
+func sanitizeName(name string, playerID int) string {
+	var b strings.Builder
+	length := 0
+	space := false
+	for _, c := range strings.TrimSpace(name) {
+		if length >= MaxNameLength {
+			break
+		}
+		if unicode.IsSpace(c) {
+			space = true
+			continue
+		}
+		if !unicode.IsPrint(c) {
+			continue
+		}
+		if space && length > 0 {
+			// Пробел в конце имени не нужен
+			if length+1 >= MaxNameLength {
+				break
+			}
+			b.WriteRune(' ')
+			length++
+		}
+		space = false
+		b.WriteRune(c)
+		length++
+	}
+	if length == 0 {
+		return fmt.Sprintf("Player %d", playerID)
+	}
+	return b.String()
+}
+
+// Из этих частей собираются имена ботов
+var (
+	botNameFirst  = []string{"Grim", "Silent", "Lucky", "Rusty", "Crimson", "Salty", "Frosty", "Shadow", "Mad", "Sneaky", "Iron", "Lazy", "Feral", "Toxic", "Noble"}
+	botNameSecond = []string{"Wolf", "Raven", "Potato", "Blade", "Viking", "Goblin", "Falcon", "Badger", "Monk", "Knight", "Pickle", "Hammer", "Fox", "Tiger", "Wizard"}
+)
+
+// botName придумывает боту имя, которого еще нет в комнате, иногда с
+// числом, как любят игроки. Без humanize.names - "Bot <ID>". Вызывается в
+// горутине комнаты.
+func (r *Room) botName(id int) string {
+	if !balance().Humanize.Names {
+		return fmt.Sprintf("Bot %d", id)
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		name := botNameFirst[r.rng.Intn(len(botNameFirst))] + botNameSecond[r.rng.Intn(len(botNameSecond))]
+		if r.rng.Intn(3) == 0 {
+			name += fmt.Sprint(r.rng.Intn(100))
+		}
+		if !r.nameTaken(name) {
+			return name
+		}
+	}
+	return fmt.Sprintf("Bot %d", id)
+}
+
+func (r *Room) nameTaken(name string) bool {
+	for _, player := range r.worldState.Players {
+		if strings.EqualFold(player.Name, name) {
+			return true
+		}
+	}
+	return false
+}
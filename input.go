@@ -0,0 +1,64 @@
+package main
+
+// Сколько действий игрока может ждать следующего тика. Лишние отбрасываются,
+// чтобы клиент не мог завалить комнату вводом.
+const MaxQueuedInputs = 32
+
+// inputQueue - действия игрока, полученные между тиками
+type inputQueue struct {
+	actions []PlayerAction
+	lastSeq uint64 // Последний принятый номер, старые и повторные отбрасываются
+}
+
+// queueAction ставит действие в очередь игрока. Применяется оно в начале
+// следующего тика, а не сразу при получении.
+func (r *Room) queueAction(playerID int, action PlayerAction) {
+	r.do(func() {
+		r.enqueueAction(playerID, action)
+	})
+}
+
+// enqueueAction - queueAction для тех, кто уже в горутине комнаты: через
+// него же свои действия отдают боты
+func (r *Room) enqueueAction(playerID int, action PlayerAction) {
+	if _, ok := r.worldState.Players[playerID]; !ok {
+		return
+	}
+
+	q, ok := r.inputs[playerID]
+	if !ok {
+		q = &inputQueue{}
+		r.inputs[playerID] = q
+	}
+	if action.Seq != 0 && action.Seq <= q.lastSeq {
+		return
+	}
+	if len(q.actions) >= MaxQueuedInputs {
+		netLog.Debug("Input queue full, dropping action", "player_id", playerID, "seq", action.Seq)
+		return
+	}
+	if action.Seq != 0 {
+		q.lastSeq = action.Seq
+	}
+	q.actions = append(q.actions, action)
+}
+
+// drainInputs применяет накопленные действия в порядке ID игроков и
+// запоминает номер последнего обработанного. Вызывается в горутине комнаты.
+func (r *Room) drainInputs() {
+	for _, id := range sortedIDs(r.inputs) {
+		q := r.inputs[id]
+		player, ok := r.worldState.Players[id]
+		if !ok {
+			delete(r.inputs, id)
+			continue
+		}
+		for _, action := range q.actions {
+			r.applyAction(player, action)
+			if action.Seq != 0 {
+				player.AckSeq = action.Seq
+			}
+		}
+		q.actions = q.actions[:0]
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+
+	"meatgrinder/protocol"
+)
+
+// Радиус обзора по умолчанию: половина диагонали экрана с запасом на
+// миникарту и на то, чтобы игроки не появлялись у самого края
+const DefaultViewRadius = 900
+
+// limitsVisibility сообщает, видят ли игроки разное: обзор ограничен
+// радиусом или стенами, имена далеких врагов скрыты или кто-то невидим
+func (r *Room) limitsVisibility() bool {
+	return r.cfg.ViewRadius > 0 || len(r.obstacles()) > 0 || r.cfg.RevealDistance > 0 || r.anyoneStealthed()
+}
+
+// visibleView решает, что видит игрок client: он сам и все в радиусе
+// обзора, кого не закрывают стены и кто не невидим для него. Появившиеся и пропавшие из обзора игроки
+// уходят клиенту отдельными сообщениями перед состоянием, они возвращаются
+// вторым значением. Вызывается в горутине комнаты после rebuildGrid.
+func (r *Room) visibleView(client *clientConnection) (*stateView, [][]byte) {
+	viewer, ok := r.worldState.Players[client.playerID]
+	if !ok || !r.limitsVisibility() {
+		return nil, nil
+	}
+
+	radius := r.cfg.ViewRadius
+	if radius <= 0 {
+		radius = math.Hypot(r.cfg.WorldWidth, r.cfg.WorldHeight)
+	}
+	visible := make(map[int]bool)
+	visible[viewer.ID] = true
+	r.grid.query(viewer.Position, radius, func(id int) {
+		other := r.worldState.Players[id]
+		if math.Hypot(other.Position.X-viewer.Position.X, other.Position.Y-viewer.Position.Y) > radius || hiddenFrom(viewer, other) {
+			return
+		}
+		// Союзников видно сквозь стены, врагов - только напрямую
+		ally := viewer.Team != 0 && other.Team == viewer.Team
+		if ally || r.lineOfSight(viewer.Position, other.Position) {
+			visible[id] = true
+		}
+	})
+
+	// Флаги, зона и матч видны всегда
+	view := &stateView{players: sortedIDs(visible), visible: visible}
+	view.concealed = r.concealedFrom(viewer, view.players)
+	var entered, left []int
+	for _, id := range view.players {
+		if !client.visible[id] {
+			entered = append(entered, id)
+		}
+	}
+	for _, id := range sortedIDs(client.visible) {
+		if !visible[id] {
+			left = append(left, id)
+		}
+	}
+	for id, item := range r.worldState.Items {
+		if math.Hypot(item.Position.X-viewer.Position.X, item.Position.Y-viewer.Position.Y) <= radius {
+			view.items = append(view.items, id)
+		}
+	}
+	for id, projectile := range r.worldState.Projectiles {
+		if math.Hypot(projectile.Position.X-viewer.Position.X, projectile.Position.Y-viewer.Position.Y) <= radius {
+			view.projectiles = append(view.projectiles, id)
+		}
+	}
+	for id, minion := range r.worldState.Minions {
+		if math.Hypot(minion.Position.X-viewer.Position.X, minion.Position.Y-viewer.Position.Y) <= radius {
+			view.minions = append(view.minions, id)
+		}
+	}
+	client.visible = visible
+
+	var visibility [][]byte
+	if len(entered) > 0 {
+		visibility = appendVisibility(visibility, protocol.MsgEntityEnter, entered)
+	}
+	if len(left) > 0 {
+		visibility = appendVisibility(visibility, protocol.MsgEntityLeave, left)
+	}
+	return view, visibility
+}
+
+// concealedFrom - враги из ids, которые дальше RevealDistance и от viewer,
+// и от каждого живого союзника viewer: клиент не показывает их имя и
+// здоровье. nil - скрывать нечего. Вызывается в горутине комнаты.
+func (r *Room) concealedFrom(viewer *PlayerState, ids []int) map[int]bool {
+	if r.cfg.RevealDistance <= 0 {
+		return nil
+	}
+	eyes := []Point{viewer.Position}
+	if viewer.Team != 0 {
+		for _, id := range sortedIDs(r.worldState.Players) {
+			ally := r.worldState.Players[id]
+			if id != viewer.ID && ally.Team == viewer.Team && !ally.Eliminated {
+				eyes = append(eyes, ally.Position)
+			}
+		}
+	}
+	var concealed map[int]bool
+	for _, id := range ids {
+		other := r.worldState.Players[id]
+		if id == viewer.ID || (viewer.Team != 0 && other.Team == viewer.Team) {
+			continue
+		}
+		revealed := false
+		for _, eye := range eyes {
+			if math.Hypot(other.Position.X-eye.X, other.Position.Y-eye.Y) <= r.cfg.RevealDistance {
+				revealed = true
+				break
+			}
+		}
+		if !revealed {
+			if concealed == nil {
+				concealed = make(map[int]bool)
+			}
+			concealed[id] = true
+		}
+	}
+	return concealed
+}
+
+func appendVisibility(msgs [][]byte, msgType string, ids []int) [][]byte {
+	msg, err := protocol.Marshal(msgType, protocol.EntityVisibility{IDs: ids})
+	if err != nil {
+		netLog.Error("Error encoding message", "type", msgType, "err", err)
+		return msgs
+	}
+	return append(msgs, msg)
+}
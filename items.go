@@ -0,0 +1,125 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+)
+
+// Предметы на поле
+const (
+	ItemRadius            = 10
+	ItemSpawnInterval     = 5.0  // Раз в сколько секунд появляется новый предмет
+	MaxItems              = 5    // Максимальное количество предметов на поле
+	HealthPackAmount      = 30.0 // Сколько здоровья восстанавливает аптечка
+	DamageBoostMultiplier = 1.5  // Множитель урона под действием усиления
+	DamageBoostDuration   = 10.0 // Длительность усиления урона в секундах
+	ShieldAmount          = 25.0 // Сколько урона поглощает щит
+	ShieldDuration        = 10.0
+	EventItemSpawned      = "item_spawned"
+	EventItemPickedUp     = "item_picked_up"
+)
+
+// Types of items
+const (
+	HealthPackItem = iota
+	DamageBoostItem
+	ShieldItem
+	TotalItemTypes
+)
+
+var ItemNames = map[int]string{
+	HealthPackItem:  "Health Pack",
+	DamageBoostItem: "Damage Boost",
+	ShieldItem:      "Shield",
+}
+
+var ItemColors = map[int]color.RGBA{
+	HealthPackItem:  {0, 200, 0, 255},     // Green
+	DamageBoostItem: {255, 200, 0, 255},   // Yellow
+	ShieldItem:      {180, 180, 255, 255}, // Light blue
+}
+
+type Item struct {
+	ID       int   `json:"id"`
+	Type     int   `json:"type"`
+	Position Point `json:"position"`
+}
+
+// spawnItems создает новый предмет в случайной точке, если пришло время.
+// Вызывается в горутине комнаты.
+func (r *Room) spawnItems(now time.Time) {
+	if len(r.worldState.Items) >= MaxItems {
+		return
+	}
+	if now.Sub(r.lastItemSpawn).Seconds() < ItemSpawnInterval {
+		return
+	}
+	r.lastItemSpawn = now
+
+	pos, itemType, ok := r.itemSpot()
+	if !ok {
+		return
+	}
+	item := &Item{
+		ID:       r.nextItemID,
+		Type:     itemType,
+		Position: pos,
+	}
+	r.nextItemID++
+	r.worldState.Items[item.ID] = item
+
+	r.logEvent(now, EventItemSpawned, map[string]interface{}{
+		"item_id":  item.ID,
+		"type":     ItemNames[item.Type],
+		"position": item.Position,
+	})
+	r.log.Debug("Item spawned", "item_id", item.ID, "item", ItemNames[item.Type], "position", item.Position)
+}
+
+// pickupItems проверяет, наступил ли кто-то из игроков на предмет.
+// Вызывается в горутине комнаты.
+func (r *Room) pickupItems(now time.Time) {
+	for _, itemID := range sortedIDs(r.worldState.Items) {
+		item := r.worldState.Items[itemID]
+		for _, playerID := range sortedIDs(r.worldState.Players) {
+			player := r.worldState.Players[playerID]
+			if player.Eliminated {
+				continue
+			}
+			dist := math.Sqrt(math.Pow(player.Position.X-item.Position.X, 2) +
+				math.Pow(player.Position.Y-item.Position.Y, 2))
+			if dist > PlayerRadius+ItemRadius {
+				continue
+			}
+
+			switch item.Type {
+			case HealthPackItem:
+				healed := math.Min(PlayerMaxHealth-player.Health, HealthPackAmount)
+				player.Health += healed
+				recordHealing(player, healed)
+			case DamageBoostItem:
+				r.addEffect(player, StatusEffect{
+					Type:      EffectDamageBoost,
+					Remaining: DamageBoostDuration,
+					Magnitude: DamageBoostMultiplier,
+				}, now)
+			case ShieldItem:
+				r.addEffect(player, StatusEffect{
+					Type:      EffectShield,
+					Remaining: ShieldDuration,
+					Magnitude: ShieldAmount,
+				}, now)
+			}
+			delete(r.worldState.Items, itemID)
+
+			r.logEvent(now, EventItemPickedUp, map[string]interface{}{
+				"item_id":   itemID,
+				"type":      ItemNames[item.Type],
+				"player_id": player.ID,
+			})
+			r.log.Debug("Item picked up", "player_id", player.ID, "item", ItemNames[item.Type])
+			break
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Действия, которые можно переназначить
+const (
+	InputMoveUp     = "move_up"
+	InputMoveDown   = "move_down"
+	InputMoveLeft   = "move_left"
+	InputMoveRight  = "move_right"
+	InputAttack     = "attack"
+	InputMoveTo     = "move_to"
+	InputSprint     = "sprint"
+	InputSummon     = "summon"
+	InputStealth    = "stealth"
+	InputMute       = "mute"
+	InputVolumeDown = "volume_down"
+	InputVolumeUp   = "volume_up"
+	InputCombatLog  = "combat_log"
+	InputScoreboard = "scoreboard" // Таблица матча, пока клавиша зажата
+	InputPause      = "pause"      // Только в тренировке и у хозяина игры
+)
+
+// actionNames - названия действий на экране настройки, переводятся по
+// каталогу
+var actionNames = map[string]string{
+	InputMoveUp:     "Move up",
+	InputMoveDown:   "Move down",
+	InputMoveLeft:   "Move left",
+	InputMoveRight:  "Move right",
+	InputAttack:     "Attack",
+	InputMoveTo:     "Move to cursor",
+	InputSprint:     "Sprint",
+	InputSummon:     "Summon",
+	InputStealth:    "Stealth",
+	InputMute:       "Mute",
+	InputVolumeDown: "Volume down",
+	InputVolumeUp:   "Volume up",
+	InputCombatLog:  "Combat log",
+	InputScoreboard: "Scoreboard",
+	InputPause:      "Pause",
+}
+
+// Порядок действий на экране настройки
+var inputActions = []string{
+	InputMoveUp, InputMoveDown, InputMoveLeft, InputMoveRight,
+	InputAttack, InputMoveTo, InputSprint, InputSummon, InputStealth,
+	InputMute, InputVolumeDown, InputVolumeUp, InputCombatLog, InputScoreboard, InputPause,
+}
+
+// Экран настройки клавиш открывается и закрывается этой клавишей, сама она
+// не переназначается
+const KeyBindingsScreenKey = ebiten.KeyF1
+
+// Binding - клавиша или кнопка мыши. В файле хранится строкой: имя клавиши
+// Ebiten ("W", "ArrowUp") или "MouseLeft", "MouseRight", "MouseMiddle".
+type Binding struct {
+	Key     ebiten.Key
+	Mouse   ebiten.MouseButton
+	IsMouse bool
+}
+
+var mouseButtonNames = map[ebiten.MouseButton]string{
+	ebiten.MouseButtonLeft:   "MouseLeft",
+	ebiten.MouseButtonRight:  "MouseRight",
+	ebiten.MouseButtonMiddle: "MouseMiddle",
+}
+
+func KeyBinding(key ebiten.Key) Binding {
+	return Binding{Key: key}
+}
+
+func MouseBinding(button ebiten.MouseButton) Binding {
+	return Binding{Mouse: button, IsMouse: true}
+}
+
+func (b Binding) String() string {
+	if b.IsMouse {
+		return mouseButtonNames[b.Mouse]
+	}
+	return b.Key.String()
+}
+
+func (b Binding) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *Binding) UnmarshalText(text []byte) error {
+	for button, name := range mouseButtonNames {
+		if strings.EqualFold(string(text), name) {
+			*b = MouseBinding(button)
+			return nil
+		}
+	}
+	var key ebiten.Key
+	if err := key.UnmarshalText(text); err != nil {
+		return fmt.Errorf("unknown key %q", text)
+	}
+	*b = KeyBinding(key)
+	return nil
+}
+
+func (b Binding) Pressed() bool {
+	if b.IsMouse {
+		return ebiten.IsMouseButtonPressed(b.Mouse)
+	}
+	return ebiten.IsKeyPressed(b.Key)
+}
+
+func (b Binding) JustPressed() bool {
+	if b.IsMouse {
+		return inpututil.IsMouseButtonJustPressed(b.Mouse)
+	}
+	return inpututil.IsKeyJustPressed(b.Key)
+}
+
+// KeyBindings - действие -> клавиша
+type KeyBindings map[string]Binding
+
+func DefaultKeyBindings() KeyBindings {
+	return KeyBindings{
+		InputMoveUp:     KeyBinding(ebiten.KeyW),
+		InputMoveDown:   KeyBinding(ebiten.KeyS),
+		InputMoveLeft:   KeyBinding(ebiten.KeyA),
+		InputMoveRight:  KeyBinding(ebiten.KeyD),
+		InputAttack:     MouseBinding(ebiten.MouseButtonLeft),
+		InputMoveTo:     MouseBinding(ebiten.MouseButtonRight),
+		InputSprint:     KeyBinding(ebiten.KeyShiftLeft),
+		InputSummon:     KeyBinding(ebiten.KeyQ),
+		InputStealth:    KeyBinding(ebiten.KeyE),
+		InputMute:       KeyBinding(ebiten.KeyM),
+		InputVolumeDown: KeyBinding(ebiten.KeyMinus),
+		InputVolumeUp:   KeyBinding(ebiten.KeyEqual),
+		InputCombatLog:  KeyBinding(ebiten.KeyL),
+		InputScoreboard: KeyBinding(ebiten.KeyTab),
+		InputPause:      KeyBinding(ebiten.KeyP),
+	}
+}
+
+func (kb KeyBindings) Pressed(action string) bool {
+	b, ok := kb[action]
+	return ok && b.Pressed()
+}
+
+func (kb KeyBindings) JustPressed(action string) bool {
+	b, ok := kb[action]
+	return ok && b.JustPressed()
+}
+
+// LoadKeyBindings читает keys.json. Действия, которых нет в файле,
+// получают клавиши по умолчанию.
+func LoadKeyBindings() KeyBindings {
+	bindings := DefaultKeyBindings()
+	path, err := configPath("keys.json")
+	if err != nil {
+		clientLog.Error("Error locating key bindings", "err", err)
+		return bindings
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return bindings
+	}
+	if err != nil {
+		clientLog.Error("Error reading key bindings", "err", err)
+		return bindings
+	}
+	var saved KeyBindings
+	if err := json.Unmarshal(data, &saved); err != nil {
+		clientLog.Error("Error parsing key bindings", "path", path, "err", err)
+		return bindings
+	}
+	for action, binding := range saved {
+		if _, ok := bindings[action]; ok {
+			bindings[action] = binding
+		}
+	}
+	return bindings
+}
+
+func (kb KeyBindings) Save() error {
+	path, err := configPath("keys.json")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(kb, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// keyBindingsScreen - экран переназначения клавиш
+type keyBindingsScreen struct {
+	active   bool
+	selected int
+	waiting  bool // Ждем нажатия новой клавиши для выбранного действия
+}
+
+// updateKeyBindingsScreen: вверх/вниз - выбор, Enter - переназначить,
+// F5 - вернуть клавиши по умолчанию, F1 или Esc - закрыть. Вызывается в игровом цикле.
+func (g *Game) updateKeyBindingsScreen() {
+	s := &g.keyScreen
+	if s.waiting {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			s.waiting = false
+			return
+		}
+		binding, ok := justPressedBinding()
+		if !ok {
+			return
+		}
+		g.keys[inputActions[s.selected]] = binding
+		s.waiting = false
+		g.saveKeyBindings()
+		return
+	}
+
+	switch {
+	case inpututil.IsKeyJustPressed(KeyBindingsScreenKey), inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		s.active = false
+	case inpututil.IsKeyJustPressed(ebiten.KeyUp):
+		s.selected = (s.selected + len(inputActions) - 1) % len(inputActions)
+	case inpututil.IsKeyJustPressed(ebiten.KeyDown):
+		s.selected = (s.selected + 1) % len(inputActions)
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter):
+		s.waiting = true
+	case inpututil.IsKeyJustPressed(ebiten.KeyF5):
+		g.keys = DefaultKeyBindings()
+		g.saveKeyBindings()
+	}
+}
+
+func (g *Game) saveKeyBindings() {
+	if err := g.keys.Save(); err != nil {
+		clientLog.Error("Error saving key bindings", "err", err)
+	}
+}
+
+// justPressedBinding возвращает клавишу или кнопку мыши, нажатую в этом кадре
+func justPressedBinding() (Binding, bool) {
+	for _, button := range []ebiten.MouseButton{ebiten.MouseButtonLeft, ebiten.MouseButtonRight, ebiten.MouseButtonMiddle} {
+		if inpututil.IsMouseButtonJustPressed(button) {
+			return MouseBinding(button), true
+		}
+	}
+	for _, key := range inpututil.AppendJustPressedKeys(nil) {
+		if key == KeyBindingsScreenKey || key == ebiten.KeyEscape {
+			continue
+		}
+		return KeyBinding(key), true
+	}
+	return Binding{}, false
+}
+
+func (g *Game) drawKeyBindingsScreen(screen *ebiten.Image) {
+	s := g.keyScreen
+	const top, row = 100, 20
+	ebitenutil.DrawRect(screen, 150, top-50, ScreenWidth-300, float64(80+row*len(inputActions)), color.RGBA{0, 0, 0, 220})
+	drawCentered(screen, tr("KEY BINDINGS"), top-40)
+	drawCentered(screen, tr("Up/Down - select, Enter - rebind, F5 - defaults, F1/Esc - close"), top-22)
+	for i, action := range inputActions {
+		y := top + i*row
+		if i == s.selected {
+			ebitenutil.DrawRect(screen, 165, float64(y), ScreenWidth-330, row, color.RGBA{255, 255, 255, 40})
+		}
+		binding := g.keys[action].String()
+		if i == s.selected && s.waiting {
+			binding = tr("press a key...")
+		}
+		drawText(screen, fmt.Sprintf("%-14s %s", tr(actionNames[action]), binding), 175, y+2)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+const (
+	KillFeedSize     = 5 // Сколько последних убийств показываем
+	KillFeedDuration = 6 * time.Second
+	KillFeedFade     = time.Second // Последнюю секунду строка гаснет
+	KillFeedTop      = 156         // Под миникартой
+	KillFeedWidth    = 240
+	killFeedRow      = 18
+)
+
+type killFeedEntry struct {
+	Killer      string // Пусто, если игрок погиб сам
+	KillerClass int
+	Victim      string
+	VictimClass int
+	At          time.Time
+}
+
+// feedName - подпись игрока в ленте убийств
+func feedName(name string, bot bool) string {
+	if bot {
+		return tr("[BOT]") + " " + name
+	}
+	return name
+}
+
+// addKill добавляет убийство в ленту. Вызывается в игровом цикле.
+func (g *Game) addKill(death DeathEvent, now time.Time) {
+	// Имена берем из события: жертва и убийца могут быть вне обзора
+	entry := killFeedEntry{Victim: feedName(death.Name, death.Bot), VictimClass: death.Class, At: now}
+	if death.KillerName != "" {
+		entry.Killer = feedName(death.KillerName, death.KillerBot)
+		entry.KillerClass = death.KillerClass
+	}
+	g.killFeed = append(g.killFeed, entry)
+	if len(g.killFeed) > KillFeedSize {
+		g.killFeed = g.killFeed[len(g.killFeed)-KillFeedSize:]
+	}
+}
+
+// drawKillFeed рисует ленту убийств в правом верхнем углу, новые снизу
+func (g *Game) drawKillFeed(screen *ebiten.Image, hud hudLayout, now time.Time) {
+	left := float64(hud.w - KillFeedWidth - MinimapMargin)
+	row := 0
+	for _, entry := range g.killFeed {
+		age := now.Sub(entry.At)
+		if age >= KillFeedDuration {
+			continue
+		}
+		fade := 1.0
+		if remaining := KillFeedDuration - age; remaining < KillFeedFade {
+			fade = float64(remaining) / float64(KillFeedFade)
+		}
+		y := float64(KillFeedTop + row*killFeedRow)
+		ebitenutil.DrawRect(screen, left, y, KillFeedWidth, killFeedRow-2, color.RGBA{0, 0, 0, uint8(150 * fade)})
+
+		// Цветная метка класса перед каждым именем
+		x := left + 4
+		line := trf("%s died", entry.Victim)
+		if entry.Killer != "" {
+			ebitenutil.DrawRect(screen, x, y+4, 8, 8, fadeColor(ClassColors[entry.KillerClass], fade))
+			x += 12
+			line = trf("%s killed", entry.Killer)
+			drawText(screen, line, int(x), int(y))
+			x += float64(textWidth(line) + 6)
+			line = entry.Victim
+		}
+		ebitenutil.DrawRect(screen, x, y+4, 8, 8, fadeColor(ClassColors[entry.VictimClass], fade))
+		drawText(screen, line, int(x)+12, int(y))
+		row++
+	}
+}
+
+func fadeColor(c color.RGBA, fade float64) color.RGBA {
+	c.A = uint8(float64(c.A) * fade)
+	return c
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	KnockbackDuration = 0.2  // За столько секунд цель отлетает на всю дистанцию
+	WarriorKnockback  = 60.0 // Удар воина отбрасывает цель
+	MageKnockback     = 20.0 // Взрыв огненного шара расталкивает всех в радиусе
+)
+
+// Knockback - полет после удара. Пока он идет, цель смещается сама,
+// независимо от ввода.
+type Knockback struct {
+	Velocity  Point   `json:"velocity"`  // Пикселей в секунду
+	Remaining float64 `json:"remaining"` // Секунд до конца полета
+}
+
+// applyKnockback отталкивает цель от точки from на distance пикселей.
+// Новый толчок заменяет незаконченный.
+func applyKnockback(target *PlayerState, from Point, distance float64) {
+	dx, dy := target.Position.X-from.X, target.Position.Y-from.Y
+	length := math.Hypot(dx, dy)
+	if distance <= 0 || length == 0 {
+		return
+	}
+	speed := distance / KnockbackDuration
+	target.Knockback = &Knockback{
+		Velocity:  Point{X: dx / length * speed, Y: dy / length * speed},
+		Remaining: KnockbackDuration,
+	}
+}
+
+// updateKnockback продвигает полет игрока. Вызывается в горутине комнаты.
+func (r *Room) updateKnockback(player *PlayerState, deltaTime float64) {
+	kb := player.Knockback
+	if kb == nil {
+		return
+	}
+	dt := math.Min(deltaTime, kb.Remaining)
+	player.Position.X += kb.Velocity.X * dt
+	player.Position.Y += kb.Velocity.Y * dt
+	r.clampToWorld(player)
+	kb.Remaining -= dt
+	if kb.Remaining <= 0 {
+		player.Knockback = nil
+	}
+}
+
+// clampToWorld не дает игроку выйти за границы мира и зайти в стены.
+// Вызывается в горутине комнаты.
+func (r *Room) clampToWorld(player *PlayerState) {
+	r.pushOutOfObstacles(&player.Position, PlayerRadius)
+	player.Position.X = math.Max(0, math.Min(player.Position.X, r.cfg.WorldWidth))
+	player.Position.Y = math.Max(0, math.Min(player.Position.Y, r.cfg.WorldHeight))
+}
+
+// updateKnockbacks досчитывает полет отброшенных игроков между снимками
+// состояния, чтобы они отлетали плавно, а не скачками. Вызывается в игровом цикле.
+func (g *Game) updateKnockbacks(now time.Time) {
+	elapsed := now.Sub(g.stateReceived).Seconds()
+	for id, player := range g.worldState.Players {
+		kb := player.Knockback
+		if kb == nil {
+			continue
+		}
+		t := math.Min(elapsed, kb.Remaining)
+		g.playerPositions[id] = Point{
+			X: math.Max(0, math.Min(player.Position.X+kb.Velocity.X*t, g.worldWidth)),
+			Y: math.Max(0, math.Min(player.Position.Y+kb.Velocity.Y*t, g.worldHeight)),
+		}
+	}
+}
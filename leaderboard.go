@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+
+	"meatgrinder/protocol"
+)
+
+// Таблица лидеров сервера собирается из сохраненных профилей (-profiles).
+// Ее можно получить по HTTP (GET /api/leaderboard) или сообщением
+// get_leaderboard до входа в комнату - так ее показывает клиент по F6 в
+// главном меню.
+const (
+	LeaderboardSize       = 10
+	LeaderboardMinMatches = 5 // Для K/D, доли побед и рейтинга, чтобы один удачный матч не выводил в лидеры
+)
+
+var errProfilesDisabled = errors.New("profiles are disabled")
+
+// matchWinners - кто выиграл закончившийся матч. В командном режиме
+// побеждает вся команда с большим счетом, иначе - игрок с наибольшим
+// счетом. При ничьей победителей нет. Вызывается в горутине комнаты.
+func (r *Room) matchWinners() map[int]bool {
+	winners := make(map[int]bool)
+	if hud := r.mode.HUD(r); hud != nil && len(hud.TeamScores) > 0 {
+		red, blue := hud.TeamScores[TeamRed], hud.TeamScores[TeamBlue]
+		if red == blue {
+			return winners
+		}
+		team := TeamRed
+		if blue > red {
+			team = TeamBlue
+		}
+		for id, player := range r.worldState.Players {
+			if player.Team == team {
+				winners[id] = true
+			}
+		}
+		return winners
+	}
+	results := r.worldState.Match.Results
+	if len(results) == 0 || (len(results) > 1 && results[1].Score == results[0].Score) {
+		return winners
+	}
+	winners[results[0].PlayerID] = true
+	return winners
+}
+
+// leaderboard строит таблицу лидеров по всем профилям
+func (s *Server) leaderboard() (protocol.Leaderboard, error) {
+	if s.profiles == nil {
+		return protocol.Leaderboard{}, errProfilesDisabled
+	}
+	profiles, err := s.profiles.All()
+	if err != nil {
+		return protocol.Leaderboard{}, err
+	}
+	entries := make([]protocol.LeaderboardEntry, 0, len(profiles))
+	for _, p := range profiles {
+		entry := protocol.LeaderboardEntry{
+			Name:          p.Name,
+			Kills:         p.Kills,
+			Deaths:        p.Deaths,
+			Wins:          p.Wins,
+			MatchesPlayed: p.MatchesPlayed,
+			KD:            float64(p.Kills) / float64(max(p.Deaths, 1)),
+			Rating:        int(math.Round(p.Rating)),
+		}
+		if p.MatchesPlayed > 0 {
+			entry.WinRate = float64(p.Wins) / float64(p.MatchesPlayed)
+		}
+		entries = append(entries, entry)
+	}
+	return protocol.Leaderboard{
+		TopKills: topEntries(entries, 0, func(e protocol.LeaderboardEntry) float64 { return float64(e.Kills) }),
+		BestKD:   topEntries(entries, LeaderboardMinMatches, func(e protocol.LeaderboardEntry) float64 { return e.KD }),
+		WinRate:  topEntries(entries, LeaderboardMinMatches, func(e protocol.LeaderboardEntry) float64 { return e.WinRate }),
+		Rating:   topEntries(entries, LeaderboardMinMatches, func(e protocol.LeaderboardEntry) float64 { return float64(e.Rating) }),
+	}, nil
+}
+
+// topEntries - лучшие LeaderboardSize записей по key среди сыгравших не
+// меньше minMatches матчей. При равенстве выше тот, кто сыграл больше.
+func topEntries(entries []protocol.LeaderboardEntry, minMatches int, key func(protocol.LeaderboardEntry) float64) []protocol.LeaderboardEntry {
+	top := make([]protocol.LeaderboardEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.MatchesPlayed >= minMatches {
+			top = append(top, e)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if a, b := key(top[i]), key(top[j]); a != b {
+			return a > b
+		}
+		if top[i].MatchesPlayed != top[j].MatchesPlayed {
+			return top[i].MatchesPlayed > top[j].MatchesPlayed
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > LeaderboardSize {
+		top = top[:LeaderboardSize]
+	}
+	return top
+}
+
+// LeaderboardKey открывает таблицу лидеров в главном меню
+const LeaderboardKey = ebiten.KeyF6
+
+// Вкладки экрана лидеров
+const (
+	LeaderboardKills = iota
+	LeaderboardKD
+	LeaderboardWinRate
+	LeaderboardRating
+	leaderboardTabs
+)
+
+// leaderboardScreen - таблица лидеров сервера из поля адреса главного меню
+type leaderboardScreen struct {
+	active  bool
+	loading bool
+	board   *protocol.Leaderboard
+	tab     int
+	err     string
+}
+
+// refreshLeaderboard запрашивает таблицу у сервера в фоне отдельным
+// коротким соединением. Вызывается в игровом цикле.
+func (g *Game) refreshLeaderboard() {
+	l := &g.leaderboard
+	if l.loading {
+		return
+	}
+	address := g.connect.address
+	if address == "" {
+		l.err = tr("enter the server address first")
+		return
+	}
+	l.loading = true
+	l.err = ""
+	go func() {
+		board, err := fetchLeaderboard(g.cfg.Transport, address)
+		g.post(func() {
+			l.loading = false
+			if err != nil {
+				clientLog.Warn("Error fetching leaderboard", "addr", address, "err", err)
+				l.err = err.Error()
+				return
+			}
+			l.board = &board
+		})
+	}()
+}
+
+func fetchLeaderboard(transport, address string) (protocol.Leaderboard, error) {
+	var board protocol.Leaderboard
+	conn, err := dialTransport(transport, address)
+	if err != nil {
+		return board, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ConnectTimeout))
+	if err := protocol.NewEncoder(conn).Encode(protocol.MsgGetLeaderboard, struct{}{}); err != nil {
+		return board, err
+	}
+	msg, err := protocol.NewDecoder(conn).Next()
+	if err != nil {
+		return board, err
+	}
+	switch msg.Type {
+	case protocol.MsgLeaderboard:
+		err = msg.Decode(&board)
+	case protocol.MsgError:
+		var rejection protocol.Error
+		if err = msg.Decode(&rejection); err == nil {
+			err = &rejection
+		}
+	default:
+		err = fmt.Errorf("unexpected message %q", msg.Type)
+	}
+	return board, err
+}
+
+// updateLeaderboard: влево/вправо - вкладка, R - обновить, F6 или
+// Backspace - назад. Вызывается в игровом цикле.
+func (g *Game) updateLeaderboard() {
+	l := &g.leaderboard
+	if inpututil.IsKeyJustPressed(LeaderboardKey) || inpututil.IsKeyJustPressed(ebiten.KeyBackspace) {
+		l.active = false
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		g.refreshLeaderboard()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+		l.tab = cycle(l.tab, -1, leaderboardTabs)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+		l.tab = cycle(l.tab, 1, leaderboardTabs)
+	}
+}
+
+func (g *Game) drawLeaderboard(screen *ebiten.Image) {
+	l := g.leaderboard
+	const left, top = 100, roomListTop + 2*roomListRowHeight
+	drawCentered(screen, tr("LEADERBOARD"), 20)
+	drawCentered(screen, tr("Left/Right - category, R - refresh, F6 - back"), 40)
+
+	titles := [leaderboardTabs]string{
+		LeaderboardKills:   tr("Top kills"),
+		LeaderboardKD:      tr("Best K/D"),
+		LeaderboardWinRate: tr("Win rate"),
+		LeaderboardRating:  tr("Rating"),
+	}
+	x := left
+	for tab, title := range titles {
+		if tab == l.tab {
+			ebitenutil.DrawRect(screen, float64(x-4), top-2*roomListRowHeight, float64(textWidth(title)+8), roomListRowHeight, color.RGBA{255, 255, 255, 40})
+		}
+		drawText(screen, title, x, top-2*roomListRowHeight+2)
+		x += textWidth(title) + 24
+	}
+	drawText(screen, fmt.Sprintf("#  %-20s %6s %7s %5s %7s %5s %6s %7s",
+		tr("Player"), tr("Kills"), tr("Deaths"), tr("K/D"), tr("Matches"), tr("Wins"), tr("Win %"), tr("Rating")), left, top-roomListRowHeight+2)
+
+	switch {
+	case l.loading:
+		drawText(screen, tr("Loading leaderboard..."), left, top)
+	case l.board == nil:
+	default:
+		entries := [leaderboardTabs][]protocol.LeaderboardEntry{
+			LeaderboardKills:   l.board.TopKills,
+			LeaderboardKD:      l.board.BestKD,
+			LeaderboardWinRate: l.board.WinRate,
+			LeaderboardRating:  l.board.Rating,
+		}[l.tab]
+		switch {
+		case len(entries) > 0:
+		case l.tab == LeaderboardKills:
+			drawText(screen, tr("No players yet"), left, top)
+		default:
+			drawText(screen, trf("No players with %d+ matches yet", LeaderboardMinMatches), left, top)
+		}
+		for i, e := range entries {
+			line := fmt.Sprintf("%-2d %-20s %6d %7d %5.2f %7d %5d %5.0f%% %7d",
+				i+1, e.Name, e.Kills, e.Deaths, e.KD, e.MatchesPlayed, e.Wins, e.WinRate*100, e.Rating)
+			drawText(screen, line, left, top+i*roomListRowHeight+2)
+		}
+	}
+
+	if l.err != "" {
+		drawText(screen, trf("Error: %s", l.err), left, ScreenHeight-40)
+	}
+}
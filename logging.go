@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Подсистемы со своим уровнем логирования
+const (
+	LogNet    = "net"    // Подключения, протокол, HTTP, мастер-сервер, вебхуки
+	LogSim    = "sim"    // Симуляция комнат: матчи, бои, предметы
+	LogBots   = "bots"   // Решения ботов
+	LogClient = "client" // Клиент и его настройки
+)
+
+var LogSubsystems = []string{LogNet, LogSim, LogBots, LogClient}
+
+// Логгеры подсистем. До setupLogging пишут через slog.Default.
+var (
+	netLog    = slog.Default().With("subsystem", LogNet)
+	simLog    = slog.Default().With("subsystem", LogSim)
+	botLog    = slog.Default().With("subsystem", LogBots)
+	clientLog = slog.Default().With("subsystem", LogClient)
+)
+
+// setupLogging настраивает общий формат и уровни подсистем. level - общий
+// уровень, overrides - "подсистема=уровень" через запятую.
+func setupLogging(level string, overrides string, jsonOutput bool) error {
+	base, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]slog.Level, len(LogSubsystems))
+	for _, name := range LogSubsystems {
+		levels[name] = base
+	}
+	for _, pair := range strings.Split(overrides, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if _, known := levels[name]; !ok || !known {
+			return fmt.Errorf("invalid log level override %q, want subsystem=level with subsystem one of %s", pair, strings.Join(LogSubsystems, ", "))
+		}
+		if levels[name], err = parseLogLevel(value); err != nil {
+			return err
+		}
+	}
+
+	newLogger := func(level slog.Level) *slog.Logger {
+		return slog.New(newLogHandler(os.Stderr, level, jsonOutput))
+	}
+	netLog = newLogger(levels[LogNet]).With("subsystem", LogNet)
+	simLog = newLogger(levels[LogSim]).With("subsystem", LogSim)
+	botLog = newLogger(levels[LogBots]).With("subsystem", LogBots)
+	clientLog = newLogger(levels[LogClient]).With("subsystem", LogClient)
+
+	// Остальное, что пишет через пакет log, идет в тот же поток и формат
+	slog.SetDefault(newLogger(base))
+	return nil
+}
+
+func newLogHandler(w io.Writer, level slog.Level, jsonOutput bool) slog.Handler {
+	options := &slog.HandlerOptions{Level: level}
+	if jsonOutput {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, want debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// fatal пишет ошибку и завершает процесс, как log.Fatal
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+
+	"meatgrinder/protocol"
+)
+
+const (
+	FogAlpha         = 0.65 // Насколько темен туман, 1 - непрозрачный
+	ShadowLength     = 4000 // Длина тени препятствия, больше диагонали экрана
+	MaxSpawnAttempts = 20   // Сколько раз искать точку появления вне стен
+)
+
+var ObstacleColor = color.RGBA{90, 90, 100, 255}
+
+// Obstacle - стена: через нее нельзя пройти и нельзя смотреть
+type Obstacle struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (o Obstacle) message() protocol.Obstacle {
+	return protocol.Obstacle{X: o.X, Y: o.Y, Width: o.Width, Height: o.Height}
+}
+
+func (o Obstacle) contains(p Point) bool {
+	return p.X >= o.X && p.X <= o.X+o.Width && p.Y >= o.Y && p.Y <= o.Y+o.Height
+}
+
+// blocks сообщает, пересекает ли отрезок a-b препятствие
+func (o Obstacle) blocks(a, b Point) bool {
+	_, ok := o.intersect(a, b)
+	return ok
+}
+
+// intersect возвращает долю отрезка a-b, на которой он входит в препятствие
+// (алгоритм Лианга-Барски)
+func (o Obstacle) intersect(a, b Point) (float64, bool) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t0, t1 := 0.0, 1.0
+	clip := func(p, q float64) bool {
+		if p == 0 {
+			return q >= 0
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return false
+			}
+			t0 = math.Max(t0, t)
+		} else {
+			if t < t0 {
+				return false
+			}
+			t1 = math.Min(t1, t)
+		}
+		return true
+	}
+	ok := clip(-dx, a.X-o.X) && clip(dx, o.X+o.Width-a.X) &&
+		clip(-dy, a.Y-o.Y) && clip(dy, o.Y+o.Height-a.Y) && t0 <= t1
+	return t0, ok
+}
+
+// obstacles возвращает стены карты комнаты
+func (r *Room) obstacles() []Obstacle {
+	if r.cfg.Map == nil {
+		return nil
+	}
+	return r.cfg.Map.Obstacles
+}
+
+// lineOfSight сообщает, видно ли b из a
+func (r *Room) lineOfSight(a, b Point) bool {
+	for _, obstacle := range r.obstacles() {
+		if obstacle.blocks(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// pushOutOfObstacles выталкивает круг с центром p из стен к ближайшему
+// краю. Вызывается в горутине комнаты.
+func (r *Room) pushOutOfObstacles(p *Point, radius float64) {
+	for _, obstacle := range r.obstacles() {
+		// Стена, расширенная на радиус: центр круга в нее не заходит
+		left, right := obstacle.X-radius, obstacle.X+obstacle.Width+radius
+		top, bottom := obstacle.Y-radius, obstacle.Y+obstacle.Height+radius
+		if p.X <= left || p.X >= right || p.Y <= top || p.Y >= bottom {
+			continue
+		}
+		toLeft, toRight, toTop, toBottom := p.X-left, right-p.X, p.Y-top, bottom-p.Y
+		switch math.Min(math.Min(toLeft, toRight), math.Min(toTop, toBottom)) {
+		case toLeft:
+			p.X = left
+		case toRight:
+			p.X = right
+		case toTop:
+			p.Y = top
+		default:
+			p.Y = bottom
+		}
+	}
+}
+
+func (r *Room) insideObstacle(p Point) bool {
+	for _, obstacle := range r.obstacles() {
+		if obstacle.contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Game) drawObstacles(screen *ebiten.Image) {
+	for _, obstacle := range g.obstacles {
+		pos := g.camera.toScreen(Point{X: obstacle.X, Y: obstacle.Y})
+		ebitenutil.DrawRect(screen, pos.X, pos.Y, obstacle.Width, obstacle.Height, ObstacleColor)
+	}
+}
+
+// drawFog затемняет все, что дальше радиуса обзора или за стенами. Туман
+// рисуется непрозрачным в отдельное изображение, чтобы тени от нескольких
+// стен не темнели там, где накладываются.
+func (g *Game) drawFog(screen *ebiten.Image) {
+	me, ok := g.worldState.Players[g.playerID]
+	if !ok || (g.viewRadius <= 0 && len(g.obstacles) == 0) {
+		return
+	}
+	if g.fog == nil {
+		g.fog = ebiten.NewImage(ScreenWidth, ScreenHeight)
+	}
+	g.fog.Clear()
+	eye := g.camera.toScreen(g.playerPositions[me.ID])
+
+	if g.viewRadius > 0 {
+		// Толстое кольцо от радиуса обзора до края экрана
+		width := math.Hypot(ScreenWidth, ScreenHeight) * 2
+		vector.StrokeCircle(g.fog, float32(eye.X), float32(eye.Y), float32(g.viewRadius+width/2), float32(width), color.Black, true)
+	}
+	for _, obstacle := range g.obstacles {
+		pos := g.camera.toScreen(Point{X: obstacle.X, Y: obstacle.Y})
+		corners := []Point{
+			{X: pos.X, Y: pos.Y},
+			{X: pos.X + obstacle.Width, Y: pos.Y},
+			{X: pos.X + obstacle.Width, Y: pos.Y + obstacle.Height},
+			{X: pos.X, Y: pos.Y + obstacle.Height},
+		}
+		for i, a := range corners {
+			b := corners[(i+1)%len(corners)]
+			drawShadow(g.fog, eye, a, b)
+		}
+	}
+
+	op := &ebiten.DrawImageOptions{}
+	op.ColorScale.ScaleAlpha(FogAlpha)
+	screen.DrawImage(g.fog, op)
+	// Стены поверх тумана, чтобы было видно, что загораживает обзор
+	g.drawObstacles(screen)
+}
+
+// drawShadow закрашивает тень, которую грань a-b отбрасывает от eye
+func drawShadow(dst *ebiten.Image, eye, a, b Point) {
+	project := func(p Point) Point {
+		dx, dy := p.X-eye.X, p.Y-eye.Y
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			return p
+		}
+		return Point{X: p.X + dx/length*ShadowLength, Y: p.Y + dy/length*ShadowLength}
+	}
+	farA, farB := project(a), project(b)
+	var path vector.Path
+	path.MoveTo(float32(a.X), float32(a.Y))
+	path.LineTo(float32(b.X), float32(b.Y))
+	path.LineTo(float32(farB.X), float32(farB.Y))
+	path.LineTo(float32(farA.X), float32(farA.Y))
+	path.Close()
+	vs, is := path.AppendVerticesAndIndicesForFilling(nil, nil)
+	for i := range vs {
+		vs[i].SrcX, vs[i].SrcY = 1, 1
+		vs[i].ColorR, vs[i].ColorG, vs[i].ColorB, vs[i].ColorA = 0, 0, 0, 1
+	}
+	dst.DrawTriangles(vs, is, whitePixel(), &ebiten.DrawTrianglesOptions{})
+}
+
+var whitePixelImage *ebiten.Image
+
+// whitePixel - источник для DrawTriangles, цвет задают вершины
+func whitePixel() *ebiten.Image {
+	if whitePixelImage == nil {
+		img := ebiten.NewImage(3, 3)
+		img.Fill(color.White)
+		whitePixelImage = img.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+	}
+	return whitePixelImage
+}
@@ -1,17 +1,16 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
 	"image/color"
-	"log"
 	"math"
 	"math/rand"
 	"net"
-	"os"
-	"sync"
+	"sync/atomic"
 	"time"
 
+	"meatgrinder/protocol"
+
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
@@ -19,27 +18,30 @@ import (
 
 // Constants
 const (
-	FieldWidth                 = 800
-	FieldHeight                = 600
-	TickRate                   = 30 // Times per second the server processes updates
-	UpdateRate                 = 10 // Times per second the client renders the screen, can be different from tick rate
-	PlayerRadius               = 20
-	DamageRadius               = 50
-	PlayerAttackSpeed          = 1.0
-	DamageResistanceMultiplier = 2.0 // Урон уменьшается в 2 раза для устойчивых персонажей
-	EventPlayerJoined          = "player_joined"
-	EventPlayerLeft            = "player_left"
-	EventPlayerDamage          = "player_damage"
-	EventPlayerDeath           = "player_death"
-	EventPlayerRespawn         = "player_respawn"
-	EventPlayerAttack          = "player_attack"
-	EventSplashDamage          = "splash_damage"
-	MaxBots                    = 5   // Максимальное количество ботов
-	BotUpdateRate              = 2.0 // Частота обновления направления ботов (раз в секунду)
-	AttackRangeWarrior         = 50  // Радиус атаки для воина
-	AttackRangeMage            = 200 // Радиус атаки для мага
-	MaxDamageDistance          = 50  // Расстояние максимального урона
-	MinDamageMultiplier        = 0.2 // Минимальный множитель урона (20% на максимальной дистанции)
+	ScreenWidth         = 800 // Размер окна клиента
+	ScreenHeight        = 600
+	DefaultWorldWidth   = 1600 // Размер мира, если сервер не задал другой
+	DefaultWorldHeight  = 1200
+	PlayerMaxHealth     = 100
+	TickRate            = 30 // Default times per second the server processes updates
+	MaxCatchUpSteps     = 5  // Сколько шагов симуляция догоняет после зависания, остальное пропускает
+	UpdateRate          = 10 // Times per second the client renders the screen, can be different from tick rate
+	PlayerRadius        = 20
+	DamageRadius        = 50
+	PlayerAttackSpeed   = 1.0
+	EventPlayerJoined   = "player_joined"
+	EventPlayerLeft     = "player_left"
+	EventPlayerDamage   = "player_damage"
+	EventPlayerDeath    = "player_died"
+	EventPlayerRespawn  = "player_respawned"
+	EventPlayerAttack   = "player_attack"
+	EventSplashDamage   = "splash_damage"
+	MaxBots             = 5   // Максимальное количество ботов
+	BotUpdateRate       = 2.0 // Частота обновления направления ботов (раз в секунду)
+	AttackRangeWarrior  = 50  // Радиус атаки для воина
+	AttackRangeMage     = 200 // Радиус атаки для мага
+	MaxDamageDistance   = 50  // Расстояние максимального урона
+	MinDamageMultiplier = 0.2 // Минимальный множитель урона (20% на максимальной дистанции)
 )
 
 // Types of characters
@@ -63,10 +65,12 @@ var ClassColors = map[int]color.RGBA{
 const (
 	PhysicalDamage = iota
 	MagicalDamage
+	TrueDamage // Проходит мимо брони и сопротивления магии
 )
 
 // LogEntry struct
 type LogEntry struct {
+	Tick      uint64                 `json:"tick"`
 	Timestamp time.Time              `json:"timestamp"`
 	EventType string                 `json:"event"`
 	Data      map[string]interface{} `json:"data"`
@@ -78,695 +82,632 @@ type Point struct {
 	Y float64 `json:"y"`
 }
 
+func (p Point) message() protocol.Point {
+	return protocol.Point{X: p.X, Y: p.Y}
+}
+
 type PlayerState struct {
-	ID              int       `json:"id"`
-	Class           int       `json:"class"`
-	Position        Point     `json:"position"`
-	Health          float64   `json:"health"`
-	Target          int       `json:"target"`
-	LastAttackTime  time.Time `json:"last_attack_time"`
-	MovingDirection Point     `json:"moving_direction"`
+	ID              int            `json:"id"`
+	Name            string         `json:"name"`
+	Class           int            `json:"class"`
+	Position        Point          `json:"position"`
+	Health          float64        `json:"health"`
+	Resource        float64        `json:"resource"` // Мана или выносливость, см. ClassStats
+	Target          int            `json:"target"`
+	LastAttackTime  time.Time      `json:"last_attack_time"`
+	MovingDirection Point          `json:"moving_direction"`
+	Destination     *Point         `json:"destination,omitempty"` // Куда идет по клику
+	Sprinting       bool           `json:"sprinting,omitempty"`   // Зажат рывок
+	Knockback       *Knockback     `json:"knockback,omitempty"`   // Отлетает после удара
+	Effects         []StatusEffect `json:"effects,omitempty"`
+	Level           int            `json:"level"`                   // Уровень в текущем матче, с первого
+	XP              float64        `json:"xp"`                      // Опыт на текущем уровне
+	Team            int            `json:"team,omitempty"`          // 0 - без команды, бьет всех
+	Score           float64        `json:"score"`                   // Очки режима в текущем матче
+	Eliminated      bool           `json:"eliminated,omitempty"`    // Выбыл до конца раунда
+	CarryingFlag    int            `json:"carrying_flag,omitempty"` // Команда флага, который несет
+	Kills           int            `json:"kills"`
+	Deaths          int            `json:"deaths"`
+	Bot             bool           `json:"bot"`
+	AFK             bool           `json:"afk,omitempty"`              // Клиент давно молчит, см. watchConnections
+	Concealed       bool           `json:"concealed,omitempty"`        // Враг далеко от своих, имя и здоровье не показываются, см. -reveal-distance
+	Ping            int            `json:"ping,omitempty"`             // Задержка до клиента, мс
+	AttackCooldown  float64        `json:"attack_cooldown,omitempty"`  // Секунд до следующей атаки
+	SummonCooldown  float64        `json:"summon_cooldown,omitempty"`  // Секунд до следующего призыва
+	StealthCooldown float64        `json:"stealth_cooldown,omitempty"` // Секунд до следующей невидимости
+	AckSeq          uint64         `json:"ack_seq,omitempty"`          // Номер последнего обработанного действия
+	LastDamagedBy   int            `json:"-"`                          // Кому засчитать убийство
+	LastSummonTime  time.Time      `json:"-"`
+	LastStealthTime time.Time      `json:"-"`
+	Stats           MatchStats     `json:"-"` // Для итогов матча
+	Party           string         `json:"-"` // Код группы из очереди подбора, пусто - один
+	ReconnectToken  string         `json:"-"` // Секрет входа из init, по нему место возвращается после перезапуска
 }
 
 type WorldState struct {
+	// Tick - шаг симуляции снимка, Seq - номер рассылки комнаты. Клиент
+	// отбрасывает снимки не новее последнего, а пропуск Seq значит потерю.
+	Tick uint64 `json:"tick"`
+	Seq  uint64 `json:"seq"`
+	// Контрольная сумма сущностей этого снимка, см. viewChecksum. Приходит
+	// раз в DesyncCheckInterval рассылок, 0 - не в этот раз.
+	Checksum uint64 `json:"checksum,omitempty"`
+	// Сколько снимков перед этим сервер не отправил из-за предела трафика:
+	// они не потеряны
+	Skipped uint64 `json:"skipped,omitempty"`
+
 	Players map[int]*PlayerState `json:"players"`
+	Items   map[int]*Item        `json:"items"`
+	Flags   []*Flag              `json:"flags,omitempty"` // Только в режиме ctf
+	Zone    *Zone                `json:"zone,omitempty"`  // Только с -zone и во время боя
+
+	Projectiles map[int]*Projectile `json:"projectiles,omitempty"`
+	Minions     map[int]*Minion     `json:"minions,omitempty"`
+	Match       MatchInfo           `json:"match"`
+	Paused      bool                `json:"paused,omitempty"` // Комната на паузе, см. setPaused
 }
 
 // Player actions
 type PlayerAction struct {
-	ActionType   string `json:"action_type"`   // "move", "attack"
-	Target       Point  `json:"target"`        // only for move
+	Seq          uint64 `json:"seq"`           // Растет с каждым действием клиента
+	ActionType   string `json:"action_type"`   // "move", "move_to", "attack", "cancel_attack", "sprint", "summon", "stealth"
+	Target       Point  `json:"target"`        // only for move_to
 	AttackTarget int    `json:"attack_target"` // only for attack
 	Direction    Point  `json:"direction"`     // only for move
+	Sprint       bool   `json:"sprint"`        // only for sprint
 }
 
-// Network messages
-type NetworkMessage struct {
-	MessageType string      `json:"message_type"` // "state", "action"
-	Data        interface{} `json:"data"`
-}
+// Сколько сообщений от сети и фоновых загрузок ждут игровой цикл
+const ClientCommandQueue = 256
 
-// Game state
+// Game state. Состояние клиента принадлежит игровому циклу ebiten: Update и
+// Draw работают с ним напрямую, а сетевые и фоновые горутины присылают
+// изменения через post.
 type Game struct {
-	mu             sync.Mutex
-	worldState     WorldState
-	logEntries     []LogEntry
-	serverMode     bool
-	serverConn     net.Conn
-	clientConn     net.Conn
-	nextPlayerID   int
-	lastUpdateTime time.Time
-	inputAction    chan PlayerAction
-	playerID       int
+	commands   chan func()
+	cfg        Config
+	worldState WorldState
+	clientConn net.Conn
+	practice   *Room   // Комната тренировки внутри клиента, nil при игре на сервере
+	hosting    *Server // Сервер, запущенный из клиента для друзей
+	room       string  // Комната, в которую мы вошли
+	serverAddr string  // Адрес сервера, к которому подключились
+	playerID   int
+	inputSeq   atomic.Uint64
+
+	reconnectToken string // Из init, предъявляется при переподключении, см. reconnect.go
 
 	// UI state
-	playerPositions   map[int]Point
-	playerConnections map[int]net.Conn
-	bots              map[int]*Bot // ID игрока -> бот
+	worldWidth      float64 // Размер мира из init
+	worldHeight     float64
+	hazards         []protocol.Hazard // Опасные зоны карты комнаты
+	obstacles       []protocol.Obstacle
+	mapRef          *protocol.MapRef // Карта комнаты из init или map_change, nil - пустой мир
+	mapVote         string           // За какую карту игрок проголосовал
+	viewRadius      float64          // Радиус обзора из init, 0 - без тумана
+	rules           protocol.Rules   // Правила сервера, свои константы клиент не использует
+	fog             *ebiten.Image    // Буфер для тумана войны
+	camera          camera
+	playerPositions map[int]Point
+	reckoning       map[int]Point     // ID игрока -> расхождение с последним снимком, см. correctReckoning
+	stateReceived   time.Time         // Когда пришел последний снимок состояния
+	missedStates    uint64            // Сколько снимков подряд пропало по номерам Seq, см. countMissedStates
+	lastResync      time.Time         // Когда последний раз просили полный снимок
+	keyDirection    Point             // Последнее отправленное направление WASD
+	sprintHeld      bool              // Последнее отправленное состояние рывка
+	damageFlashes   map[int]time.Time // ID игрока -> когда он последний раз получил урон
+	healthBars      map[int]*healthBar
+	sprites         map[int]*ebiten.Image
+	anims           map[int]animState
+	corpses         []corpse
+	vfx             []vfx
+	killFeed        []killFeedEntry
+	combatLog       combatLog
+	damageTaken     []damageTaken          // Удары по своему игроку для сводки смерти
+	matchSummary    *protocol.MatchSummary // Итоги последнего матча, nil - не приходили
+	matchStats      *protocol.MatchStats   // Последняя сводка идущего матча для таблицы по Tab
+	screenFlash     time.Time              // Когда нас последний раз ранили
+	levelUpAt       time.Time              // Когда мы последний раз получили уровень
+	settings        Settings
+	keys            KeyBindings
+	keyScreen       keyBindingsScreen
+	menu            settingsMenu
+	nextFrame       time.Time // Когда рисовать следующий кадр при ограничении FPSCap
+	connect         connectMenu
+	scene           Scene
+	sound           *soundSystem
+	browser         roomBrowser
+	servers         serverBrowser
+	leaderboard     leaderboardScreen
+	editor          mapEditor
+	profile         *protocol.Profile // nil, пока сервер не прислал профиль
+}
+
+// ClassStats и ClassAttacks - баланс по умолчанию. Сервер читает действующий
+// через balance(), его можно переопределить файлом -balance.
+var ClassStats = map[int]ClassStat{
+	WarriorClass: {
+		MoveSpeed:     100,
+		AttackSpeed:   1.0,
+		AttackDamage:  15.0,
+		Resource:      ResourceStamina,
+		MaxResource:   100,
+		ResourceRegen: 15,
+		SprintCost:    35,
+		Armor:         100,
+	},
+	MageClass: {
+		MoveSpeed:     80,
+		AttackSpeed:   0.8,
+		AttackDamage:  20.0,
+		Resource:      ResourceMana,
+		MaxResource:   100,
+		ResourceRegen: 8,
+		AttackCost:    15,
+		SprintCost:    25,
+		MagicResist:   100,
+	},
 }
 
-var ClassStats = map[int]struct {
-	MoveSpeed    float64
-	AttackSpeed  float64
-	AttackDamage float64
-}{
+// AttackSpec описывает атаку класса. Урон по области есть только у атак
+// с ненулевым SplashRadius.
+type AttackSpec struct {
+	Name         string       `json:"name"`
+	DamageType   int          `json:"damage_type"`
+	Range        float64      `json:"range"`
+	SplashRadius float64      `json:"splash_radius"`
+	OnHit        StatusEffect `json:"on_hit"`    // Эффект, накладываемый на основную цель
+	Knockback    float64      `json:"knockback"` // На сколько пикселей отбрасывает цель
+	// Скорость снаряда, 0 - удар попадает сразу
+	ProjectileSpeed float64 `json:"projectile_speed,omitempty"`
+	// Доля брони или сопротивления магии цели, которую атака не замечает
+	ArmorPenetration float64 `json:"armor_penetration,omitempty"`
+}
+
+var ClassAttacks = map[int]AttackSpec{
 	WarriorClass: {
-		MoveSpeed:    100,
-		AttackSpeed:  1.0,
-		AttackDamage: 15.0,
+		Name:       "slash",
+		DamageType: PhysicalDamage,
+		Range:      AttackRangeWarrior,
+		OnHit:      StatusEffect{Type: EffectSlow, Remaining: WarriorSlowTime, Magnitude: WarriorSlow},
+		Knockback:  WarriorKnockback,
+
+		ArmorPenetration: WarriorArmorPenetration,
 	},
 	MageClass: {
-		MoveSpeed:    80,
-		AttackSpeed:  0.8,
-		AttackDamage: 20.0,
+		Name:         "fireball",
+		DamageType:   MagicalDamage,
+		Range:        AttackRangeMage,
+		SplashRadius: DamageRadius,
+		OnHit:        StatusEffect{Type: EffectBurn, Remaining: MageBurnTime, Magnitude: MageBurnDPS},
+		Knockback:    MageKnockback,
+
+		ProjectileSpeed: FireballSpeed,
 	},
 }
 
-// Добавим структуру для ботов
-type Bot struct {
-	LastDirectionChange time.Time
+// resistanceMultiplier возвращает множитель урона с учетом брони или
+// сопротивления магии цели за вычетом пробивания атаки. Защита ArmorScale
+// вдвое уменьшает урон, чистый урон не уменьшается ничем.
+func resistanceMultiplier(target *PlayerState, spec AttackSpec) float64 {
+	stat := balance().Classes[target.Class]
+	var defense float64
+	switch spec.DamageType {
+	case PhysicalDamage:
+		defense = stat.Armor
+	case MagicalDamage:
+		defense = stat.MagicResist
+	default:
+		return 1.0
+	}
+	defense *= 1 - spec.ArmorPenetration
+	if defense <= 0 {
+		return 1.0
+	}
+	return ArmorScale / (ArmorScale + defense)
 }
 
-func NewGame(serverMode bool) *Game {
-	rand.Seed(time.Now().UnixNano())
-	g := &Game{
+func NewGame(cfg Config) *Game {
+	return &Game{
+		commands: make(chan func(), ClientCommandQueue),
+		cfg:      cfg,
 		worldState: WorldState{
 			Players: make(map[int]*PlayerState),
+			Items:   make(map[int]*Item),
 		},
-		logEntries:        make([]LogEntry, 0),
-		serverMode:        serverMode,
-		nextPlayerID:      1,
-		lastUpdateTime:    time.Now(),
-		inputAction:       make(chan PlayerAction, 10),
-		playerPositions:   make(map[int]Point),
-		playerConnections: make(map[int]net.Conn),
-		bots:              make(map[int]*Bot),
-	}
-
-	if serverMode {
-		g.playerID = 0
-		go g.spawnBots()
-	} else {
-		g.playerID = -1
+		playerID:        -1,
+		scene:           menuScene{},
+		keys:            DefaultKeyBindings(),
+		worldWidth:      DefaultWorldWidth,
+		worldHeight:     DefaultWorldHeight,
+		playerPositions: make(map[int]Point),
+		damageFlashes:   make(map[int]time.Time),
+		healthBars:      make(map[int]*healthBar),
+		anims:           make(map[int]animState),
 	}
-
-	return g
 }
 
-// Добавим функцию для создания ботов
-func (g *Game) spawnBots() {
-	time.Sleep(2 * time.Second) // Ждем немного для подключения реальных игроков
-
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	// Проверяем текущее количество ботов
-	currentBots := len(g.bots)
-	if currentBots >= MaxBots {
-		return
-	}
-
-	// Создаем только недостающее количество ботов
-	for i := 0; i < MaxBots-currentBots; i++ {
-		botID := g.nextPlayerID
-		g.nextPlayerID++
+// --- Client Logic ---
 
-		// Случайный класс и позиция
-		playerClass := rand.Intn(TotalClasses)
-		pos := Point{X: rand.Float64() * FieldWidth, Y: rand.Float64() * FieldHeight}
+func (g *Game) StartClient() {
+	ebiten.SetWindowTitle("Meat Grinder")
+	// Пропущенный из-за FPSCap кадр оставляет на экране предыдущий
+	ebiten.SetScreenClearedEveryFrame(false)
+	g.sprites = loadSprites()
+	g.settings = LoadSettings()
+	g.keys = LoadKeyBindings()
+	g.sound = newSoundSystem(g.settings)
+	g.applySettings()
+
+	// Подключение начинается из главного меню
+	g.connect = connectMenu{
+		address:  g.cfg.Addr,
+		name:     g.cfg.Name,
+		password: g.cfg.Password,
+	}
+	g.scene = menuScene{}
 
-		g.worldState.Players[botID] = &PlayerState{
-			ID:              botID,
-			Class:           playerClass,
-			Position:        pos,
-			Health:          100,
-			Target:          0,
-			LastAttackTime:  time.Now(),
-			MovingDirection: Point{X: 0, Y: 0},
-		}
-		g.playerPositions[botID] = pos
-		g.bots[botID] = &Bot{
-			LastDirectionChange: time.Now(),
-		}
+	if err := ebiten.RunGame(g); err != nil {
+		fatal(clientLog, "Game stopped", "err", err)
 	}
 }
 
-// --- Server Logic ---
-func (g *Game) StartServer() {
-	ln, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer ln.Close()
-	log.Println("Server listening on :8080")
-
-	go g.serverTick()
-
+func (g *Game) clientReceive(conn net.Conn) {
+	decoder := protocol.NewDecoder(conn)
 	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Println("Error accepting connection:", err)
+		msg, err := decoder.Next()
+		if errors.Is(err, protocol.ErrMalformed) {
+			clientLog.Warn("Skipping malformed message", "err", err)
 			continue
 		}
-		log.Println("Accepted new client")
-		go g.handleClient(conn)
-	}
-}
-
-func (g *Game) handleClient(conn net.Conn) {
-	defer func() {
-		g.mu.Lock()
-		defer g.mu.Unlock()
-		for playerID, playerConn := range g.playerConnections {
-			if playerConn == conn {
-				conn.Close()
-				delete(g.playerConnections, playerID)
-				break
-			}
-		}
-
-	}()
-
-	playerID := g.addPlayer()
-	g.mu.Lock()
-	g.playerConnections[playerID] = conn
-	g.mu.Unlock()
-
-	g.sendInitialState(conn, playerID)
-
-	decoder := json.NewDecoder(conn)
-	for {
-		var msg NetworkMessage
-		err := decoder.Decode(&msg)
 		if err != nil {
-			log.Printf("Error decoding message: %v", err)
-			g.removePlayer(playerID)
+			clientLog.Warn("Error decoding message", "err", err)
+			g.post(func() {
+				g.disconnected(conn, err)
+			})
 			return
 		}
 
-		if msg.MessageType == "action" {
-			var action PlayerAction
-			data, ok := msg.Data.(map[string]interface{})
-			if !ok {
-				log.Println("Error invalid message data:", data)
+		switch msg.Type {
+		case protocol.MsgInit:
+			var init protocol.Init
+			if err := msg.Decode(&init); err != nil {
+				clientLog.Error("Invalid init message", "err", err)
 				continue
 			}
-
-			action.ActionType = data["action_type"].(string)
-
-			if action.ActionType == "move" {
-				if target, ok := data["target"].(map[string]interface{}); ok {
-					action.Target.X = target["x"].(float64)
-					action.Target.Y = target["y"].(float64)
-				}
-				if dir, ok := data["direction"].(map[string]interface{}); ok {
-					action.Direction.X = dir["x"].(float64)
-					action.Direction.Y = dir["y"].(float64)
-				}
-				g.mu.Lock()
-				if player, ok := g.worldState.Players[playerID]; ok {
-					player.MovingDirection = action.Direction
-					g.playerPositions[playerID] = player.Position
-				}
-				g.mu.Unlock()
-				select {
-				case g.inputAction <- action:
-				default:
-					// Если канал полон, пропускаем
-				}
-			} else if action.ActionType == "attack" {
-				if attackTarget, ok := data["attack_target"].(float64); ok {
-					action.AttackTarget = int(attackTarget)
-				}
+			if err := protocol.CheckVersion(init.Version); err != nil {
+				clientLog.Warn("Incompatible server", "version", init.Version, "err", err)
+				g.post(func() {
+					g.incompatible(conn, 0, "")
+				})
+				return
 			}
-			g.mu.Lock()
-			if player, ok := g.worldState.Players[playerID]; ok {
-				player.Target = action.AttackTarget
+			g.post(func() {
+				g.playerID = init.PlayerID
+				g.reconnectToken = init.ReconnectToken
+				g.room = init.Room
+				g.worldWidth = init.WorldWidth
+				g.worldHeight = init.WorldHeight
+				g.applyMap(init.Map)
+				g.viewRadius = init.ViewRadius
+				g.rules = init.Rules
+				// Номера снимков у каждой комнаты свои
+				g.worldState.Seq = 0
+				g.missedStates = 0
+				g.browser.active = false
+				g.scene = playScene{}
+				clientLog.Info("Joined room", "room", init.Room, "player_id", init.PlayerID)
+				g.requestProfile()
+			})
+		case protocol.MsgMapChange:
+			var change protocol.MapChange
+			if err := msg.Decode(&change); err != nil {
+				clientLog.Error("Invalid map change", "err", err)
+				continue
 			}
-			g.mu.Unlock()
-		}
-	}
-}
-
-func (g *Game) addPlayer() int {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	playerID := g.nextPlayerID
-	g.nextPlayerID++
-
-	// Random class
-	playerClass := rand.Intn(TotalClasses)
-
-	// Random position
-	pos := Point{X: rand.Float64() * FieldWidth, Y: rand.Float64() * FieldHeight}
-
-	g.worldState.Players[playerID] = &PlayerState{
-		ID:              playerID,
-		Class:           playerClass,
-		Position:        pos,
-		Health:          100,
-		Target:          0, // No target by default
-		LastAttackTime:  time.Now(),
-		MovingDirection: Point{X: 0, Y: 0},
-	}
-	g.playerPositions[playerID] = pos
-
-	logEntry := LogEntry{
-		Timestamp: time.Now(),
-		EventType: "player_joined",
-		Data: map[string]interface{}{
-			"player_id": playerID,
-			"class":     ClassNames[playerClass],
-			"position":  pos,
-		},
-	}
-	g.logEntries = append(g.logEntries, logEntry)
-	log.Printf("Player %d joined, class: %v, position: %v\n", playerID, ClassNames[playerClass], pos)
-	return playerID
-}
-
-func (g *Game) removePlayer(playerID int) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	if _, ok := g.worldState.Players[playerID]; ok {
-		logEntry := LogEntry{
-			Timestamp: time.Now(),
-			EventType: "player_left",
-			Data: map[string]interface{}{
-				"player_id": playerID,
-			},
-		}
-		g.logEntries = append(g.logEntries, logEntry)
-		delete(g.worldState.Players, playerID)
-		delete(g.playerPositions, playerID)
-		delete(g.playerConnections, playerID)
-		log.Printf("Player %d disconnected\n", playerID)
-	}
-}
-
-func (g *Game) serverTick() {
-	ticker := time.NewTicker(time.Second / TickRate)
-	defer ticker.Stop()
-	for range ticker.C {
-		g.updateGameState()
-		g.broadcastState()
-	}
-}
-
-func (g *Game) updateGameState() {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	now := time.Now()
-	deltaTime := now.Sub(g.lastUpdateTime).Seconds()
-	g.lastUpdateTime = now
-
-	// Обновляем поведение ботов
-	for id, bot := range g.bots {
-		if player, ok := g.worldState.Players[id]; ok {
-			// Меняем направление движения бота каждые BotUpdateRate секунд
-			if now.Sub(bot.LastDirectionChange).Seconds() >= 1.0/BotUpdateRate {
-				// Случайное направление
-				angle := rand.Float64() * 2 * math.Pi
-				player.MovingDirection = Point{
-					X: math.Cos(angle),
-					Y: math.Sin(angle),
-				}
-				bot.LastDirectionChange = now
-
-				// Находим ближайшую цель
-				var closestDist float64 = math.MaxFloat64
-				var closestID int
-				for targetID, target := range g.worldState.Players {
-					if targetID == id {
-						continue
-					}
-					dist := math.Sqrt(math.Pow(player.Position.X-target.Position.X, 2) +
-						math.Pow(player.Position.Y-target.Position.Y, 2))
-					if dist < closestDist {
-						closestDist = dist
-						closestID = targetID
-					}
+			g.post(func() {
+				g.worldWidth = change.WorldWidth
+				g.worldHeight = change.WorldHeight
+				g.applyMap(change.Map)
+			})
+		case protocol.MsgMapData:
+			var data protocol.MapData
+			if err := msg.Decode(&data); err != nil {
+				clientLog.Error("Invalid map data", "err", err)
+				continue
+			}
+			g.post(func() {
+				g.receiveMap(data)
+			})
+		case protocol.MsgRules:
+			var rules protocol.Rules
+			if err := msg.Decode(&rules); err != nil {
+				clientLog.Error("Invalid rules", "err", err)
+				continue
+			}
+			g.post(func() {
+				g.rules = rules
+			})
+			clientLog.Info("Server rules updated")
+		case protocol.MsgPing:
+			var ping protocol.Ping
+			if err := msg.Decode(&ping); err != nil {
+				clientLog.Error("Invalid ping", "err", err)
+				continue
+			}
+			// Отвечаем из игрового цикла: если он завис, сервер это заметит
+			g.post(func() {
+				if g.clientConn == nil {
+					return
 				}
-				if closestID != 0 {
-					player.Target = closestID
+				if err := protocol.NewEncoder(g.clientConn).Encode(protocol.MsgPong, ping); err != nil {
+					clientLog.Error("Error answering ping", "err", err)
 				}
+			})
+		case protocol.MsgProfile:
+			var profile protocol.Profile
+			if err := msg.Decode(&profile); err != nil {
+				clientLog.Error("Invalid profile", "err", err)
+				continue
 			}
-		}
-	}
-
-	for id, player := range g.worldState.Players {
-		// Movement
-		if player.MovingDirection.X != 0 || player.MovingDirection.Y != 0 {
-			speed := ClassStats[player.Class].MoveSpeed
-			player.Position.X += player.MovingDirection.X * speed * deltaTime
-			player.Position.Y += player.MovingDirection.Y * speed * deltaTime
-
-			// Clamp to field
-			player.Position.X = math.Max(0, math.Min(player.Position.X, FieldWidth))
-			player.Position.Y = math.Max(0, math.Min(player.Position.Y, FieldHeight))
-
-			// Обновляем позицию в playerPositions
-			g.playerPositions[id] = player.Position
-		}
-
-		// Attack
-		if player.Target != 0 {
-			targetPlayer, ok := g.worldState.Players[player.Target]
-			if !ok {
-				continue // Target is invalid
+			g.post(func() {
+				g.profile = &profile
+			})
+		case protocol.MsgMatchSummary:
+			var summary protocol.MatchSummary
+			if err := msg.Decode(&summary); err != nil {
+				clientLog.Error("Invalid match summary", "err", err)
+				continue
 			}
-
-			if now.Sub(player.LastAttackTime).Seconds() >= 1.0/PlayerAttackSpeed {
-				g.performAttack(player, targetPlayer, now)
-				player.LastAttackTime = now
+			g.post(func() {
+				g.matchSummary = &summary
+			})
+		case protocol.MsgMatchStats:
+			var stats protocol.MatchStats
+			if err := msg.Decode(&stats); err != nil {
+				clientLog.Error("Invalid match stats", "err", err)
+				continue
 			}
-		}
-	}
-
-	// Respawn dead players
-	for id, player := range g.worldState.Players {
-		if player.Health <= 0 {
-			log.Printf("Player %d died.\n", id)
-
-			logEntry := LogEntry{
-				Timestamp: time.Now(),
-				EventType: "player_died",
-				Data: map[string]interface{}{
-					"player_id": id,
-				},
+			g.post(func() {
+				g.matchStats = &stats
+			})
+		case protocol.MsgQueueStatus:
+			var status protocol.QueueStatus
+			if err := msg.Decode(&status); err != nil {
+				clientLog.Error("Invalid queue status", "err", err)
+				continue
 			}
-			g.logEntries = append(g.logEntries, logEntry)
-
-			// Respawn
-			player.Health = 100
-			player.Position.X = rand.Float64() * FieldWidth
-			player.Position.Y = rand.Float64() * FieldHeight
-
-			logEntry = LogEntry{
-				Timestamp: time.Now(),
-				EventType: "player_respawned",
-				Data: map[string]interface{}{
-					"player_id": id,
-					"position":  player.Position,
-				},
+			g.post(func() {
+				g.browser.queue = status
+			})
+		case protocol.MsgRoomAssignment:
+			var assignment protocol.RoomAssignment
+			if err := msg.Decode(&assignment); err != nil {
+				clientLog.Error("Invalid room assignment", "err", err)
+				continue
 			}
-			g.logEntries = append(g.logEntries, logEntry)
-
-			log.Printf("Player %d respawned at %v\n", id, player.Position)
-		}
-	}
-}
-
-func (g *Game) performAttack(attacker *PlayerState, target *PlayerState, now time.Time) {
-	// Базовый урон из характеристик класса
-	baseDamage := ClassStats[attacker.Class].AttackDamage
-	damageType := PhysicalDamage
-	if attacker.Class == MageClass {
-		damageType = MagicalDamage
-	}
-
-	// Расчет расстояния до цели
-	dist := math.Sqrt(math.Pow(attacker.Position.X-target.Position.X, 2) +
-		math.Pow(attacker.Position.Y-target.Position.Y, 2))
-
-	// Расчет множителя урона в зависимости от расстояния
-	distanceMultiplier := 1.0
-	if dist > MaxDamageDistance {
-		// Линейное уменьшение урона с расстоянием
-		distanceMultiplier = math.Max(MinDamageMultiplier,
-			1.0-((dist-MaxDamageDistance)/MaxDamageDistance)*(1.0-MinDamageMultiplier))
-	}
-
-	// Расчет сопротивления урону
-	resistanceMultiplier := 1.0
-	if (target.Class == WarriorClass && damageType == PhysicalDamage) ||
-		(target.Class == MageClass && damageType == MagicalDamage) {
-		resistanceMultiplier = 1.0 / DamageResistanceMultiplier
-	}
-
-	// Применяем все множители к базовому урону
-	finalDamage := baseDamage * distanceMultiplier * resistanceMultiplier
-	target.Health -= finalDamage
-	if target.Health < 0 {
-		target.Health = 0
-	}
-
-	logEntry := LogEntry{
-		Timestamp: now,
-		EventType: "player_attack",
-		Data: map[string]interface{}{
-			"attacker_id": attacker.ID,
-			"target_id":   target.ID,
-			"damage":      finalDamage,
-			"damage_type": damageType,
-		},
-	}
-	g.logEntries = append(g.logEntries, logEntry)
-	log.Printf("Player %d attacked Player %d for %.2f damage\n", attacker.ID, target.ID, finalDamage)
-
-	// Apply splash damage
-	for _, other := range g.worldState.Players {
-		if other.ID == target.ID {
-			continue
-		}
-
-		dist := math.Sqrt(math.Pow(target.Position.X-other.Position.X, 2) + math.Pow(target.Position.Y-other.Position.Y, 2))
-		if dist < DamageRadius {
-
-			otherReduction := 1.0
-			if (other.Class == WarriorClass && damageType == PhysicalDamage) || (other.Class == MageClass && damageType == MagicalDamage) {
-				otherReduction = 0.5 // Resist
+			g.post(func() {
+				g.assignedRoom(assignment)
+			})
+		case protocol.MsgParty:
+			var party protocol.Party
+			if err := msg.Decode(&party); err != nil {
+				clientLog.Error("Invalid party", "err", err)
+				continue
 			}
-			splashDamage := finalDamage * otherReduction
-			other.Health -= splashDamage
-			if other.Health < 0 {
-				other.Health = 0
+			g.post(func() {
+				g.partyChanged(party)
+			})
+		case protocol.MsgPartyInvite:
+			var invite protocol.PartyInvite
+			if err := msg.Decode(&invite); err != nil {
+				clientLog.Error("Invalid party invite", "err", err)
+				continue
 			}
-
-			logEntry = LogEntry{
-				Timestamp: now,
-				EventType: "splash_damage",
-				Data: map[string]interface{}{
-					"attacker_id": attacker.ID,
-					"target_id":   other.ID,
-					"damage":      splashDamage,
-					"damage_type": damageType,
-				},
+			g.post(func() {
+				g.browser.party.invite = &invite
+			})
+		case protocol.MsgRoomList:
+			var list protocol.RoomList
+			if err := msg.Decode(&list); err != nil {
+				clientLog.Error("Invalid room list", "err", err)
+				continue
 			}
-			g.logEntries = append(g.logEntries, logEntry)
-			log.Printf("Player %d received %.2f splash damage from Player %d\n", other.ID, splashDamage, attacker.ID)
-		}
-	}
-}
-
-func (g *Game) broadcastState() {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	state := NetworkMessage{
-		MessageType: "state",
-		Data:        g.worldState,
-	}
-
-	for _, player := range g.worldState.Players {
-		if g.serverMode {
-			if conn, ok := g.playerConnections[player.ID]; ok {
-				g.mu.Unlock()
-				if err := json.NewEncoder(conn).Encode(state); err != nil {
-					log.Printf("Error encoding state for player %d: %v\n", player.ID, err)
+			g.post(func() {
+				g.browser.rooms = list.Rooms
+				if g.browser.selected >= len(list.Rooms) {
+					g.browser.selected = 0
+				}
+			})
+		case protocol.MsgError:
+			var rejection protocol.Error
+			if err := msg.Decode(&rejection); err != nil {
+				clientLog.Error("Invalid error message", "err", err)
+				continue
+			}
+			clientLog.Warn("Server error", "message", rejection.Message)
+			if rejection.Code == protocol.ErrCodeVersionMismatch {
+				g.post(func() {
+					g.incompatible(conn, rejection.MinVersion, rejection.Download)
+				})
+				return
+			}
+			if rejection.AuthFailed() {
+				// С неверным паролем дальше делать нечего, пароль вводится в главном меню
+				g.post(func() {
+					g.rejected(conn, rejection.Message)
+				})
+				return
+			}
+			g.post(func() {
+				// Если войти не удалось, возвращаемся к списку комнат
+				if g.playerID < 0 {
+					g.browser.active = true
+					g.browser.queued = time.Time{}
+					g.browser.err = rejection.Message
 				}
-				g.mu.Lock()
+			})
+		case protocol.MsgAttack:
+			var attack protocol.Attack
+			if err := msg.Decode(&attack); err != nil {
+				clientLog.Error("Invalid attack", "err", err)
+				continue
+			}
+			g.post(func() {
+				g.addAttackVFX(attack, time.Now())
+				g.playAt(SoundAttack, toPoint(attack.From))
+			})
+		case protocol.MsgImpact:
+			var impact protocol.Impact
+			if err := msg.Decode(&impact); err != nil {
+				clientLog.Error("Invalid impact", "err", err)
+				continue
 			}
-		} else if player.ID == g.playerID {
-			if g.clientConn == nil {
+			g.post(func() {
+				g.addImpactVFX(impact, time.Now())
+			})
+		case protocol.MsgDamage:
+			var damage protocol.Damage
+			if err := msg.Decode(&damage); err != nil {
+				clientLog.Error("Invalid damage", "err", err)
 				continue
 			}
-			if err := json.NewEncoder(g.clientConn).Encode(state); err != nil {
-				log.Printf("Error encoding state for client: %v\n", err)
+			g.post(func() {
+				g.addDamageVFX(damage, time.Now())
+				g.playAt(SoundHit, toPoint(damage.Position))
+			})
+		case protocol.MsgEvent:
+			var event protocol.Event
+			if err := msg.Decode(&event); err != nil {
+				clientLog.Error("Invalid event", "err", err)
+				continue
 			}
+			g.post(func() {
+				g.handleEvent(event, time.Now())
+			})
+		case protocol.MsgEntityEnter, protocol.MsgEntityLeave:
+			var visibility protocol.EntityVisibility
+			if err := msg.Decode(&visibility); err != nil {
+				clientLog.Error("Invalid visibility update", "err", err)
+				continue
+			}
+			g.post(func() {
+				// Вошедший в обзор игрок появляется сразу на своем месте и с начала
+				// анимации, о пропавшем больше ничего не храним
+				for _, id := range visibility.IDs {
+					delete(g.playerPositions, id)
+					delete(g.anims, id)
+					if msg.Type == protocol.MsgEntityLeave {
+						delete(g.damageFlashes, id)
+					}
+				}
+			})
+		case protocol.MsgState:
+			// Разбираем в новую структуру, иначе Unmarshal сольет карты и удаленные
+			// игроки и подобранные предметы останутся на экране
+			var state WorldState
+			if err := msg.Decode(&state); err != nil {
+				clientLog.Error("Invalid state data", "err", err)
+				continue
+			}
+
+			g.post(func() {
+				if state.Seq <= g.worldState.Seq {
+					// Устаревший или повторный снимок
+					return
+				}
+				g.countMissedStates(state)
+				desynced := !g.checkDesync(state)
+				g.trackDamage(state, time.Now())
+				g.trackHealthBars(state, time.Now())
+				g.updateAnimations(state, time.Now())
+				// После матча статистика в профиле обновилась
+				matchEnded := state.Match.Phase == MatchEnded && g.worldState.Match.Phase != MatchEnded
+				g.correctReckoning(state)
+				g.worldState = state
+				g.stateReceived = time.Now()
+				// Обновляем позиции после получения нового состояния
+				for id, player := range g.worldState.Players {
+					g.playerPositions[id] = player.Position
+				}
+				if desynced {
+					g.requestResync("checksum mismatch", time.Now())
+				} else if reason := g.resyncReason(state); reason != "" {
+					g.requestResync(reason, time.Now())
+				}
+				if matchEnded {
+					g.requestProfile()
+				}
+			})
+		default:
+			clientLog.Warn("Unknown message type", "type", msg.Type)
 		}
 	}
 }
 
-func (g *Game) getPlayerConnection(playerID int) (net.Conn, bool) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	conn, ok := g.playerConnections[playerID]
-	return conn, ok
+// post передает fn игровому циклу. Если цикл не успевает, горутина ждет.
+func (g *Game) post(fn func()) {
+	g.commands <- fn
 }
 
-func (g *Game) sendInitialState(conn net.Conn, playerID int) {
-	initialState := NetworkMessage{
-		MessageType: "init",
-		Data: map[string]interface{}{
-			"player_id":   playerID,
-			"server_mode": g.serverMode,
-		},
-	}
-	if err := json.NewEncoder(conn).Encode(initialState); err != nil {
-		log.Println("Error sending initial state:", err)
-	}
-
-	state := NetworkMessage{
-		MessageType: "state",
-		Data:        g.worldState,
-	}
-
-	if err := json.NewEncoder(conn).Encode(state); err != nil {
-		log.Println("Error sending state:", err)
+// runCommands выполняет присланное до начала кадра. Пришедшее во время
+// выполнения ждет следующего кадра.
+func (g *Game) runCommands() {
+	for n := len(g.commands); n > 0; n-- {
+		(<-g.commands)()
 	}
-
-	log.Printf("Sent initial state to player %d\n", playerID)
-
 }
 
-// --- Client Logic ---
-
-func (g *Game) StartClient() {
-	ebiten.SetWindowSize(FieldWidth, FieldHeight)
-	ebiten.SetWindowTitle("Meat Grinder")
-
-	conn, err := net.Dial("tcp", "localhost:8080")
-	if err != nil {
-		log.Fatal("Failed to connect to server:", err)
-	}
-	g.clientConn = conn
-	log.Println("Connected to server")
-
-	go g.clientReceive()
-
-	if err := ebiten.RunGame(g); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func (g *Game) clientReceive() {
-	decoder := json.NewDecoder(g.clientConn)
-
-	var initMsg NetworkMessage
-	if err := decoder.Decode(&initMsg); err != nil {
-		log.Println("Error decoding init message:", err)
-		return
-	}
-
-	if initMsg.MessageType != "init" {
-		log.Println("Expected 'init' message, but got:", initMsg.MessageType)
-		return
-	}
-
-	data, ok := initMsg.Data.(map[string]interface{})
-	if !ok {
-		log.Println("Error invalid message data in init message:", initMsg.Data)
-		return
-	}
-
-	if id, ok := data["player_id"].(float64); ok {
-		g.playerID = int(id)
-		log.Println("Assigned player ID:", g.playerID)
-	}
-
-	var stateMsg NetworkMessage
-	if err := decoder.Decode(&stateMsg); err != nil {
-		log.Println("Error decoding state message:", err)
-		return
-	}
-
-	if stateMsg.MessageType != "state" {
-		log.Println("Expected 'state' message, but got:", stateMsg.MessageType)
-		return
-	}
-
-	stateData, ok := stateMsg.Data.(map[string]interface{})
-	if !ok {
-		log.Println("Error invalid state data:", stateMsg.Data)
-		return
-	}
-
-	stateJSON, err := json.Marshal(stateData)
-	if err != nil {
-		log.Println("Error marshaling state data to json:", err)
-		return
-	}
-
-	g.mu.Lock()
-	err = json.Unmarshal(stateJSON, &g.worldState)
-	if err != nil {
-		log.Println("Error unmarshaling world state:", err)
-	}
-	// Обновляем позиции после получения нового состояния
-	for id, player := range g.worldState.Players {
-		g.playerPositions[id] = player.Position
-	}
-	g.mu.Unlock()
-
-	for {
-		var msg NetworkMessage
-		err := decoder.Decode(&msg)
-		if err != nil {
-			log.Println("Error decoding message:", err)
-			return
-		}
-
-		if msg.MessageType == "state" {
-			stateData, ok := msg.Data.(map[string]interface{})
-			if !ok {
-				log.Println("Error invalid state data:", msg.Data)
-				continue
-			}
-
-			stateJSON, err := json.Marshal(stateData)
-			if err != nil {
-				log.Println("Error marshaling state data to json:", err)
-				continue
-			}
-
-			g.mu.Lock()
-			err = json.Unmarshal(stateJSON, &g.worldState)
-			if err != nil {
-				log.Println("Error unmarshaling world state:", err)
-			}
-			// Обновляем позиции после получения нового состояния
-			for id, player := range g.worldState.Players {
-				g.playerPositions[id] = player.Position
-			}
-			g.mu.Unlock()
+// Update implements ebiten.Game interface
+func (g *Game) Update() error {
+	g.runCommands()
+	// Меню настроек и экран клавиш открываются поверх любой сцены и
+	// забирают ввод себе
+	overlay := true
+	switch {
+	case g.menu.active:
+		g.updateSettingsMenu()
+	case g.keyScreen.active:
+		g.updateKeyBindingsScreen()
+	case g.editor.testing && inpututil.IsKeyJustPressed(EditorKey):
+		// Из проверки карты - обратно в редактор
+		g.stopEditorTest()
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		// Во время атаки Esc сначала отменяет ее
+		if !g.cancelAttack() {
+			g.menu.active = true
 		}
+	default:
+		overlay = false
 	}
-}
+	g.updateDeadReckoning(time.Now())
+	g.updateKnockbacks(time.Now())
 
-// Update implements ebiten.Game interface
-func (g *Game) Update() error {
-	g.handleInput()
+	if !overlay {
+		g.scene.Update(g)
+	}
 	return nil
 }
 
 func (g *Game) handleInput() {
-	if g.serverMode {
-		return
-	}
-
-	g.mu.Lock()
 	// Проверяем только существование игрока, переменная не нужна
 	if _, ok := g.worldState.Players[g.playerID]; !ok {
-		g.mu.Unlock()
 		return // Player hasn't joined yet
 	}
-	g.mu.Unlock()
 
 	var direction Point
 
 	// Movement Input
-	if ebiten.IsKeyPressed(ebiten.KeyW) {
+	if g.keys.Pressed(InputMoveUp) {
 		direction.Y -= 1
 	}
-	if ebiten.IsKeyPressed(ebiten.KeyS) {
+	if g.keys.Pressed(InputMoveDown) {
 		direction.Y += 1
 	}
-	if ebiten.IsKeyPressed(ebiten.KeyA) {
+	if g.keys.Pressed(InputMoveLeft) {
 		direction.X -= 1
 	}
-	if ebiten.IsKeyPressed(ebiten.KeyD) {
+	if g.keys.Pressed(InputMoveRight) {
 		direction.X += 1
 	}
 
@@ -777,11 +718,14 @@ func (g *Game) handleInput() {
 		direction.Y /= magnitude
 	}
 
-	g.mu.Lock()
 	if player, ok := g.worldState.Players[g.playerID]; ok {
-		if direction.X != player.MovingDirection.X || direction.Y != player.MovingDirection.Y {
+		// Сравниваем с последним отправленным направлением клавиш: при движении
+		// по клику сервер сам меняет MovingDirection, и его нельзя сбрасывать
+		if direction != g.keyDirection {
+			g.keyDirection = direction
 			// Обновляем локальное направление
 			player.MovingDirection = direction
+			player.Destination = nil
 			// Отправляем на сервер
 			g.sendActionToServer(PlayerAction{
 				ActionType: "move",
@@ -789,19 +733,49 @@ func (g *Game) handleInput() {
 			})
 		}
 	}
-	g.mu.Unlock()
+
+	if g.keys.JustPressed(InputSummon) {
+		g.sendActionToServer(PlayerAction{ActionType: "summon"})
+	}
+	if g.keys.JustPressed(InputStealth) {
+		g.sendActionToServer(PlayerAction{ActionType: "stealth"})
+	}
+
+	// Рывок действует, пока клавиша зажата
+	if sprint := g.keys.Pressed(InputSprint); sprint != g.sprintHeld {
+		g.sprintHeld = sprint
+		if p, ok := g.worldState.Players[g.playerID]; ok {
+			p.Sprinting = sprint
+		}
+		g.sendActionToServer(PlayerAction{ActionType: "sprint", Sprint: sprint})
+	}
+
+	// Move-to Input
+	if g.keys.JustPressed(InputMoveTo) {
+		x, y := ebiten.CursorPosition()
+		destination := g.camera.toWorld(x, y)
+		if p, ok := g.worldState.Players[g.playerID]; ok {
+			p.Destination = &destination
+		}
+		// Переход в точку прекращает атаку
+		g.cancelAttack()
+
+		g.sendActionToServer(PlayerAction{
+			ActionType: "move_to",
+			Target:     destination,
+		})
+	}
 
 	// Attack Input
-	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+	if g.keys.JustPressed(InputAttack) {
 		x, y := ebiten.CursorPosition()
-		closestPlayer := g.findClosestPlayer(Point{X: float64(x), Y: float64(y)})
+		mousePos := g.camera.toWorld(x, y)
+		closestPlayer := g.findClosestPlayer(mousePos)
 
 		if closestPlayer != 0 {
-			g.mu.Lock()
 			if p, ok := g.worldState.Players[g.playerID]; ok {
 				p.Target = closestPlayer
 			}
-			g.mu.Unlock()
 
 			g.sendActionToServer(PlayerAction{
 				ActionType:   "attack",
@@ -811,9 +785,18 @@ func (g *Game) handleInput() {
 	}
 }
 
+// cancelAttack снимает цель игрока. Возвращает false, если цели не было.
+func (g *Game) cancelAttack() bool {
+	p, ok := g.worldState.Players[g.playerID]
+	if !ok || p.Target == 0 {
+		return false
+	}
+	p.Target = 0
+	g.sendActionToServer(PlayerAction{ActionType: "cancel_attack"})
+	return true
+}
+
 func (g *Game) findClosestPlayer(mousePos Point) int {
-	g.mu.Lock()
-	defer g.mu.Unlock()
 	var closestPlayer int
 	minDistance := math.MaxFloat64
 
@@ -827,19 +810,20 @@ func (g *Game) findClosestPlayer(mousePos Point) int {
 		return 0
 	}
 
-	attackRange := AttackRangeWarrior
-	if currentPlayer.Class == MageClass {
-		attackRange = AttackRangeMage
-	}
+	attackRange := g.rules.Classes[currentPlayer.Class].AttackRange
 
 	for _, player := range g.worldState.Players {
-		if player.ID == g.playerID {
+		if player.ID == g.playerID || player.Eliminated {
+			continue
+		}
+		// По своим урон не проходит, их не выбираем
+		if player.Team != 0 && player.Team == currentPlayer.Team {
 			continue
 		}
 
 		dist := math.Sqrt(math.Pow(mousePos.X-player.Position.X, 2) + math.Pow(mousePos.Y-player.Position.Y, 2))
 		// Проверяем, находится ли цель в радиусе атаки
-		if dist <= float64(attackRange) && dist < minDistance {
+		if dist <= attackRange && dist < minDistance {
 			minDistance = dist
 			closestPlayer = player.ID
 		}
@@ -849,59 +833,151 @@ func (g *Game) findClosestPlayer(mousePos Point) int {
 }
 
 func (g *Game) sendActionToServer(action PlayerAction) {
-	msg := NetworkMessage{
-		MessageType: "action",
-		Data:        action,
-	}
 	if g.clientConn == nil {
 		return
 	}
-	err := json.NewEncoder(g.clientConn).Encode(msg)
+	action.Seq = g.inputSeq.Add(1)
+	err := protocol.NewEncoder(g.clientConn).Encode(protocol.MsgAction, action)
 	if err != nil {
-		log.Println("Error sending action:", err)
+		clientLog.Error("Error sending action", "err", err)
+	}
+}
+
+func (g *Game) requestProfile() {
+	if g.clientConn == nil {
+		return
+	}
+	if err := protocol.NewEncoder(g.clientConn).Encode(protocol.MsgGetProfile, struct{}{}); err != nil {
+		clientLog.Error("Error requesting profile", "err", err)
 	}
 }
 
 // Draw implements ebiten.Game interface
 func (g *Game) Draw(screen *ebiten.Image) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	if !g.frameDue(time.Now()) {
+		return
+	}
 	screen.Fill(hexToRGBA(0x2b2b2b))
+	g.scene.Draw(g, screen)
+	g.drawMenus(screen)
+}
+
+// drawWorld рисует игровой мир и HUD. Вызывается в игровом цикле.
+func (g *Game) drawWorld(screen *ebiten.Image) {
+	g.drawWorldBounds(screen)
+	g.drawHazards(screen)
+	g.drawObstacles(screen)
+	g.drawHill(screen)
+	g.drawFlags(screen)
+	g.drawZone(screen)
+	g.drawTelegraphs(screen)
+
+	// Отрисовка предметов
+	for _, item := range g.worldState.Items {
+		itemPos := g.camera.toScreen(item.Position)
+		ebitenutil.DrawCircle(screen, itemPos.X, itemPos.Y, ItemRadius, ItemColors[item.Type])
+		label := "+"
+		switch item.Type {
+		case DamageBoostItem:
+			label = "!"
+		case ShieldItem:
+			label = "O"
+		}
+		drawText(screen, label, int(itemPos.X)-3, int(itemPos.Y)-8)
+	}
+
+	now := time.Now()
+	// Павшие игроки уже возродились, на месте смерти доигрываем анимацию
+	for _, c := range g.corpses {
+		g.drawSprite(screen, c.Class, AnimDeath, c.Died, false, g.camera.toScreen(c.Position), now)
+	}
 
 	// Отрисовка игроков
 	for _, player := range g.worldState.Players {
+		if player.Eliminated {
+			continue
+		}
 		playerColor := ClassColors[player.Class]
-		playerPos := g.playerPositions[player.ID]
+		playerPos := g.camera.toScreen(g.playerPositions[player.ID])
 
-		// Рисуем игрока
-		ebitenutil.DrawCircle(screen, playerPos.X, playerPos.Y, PlayerRadius, playerColor)
+		// Щит рисуем кольцом вокруг игрока
+		if findEffect(player, EffectShield) != nil {
+			ebitenutil.DrawCircle(screen, playerPos.X, playerPos.Y, PlayerRadius+3, color.RGBA{180, 180, 255, 96})
+		}
+
+		if sprintActive(player) {
+			drawSprintTrail(screen, player, playerPos)
+		}
+		drawTeamRing(screen, player, playerPos)
+
+		// Рисуем игрока, без атласа - кругом
+		anim := g.anims[player.ID]
+		if anim.Name == "" {
+			anim.Name = AnimIdle
+		}
+		if stealthed(player) {
+			// Невидимого присылают только ему самому и союзникам
+			drawStealthShimmer(screen, player, playerPos, now)
+		} else if !g.drawSprite(screen, player.Class, anim.Name, anim.Started, anim.FlipX, playerPos, now) {
+			ebitenutil.DrawCircle(screen, playerPos.X, playerPos.Y, PlayerRadius, playerColor)
+		}
+
+		// Иконки эффектов над игроком
+		for i, effect := range player.Effects {
+			iconX := playerPos.X - 20 + float64(i)*14
+			iconY := playerPos.Y - 58
+			ebitenutil.DrawRect(screen, iconX, iconY, 12, 12, EffectColors[effect.Type])
+			drawText(screen, EffectIcons[effect.Type], int(iconX)+3, int(iconY)-2)
+		}
+
+		drawCarriedFlag(screen, player, playerPos)
 
-		// Рисуем имя, класс и здоровье
-		text := fmt.Sprintf("%s %d/%d", ClassNames[player.Class], int(player.Health), 100)
-		ebitenutil.DebugPrintAt(screen, text, int(playerPos.X)-20, int(playerPos.Y)-30)
+		// Рисуем имя, класс и здоровье, если враг не слишком далеко от наших
+		if !player.Concealed {
+			name := player.Name
+			if player.Bot {
+				name = tr("[BOT]") + " " + name
+			}
+			if player.AFK {
+				name += " " + tr("[AFK]")
+			}
+			drawText(screen, name, int(playerPos.X)-textWidth(name)/2, int(playerPos.Y)-44)
+			drawLevelBadge(screen, player, playerPos.X-float64(textWidth(name)/2)-10, playerPos.Y-36)
+			g.drawHealthBar(screen, player, playerPos.X-HealthBarWidth/2, playerPos.Y-28, HealthBarWidth, HealthBarHeight, now)
+		}
+
+		if g.playerID == player.ID {
+			you := tr("You")
+			drawText(screen, you, int(playerPos.X)-textWidth(you)/2, int(playerPos.Y)+30)
+		}
 
-		if g.playerID == player.ID && !g.serverMode {
-			ebitenutil.DebugPrintAt(screen, "You", int(playerPos.X)-10, int(playerPos.Y)+30)
+		// Отмечаем точку, куда идем по клику
+		if g.playerID == player.ID && player.Destination != nil {
+			dest := g.camera.toScreen(*player.Destination)
+			markerColor := color.RGBA{120, 255, 120, 200}
+			ebitenutil.DrawLine(screen, dest.X-6, dest.Y-6, dest.X+6, dest.Y+6, markerColor)
+			ebitenutil.DrawLine(screen, dest.X-6, dest.Y+6, dest.X+6, dest.Y-6, markerColor)
+			ebitenutil.DrawLine(screen, playerPos.X, playerPos.Y, dest.X, dest.Y, color.RGBA{120, 255, 120, 60})
 		}
 
 		// Рисуем линию к цели и подсветку цели
 		if player.Target != 0 {
 			if target, ok := g.worldState.Players[player.Target]; ok {
-				targetPos := g.playerPositions[target.ID]
+				targetPos := g.camera.toScreen(g.playerPositions[target.ID])
 				ebitenutil.DrawLine(screen, playerPos.X, playerPos.Y, targetPos.X, targetPos.Y, color.RGBA{255, 255, 255, 128})
 				ebitenutil.DrawCircle(screen, targetPos.X, targetPos.Y, PlayerRadius+5, color.RGBA{255, 0, 0, 64})
 			}
 		}
-
-		// Для ботов рисуем метку
-		if _, isBot := g.bots[player.ID]; isBot {
-			ebitenutil.DebugPrintAt(screen, "[BOT]", int(playerPos.X)-15, int(playerPos.Y)-45)
-		}
 	}
+
+	g.drawMinions(screen)
+	g.drawProjectiles(screen)
+	g.drawVFX(screen, now)
+	g.drawFog(screen)
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
-	return FieldWidth, FieldHeight
+	return ScreenWidth, ScreenHeight
 }
 
 func hexToRGBA(hex int) color.RGBA {
@@ -912,12 +988,14 @@ func hexToRGBA(hex int) color.RGBA {
 }
 
 func main() {
-	serverMode := os.Getenv("SERVER") == "1"
-	game := NewGame(serverMode)
+	cfg := parseConfig()
+	rand.Seed(time.Now().UnixNano())
 
-	if serverMode {
-		game.StartServer()
+	if cfg.MasterAddr != "" {
+		runMaster(cfg.MasterAddr)
+	} else if cfg.Server {
+		NewServer(cfg).Start()
 	} else {
-		game.StartClient()
+		NewGame(cfg).StartClient()
 	}
 }
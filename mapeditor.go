@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image/color"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Редактор карт (F7 в главном меню). Стены и опасные зоны тянутся мышью
+// по сетке, точки возрождения и места предметов ставятся щелчком. Ctrl+S
+// сохраняет карту в формате -map, F5 сохраняет и запускает ее в
+// тренировке с ботами, F7 в тренировке возвращает в редактор.
+const (
+	EditorGrid        = 40.0               // Шаг сетки, к нему привязано все на карте
+	EditorScrollSpeed = 600.0              // Пикселей в секунду
+	EditorResizeStep  = 200.0              // На столько Ctrl+стрелка меняет размер мира
+	EditorMapPath     = "maps/custom.json" // Файл карты, если -map не задан
+)
+
+// EditorKey открывает редактор из главного меню и возвращает в него из
+// тренировки
+const EditorKey = ebiten.KeyF7
+
+// EditorTestKey запускает редактируемую карту в тренировке
+const EditorTestKey = ebiten.KeyF5
+
+// Инструменты редактора, выбираются цифрами
+const (
+	ToolObstacle = iota
+	ToolLava
+	ToolSwamp
+	ToolTrap
+	ToolSpawn
+	ToolItem
+	editorTools
+)
+
+var editorToolNames = [editorTools]string{
+	ToolObstacle: "Wall",
+	ToolLava:     "Lava",
+	ToolSwamp:    "Swamp",
+	ToolTrap:     "Trap",
+	ToolSpawn:    "Spawn point",
+	ToolItem:     "Item spot",
+}
+
+var editorToolHazards = map[int]string{
+	ToolLava:  HazardLava,
+	ToolSwamp: HazardSwamp,
+	ToolTrap:  HazardTrap,
+}
+
+// editorItemTypes - типы мест предметов по кругу клавиши T, пустой - любой
+var editorItemTypes = []string{"", "health", "damage_boost", "shield"}
+
+// editorSpawnTeams - команды точек возрождения по кругу клавиши T, 0 - любая
+var editorSpawnTeams = []int{0, TeamRed, TeamBlue}
+
+// mapEditor - состояние редактора карт
+type mapEditor struct {
+	m        GameMap
+	path     string
+	tool     int
+	itemType int // Индекс в editorItemTypes
+	team     int // Индекс в editorSpawnTeams
+	camera   camera
+	drag     *Point // Угловая клетка прямоугольника, который тянут мышью
+	status   string
+	testing  bool // Карта запущена в тренировке, F7 возвращает в редактор
+}
+
+// openEditor открывает редактор. Карта читается из первого -map или
+// EditorMapPath при первом открытии, дальше правки живут до выхода из
+// клиента. Вызывается в игровом цикле.
+func (g *Game) openEditor() {
+	e := &g.editor
+	if e.path == "" {
+		e.path = EditorMapPath
+		if len(g.cfg.MapPaths) > 0 {
+			e.path = g.cfg.MapPaths[0]
+		}
+		e.load()
+	}
+	g.scene = editorScene{}
+}
+
+// load читает карту из файла, а если файла нет - начинает новую
+func (e *mapEditor) load() {
+	m, err := loadMap(e.path)
+	switch {
+	case err == nil:
+		e.m = *m
+	case errors.Is(err, fs.ErrNotExist):
+		e.m = GameMap{Name: strings.TrimSuffix(filepath.Base(e.path), filepath.Ext(e.path))}
+		e.status = trf("New map %s", e.path)
+	default:
+		clientLog.Warn("Error loading map for editing", "path", e.path, "err", err)
+		e.m = GameMap{Name: strings.TrimSuffix(filepath.Base(e.path), filepath.Ext(e.path))}
+		e.status = trf("Error: %s", err)
+	}
+	if e.m.Width <= 0 || e.m.Height <= 0 {
+		e.m.Width, e.m.Height = DefaultWorldWidth, DefaultWorldHeight
+	}
+}
+
+func (e *mapEditor) save() error {
+	raw, err := json.MarshalIndent(e.m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(e.path, append(raw, '\n'), 0o644); err != nil {
+		return err
+	}
+	clientLog.Info("Map saved", "path", e.path)
+	e.status = trf("Saved to %s", e.path)
+	return nil
+}
+
+// cell - клетка сетки под точкой мира, не дальше края мира
+func (e *mapEditor) cell(p Point) Point {
+	snap := func(v, world float64) float64 {
+		return math.Max(0, math.Min(math.Floor(v/EditorGrid)*EditorGrid, world-EditorGrid))
+	}
+	return Point{X: snap(p.X, e.m.Width), Y: snap(p.Y, e.m.Height)}
+}
+
+// dragRect - прямоугольник от клетки начала перетаскивания до клетки to
+// включительно
+func (e *mapEditor) dragRect(to Point) Obstacle {
+	x, y := math.Min(e.drag.X, to.X), math.Min(e.drag.Y, to.Y)
+	return Obstacle{
+		X:      x,
+		Y:      y,
+		Width:  math.Max(e.drag.X, to.X) + EditorGrid - x,
+		Height: math.Max(e.drag.Y, to.Y) + EditorGrid - y,
+	}
+}
+
+// remove удаляет то, что лежит под точкой: сначала точки, потом зоны и
+// стены, из каждого списка - поставленное последним
+func (e *mapEditor) remove(p Point) {
+	near := func(x, y float64) bool {
+		return math.Hypot(p.X-x, p.Y-y) <= EditorGrid/2
+	}
+	m := &e.m
+	for i := len(m.Spawns) - 1; i >= 0; i-- {
+		if near(m.Spawns[i].X, m.Spawns[i].Y) {
+			m.Spawns = slices.Delete(m.Spawns, i, i+1)
+			return
+		}
+	}
+	for i := len(m.Items) - 1; i >= 0; i-- {
+		if near(m.Items[i].X, m.Items[i].Y) {
+			m.Items = slices.Delete(m.Items, i, i+1)
+			return
+		}
+	}
+	for i := len(m.Hazards) - 1; i >= 0; i-- {
+		if m.Hazards[i].contains(p) {
+			m.Hazards = slices.Delete(m.Hazards, i, i+1)
+			return
+		}
+	}
+	for i := len(m.Obstacles) - 1; i >= 0; i-- {
+		if m.Obstacles[i].contains(p) {
+			m.Obstacles = slices.Delete(m.Obstacles, i, i+1)
+			return
+		}
+	}
+}
+
+// resize меняет размер мира в пределах MinWorldSize..MaxWorldSize
+func (e *mapEditor) resize(dw, dh float64) {
+	e.m.Width = math.Max(MinWorldSize, math.Min(e.m.Width+dw, MaxWorldSize))
+	e.m.Height = math.Max(MinWorldSize, math.Min(e.m.Height+dh, MaxWorldSize))
+}
+
+// updateEditor - ввод редактора. Вызывается в игровом цикле.
+func (g *Game) updateEditor() {
+	e := &g.editor
+	ctrl := ebiten.IsKeyPressed(ebiten.KeyControl)
+	switch {
+	case inpututil.IsKeyJustPressed(EditorKey):
+		e.drag = nil
+		g.scene = menuScene{}
+		return
+	case inpututil.IsKeyJustPressed(EditorTestKey):
+		g.testEditorMap()
+		return
+	case ctrl && inpututil.IsKeyJustPressed(ebiten.KeyS):
+		if err := e.save(); err != nil {
+			clientLog.Error("Error saving map", "path", e.path, "err", err)
+			e.status = trf("Error: %s", err)
+		}
+	}
+	for i := 0; i < editorTools; i++ {
+		if inpututil.IsKeyJustPressed(ebiten.KeyDigit1 + ebiten.Key(i)) {
+			e.tool = i
+			e.drag = nil
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyT) {
+		switch e.tool {
+		case ToolSpawn:
+			e.team = (e.team + 1) % len(editorSpawnTeams)
+		case ToolItem:
+			e.itemType = (e.itemType + 1) % len(editorItemTypes)
+		}
+	}
+
+	if ctrl {
+		switch {
+		case inpututil.IsKeyJustPressed(ebiten.KeyLeft):
+			e.resize(-EditorResizeStep, 0)
+		case inpututil.IsKeyJustPressed(ebiten.KeyRight):
+			e.resize(EditorResizeStep, 0)
+		case inpututil.IsKeyJustPressed(ebiten.KeyUp):
+			e.resize(0, -EditorResizeStep)
+		case inpututil.IsKeyJustPressed(ebiten.KeyDown):
+			e.resize(0, EditorResizeStep)
+		}
+	} else {
+		var scroll Point
+		if ebiten.IsKeyPressed(ebiten.KeyLeft) {
+			scroll.X -= 1
+		}
+		if ebiten.IsKeyPressed(ebiten.KeyRight) {
+			scroll.X += 1
+		}
+		if ebiten.IsKeyPressed(ebiten.KeyUp) {
+			scroll.Y -= 1
+		}
+		if ebiten.IsKeyPressed(ebiten.KeyDown) {
+			scroll.Y += 1
+		}
+		dt := 1.0 / float64(ebiten.TPS())
+		e.camera.X += scroll.X * EditorScrollSpeed * dt
+		e.camera.Y += scroll.Y * EditorScrollSpeed * dt
+	}
+	e.camera.clamp(e.m.Width, e.m.Height)
+
+	cursor := e.camera.toWorld(ebiten.CursorPosition())
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) {
+		e.remove(cursor)
+		return
+	}
+	// Вне мира можно только дотянуть уже начатый прямоугольник
+	outside := cursor.X < 0 || cursor.Y < 0 || cursor.X > e.m.Width || cursor.Y > e.m.Height
+	if outside && e.drag == nil {
+		return
+	}
+	cell := e.cell(cursor)
+	center := Point{X: cell.X + EditorGrid/2, Y: cell.Y + EditorGrid/2}
+	switch e.tool {
+	case ToolSpawn:
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			e.m.Spawns = append(e.m.Spawns, SpawnPoint{X: center.X, Y: center.Y, Team: editorSpawnTeams[e.team]})
+		}
+	case ToolItem:
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			e.m.Items = append(e.m.Items, ItemSpot{X: center.X, Y: center.Y, Type: editorItemTypes[e.itemType]})
+		}
+	default:
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			e.drag = &cell
+		}
+		if e.drag == nil || !inpututil.IsMouseButtonJustReleased(ebiten.MouseButtonLeft) {
+			break
+		}
+		rect := e.dragRect(cell)
+		e.drag = nil
+		if hazardType, ok := editorToolHazards[e.tool]; ok {
+			defaults := HazardDefaults[hazardType]
+			e.m.Hazards = append(e.m.Hazards, Hazard{
+				Type:   hazardType,
+				X:      rect.X,
+				Y:      rect.Y,
+				Width:  rect.Width,
+				Height: rect.Height,
+				Damage: defaults.Damage,
+				Slow:   defaults.Slow,
+			})
+		} else {
+			e.m.Obstacles = append(e.m.Obstacles, rect)
+		}
+	}
+}
+
+// testEditorMap сохраняет карту и запускает ее в тренировке с ботами.
+// Карта читается из файла заново, чтобы в игру попало ровно то, что
+// сохранено. Вызывается в игровом цикле.
+func (g *Game) testEditorMap() {
+	e := &g.editor
+	if err := e.save(); err != nil {
+		clientLog.Error("Error saving map", "path", e.path, "err", err)
+		e.status = trf("Error: %s", err)
+		return
+	}
+	m, err := loadMap(e.path)
+	if err != nil {
+		e.status = trf("Error: %s", err)
+		return
+	}
+	m.resolve(e.m.Width, e.m.Height)
+	cfg := g.cfg
+	cfg.Maps, cfg.Map = []*GameMap{m}, m
+	cfg.WorldWidth, cfg.WorldHeight = m.Width, m.Height
+	e.drag = nil
+	e.testing = true
+	g.startPractice(cfg)
+}
+
+// stopEditorTest останавливает тренировку с редактируемой картой и
+// возвращает в редактор. Вызывается в игровом цикле.
+func (g *Game) stopEditorTest() {
+	if conn := g.clientConn; conn != nil {
+		g.clientConn = nil
+		conn.Close()
+	}
+	g.stopPractice()
+	g.resetWorld()
+	g.editor.testing = false
+	g.scene = editorScene{}
+}
+
+func (g *Game) drawEditor(screen *ebiten.Image) {
+	e := &g.editor
+	m := &e.m
+	toScreen := e.camera.toScreen
+
+	gridColor := color.RGBA{255, 255, 255, 24}
+	origin := toScreen(Point{})
+	for x := 0.0; x <= m.Width; x += EditorGrid {
+		ebitenutil.DrawLine(screen, origin.X+x, origin.Y, origin.X+x, origin.Y+m.Height, gridColor)
+	}
+	for y := 0.0; y <= m.Height; y += EditorGrid {
+		ebitenutil.DrawLine(screen, origin.X, origin.Y+y, origin.X+m.Width, origin.Y+y, gridColor)
+	}
+	borderColor := color.RGBA{200, 60, 60, 255}
+	ebitenutil.DrawLine(screen, origin.X, origin.Y, origin.X+m.Width, origin.Y, borderColor)
+	ebitenutil.DrawLine(screen, origin.X+m.Width, origin.Y, origin.X+m.Width, origin.Y+m.Height, borderColor)
+	ebitenutil.DrawLine(screen, origin.X+m.Width, origin.Y+m.Height, origin.X, origin.Y+m.Height, borderColor)
+	ebitenutil.DrawLine(screen, origin.X, origin.Y+m.Height, origin.X, origin.Y, borderColor)
+
+	for _, hazard := range m.Hazards {
+		pos := toScreen(Point{X: hazard.X, Y: hazard.Y})
+		ebitenutil.DrawRect(screen, pos.X, pos.Y, hazard.Width, hazard.Height, HazardColors[hazard.Type])
+	}
+	for _, obstacle := range m.Obstacles {
+		pos := toScreen(Point{X: obstacle.X, Y: obstacle.Y})
+		ebitenutil.DrawRect(screen, pos.X, pos.Y, obstacle.Width, obstacle.Height, ObstacleColor)
+	}
+	for i, spawn := range m.Spawns {
+		pos := toScreen(Point{X: spawn.X, Y: spawn.Y})
+		spawnColor := color.RGBA{255, 255, 255, 120}
+		if c, ok := TeamColors[spawn.Team]; ok {
+			spawnColor = color.RGBA{c.R, c.G, c.B, 120}
+		}
+		ebitenutil.DrawCircle(screen, pos.X, pos.Y, PlayerRadius, spawnColor)
+		drawText(screen, "S"+strconv.Itoa(i+1), int(pos.X)-6, int(pos.Y)-6)
+	}
+	for _, spot := range m.Items {
+		pos := toScreen(Point{X: spot.X, Y: spot.Y})
+		itemColor := color.RGBA{255, 255, 255, 255}
+		if itemType, ok := ItemKinds[spot.Type]; ok {
+			itemColor = ItemColors[itemType]
+		}
+		ebitenutil.DrawCircle(screen, pos.X, pos.Y, ItemRadius, itemColor)
+	}
+	if e.drag != nil {
+		rect := e.dragRect(e.cell(e.camera.toWorld(ebiten.CursorPosition())))
+		pos := toScreen(Point{X: rect.X, Y: rect.Y})
+		ebitenutil.DrawRect(screen, pos.X, pos.Y, rect.Width, rect.Height, color.RGBA{255, 255, 255, 60})
+	}
+
+	ebitenutil.DrawRect(screen, 0, 0, ScreenWidth, 52, color.RGBA{0, 0, 0, 180})
+	drawText(screen, tr("1-6 - tool, T - item type or spawn team, mouse - draw, right click - delete"), 10, 6)
+	drawText(screen, tr("Arrows - scroll, Ctrl+arrows - world size, Ctrl+S - save, F5 - play-test, F7 - back"), 10, 21)
+	status := trf("%s (%gx%g) - tool: %s", e.path, m.Width, m.Height, tr(editorToolNames[e.tool]))
+	switch e.tool {
+	case ToolSpawn:
+		team := tr("Any team")
+		if name, ok := TeamNames[editorSpawnTeams[e.team]]; ok {
+			team = tr(name)
+		}
+		status += ", " + trf("team: %s", team)
+	case ToolItem:
+		item := tr("Any item")
+		if itemType, ok := ItemKinds[editorItemTypes[e.itemType]]; ok {
+			item = tr(ItemNames[itemType])
+		}
+		status += ", " + trf("item: %s", item)
+	}
+	drawText(screen, status, 10, 36)
+	if e.status != "" {
+		drawText(screen, e.status, 10, ScreenHeight-20)
+	}
+}
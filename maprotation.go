@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+
+	"meatgrinder/protocol"
+)
+
+// Пул карт сервера (-map несколько раз). Когда результаты матча убраны,
+// комната переходит на следующую карту: по кругу или, с -map-rotation
+// vote, на ту, за которую на экране итогов проголосовало больше игроков.
+// Клиенту в init и map_change приходят только имя и хеш карты, саму карту
+// он берет из своего кеша или запрашивает get_map.
+const (
+	MapRotationCycle = "cycle"
+	MapRotationVote  = "vote"
+	EventMapChange   = "map_change"
+)
+
+var MapRotations = []string{MapRotationCycle, MapRotationVote}
+
+// MapVoting - голосование за следующую карту, идет на экране итогов
+type MapVoting struct {
+	Options []string `json:"options"` // Карты пула по порядку ротации
+	Votes   []int    `json:"votes"`   // Голосов за каждую
+}
+
+// rotates сообщает, что комнате есть из чего выбирать следующую карту
+func (r *Room) rotates() bool {
+	return r.cfg.Map != nil && len(r.cfg.Maps) > 1
+}
+
+// openMapVote начинает голосование за следующую карту. Вызывается в
+// горутине комнаты в конце матча.
+func (r *Room) openMapVote() {
+	if !r.rotates() || r.cfg.MapRotation != MapRotationVote {
+		return
+	}
+	r.mapVotes = make(map[int]string)
+	voting := &MapVoting{Votes: make([]int, len(r.cfg.Maps))}
+	for _, m := range r.cfg.Maps {
+		voting.Options = append(voting.Options, m.Name)
+	}
+	r.worldState.Match.MapVote = voting
+}
+
+// voteMap принимает голос игрока, повторный голос заменяет прежний
+func (r *Room) voteMap(playerID int, name string) error {
+	err := errors.New("map vote is not open")
+	r.do(func() {
+		voting := r.worldState.Match.MapVote
+		if voting == nil {
+			return
+		}
+		index := r.mapIndex(name)
+		if index < 0 {
+			err = fmt.Errorf("unknown map %q", name)
+			return
+		}
+		r.mapVotes[playerID] = name
+		for i := range voting.Votes {
+			voting.Votes[i] = 0
+		}
+		for id, vote := range r.mapVotes {
+			if _, ok := r.worldState.Players[id]; ok {
+				voting.Votes[r.mapIndex(vote)]++
+			}
+		}
+		err = nil
+	})
+	return err
+}
+
+// mapIndex - номер карты в пуле, -1 - такой нет
+func (r *Room) mapIndex(name string) int {
+	for i, m := range r.cfg.Maps {
+		if m.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// rotateMap переводит комнату на следующую карту. При голосовании
+// побеждает карта с большим числом голосов, из равных - ближайшая по
+// кругу. Вызывается в горутине комнаты, когда итоги матча убраны.
+func (r *Room) rotateMap(now time.Time) {
+	if !r.rotates() {
+		return
+	}
+	maps := r.cfg.Maps
+	current := r.rotation
+	next := (current + 1) % len(maps)
+	if voting := r.worldState.Match.MapVote; voting != nil {
+		best := 0
+		for step := 1; step <= len(maps); step++ {
+			i := (current + step) % len(maps)
+			if voting.Votes[i] > best {
+				best, next = voting.Votes[i], i
+			}
+		}
+		r.worldState.Match.MapVote = nil
+		r.mapVotes = nil
+	}
+	r.rotation = next
+	if maps[next] != r.cfg.Map {
+		r.changeMap(maps[next], now)
+	}
+}
+
+// changeMap перестраивает комнату под карту m: размер мира, стены для
+// поиска пути, режим, который ставит объекты по размеру мира, и позиции
+// игроков. Вызывается в горутине комнаты между матчами.
+func (r *Room) changeMap(m *GameMap, now time.Time) {
+	r.cfg.Map = m
+	r.cfg.WorldWidth, r.cfg.WorldHeight = m.Width, m.Height
+	r.nav = newNavGrid(r.cfg)
+	r.mode = newGameMode(r.cfg.Mode, r.cfg)
+	r.worldState.Items = make(map[int]*Item)
+	r.worldState.Flags = nil
+	for _, id := range sortedIDs(r.worldState.Players) {
+		r.resetPlayer(r.worldState.Players[id])
+	}
+	r.push(protocol.MsgMapChange, protocol.MapChange{Map: m.ref(), WorldWidth: m.Width, WorldHeight: m.Height})
+	r.logEvent(now, EventMapChange, map[string]interface{}{
+		"map": m.Name,
+	})
+	r.log.Info("Map changed", "map", m.Name)
+}
+
+// mapRef - карта комнаты для init, nil - пустой мир
+func (r *Room) mapRef() *protocol.MapRef {
+	if r.cfg.Map == nil {
+		return nil
+	}
+	return r.cfg.Map.ref()
+}
+
+// sendMap отвечает на get_map картой из пула сервера
+func (r *Room) sendMap(client *clientConnection, ref protocol.MapRef) {
+	var msg []byte
+	var err error
+	r.do(func() {
+		for _, m := range r.cfg.Maps {
+			if m.Hash == ref.Hash {
+				msg, err = protocol.Marshal(protocol.MsgMapData, m.message())
+				return
+			}
+		}
+		msg, err = protocol.Marshal(protocol.MsgError, protocol.Error{Message: fmt.Sprintf("unknown map %q", ref.Name)})
+	})
+	if err != nil {
+		netLog.Error("Error sending map", "err", err)
+		return
+	}
+	client.enqueue(msg)
+}
+
+// cachedMapPath - файл карты в кеше клиента, по хешу
+func cachedMapPath(hash string) (string, error) {
+	return configPath(filepath.Join("maps", hash+".json"))
+}
+
+// loadCachedMap ищет карту в кеше клиента. Карта с неверным хешем
+// считается отсутствующей.
+func loadCachedMap(ref protocol.MapRef) (protocol.MapData, bool) {
+	var data protocol.MapData
+	path, err := cachedMapPath(ref.Hash)
+	if err != nil {
+		return data, false
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return data, false
+	}
+	if err := json.Unmarshal(raw, &data); err != nil || mapHash(data) != ref.Hash {
+		clientLog.Warn("Ignoring broken cached map", "path", path)
+		return data, false
+	}
+	return data, true
+}
+
+func saveCachedMap(data protocol.MapData) error {
+	path, err := cachedMapPath(data.Hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0o644)
+}
+
+// applyMap переключает клиента на карту ref: берет ее из кеша или просит
+// у сервера. Пока карта не пришла, опасные зоны и стены не рисуются.
+// Вызывается в игровом цикле.
+func (g *Game) applyMap(ref *protocol.MapRef) {
+	g.mapRef = ref
+	g.mapVote = ""
+	g.hazards, g.obstacles = nil, nil
+	if ref == nil {
+		return
+	}
+	if data, ok := loadCachedMap(*ref); ok {
+		g.hazards, g.obstacles = data.Hazards, data.Obstacles
+		return
+	}
+	clientLog.Info("Downloading map", "map", ref.Name, "hash", ref.Hash)
+	if err := protocol.NewEncoder(g.clientConn).Encode(protocol.MsgGetMap, ref); err != nil {
+		clientLog.Error("Error requesting map", "err", err)
+	}
+}
+
+// receiveMap принимает карту от сервера и кладет ее в кеш. Вызывается в
+// игровом цикле.
+func (g *Game) receiveMap(data protocol.MapData) {
+	if g.mapRef == nil || data.Hash != g.mapRef.Hash || mapHash(data) != data.Hash {
+		clientLog.Warn("Ignoring unexpected map", "map", data.Name, "hash", data.Hash)
+		return
+	}
+	if err := saveCachedMap(data); err != nil {
+		clientLog.Error("Error caching map", "err", err)
+	}
+	g.hazards, g.obstacles = data.Hazards, data.Obstacles
+}
+
+// updateMapVote отдает голос цифрой с номером карты. Вызывается в игровом
+// цикле на экране итогов.
+func (g *Game) updateMapVote() {
+	voting := g.worldState.Match.MapVote
+	if voting == nil {
+		return
+	}
+	for i, name := range voting.Options {
+		if i >= 9 || !inpututil.IsKeyJustPressed(ebiten.KeyDigit1+ebiten.Key(i)) {
+			continue
+		}
+		g.mapVote = name
+		if err := protocol.NewEncoder(g.clientConn).Encode(protocol.MsgVoteMap, protocol.MapVote{Map: name}); err != nil {
+			clientLog.Error("Error sending map vote", "err", err)
+		}
+	}
+}
+
+// drawMapVote рисует голосование за следующую карту под итогами матча
+func (g *Game) drawMapVote(screen *ebiten.Image) {
+	voting := g.worldState.Match.MapVote
+	if voting == nil {
+		return
+	}
+	const row = 16
+	top := ScreenHeight - 40 - row*(len(voting.Options)+1)
+	ebitenutil.DrawRect(screen, ScreenWidth/2-150, float64(top-6), 300, float64(row*(len(voting.Options)+1)+10), color.RGBA{0, 0, 0, 200})
+	drawCentered(screen, tr("Vote for the next map"), top)
+	for i, name := range voting.Options {
+		line := fmt.Sprintf("%d - %s: %d", i+1, name, voting.Votes[i])
+		if name == g.mapVote {
+			line += " " + tr("(your vote)")
+		}
+		drawText(screen, line, ScreenWidth/2-130, top+row*(i+1))
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"meatgrinder/protocol"
+)
+
+// Мастер-сервер хранит список публичных серверов. Серверы с -master-url
+// присылают heartbeat, клиенты забирают список GET /servers.
+const (
+	MasterHeartbeatInterval = 10 * time.Second
+	MasterServerTTL         = 3 * MasterHeartbeatInterval // Сервер без heartbeat дольше этого пропадает из списка
+	MasterRequestTimeout    = 5 * time.Second
+	MaxServerNameLen        = 32
+	MaxMasterServers        = 1000
+	MaxServersPerHost       = 8 // Серверов с одного IP: больше не запишет в список и флудер
+)
+
+type masterEntry struct {
+	info protocol.ServerInfo
+	seen time.Time
+}
+
+// masterServer - список серверов по адресам
+type masterServer struct {
+	mu      sync.Mutex
+	servers map[string]masterEntry
+}
+
+// runMaster запускает мастер-сервер и не возвращается
+func runMaster(addr string) {
+	m := &masterServer{servers: make(map[string]masterEntry)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/servers", m.handleServers)
+	netLog.Info("Master server listening", "addr", addr)
+	fatal(netLog, "Master server stopped", "err", http.ListenAndServe(addr, mux))
+}
+
+// handleServers: GET - список живых серверов, POST - heartbeat сервера
+func (m *masterServer) handleServers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.list(time.Now()))
+	case http.MethodPost:
+		var info protocol.ServerInfo
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&info); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.register(info, r.RemoteAddr, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// register запоминает heartbeat. Из адреса в heartbeat берется только
+// порт, а хост - тот, с которого пришел запрос: иначе любой мог бы
+// перезаписать чужую запись или заполнить список выдуманными адресами.
+func (m *masterServer) register(info protocol.ServerInfo, remoteAddr string, now time.Time) error {
+	_, port, err := net.SplitHostPort(info.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", info.Addr)
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return err
+	}
+	info.Addr = net.JoinHostPort(host, port)
+	info.Name = strings.TrimSpace(info.Name)
+	if info.Name == "" || utf8.RuneCountInString(info.Name) > MaxServerNameLen {
+		return fmt.Errorf("invalid server name %q", info.Name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+	if _, ok := m.servers[info.Addr]; !ok {
+		if len(m.servers) >= MaxMasterServers {
+			return fmt.Errorf("server list is full")
+		}
+		if m.hostServers(host) >= MaxServersPerHost {
+			return fmt.Errorf("too many servers from %s", host)
+		}
+		netLog.Info("Server registered", "name", info.Name, "addr", info.Addr)
+	}
+	m.servers[info.Addr] = masterEntry{info: info, seen: now}
+	return nil
+}
+
+func (m *masterServer) list(now time.Time) protocol.ServerList {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+	list := protocol.ServerList{Servers: make([]protocol.ServerInfo, 0, len(m.servers))}
+	for _, entry := range m.servers {
+		list.Servers = append(list.Servers, entry.info)
+	}
+	sort.Slice(list.Servers, func(i, j int) bool {
+		if list.Servers[i].Players != list.Servers[j].Players {
+			return list.Servers[i].Players > list.Servers[j].Players
+		}
+		return list.Servers[i].Addr < list.Servers[j].Addr
+	})
+	return list
+}
+
+// hostServers - сколько серверов в списке с адресом host. Вызывается под m.mu.
+func (m *masterServer) hostServers(host string) int {
+	n := 0
+	for addr := range m.servers {
+		if h, _, _ := net.SplitHostPort(addr); h == host {
+			n++
+		}
+	}
+	return n
+}
+
+// expire убирает серверы, переставшие присылать heartbeat. Вызывается под m.mu.
+func (m *masterServer) expire(now time.Time) {
+	for addr, entry := range m.servers {
+		if now.Sub(entry.seen) > MasterServerTTL {
+			delete(m.servers, addr)
+			netLog.Info("Server timed out", "name", entry.info.Name, "addr", addr)
+		}
+	}
+}
+
+// serverInfo - то, что сервер сообщает о себе мастер-серверу
+func (s *Server) serverInfo() protocol.ServerInfo {
+	info := protocol.ServerInfo{
+		Name:      s.cfg.ServerName,
+		Addr:      s.cfg.PublicAddr,
+		Mode:      s.cfg.Mode,
+		Transport: s.cfg.Transport,
+		Password:  s.cfg.Password != "",
+	}
+	if info.Addr == "" {
+		info.Addr = ServerAddr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info.Rooms = len(s.rooms)
+	for _, room := range s.rooms {
+		roomInfo := room.info()
+		info.Players += roomInfo.Players
+		info.Bots += roomInfo.Bots
+	}
+	return info
+}
+
+// registerWithMaster шлет heartbeat мастер-серверу, пока сервер не закроют
+func (s *Server) registerWithMaster() {
+	client := &http.Client{Timeout: MasterRequestTimeout}
+	url := strings.TrimSuffix(s.cfg.MasterURL, "/") + "/servers"
+	ticker := time.NewTicker(MasterHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		body, err := json.Marshal(s.serverInfo())
+		if err == nil {
+			var resp *http.Response
+			if resp, err = client.Post(url, "application/json", bytes.NewReader(body)); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNoContent {
+					err = fmt.Errorf("master server replied %s", resp.Status)
+				}
+			}
+		}
+		if err != nil {
+			netLog.Warn("Error registering with master server", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// fetchServerList забирает список серверов у мастер-сервера
+func fetchServerList(masterURL string) ([]protocol.ServerInfo, error) {
+	client := &http.Client{Timeout: MasterRequestTimeout}
+	resp, err := client.Get(strings.TrimSuffix(masterURL, "/") + "/servers")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("master server replied %s", resp.Status)
+	}
+	var list protocol.ServerList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list.Servers, nil
+}
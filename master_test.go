@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// Хост в heartbeat не доверяется: запись получает адрес отправителя, и с
+// одного адреса в списке не больше MaxServersPerHost серверов
+func TestMasterRegisterUsesSourceHost(t *testing.T) {
+	m := &masterServer{servers: make(map[string]masterEntry)}
+	now := time.Now()
+	victim := protocol.ServerInfo{Name: "real", Addr: "203.0.113.5:8080"}
+	if err := m.register(victim, "203.0.113.5:40000", now); err != nil {
+		t.Fatal(err)
+	}
+	spoofed := protocol.ServerInfo{Name: "fake", Addr: "203.0.113.5:8080"}
+	if err := m.register(spoofed, "198.51.100.7:40000", now); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.servers["203.0.113.5:8080"].info.Name; got != "real" {
+		t.Errorf("listing of 203.0.113.5:8080 is %q", got)
+	}
+	if _, ok := m.servers["198.51.100.7:8080"]; !ok {
+		t.Error("heartbeat not listed under its source address")
+	}
+
+	for port := 9000; port < 9000+MaxServersPerHost-1; port++ {
+		info := protocol.ServerInfo{Name: "flood", Addr: fmt.Sprintf(":%d", port)}
+		if err := m.register(info, "198.51.100.7:40000", now); err != nil {
+			t.Fatalf("server %d of %d: %v", port-9000+2, MaxServersPerHost, err)
+		}
+	}
+	over := protocol.ServerInfo{Name: "flood", Addr: ":10000"}
+	if err := m.register(over, "198.51.100.7:40000", now); err == nil {
+		t.Error("registered more than MaxServersPerHost servers from one host")
+	}
+	// Повторный heartbeat уже записанного сервера проходит
+	if err := m.register(spoofed, "198.51.100.7:40001", now); err != nil {
+		t.Errorf("heartbeat of a listed server: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+
+	"meatgrinder/protocol"
+)
+
+// Фазы матча: лобби -> отсчет -> бой -> результаты -> снова лобби
+const (
+	MatchWaiting   = "waiting"
+	MatchCountdown = "countdown"
+	MatchActive    = "active"
+	MatchEnded     = "ended"
+)
+
+const (
+	CountdownDuration = 5.0  // Отсчет перед началом матча, секунды
+	ResultsDuration   = 10.0 // Сколько показываем результаты перед возвратом в лобби
+	EventMatchPhase   = "match_phase"
+)
+
+type MatchResult struct {
+	PlayerID int     `json:"player_id"`
+	Class    int     `json:"class"`
+	Score    float64 `json:"score"` // Очки режима
+	Kills    int     `json:"kills"`
+	Deaths   int     `json:"deaths"`
+	Bot      bool    `json:"bot"`
+}
+
+type MatchInfo struct {
+	Phase       string        `json:"phase"`
+	Remaining   float64       `json:"remaining"`              // Секунд до конца фазы, в лобби 0
+	Duration    float64       `json:"duration"`               // Длительность фазы или овертайма, секунды
+	SuddenDeath string        `json:"sudden_death,omitempty"` // Правило овертайма, пустое - обычное время
+	Mode        string        `json:"mode"`
+	HUD         *ModeHUD      `json:"hud,omitempty"`
+	Results     []MatchResult `json:"results,omitempty"`
+	MapVote     *MapVoting    `json:"map_vote,omitempty"` // Только на экране итогов с -map-rotation vote
+}
+
+func (r *Room) setMatchPhase(phase string, duration float64, now time.Time) {
+	r.worldState.Match.Phase = phase
+	r.worldState.Match.Remaining = duration
+	r.worldState.Match.Duration = duration
+	r.worldState.Match.SuddenDeath = ""
+	r.logEvent(now, EventMatchPhase, map[string]interface{}{
+		"phase":    phase,
+		"duration": duration,
+	})
+	r.notifyMatchPhase(phase)
+	r.log.Info("Match phase", "phase", phase)
+}
+
+// updateMatch продвигает конечный автомат матча. Вызывается в горутине комнаты.
+func (r *Room) updateMatch(deltaTime float64, now time.Time) {
+	match := &r.worldState.Match
+	humans := len(r.playerConnections)
+
+	switch match.Phase {
+	case MatchWaiting:
+		if humans >= r.cfg.MinPlayers {
+			r.setMatchPhase(MatchCountdown, CountdownDuration, now)
+		}
+	case MatchCountdown:
+		if humans < r.cfg.MinPlayers {
+			r.setMatchPhase(MatchWaiting, 0, now)
+			return
+		}
+		match.Remaining -= deltaTime
+		if match.Remaining <= 0 {
+			r.startMatch()
+			r.setMatchPhase(MatchActive, r.cfg.MatchDuration.Seconds(), now)
+		}
+	case MatchActive:
+		match.Remaining -= deltaTime
+		r.mode.Update(r, deltaTime, now)
+		if r.mode.Finished(r) {
+			r.endMatch(now)
+		} else if match.Remaining <= 0 || (match.SuddenDeath != "" && r.overtimeDecided()) {
+			r.timeUp(now)
+		}
+	case MatchEnded:
+		match.Remaining -= deltaTime
+		if match.Remaining <= 0 {
+			match.Results = nil
+			r.rotateMap(now)
+			r.setMatchPhase(MatchWaiting, 0, now)
+		}
+	default:
+		r.setMatchPhase(MatchWaiting, 0, now)
+	}
+	match.Mode = r.mode.Name()
+	match.HUD = r.mode.HUD(r)
+}
+
+// resetPlayer возрождает игрока подальше от врагов, см. spawnPosition.
+// Вызывается в горутине комнаты.
+func (r *Room) resetPlayer(player *PlayerState) {
+	player.Health = PlayerMaxHealth
+	player.Resource = maxResource(player)
+	player.Effects = nil
+	player.Knockback = nil
+	player.Eliminated = false
+	player.CarryingFlag = 0
+	player.LastDamagedBy = 0
+	player.Destination = nil
+	player.Target = 0
+	player.Position = r.spawnPosition(player)
+}
+
+// startMatch возвращает мир в исходное состояние перед новым раундом
+func (r *Room) startMatch() {
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		r.resetPlayer(player)
+		player.Level = 1
+		player.XP = 0
+		player.Score = 0
+		player.Kills = 0
+		player.Deaths = 0
+		player.Stats = MatchStats{}
+	}
+	r.worldState.Items = make(map[int]*Item)
+	r.worldState.Projectiles = make(map[int]*Projectile)
+	r.worldState.Minions = make(map[int]*Minion)
+	r.worldState.Flags = nil
+	r.worldState.Zone = nil
+	r.firstBlood = false
+	if r.cfg.Zone {
+		r.worldState.Zone = r.newZone()
+	}
+	r.mode.Start(r)
+}
+
+// endMatch подводит итоги матча. Выбывшие возвращаются в лобби живыми.
+func (r *Room) endMatch(now time.Time) {
+	match := &r.worldState.Match
+	match.Results = r.matchResults()
+	r.push(protocol.MsgMatchSummary, r.matchSummary(match.Results))
+	r.recordMatchPlayed()
+	for _, id := range sortedIDs(r.worldState.Players) {
+		if player := r.worldState.Players[id]; player.Eliminated {
+			r.resetPlayer(player)
+		}
+	}
+	r.worldState.Zone = nil
+	r.worldState.Projectiles = make(map[int]*Projectile)
+	r.worldState.Minions = make(map[int]*Minion)
+	r.setMatchPhase(MatchEnded, ResultsDuration, now)
+	r.openMapVote()
+}
+
+// matchResults возвращает таблицу результатов: больше очков режима - выше,
+// затем больше убийств, затем меньше смертей
+func (r *Room) matchResults() []MatchResult {
+	results := make([]MatchResult, 0, len(r.worldState.Players))
+	for id, player := range r.worldState.Players {
+		_, isBot := r.bots[id]
+		results = append(results, MatchResult{
+			PlayerID: id,
+			Class:    player.Class,
+			Score:    player.Score,
+			Kills:    player.Kills,
+			Deaths:   player.Deaths,
+			Bot:      isBot,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Kills != results[j].Kills {
+			return results[i].Kills > results[j].Kills
+		}
+		if results[i].Deaths != results[j].Deaths {
+			return results[i].Deaths < results[j].Deaths
+		}
+		return results[i].PlayerID < results[j].PlayerID
+	})
+	return results
+}
+
+// drawMatchOverlay рисует на клиенте информацию о текущей фазе матча
+func (g *Game) drawMatchOverlay(screen *ebiten.Image, hud hudLayout) {
+	match := g.worldState.Match
+	switch match.Phase {
+	case MatchWaiting:
+		text := tr("Waiting for players...")
+		drawText(screen, text, hud.centerText(text), HUDMargin)
+	case MatchCountdown:
+		text := trf("Match starts in %d", int(math.Ceil(match.Remaining)))
+		drawText(screen, text, hud.centerText(text), HUDMargin)
+	case MatchActive:
+		remaining := int(math.Ceil(match.Remaining))
+		timer := fmt.Sprintf("%02d:%02d", remaining/60, remaining%60)
+		if match.SuddenDeath != "" {
+			timer = trf("SUDDEN DEATH (%s) %s", match.SuddenDeath, timer)
+		}
+		// Последние секунды и овертайм подсвечены
+		if match.SuddenDeath != "" || remaining <= 10 {
+			ebitenutil.DrawRect(screen, float64(hud.centerText(timer)-4), HUDMargin-2, float64(textWidth(timer)+8), 18, color.RGBA{200, 0, 0, 160})
+		}
+		drawText(screen, timer, hud.centerText(timer), HUDMargin)
+	}
+	g.drawModeHUD(screen, hud)
+	g.drawZoneTimer(screen, hud)
+}
+
+// drawMatchResults рисует таблицу результатов закончившегося матча
+func (g *Game) drawMatchResults(screen *ebiten.Image) {
+	if g.matchSummary != nil {
+		g.drawMatchSummary(screen, g.matchSummary)
+		return
+	}
+	// Итоги не пришли, например, игрок вошел уже после конца матча
+	match := g.worldState.Match
+	ebitenutil.DrawRect(screen, ScreenWidth/2-150, 100, 300, float64(90+16*len(match.Results)), color.RGBA{0, 0, 0, 200})
+	drawText(screen, tr("MATCH OVER"), ScreenWidth/2-textWidth(tr("MATCH OVER"))/2, 110)
+	drawText(screen, fmt.Sprintf("#  %-15s %5s %6s %7s", tr("Player"), tr("Score"), tr("Kills"), tr("Deaths")), ScreenWidth/2-130, 135)
+	for i, result := range match.Results {
+		name := fmt.Sprintf("%s %d", tr(ClassNames[result.Class]), result.PlayerID)
+		if result.Bot {
+			name += " " + tr("[BOT]")
+		} else if result.PlayerID == g.playerID {
+			name += " " + tr("(You)")
+		}
+		line := fmt.Sprintf("%-2d %-15s %5d %6d %7d", i+1, name, int(result.Score), result.Kills, result.Deaths)
+		drawText(screen, line, ScreenWidth/2-130, 151+16*i)
+	}
+	g.drawCareer(screen, ScreenWidth/2-130, 170+16*len(match.Results))
+}
+
+// drawCareer пишет статистику игрока за все матчи
+func (g *Game) drawCareer(screen *ebiten.Image, x, y int) {
+	p := g.profile
+	if p == nil {
+		return
+	}
+	line := trf("Career: %d/%d K/D, %d matches, rating %d", p.Kills, p.Deaths, p.MatchesPlayed, p.Rating)
+	if p.FavoriteClass != "" {
+		line += trf(", main %s", p.FavoriteClass)
+	}
+	drawText(screen, line, x, y)
+}
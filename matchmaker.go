@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"meatgrinder/protocol"
+)
+
+// Очередь подбора матчей (-match-size). Клиент вместо join_room присылает
+// join_queue и ждет; раз в MatchmakerInterval сервер собирает из очереди
+// группы по MatchSize игроков с близким рейтингом, создает для каждой
+// отдельную комнату и присылает участникам room_assignment. Чем дольше
+// игрок ждет, тем шире допустимый разброс рейтинга.
+const (
+	MatchmakerInterval     = time.Second
+	MatchmakerRatingWindow = 100.0 // Разброс рейтинга сразу после входа в очередь
+	MatchmakerWindowGrowth = 25.0  // На столько разброс растет за секунду ожидания
+	MatchRoomPrefix        = "match-"
+)
+
+var errAlreadyQueued = errors.New("already in the matchmaking queue")
+
+// queueTicket - место в очереди: одиночка или вся группа. Участники одного
+// места попадают в одну комнату.
+type queueTicket struct {
+	members  []*lobbyClient // Первый - тот, кто встал в очередь
+	party    string         // Код группы, пусто у одиночки
+	size     int
+	rating   float64 // Средний по участникам
+	joined   time.Time
+	assigned chan protocol.RoomAssignment // Буфер на одно назначение
+	cancel   chan struct{}                // Закрывается, если место ушло из очереди до подбора
+	done     chan struct{}                // Закрывается, когда назначение отправлено или отменено
+}
+
+// waiting сообщает, что место еще ждет подбора
+func (t *queueTicket) waiting() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// wait ждет назначения и рассылает его участникам
+func (t *queueTicket) wait(timeout time.Duration) {
+	defer close(t.done)
+	select {
+	case assignment := <-t.assigned:
+		// В очереди клиент мог молчать, а теперь должен войти в комнату.
+		// Дедлайн ставится до отправки, чтобы не затереть снятый при входе.
+		t.setDeadline(time.Now().Add(timeout))
+		for _, member := range t.members {
+			member.send(protocol.MsgRoomAssignment, assignment)
+		}
+	case <-t.cancel:
+	}
+}
+
+// setDeadline ставит дедлайн чтения всем участникам места
+func (t *queueTicket) setDeadline(deadline time.Time) {
+	for _, member := range t.members {
+		member.conn.SetReadDeadline(deadline)
+	}
+}
+
+// matchmaker - очередь подбора сервера
+type matchmaker struct {
+	mu      sync.Mutex
+	size    int
+	tickets []*queueTicket
+	rooms   int // Счетчик для имен комнат
+}
+
+func newMatchmaker(size int) *matchmaker {
+	return &matchmaker{size: size}
+}
+
+// add ставит место в очередь и возвращает длину очереди в игроках
+func (m *matchmaker) add(t *queueTicket) (int, error) {
+	if t.size > m.size {
+		return 0, fmt.Errorf("group of %d does not fit a match of %d", t.size, m.size)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tickets = append(m.tickets, t)
+	queued := 0
+	for _, t := range m.tickets {
+		queued += t.size
+	}
+	return queued, nil
+}
+
+// remove убирает место из очереди. false - его уже подобрали.
+func (m *matchmaker) remove(t *queueTicket) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, queued := range m.tickets {
+		if queued == t {
+			m.tickets = append(m.tickets[:i], m.tickets[i+1:]...)
+			close(t.cancel)
+			return true
+		}
+	}
+	return false
+}
+
+// formGroups забирает из очереди готовые группы. Первым группу собирает
+// тот, кто ждет дольше всех: к нему добавляются ближайшие по рейтингу
+// места в пределах его разброса, пока группа не заполнится ровно.
+func (m *matchmaker) formGroups(now time.Time) [][]*queueTicket {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sort.SliceStable(m.tickets, func(i, j int) bool {
+		return m.tickets[i].joined.Before(m.tickets[j].joined)
+	})
+	var groups [][]*queueTicket
+	used := make(map[*queueTicket]bool)
+	for _, anchor := range m.tickets {
+		if used[anchor] {
+			continue
+		}
+		window := MatchmakerRatingWindow + MatchmakerWindowGrowth*now.Sub(anchor.joined).Seconds()
+		var candidates []*queueTicket
+		for _, t := range m.tickets {
+			if t != anchor && !used[t] && math.Abs(t.rating-anchor.rating) <= window {
+				candidates = append(candidates, t)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return math.Abs(candidates[i].rating-anchor.rating) < math.Abs(candidates[j].rating-anchor.rating)
+		})
+		group, players := []*queueTicket{anchor}, anchor.size
+		for _, t := range candidates {
+			if players == m.size {
+				break
+			}
+			if players+t.size <= m.size {
+				group = append(group, t)
+				players += t.size
+			}
+		}
+		if players < m.size {
+			continue
+		}
+		for _, t := range group {
+			used[t] = true
+		}
+		groups = append(groups, group)
+	}
+
+	remaining := m.tickets[:0]
+	for _, t := range m.tickets {
+		if !used[t] {
+			remaining = append(remaining, t)
+		}
+	}
+	m.tickets = remaining
+	return groups
+}
+
+// requeue возвращает в очередь группу, для которой не нашлось комнаты.
+// Время входа сохраняется, поэтому очередь группа не теряет.
+func (m *matchmaker) requeue(group []*queueTicket) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tickets = append(m.tickets, group...)
+}
+
+func (m *matchmaker) nextRoomName() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rooms++
+	return fmt.Sprintf("%s%d", MatchRoomPrefix, m.rooms)
+}
+
+// runMatchmaker раз в MatchmakerInterval раздает комнаты собранным группам
+func (s *Server) runMatchmaker() {
+	ticker := time.NewTicker(MatchmakerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		for _, group := range s.matchmaker.formGroups(time.Now()) {
+			s.assignGroup(group)
+		}
+	}
+}
+
+// assignGroup создает комнату для группы и сообщает ее участникам.
+// Комната заранее узнает, кто с кем в группе, чтобы не разводить их по
+// разным командам.
+func (s *Server) assignGroup(group []*queueTicket) {
+	name := s.matchmaker.nextRoomName()
+	room, err := s.createRoom(name, 0, 0)
+	if err != nil {
+		netLog.Warn("Error creating match room", "room", name, "err", err)
+		s.matchmaker.requeue(group)
+		return
+	}
+	assignment := protocol.RoomAssignment{Room: name}
+	parties := make(map[string]string)
+	sum := 0.0
+	for _, t := range group {
+		assignment.Players += t.size
+		sum += t.rating * float64(t.size)
+		for _, member := range t.members {
+			if t.party != "" {
+				parties[member.name] = t.party
+			}
+		}
+	}
+	room.expectParties(parties)
+	assignment.Rating = int(math.Round(sum / float64(assignment.Players)))
+	netLog.Info("Match assigned", "room", name, "players", assignment.Players, "rating", assignment.Rating)
+	for _, t := range group {
+		t.assigned <- assignment
+	}
+}
+
+// queueRating - рейтинг, по которому игрока подбирают. Без профилей у
+// всех начальный.
+func (s *Server) queueRating(name string) float64 {
+	if s.profiles == nil {
+		return DefaultRating
+	}
+	profile, err := s.profiles.Load(name)
+	if err != nil {
+		netLog.Error("Error loading profile", "name", name, "err", err)
+		return DefaultRating
+	}
+	return profile.Rating
+}
+
+// enqueue ставит клиента в очередь, а если он лидер группы - всю группу,
+// и ждет назначения в фоне
+func (s *Server) enqueue(client *lobbyClient) error {
+	if s.matchmaker == nil {
+		return errors.New("matchmaking is disabled on this server")
+	}
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	if client.ticket != nil && client.ticket.waiting() {
+		return errAlreadyQueued
+	}
+	t := &queueTicket{
+		members:  []*lobbyClient{client},
+		joined:   time.Now(),
+		assigned: make(chan protocol.RoomAssignment, 1),
+		cancel:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if p := client.party; p != nil {
+		if p.members[0] != client {
+			return errors.New("only the party leader can queue")
+		}
+		t.members = slices.Clone(p.members)
+		t.party = p.code
+	}
+	t.size = len(t.members)
+	for _, member := range t.members {
+		t.rating += s.queueRating(member.name) / float64(t.size)
+	}
+	queued, err := s.matchmaker.add(t)
+	if err != nil {
+		return err
+	}
+	client.ticket = t
+	// Участники группы уже ждут следующего сообщения с дедлайном лобби
+	t.setDeadline(time.Time{})
+	go t.wait(s.cfg.ClientTimeout)
+	netLog.Debug("Client queued", "remote", client.conn.RemoteAddr().String(), "name", client.name, "players", t.size, "rating", math.Round(t.rating))
+	client.send(protocol.MsgQueueStatus, protocol.QueueStatus{Queued: queued, MatchSize: s.matchmaker.size, Rating: int(math.Round(t.rating))})
+	if client.party != nil {
+		s.sendParty(client.party)
+	}
+	return nil
+}
+
+// dequeue убирает клиента из очереди и ждет, пока назначение допишется
+// в соединение, чтобы оно не смешалось с сообщениями комнаты. Участник
+// группы снимает с очереди всю группу.
+func (s *Server) dequeue(client *lobbyClient) {
+	if s.matchmaker == nil {
+		return
+	}
+	s.lobby.mu.Lock()
+	t := client.ticket
+	client.ticket = nil
+	if p := client.party; p != nil {
+		if (t != nil && s.removeTicket(t)) || (t == nil && s.cancelPartyQueue(p)) {
+			s.sendParty(p)
+		}
+	} else if t != nil {
+		s.removeTicket(t)
+	}
+	s.lobby.mu.Unlock()
+	if t != nil {
+		<-t.done
+	}
+}
+
+// removeTicket убирает место из очереди и возвращает его участникам
+// дедлайн лобби. false - место уже подобрали. Вызывается под lobby.mu.
+func (s *Server) removeTicket(t *queueTicket) bool {
+	if !s.matchmaker.remove(t) {
+		return false
+	}
+	t.setDeadline(time.Now().Add(s.cfg.ClientTimeout))
+	return true
+}
+
+// queued сообщает, что клиент или группа, в которой он состоит, ждет
+// подбора в очереди. Вызывается под lobby.mu.
+func (s *Server) queued(client *lobbyClient) bool {
+	t := client.ticket
+	if client.party != nil {
+		t = client.party.members[0].ticket
+	}
+	return t != nil && t.waiting()
+}
+
+// setLobbyDeadline ставит дедлайн чтения клиенту в лобби. До входа в
+// комнату ping не идут, поэтому молчуна отключает дедлайн. В очереди
+// клиент молчит законно. Под lobby.mu, чтобы не разойтись с enqueue
+// лидера группы.
+func (s *Server) setLobbyDeadline(client *lobbyClient) {
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	if s.queued(client) {
+		client.conn.SetReadDeadline(time.Time{})
+	} else {
+		client.conn.SetReadDeadline(time.Now().Add(s.cfg.ClientTimeout))
+	}
+}
+
+// QueueKey на экране выбора комнаты встает в очередь подбора и выходит из нее
+const QueueKey = ebiten.KeyQ
+
+// toggleQueue встает в очередь подбора или выходит из нее. Вызывается в
+// игровом цикле.
+func (g *Game) toggleQueue() {
+	b := &g.browser
+	msgType, data := protocol.MsgJoinQueue, interface{}(protocol.JoinRoom{Name: g.cfg.Name, Password: g.cfg.Password, Version: protocol.Version})
+	if !b.queued.IsZero() {
+		msgType, data = protocol.MsgLeaveQueue, struct{}{}
+		b.queued = time.Time{}
+	} else {
+		b.queued = time.Now()
+		b.queue = protocol.QueueStatus{}
+		b.err = ""
+	}
+	if err := protocol.NewEncoder(g.clientConn).Encode(msgType, data); err != nil {
+		clientLog.Error("Error sending queue request", "err", err)
+	}
+}
+
+// assignedRoom входит в комнату, подобранную сервером. Вызывается в
+// игровом цикле.
+func (g *Game) assignedRoom(assignment protocol.RoomAssignment) {
+	if g.browser.queued.IsZero() {
+		return
+	}
+	g.browser.queued = time.Time{}
+	clientLog.Info("Match found", "room", assignment.Room, "players", assignment.Players, "rating", assignment.Rating)
+	g.joinRoom(assignment.Room, false)
+}
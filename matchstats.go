@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+
+	"meatgrinder/protocol"
+)
+
+// Раз в StatsBroadcastInterval рассылок, пока идет бой, комната присылает
+// игрокам сводку статистики для таблицы по Tab
+const StatsBroadcastInterval = 30
+
+// MatchStats - статистика игрока за текущий матч для итогов. Сбрасывается
+// в startMatch вместе с убийствами и смертями.
+type MatchStats struct {
+	DamageDealt float64
+	DamageTaken float64
+	Healing     float64
+	Attacks     int
+	Hits        int
+}
+
+var mvpColor = color.RGBA{255, 200, 40, 70}
+
+// recordDamage записывает урон, дошедший до здоровья. attacker равен nil
+// для урона без виновника (зона, опасные места карты). Вызывается в
+// горутине комнаты.
+func recordDamage(attacker, target *PlayerState, amount float64) {
+	target.Stats.DamageTaken += amount
+	if attacker != nil && attacker.ID != target.ID {
+		attacker.Stats.DamageDealt += amount
+	}
+}
+
+// recordHealing записывает здоровье, которое игрок действительно
+// восстановил. Вызывается в горутине комнаты.
+func recordHealing(player *PlayerState, amount float64) {
+	player.Stats.Healing += amount
+}
+
+// pushMatchStats рассылает сводку статистики, если в этой рассылке пора.
+// Вызывается в горутине комнаты до того, как события тика уйдут в рассылку.
+func (r *Room) pushMatchStats() {
+	if r.worldState.Match.Phase != MatchActive || r.broadcastSeq%StatsBroadcastInterval != 0 {
+		return
+	}
+	stats := protocol.MatchStats{Players: make([]protocol.PlayerStats, 0, len(r.worldState.Players))}
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		stats.Players = append(stats.Players, protocol.PlayerStats{
+			PlayerID: player.ID,
+			Name:     player.Name,
+			Team:     player.Team,
+			Bot:      player.Bot,
+			Kills:    player.Kills,
+			Deaths:   player.Deaths,
+			Dealt:    int(math.Round(player.Stats.DamageDealt)),
+			Taken:    int(math.Round(player.Stats.DamageTaken)),
+			Healing:  int(math.Round(player.Stats.Healing)),
+			Attacks:  player.Stats.Attacks,
+			Hits:     player.Stats.Hits,
+		})
+	}
+	r.push(protocol.MsgMatchStats, stats)
+}
+
+// matchSummary собирает итоги матча в порядке таблицы результатов. MVP -
+// больше всех нанесенного урона, при равенстве - выше в таблице.
+// Вызывается в горутине комнаты.
+func (r *Room) matchSummary(results []MatchResult) protocol.MatchSummary {
+	summary := protocol.MatchSummary{Players: make([]protocol.PlayerSummary, 0, len(results))}
+	best := 0.0
+	for _, result := range results {
+		player, ok := r.worldState.Players[result.PlayerID]
+		if !ok {
+			continue
+		}
+		stats := player.Stats
+		accuracy := 0.0
+		if stats.Attacks > 0 {
+			accuracy = float64(stats.Hits) / float64(stats.Attacks)
+		}
+		summary.Players = append(summary.Players, protocol.PlayerSummary{
+			PlayerID:    player.ID,
+			Name:        player.Name,
+			Class:       player.Class,
+			Bot:         result.Bot,
+			Score:       result.Score,
+			Kills:       result.Kills,
+			Deaths:      result.Deaths,
+			DamageDealt: math.Round(stats.DamageDealt),
+			DamageTaken: math.Round(stats.DamageTaken),
+			Healing:     math.Round(stats.Healing),
+			Attacks:     stats.Attacks,
+			Hits:        stats.Hits,
+			Accuracy:    accuracy,
+		})
+		if stats.DamageDealt > best {
+			best = stats.DamageDealt
+			summary.MVP = player.ID
+		}
+	}
+	return summary
+}
+
+// drawMatchSummary рисует итоги матча, присланные сервером
+func (g *Game) drawMatchSummary(screen *ebiten.Image, summary *protocol.MatchSummary) {
+	const width, left, row = 500, ScreenWidth/2 - 240, 16
+	ebitenutil.DrawRect(screen, ScreenWidth/2-width/2, 100, width, float64(110+row*len(summary.Players)), color.RGBA{0, 0, 0, 200})
+	drawCentered(screen, tr("MATCH OVER"), 110)
+	drawText(screen, fmt.Sprintf("#  %-18s %5s %6s %7s %6s %6s %6s %5s",
+		tr("Player"), tr("Score"), tr("Kills"), tr("Deaths"), tr("Dealt"), tr("Taken"), tr("Healed"), tr("Acc")), left, 135)
+	for i, p := range summary.Players {
+		y := 151 + row*i
+		name := p.Name
+		if p.Bot {
+			name = tr("[BOT]") + " " + name
+		} else if p.PlayerID == g.playerID {
+			name += " " + tr("(You)")
+		}
+		if p.PlayerID == summary.MVP {
+			ebitenutil.DrawRect(screen, float64(left-4), float64(y), width-32, row, mvpColor)
+		}
+		line := fmt.Sprintf("%-2d %-18s %5d %6d %7d %6.0f %6.0f %6.0f %4.0f%%",
+			i+1, name, int(p.Score), p.Kills, p.Deaths, p.DamageDealt, p.DamageTaken, p.Healing, p.Accuracy*100)
+		drawText(screen, line, left, y+1)
+	}
+	y := 160 + row*len(summary.Players)
+	if mvp := summary.MVP; mvp != 0 {
+		for _, p := range summary.Players {
+			if p.PlayerID == mvp {
+				drawText(screen, trf("MVP: %s, %.0f damage", p.Name, p.DamageDealt), left, y)
+			}
+		}
+	}
+	g.drawCareer(screen, left, y+row)
+	remaining := math.Ceil(g.worldState.Match.Remaining)
+	drawText(screen, trf("Back to lobby in %.0f", math.Max(0, remaining)), left, y+2*row)
+}
+
+// drawScoreboard рисует таблицу идущего матча, пока зажата клавиша
+// InputScoreboard. Строки по убийствам, при равенстве - по смертям.
+func (g *Game) drawScoreboard(screen *ebiten.Image, hud hudLayout) {
+	if g.matchStats == nil || !g.keys.Pressed(InputScoreboard) {
+		return
+	}
+	players := append([]protocol.PlayerStats(nil), g.matchStats.Players...)
+	sort.SliceStable(players, func(i, j int) bool {
+		if players[i].Kills != players[j].Kills {
+			return players[i].Kills > players[j].Kills
+		}
+		return players[i].Deaths < players[j].Deaths
+	})
+	const width, row = 480, 16
+	left, top := hud.w/2-width/2, 70
+	ebitenutil.DrawRect(screen, float64(left), float64(top), width, float64(30+row*(len(players)+1)), color.RGBA{0, 0, 0, 200})
+	title := tr("Scoreboard")
+	drawText(screen, title, hud.centerText(title), top+6)
+	drawText(screen, fmt.Sprintf("%-18s %6s %7s %6s %6s %6s %5s",
+		tr("Player"), tr("Kills"), tr("Deaths"), tr("Dealt"), tr("Taken"), tr("Healed"), tr("Acc")), left+12, top+6+row)
+	for i, p := range players {
+		y := top + 6 + row*(i+2)
+		if c, ok := TeamColors[p.Team]; ok {
+			ebitenutil.DrawRect(screen, float64(left+4), float64(y), 4, row-2, c)
+		}
+		name := p.Name
+		if p.Bot {
+			name = tr("[BOT]") + " " + name
+		} else if p.PlayerID == g.playerID {
+			name += " " + tr("(You)")
+			ebitenutil.DrawRect(screen, float64(left+10), float64(y), width-20, row, color.RGBA{255, 255, 255, 30})
+		}
+		accuracy := 0.0
+		if p.Attacks > 0 {
+			accuracy = float64(p.Hits) / float64(p.Attacks)
+		}
+		drawText(screen, fmt.Sprintf("%-18s %6d %7d %6d %6d %6d %4.0f%%",
+			name, p.Kills, p.Deaths, p.Dealt, p.Taken, p.Healing, accuracy*100), left+12, y+1)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Размеры окна на выбор. Логическое разрешение игры не меняется,
+// картинка масштабируется.
+var windowSizes = [][2]int{
+	{800, 600},
+	{1024, 768},
+	{1280, 720},
+	{1280, 960},
+	{1600, 900},
+	{1920, 1080},
+}
+
+// Ограничения частоты кадров на выбор. Логика всегда идет с ebiten.DefaultTPS,
+// ограничение касается только отрисовки, см. Game.frameDue
+var fpsCaps = []int{30, 60, 120, 144, 240}
+
+// Пункты меню настроек
+const (
+	MenuResolution = iota
+	MenuFullscreen
+	MenuVSync
+	MenuFPSCap
+	MenuVolume
+	MenuMusicVolume
+	MenuMute
+	MenuLanguage
+	MenuKeyBindings
+	MenuClose
+	menuItems
+)
+
+// settingsMenu открывается по Esc
+type settingsMenu struct {
+	active   bool
+	selected int
+}
+
+// applySettings применяет настройки окна и звука через Ebiten
+func (g *Game) applySettings() {
+	s := g.settings
+	ebiten.SetWindowSize(s.WindowWidth, s.WindowHeight)
+	ebiten.SetFullscreen(s.Fullscreen)
+	ebiten.SetVsyncEnabled(s.VSync)
+	setLocale(s.Language)
+	if g.sound != nil {
+		g.sound.applySettings(s)
+	}
+}
+
+// frameDue решает, рисовать ли кадр при ограничении FPSCap. Кадры идут по
+// расписанию с небольшим допуском, иначе дрожание vsync пропускало бы
+// каждый второй кадр при ограничении, равном частоте монитора.
+func (g *Game) frameDue(now time.Time) bool {
+	if g.settings.FPSCap <= 0 {
+		return true
+	}
+	interval := time.Second / time.Duration(g.settings.FPSCap)
+	if now.Before(g.nextFrame.Add(-interval / 8)) {
+		return false
+	}
+	g.nextFrame = g.nextFrame.Add(interval)
+	if g.nextFrame.Before(now) {
+		// Отстали больше чем на кадр - не наверстываем
+		g.nextFrame = now.Add(interval)
+	}
+	return true
+}
+
+// updateSettingsMenu: вверх/вниз - выбор пункта, влево/вправо - изменить
+// значение, Enter - переключить, Esc - закрыть. Изменения применяются и
+// сохраняются сразу. Вызывается в игровом цикле.
+func (g *Game) updateSettingsMenu() {
+	m := &g.menu
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		m.active = false
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		m.selected = (m.selected + menuItems - 1) % menuItems
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		m.selected = (m.selected + 1) % menuItems
+	}
+
+	step := 0
+	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+		step = -1
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) || inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		step = 1
+	}
+	if step == 0 {
+		return
+	}
+
+	s := &g.settings
+	switch m.selected {
+	case MenuResolution:
+		i := cycle(windowSizeIndex(s.WindowWidth, s.WindowHeight), step, len(windowSizes))
+		s.WindowWidth, s.WindowHeight = windowSizes[i][0], windowSizes[i][1]
+	case MenuFullscreen:
+		s.Fullscreen = !s.Fullscreen
+	case MenuVSync:
+		s.VSync = !s.VSync
+	case MenuFPSCap:
+		s.FPSCap = fpsCaps[cycle(fpsCapIndex(s.FPSCap), step, len(fpsCaps))]
+	case MenuVolume:
+		s.Volume = math.Max(0, math.Min(1, s.Volume+float64(step)*VolumeStep))
+	case MenuMusicVolume:
+		s.MusicVolume = math.Max(0, math.Min(1, s.MusicVolume+float64(step)*VolumeStep))
+	case MenuMute:
+		s.Muted = !s.Muted
+	case MenuLanguage:
+		s.Language = locales[cycle(localeIndex(s.Language), step, len(locales))]
+	case MenuKeyBindings:
+		m.active = false
+		g.keyScreen.active = true
+		return
+	case MenuClose:
+		m.active = false
+		return
+	}
+
+	g.applySettings()
+	if err := g.settings.Save(); err != nil {
+		clientLog.Error("Error saving settings", "err", err)
+	}
+}
+
+func cycle(i, step, n int) int {
+	return ((i+step)%n + n) % n
+}
+
+func windowSizeIndex(width, height int) int {
+	for i, size := range windowSizes {
+		if size[0] == width && size[1] == height {
+			return i
+		}
+	}
+	return 0
+}
+
+func fpsCapIndex(fps int) int {
+	for i, c := range fpsCaps {
+		if c == fps {
+			return i
+		}
+	}
+	return 1
+}
+
+func localeIndex(name string) int {
+	for i, l := range locales {
+		if l == name {
+			return i
+		}
+	}
+	return 0
+}
+
+func onOff(v bool) string {
+	if v {
+		return tr("on")
+	}
+	return tr("off")
+}
+
+// drawMenus рисует открытое меню поверх игры или списка комнат
+func (g *Game) drawMenus(screen *ebiten.Image) {
+	switch {
+	case g.menu.active:
+		g.drawSettingsMenu(screen)
+	case g.keyScreen.active:
+		g.drawKeyBindingsScreen(screen)
+	}
+}
+
+func (g *Game) drawSettingsMenu(screen *ebiten.Image) {
+	s := g.settings
+	lines := [menuItems]string{
+		MenuResolution:  trf("Window size     < %dx%d >", s.WindowWidth, s.WindowHeight),
+		MenuFullscreen:  trf("Fullscreen      %s", onOff(s.Fullscreen)),
+		MenuVSync:       trf("VSync           %s", onOff(s.VSync)),
+		MenuFPSCap:      trf("FPS cap         < %d >", s.FPSCap),
+		MenuVolume:      trf("Volume          < %d%% >", int(math.Round(s.Volume*100))),
+		MenuMusicVolume: trf("Music volume    < %d%% >", int(math.Round(s.MusicVolume*100))),
+		MenuMute:        trf("Mute            %s", onOff(s.Muted)),
+		MenuLanguage:    trf("Language        < %s >", localeNames[locale]),
+		MenuKeyBindings: tr("Key bindings..."),
+		MenuClose:       tr("Close"),
+	}
+
+	const top, row = 120, 20
+	ebitenutil.DrawRect(screen, 220, top-60, ScreenWidth-440, float64(90+row*menuItems), color.RGBA{0, 0, 0, 220})
+	drawCentered(screen, tr("SETTINGS"), top-50)
+	drawCentered(screen, tr("Up/Down - select, Left/Right/Enter - change, Esc - close"), top-30)
+	for i, line := range lines {
+		y := top + i*row
+		if i == g.menu.selected {
+			ebitenutil.DrawRect(screen, 235, float64(y), ScreenWidth-470, row, color.RGBA{255, 255, 255, 40})
+		}
+		drawText(screen, line, 245, y+2)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameDue(t *testing.T) {
+	g := &Game{settings: Settings{FPSCap: 30}}
+	start := time.Now()
+	drawn := 0
+	// Монитор 60 Гц с дрожанием: при ограничении 30 рисуется каждый второй кадр
+	for i := 0; i < 60; i++ {
+		jitter := time.Duration(i%3-1) * time.Millisecond
+		if g.frameDue(start.Add(time.Duration(i)*time.Second/60 + jitter)) {
+			drawn++
+		}
+	}
+	if drawn != 30 {
+		t.Errorf("30 FPS cap at 60 Hz: drew %d frames, want 30", drawn)
+	}
+
+	// Ограничение, равное частоте монитора, не должно терять кадры
+	g = &Game{settings: Settings{FPSCap: 60}}
+	drawn = 0
+	for i := 0; i < 60; i++ {
+		jitter := time.Duration(i%3-1) * time.Millisecond
+		if g.frameDue(start.Add(time.Duration(i)*time.Second/60 + jitter)) {
+			drawn++
+		}
+	}
+	if drawn != 60 {
+		t.Errorf("60 FPS cap at 60 Hz: drew %d frames, want 60", drawn)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Границы корзин гистограммы длительности тика, в секундах
+var tickDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+// Metrics собирает метрики сервера и отдает их в текстовом формате Prometheus
+type Metrics struct {
+	mu sync.Mutex
+
+	tickBucketCounts []uint64
+	tickSum          float64
+	tickCount        uint64
+	simulateSum      float64
+	broadcastSum     float64
+	tickOverruns     map[string]uint64 // комната -> тики дольше бюджета
+	broadcastEvery   map[string]int    // комната -> рассылка раз в столько тиков
+
+	connectedPlayers map[string]int // комната -> игроки
+	bots             map[string]int
+
+	messagesReceived uint64
+	messagesSent     uint64
+	bytesSent        map[int]uint64 // ID игрока -> байты
+	bytesReceived    map[int]uint64
+	throttled        map[int]uint64 // ID игрока -> снимки, пропущенные из-за предела трафика
+	events           map[string]uint64
+	exported         uint64 // Записи лога, выгруженные для аналитики
+	exportDropped    uint64 // Записи, потерянные при переполненной очереди выгрузки
+}
+
+var metrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		tickBucketCounts: make([]uint64, len(tickDurationBuckets)),
+		tickOverruns:     make(map[string]uint64),
+		broadcastEvery:   make(map[string]int),
+		connectedPlayers: make(map[string]int),
+		bots:             make(map[string]int),
+		bytesSent:        make(map[int]uint64),
+		bytesReceived:    make(map[int]uint64),
+		throttled:        make(map[int]uint64),
+		events:           make(map[string]uint64),
+	}
+}
+
+func (m *Metrics) ObserveTick(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seconds := d.Seconds()
+	for i, bound := range tickDurationBuckets {
+		if seconds <= bound {
+			m.tickBucketCounts[i]++
+		}
+	}
+	m.tickSum += seconds
+	m.tickCount++
+}
+
+// ObserveTickPhases учитывает время симуляции и рассылки отдельно
+func (m *Metrics) ObserveTickPhases(room string, simulate, broadcast time.Duration, overrun bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.simulateSum += simulate.Seconds()
+	m.broadcastSum += broadcast.Seconds()
+	if overrun {
+		m.tickOverruns[room]++
+	}
+}
+
+func (m *Metrics) SetBroadcastInterval(room string, ticks int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.broadcastEvery[room] = ticks
+}
+
+func (m *Metrics) SetPlayers(room string, players, bots int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connectedPlayers[room] = players
+	m.bots[room] = bots
+}
+
+// ForgetRoom убирает метки закрытой комнаты
+func (m *Metrics) ForgetRoom(room string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.connectedPlayers, room)
+	delete(m.bots, room)
+	delete(m.tickOverruns, room)
+	delete(m.broadcastEvery, room)
+}
+
+func (m *Metrics) MessageReceived() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messagesReceived++
+}
+
+func (m *Metrics) MessageSent(playerID, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messagesSent++
+	m.bytesSent[playerID] += uint64(bytes)
+}
+
+func (m *Metrics) BytesReceived(playerID, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesReceived[playerID] += uint64(bytes)
+}
+
+func (m *Metrics) SnapshotThrottled(playerID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.throttled[playerID]++
+}
+
+// ForgetClient убирает метки отключившегося клиента
+func (m *Metrics) ForgetClient(playerID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bytesSent, playerID)
+	delete(m.bytesReceived, playerID)
+	delete(m.throttled, playerID)
+}
+
+func (m *Metrics) Event(eventType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[eventType]++
+}
+
+func (m *Metrics) Exported(records int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exported += uint64(records)
+}
+
+func (m *Metrics) ExportDropped(records int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exportDropped += uint64(records)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP meatgrinder_tick_duration_seconds Time spent simulating and broadcasting one server tick.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_tick_duration_seconds histogram")
+	for i, bound := range tickDurationBuckets {
+		fmt.Fprintf(w, "meatgrinder_tick_duration_seconds_bucket{le=\"%s\"} %d\n",
+			strconv.FormatFloat(bound, 'g', -1, 64), m.tickBucketCounts[i])
+	}
+	fmt.Fprintf(w, "meatgrinder_tick_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.tickCount)
+	fmt.Fprintf(w, "meatgrinder_tick_duration_seconds_sum %g\n", m.tickSum)
+	fmt.Fprintf(w, "meatgrinder_tick_duration_seconds_count %d\n", m.tickCount)
+
+	fmt.Fprintln(w, "# HELP meatgrinder_tick_phase_seconds_total Time spent in each phase of server ticks.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_tick_phase_seconds_total counter")
+	fmt.Fprintf(w, "meatgrinder_tick_phase_seconds_total{phase=\"simulate\"} %g\n", m.simulateSum)
+	fmt.Fprintf(w, "meatgrinder_tick_phase_seconds_total{phase=\"broadcast\"} %g\n", m.broadcastSum)
+
+	rooms := make([]string, 0, len(m.connectedPlayers))
+	for room := range m.connectedPlayers {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	fmt.Fprintln(w, "# HELP meatgrinder_connected_players Number of connected human players.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_connected_players gauge")
+	for _, room := range rooms {
+		fmt.Fprintf(w, "meatgrinder_connected_players{room=%q} %d\n", room, m.connectedPlayers[room])
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_tick_overruns_total Ticks that took longer than the tick budget.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_tick_overruns_total counter")
+	for _, room := range rooms {
+		fmt.Fprintf(w, "meatgrinder_tick_overruns_total{room=%q} %d\n", room, m.tickOverruns[room])
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_broadcast_interval_ticks State is broadcast once per this many ticks.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_broadcast_interval_ticks gauge")
+	for _, room := range rooms {
+		interval := m.broadcastEvery[room]
+		if interval == 0 {
+			interval = 1
+		}
+		fmt.Fprintf(w, "meatgrinder_broadcast_interval_ticks{room=%q} %d\n", room, interval)
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_bots Number of bots in the world.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_bots gauge")
+	for _, room := range rooms {
+		fmt.Fprintf(w, "meatgrinder_bots{room=%q} %d\n", room, m.bots[room])
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_messages_received_total Messages received from clients.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_messages_received_total counter")
+	fmt.Fprintf(w, "meatgrinder_messages_received_total %d\n", m.messagesReceived)
+
+	fmt.Fprintln(w, "# HELP meatgrinder_messages_sent_total Messages sent to clients.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_messages_sent_total counter")
+	fmt.Fprintf(w, "meatgrinder_messages_sent_total %d\n", m.messagesSent)
+
+	fmt.Fprintln(w, "# HELP meatgrinder_bytes_sent_total Bytes sent to each client.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_bytes_sent_total counter")
+	playerIDs := make([]int, 0, len(m.bytesSent))
+	for id := range m.bytesSent {
+		playerIDs = append(playerIDs, id)
+	}
+	sort.Ints(playerIDs)
+	for _, id := range playerIDs {
+		fmt.Fprintf(w, "meatgrinder_bytes_sent_total{player=\"%d\"} %d\n", id, m.bytesSent[id])
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_bytes_received_total Bytes received from each client.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_bytes_received_total counter")
+	for _, id := range sortedIDs(m.bytesReceived) {
+		fmt.Fprintf(w, "meatgrinder_bytes_received_total{player=\"%d\"} %d\n", id, m.bytesReceived[id])
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_snapshots_throttled_total State snapshots skipped because a client was over its bandwidth cap.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_snapshots_throttled_total counter")
+	for _, id := range sortedIDs(m.throttled) {
+		fmt.Fprintf(w, "meatgrinder_snapshots_throttled_total{player=\"%d\"} %d\n", id, m.throttled[id])
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_events_total Game events by type.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_events_total counter")
+	eventTypes := make([]string, 0, len(m.events))
+	for eventType := range m.events {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+	for _, eventType := range eventTypes {
+		fmt.Fprintf(w, "meatgrinder_events_total{event=%q} %d\n", eventType, m.events[eventType])
+	}
+
+	fmt.Fprintln(w, "# HELP meatgrinder_events_exported_total Log events written to the analytics export.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_events_exported_total counter")
+	fmt.Fprintf(w, "meatgrinder_events_exported_total %d\n", m.exported)
+	fmt.Fprintln(w, "# HELP meatgrinder_events_export_dropped_total Log events dropped because the analytics export fell behind.")
+	fmt.Fprintln(w, "# TYPE meatgrinder_events_export_dropped_total counter")
+	fmt.Fprintf(w, "meatgrinder_events_export_dropped_total %d\n", m.exportDropped)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+const (
+	MinimapWidth       = 160 // Ширина миникарты, высота считается по пропорциям мира
+	MinimapMargin      = 10
+	MinimapDotRadius   = 2
+	DamageFlashTimeout = 500 * time.Millisecond // Сколько мигает точка раненого игрока
+)
+
+// trackDamage запоминает, кто потерял здоровье с прошлого состояния, чтобы
+// миникарта подсветила место боя. Вызывается в игровом цикле до замены worldState.
+func (g *Game) trackDamage(state WorldState, now time.Time) {
+	for id, player := range state.Players {
+		if prev, ok := g.worldState.Players[id]; ok && player.Health < prev.Health {
+			g.damageFlashes[id] = now
+		}
+	}
+	for id, at := range g.damageFlashes {
+		if _, ok := state.Players[id]; !ok || now.Sub(at) > DamageFlashTimeout {
+			delete(g.damageFlashes, id)
+		}
+	}
+}
+
+// drawMinimap рисует мир целиком в правом верхнем углу
+func (g *Game) drawMinimap(screen *ebiten.Image, hud hudLayout) {
+	if g.worldWidth <= 0 || g.worldHeight <= 0 {
+		return
+	}
+	scale := MinimapWidth / g.worldWidth
+	height := g.worldHeight * scale
+	left := float64(hud.w - MinimapWidth - MinimapMargin)
+	top := float64(MinimapMargin + HUDLine) // Над миникартой пинг и FPS
+	toMinimap := func(p Point) (float64, float64) {
+		return left + p.X*scale, top + p.Y*scale
+	}
+
+	ebitenutil.DrawRect(screen, left, top, MinimapWidth, height, color.RGBA{0, 0, 0, 160})
+
+	// Видимая часть мира
+	viewColor := color.RGBA{255, 255, 255, 80}
+	vx, vy := toMinimap(Point{X: g.camera.X, Y: g.camera.Y})
+	vw, vh := ScreenWidth*scale, ScreenHeight*scale
+	ebitenutil.DrawLine(screen, vx, vy, vx+vw, vy, viewColor)
+	ebitenutil.DrawLine(screen, vx+vw, vy, vx+vw, vy+vh, viewColor)
+	ebitenutil.DrawLine(screen, vx+vw, vy+vh, vx, vy+vh, viewColor)
+	ebitenutil.DrawLine(screen, vx, vy+vh, vx, vy, viewColor)
+
+	if zone := g.worldState.Zone; zone != nil {
+		x, y := toMinimap(zone.Center)
+		vector.StrokeCircle(screen, float32(x), float32(y), float32(zone.Radius*scale), 1, color.RGBA{80, 140, 255, 220}, true)
+	}
+
+	now := time.Now()
+	for id, player := range g.worldState.Players {
+		x, y := toMinimap(g.playerPositions[id])
+		if at, ok := g.damageFlashes[id]; ok && now.Sub(at) < DamageFlashTimeout {
+			// Мигаем несколько раз за время подсветки
+			if now.Sub(at)/(DamageFlashTimeout/4)%2 == 0 {
+				ebitenutil.DrawCircle(screen, x, y, MinimapDotRadius+3, color.RGBA{255, 60, 60, 200})
+			}
+		}
+		if id == g.playerID {
+			ebitenutil.DrawCircle(screen, x, y, MinimapDotRadius+2, color.White)
+		}
+		ebitenutil.DrawCircle(screen, x, y, MinimapDotRadius, ClassColors[player.Class])
+	}
+}
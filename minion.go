@@ -0,0 +1,175 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+
+	"meatgrinder/protocol"
+)
+
+// Прислужники мага: живут недолго, бегут за целью хозяина и кусают ее.
+// Сами урон не получают, пропадают по времени или со смертью хозяина.
+const (
+	SummonCost          = 40.0 // Маны за призыв
+	SummonCooldown      = 10.0 // Секунд между призывами
+	MinionsPerSummon    = 2
+	MinionLifetime      = 8.0
+	MinionRadius        = 8.0
+	MinionSpeed         = 140.0
+	MinionAttackRange   = PlayerRadius + MinionRadius + 4
+	MinionAttackSpeed   = 1.5 // Укусов в секунду
+	MinionDamageFactor  = 0.3 // Доля урона хозяина
+	MinionSpawnDistance = 30.0
+
+	EventMinionSummoned = "minion_summoned"
+)
+
+// MinionAttack - укус прислужника. Урон считается от класса хозяина и
+// проходит мимо брони.
+var MinionAttack = AttackSpec{Name: "bite", DamageType: TrueDamage, Range: MinionAttackRange}
+
+// Minion - прислужник. Убийства и опыт засчитываются хозяину.
+type Minion struct {
+	ID             int       `json:"id"`
+	OwnerID        int       `json:"owner_id"`
+	Position       Point     `json:"position"`
+	Target         int       `json:"target"`
+	Remaining      float64   `json:"remaining"` // Секунд до исчезновения
+	LastAttackTime time.Time `json:"-"`
+}
+
+// summonMinions призывает прислужников, если маг может себе это позволить.
+// Вызывается в горутине комнаты.
+func (r *Room) summonMinions(player *PlayerState, now time.Time) {
+	if player.Class != MageClass || player.Eliminated || player.Health <= 0 {
+		return
+	}
+	if now.Sub(player.LastSummonTime).Seconds() < SummonCooldown {
+		return
+	}
+	if !spendResource(player, SummonCost) {
+		return
+	}
+	player.LastSummonTime = now
+	for i := 0; i < MinionsPerSummon; i++ {
+		angle := 2 * math.Pi * float64(i) / MinionsPerSummon
+		minion := &Minion{
+			ID:      r.nextMinionID,
+			OwnerID: player.ID,
+			Position: Point{
+				X: player.Position.X + math.Cos(angle)*MinionSpawnDistance,
+				Y: player.Position.Y + math.Sin(angle)*MinionSpawnDistance,
+			},
+			Target:    player.Target,
+			Remaining: MinionLifetime,
+		}
+		r.nextMinionID++
+		r.worldState.Minions[minion.ID] = minion
+	}
+	r.logEvent(now, EventMinionSummoned, map[string]interface{}{
+		"player_id": player.ID,
+		"count":     MinionsPerSummon,
+	})
+	r.log.Debug("Minions summoned", "player_id", player.ID, "count", MinionsPerSummon)
+}
+
+// dismissMinions убирает прислужников погибшего или вышедшего игрока
+func (r *Room) dismissMinions(ownerID int) {
+	for id, minion := range r.worldState.Minions {
+		if minion.OwnerID == ownerID {
+			delete(r.worldState.Minions, id)
+		}
+	}
+}
+
+// updateMinions ведет прислужников тем же выбором цели, что и ботов: цель
+// хозяина, а без нее - ближайший противник. Вызывается в горутине комнаты.
+func (r *Room) updateMinions(deltaTime float64, now time.Time) {
+	for _, id := range sortedIDs(r.worldState.Minions) {
+		minion := r.worldState.Minions[id]
+		owner, ok := r.worldState.Players[minion.OwnerID]
+		minion.Remaining -= deltaTime
+		if !ok || owner.Eliminated || minion.Remaining <= 0 {
+			delete(r.worldState.Minions, id)
+			continue
+		}
+
+		minion.Target = owner.Target
+		if target, ok := r.worldState.Players[minion.Target]; !ok || target.Eliminated ||
+			(target.Team != 0 && target.Team == owner.Team) {
+			minion.Target = r.closestEnemy(owner, minion.Position)
+		}
+		target, ok := r.worldState.Players[minion.Target]
+		if !ok {
+			continue
+		}
+
+		dx, dy := target.Position.X-minion.Position.X, target.Position.Y-minion.Position.Y
+		dist := math.Hypot(dx, dy)
+		if dist > MinionAttackRange {
+			step := math.Min(MinionSpeed*deltaTime, dist-MinionAttackRange)
+			minion.Position.X += dx / dist * step
+			minion.Position.Y += dy / dist * step
+			r.pushOutOfObstacles(&minion.Position, MinionRadius)
+			continue
+		}
+		if now.Sub(minion.LastAttackTime).Seconds() >= 1.0/MinionAttackSpeed {
+			minion.LastAttackTime = now
+			r.minionBite(minion, owner, target, now)
+		}
+	}
+}
+
+// minionBite наносит урон от имени хозяина
+func (r *Room) minionBite(minion *Minion, owner, target *PlayerState, now time.Time) {
+	hit := Hit{Attacker: owner, Target: target, Spec: MinionAttack}
+	amount := damagePlayer(target, r.calculateDamage(&hit)*MinionDamageFactor)
+	recordDamage(owner, target, amount)
+	target.LastDamagedBy = owner.ID
+	r.addThreat(target, owner.ID, amount)
+	r.awardXP(owner, amount*XPPerDamage, now)
+
+	r.logEvent(now, EventPlayerAttack, map[string]interface{}{
+		"attacker_id": owner.ID,
+		"minion_id":   minion.ID,
+		"target_id":   target.ID,
+		"attack":      MinionAttack.Name,
+		"damage":      amount,
+		"damage_type": MinionAttack.DamageType,
+		"crit":        hit.Crit,
+	})
+	r.push(protocol.MsgDamage, protocol.Damage{
+		AttackerID: owner.ID,
+		TargetID:   target.ID,
+		Amount:     amount,
+		Position:   target.Position.message(),
+		Crit:       hit.Crit,
+	}, target.ID)
+}
+
+// drawMinions рисует прислужников маленькими ромбами цвета хозяина
+func (g *Game) drawMinions(screen *ebiten.Image) {
+	for _, minion := range g.worldState.Minions {
+		c := color.RGBA{200, 120, 255, 255}
+		if owner, ok := g.worldState.Players[minion.OwnerID]; ok {
+			c = ClassColors[owner.Class]
+		}
+		// Тускнеют перед исчезновением
+		if minion.Remaining < 1 {
+			c.A = uint8(255 * math.Max(0.2, minion.Remaining))
+		}
+		pos := g.camera.toScreen(minion.Position)
+		for i := 0; i < 4; i++ {
+			a0 := math.Pi / 2 * float64(i)
+			a1 := math.Pi / 2 * float64(i+1)
+			ebitenutil.DrawLine(screen,
+				pos.X+math.Cos(a0)*MinionRadius, pos.Y+math.Sin(a0)*MinionRadius,
+				pos.X+math.Cos(a1)*MinionRadius, pos.Y+math.Sin(a1)*MinionRadius, c)
+		}
+		ebitenutil.DrawCircle(screen, pos.X, pos.Y, MinionRadius/2, c)
+	}
+}
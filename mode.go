@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Режимы игры. Режим выбирается при запуске сервера и решает, за что
+// начисляются очки, кто возрождается и когда матч кончается досрочно.
+const (
+	GameModeFFA         = "ffa"         // Каждый сам за себя, до DeathmatchScoreTarget убийств
+	GameModeElimination = "elimination" // Раунды до последнего выжившего
+	GameModeKOTH        = "koth"        // Кто дольше удержит холм
+
+	DeathmatchScoreTarget = 15
+	RoundsToWin           = 3
+	KOTHScoreTarget       = 60.0 // Секунд на холме для победы
+	HillRadius            = 120.0
+
+	EventRoundEnd = "round_end"
+)
+
+var GameModes = []string{GameModeFFA, GameModeElimination, GameModeKOTH, GameModeCTF}
+
+// GameMode - правила матча. Все методы вызываются в горутине комнаты и только
+// во время боя, кроме Start и HUD.
+type GameMode interface {
+	Name() string
+	// Start вызывается в начале матча, когда игроки уже сброшены
+	Start(r *Room)
+	// Update вызывается каждый тик боя
+	Update(r *Room, deltaTime float64, now time.Time)
+	// OnDeath начисляет очки за смерть victim. killer равен nil, если игрок
+	// погиб сам. false - жертва выбывает до конца раунда.
+	OnDeath(r *Room, victim, killer *PlayerState, now time.Time) bool
+	// Finished сообщает, что матч выигран досрочно
+	Finished(r *Room) bool
+	// HUD - данные режима для интерфейса клиента
+	HUD(r *Room) *ModeHUD
+}
+
+// ModeHUD - то, что клиент показывает про режим. Поля, не относящиеся
+// к режиму, пустые.
+type ModeHUD struct {
+	Objective string `json:"objective"` // По-английски, для ботов и старых клиентов
+	// Формат цели и его аргументы: клиент переводит формат по каталогу
+	ObjectiveKey  string `json:"objective_key,omitempty"`
+	ObjectiveArgs []int  `json:"objective_args,omitempty"`
+	Leader        int    `json:"leader,omitempty"` // ID игрока с наибольшим счетом
+	// Имя и счет лидера: его самого у клиента может не быть, если он вне обзора
+	LeaderName  string  `json:"leader_name,omitempty"`
+	LeaderScore float64 `json:"leader_score,omitempty"`
+	Round       int     `json:"round,omitempty"`
+	Alive       int     `json:"alive,omitempty"`
+	Hill        *Hill   `json:"hill,omitempty"`
+	// Счет команд в командных режимах, команда -> очки
+	TeamScores map[int]int `json:"team_scores,omitempty"`
+}
+
+// Hill - зона контроля в режиме koth
+type Hill struct {
+	Center    Point   `json:"center"`
+	Radius    float64 `json:"radius"`
+	Holder    int     `json:"holder,omitempty"` // Единственный живой игрок на холме
+	Contested bool    `json:"contested,omitempty"`
+}
+
+func newGameMode(name string, cfg Config) GameMode {
+	switch name {
+	case GameModeElimination:
+		return &eliminationMode{}
+	case GameModeCTF:
+		return newCTFMode()
+	case GameModeKOTH:
+		return &kothMode{hill: Hill{Center: Point{X: cfg.WorldWidth / 2, Y: cfg.WorldHeight / 2}, Radius: HillRadius}}
+	}
+	return deathmatchMode{}
+}
+
+// leader возвращает игрока с наибольшим счетом, при равенстве - с меньшим
+// ID. nil, если очков еще ни у кого нет.
+func (r *Room) leader() *PlayerState {
+	var best *PlayerState
+	for _, id := range sortedIDs(r.worldState.Players) {
+		if player := r.worldState.Players[id]; player.Score > 0 && (best == nil || player.Score > best.Score) {
+			best = player
+		}
+	}
+	return best
+}
+
+// leaderScore - наибольший счет в комнате
+func (r *Room) leaderScore() float64 {
+	if leader := r.leader(); leader != nil {
+		return leader.Score
+	}
+	return 0
+}
+
+// newModeHUD заполняет общие для всех режимов поля. objective - формат
+// цели по-английски с аргументами args.
+func (r *Room) newModeHUD(objective string, args ...int) *ModeHUD {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	hud := &ModeHUD{Objective: fmt.Sprintf(objective, values...), ObjectiveKey: objective, ObjectiveArgs: args}
+	if leader := r.leader(); leader != nil {
+		hud.Leader, hud.LeaderName, hud.LeaderScore = leader.ID, leader.Name, leader.Score
+	}
+	return hud
+}
+
+// deathmatchMode: очко за убийство, возрождение сразу
+type deathmatchMode struct{}
+
+func (deathmatchMode) Name() string { return GameModeFFA }
+
+func (deathmatchMode) Start(r *Room) {}
+
+func (deathmatchMode) Update(r *Room, deltaTime float64, now time.Time) {}
+
+func (deathmatchMode) OnDeath(r *Room, victim, killer *PlayerState, now time.Time) bool {
+	if killer != nil {
+		killer.Score++
+	}
+	return true
+}
+
+func (deathmatchMode) Finished(r *Room) bool {
+	return r.leaderScore() >= DeathmatchScoreTarget
+}
+
+func (deathmatchMode) HUD(r *Room) *ModeHUD {
+	return r.newModeHUD("First to %d kills", DeathmatchScoreTarget)
+}
+
+// eliminationMode: погибшие ждут конца раунда, последний выживший получает
+// очко. Матч выигрывает тот, кто первым возьмет RoundsToWin раундов.
+type eliminationMode struct {
+	round int
+}
+
+func (m *eliminationMode) Name() string { return GameModeElimination }
+
+func (m *eliminationMode) Start(r *Room) {
+	m.round = 1
+}
+
+func (m *eliminationMode) Update(r *Room, deltaTime float64, now time.Time) {
+	// Одному раунд выиграть не у кого
+	if len(r.worldState.Players) < 2 {
+		return
+	}
+	var survivor *PlayerState
+	alive := 0
+	for _, id := range sortedIDs(r.worldState.Players) {
+		if player := r.worldState.Players[id]; !player.Eliminated {
+			alive++
+			survivor = player
+		}
+	}
+	if alive > 1 {
+		return
+	}
+
+	winnerID := 0
+	if survivor != nil {
+		survivor.Score++
+		winnerID = survivor.ID
+	}
+	r.logEvent(now, EventRoundEnd, map[string]interface{}{
+		"round":  m.round,
+		"winner": winnerID,
+	})
+	r.log.Info("Round won", "round", m.round, "player_id", winnerID)
+	if m.Finished(r) {
+		return
+	}
+	m.round++
+	for _, id := range sortedIDs(r.worldState.Players) {
+		r.resetPlayer(r.worldState.Players[id])
+	}
+}
+
+func (m *eliminationMode) OnDeath(r *Room, victim, killer *PlayerState, now time.Time) bool {
+	return false
+}
+
+func (m *eliminationMode) Finished(r *Room) bool {
+	return r.leaderScore() >= RoundsToWin
+}
+
+// saveState и restoreState переносят номер раунда через горячий перезапуск
+func (m *eliminationMode) saveState() ([]byte, error) {
+	return json.Marshal(m.round)
+}
+
+func (m *eliminationMode) restoreState(data []byte) error {
+	return json.Unmarshal(data, &m.round)
+}
+
+func (m *eliminationMode) HUD(r *Room) *ModeHUD {
+	alive := 0
+	for _, player := range r.worldState.Players {
+		if !player.Eliminated {
+			alive++
+		}
+	}
+	hud := r.newModeHUD("Last one standing wins the round, first to %d rounds", RoundsToWin)
+	hud.Round, hud.Alive = m.round, alive
+	return hud
+}
+
+// kothMode: очки капают, пока на холме стоит ровно один живой игрок
+type kothMode struct {
+	hill Hill
+}
+
+func (m *kothMode) Name() string { return GameModeKOTH }
+
+func (m *kothMode) Start(r *Room) {
+	m.hill.Holder, m.hill.Contested = 0, false
+}
+
+func (m *kothMode) Update(r *Room, deltaTime float64, now time.Time) {
+	var holder *PlayerState
+	occupants := 0
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		if player.Eliminated {
+			continue
+		}
+		if math.Hypot(player.Position.X-m.hill.Center.X, player.Position.Y-m.hill.Center.Y) <= m.hill.Radius {
+			occupants++
+			holder = player
+		}
+	}
+	m.hill.Contested = occupants > 1
+	m.hill.Holder = 0
+	if occupants == 1 {
+		m.hill.Holder = holder.ID
+		holder.Score += deltaTime
+	}
+}
+
+func (m *kothMode) OnDeath(r *Room, victim, killer *PlayerState, now time.Time) bool {
+	return true
+}
+
+func (m *kothMode) Finished(r *Room) bool {
+	return r.leaderScore() >= KOTHScoreTarget
+}
+
+func (m *kothMode) HUD(r *Room) *ModeHUD {
+	hud := r.newModeHUD("Hold the hill for %d seconds", int(KOTHScoreTarget))
+	hill := m.hill
+	hud.Hill = &hill
+	return hud
+}
+
+// drawHill рисует холм режима koth: серый - свободен, цвет класса
+// удерживающего, красный - оспаривается
+func (g *Game) drawHill(screen *ebiten.Image) {
+	hud := g.worldState.Match.HUD
+	if hud == nil || hud.Hill == nil {
+		return
+	}
+	hill := hud.Hill
+	fill := color.RGBA{200, 200, 200, 40}
+	if holder, ok := g.worldState.Players[hill.Holder]; ok {
+		c := ClassColors[holder.Class]
+		fill = color.RGBA{c.R, c.G, c.B, 60}
+	}
+	if hill.Contested {
+		fill = color.RGBA{255, 60, 60, 60}
+	}
+	center := g.camera.toScreen(hill.Center)
+	ebitenutil.DrawCircle(screen, center.X, center.Y, hill.Radius, fill)
+	label := tr("HILL")
+	drawText(screen, label, int(center.X)-textWidth(label)/2, int(center.Y)-8)
+}
+
+// drawModeHUD рисует цель режима и положение игрока под таймером матча
+func (g *Game) drawModeHUD(screen *ebiten.Image, layout hudLayout) {
+	match := g.worldState.Match
+	if match.HUD == nil {
+		return
+	}
+	hud := match.HUD
+	objective := hud.Objective
+	if hud.ObjectiveKey != "" {
+		args := make([]interface{}, len(hud.ObjectiveArgs))
+		for i, arg := range hud.ObjectiveArgs {
+			args[i] = arg
+		}
+		objective = trf(hud.ObjectiveKey, args...)
+	}
+	drawText(screen, objective, layout.centerText(objective), HUDMargin+HUDLine)
+
+	var status string
+	if me, ok := g.worldState.Players[g.playerID]; ok && match.Phase == MatchActive {
+		status = trf("Score %d", int(me.Score))
+		if hud.Leader != 0 && hud.Leader != me.ID {
+			status += trf(", leader %s %d", hud.LeaderName, int(hud.LeaderScore))
+		}
+		if len(hud.TeamScores) > 0 {
+			status = fmt.Sprintf("%s %d : %d %s. ", tr(TeamNames[TeamRed]), hud.TeamScores[TeamRed],
+				hud.TeamScores[TeamBlue], tr(TeamNames[TeamBlue])) + status
+		}
+		if hud.Round > 0 {
+			status = trf("Round %d, %d alive. ", hud.Round, hud.Alive) + status
+		}
+		if me.Eliminated {
+			text := tr("ELIMINATED - waiting for the next round")
+			drawText(screen, text, layout.centerText(text), layout.h/2-80)
+		}
+	}
+	if status != "" {
+		drawText(screen, status, layout.centerText(status), HUDMargin+2*HUDLine)
+	}
+}
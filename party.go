@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+
+	"meatgrinder/protocol"
+)
+
+// Группы друзей собираются в лобби, до входа в комнату. Клиент
+// представляется сообщением enter_lobby, после чего может создать группу,
+// войти в чужую по коду или пригласить игрока из лобби по имени. Лидер
+// (первый в группе) ставит в очередь подбора всю группу, и она попадает в
+// одну комнату и в одну команду. Войдя в комнату, игрок покидает лобби, а
+// с ним и группу.
+const (
+	MaxPartySize    = 4
+	PartyCodeLength = 6
+	partyCodeChars  = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // Без похожих 0/O и 1/I
+)
+
+var errNoLobbyName = errors.New("enter a name to use parties")
+
+// lobbyClient - подключение, которое еще не вошло в комнату. Поля, кроме
+// conn, защищены lobby.mu.
+type lobbyClient struct {
+	conn    net.Conn
+	writeMu sync.Mutex // Пишут и горутина соединения, и чужие: приглашения, состав группы
+	encoder *protocol.Encoder
+
+	name       string
+	identified bool         // Прошел проверку пароля
+	party      *party       // nil - не в группе
+	ticket     *queueTicket // Место в очереди подбора, у группы - у лидера
+}
+
+func newLobbyClient(conn net.Conn) *lobbyClient {
+	return &lobbyClient{conn: conn, encoder: protocol.NewEncoder(conn)}
+}
+
+// send пишет сообщение клиенту из любой горутины
+func (c *lobbyClient) send(msgType string, data interface{}) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.encoder.Encode(msgType, data); err != nil {
+		netLog.Debug("Error writing to lobby client", "remote", c.conn.RemoteAddr().String(), "err", err)
+	}
+}
+
+type party struct {
+	code    string
+	members []*lobbyClient // Первый - лидер
+}
+
+// lobby - представившиеся клиенты и их группы
+type lobby struct {
+	mu      sync.Mutex
+	clients map[string]*lobbyClient // По имени, для приглашений
+	parties map[string]*party       // По коду
+}
+
+func newLobby() *lobby {
+	return &lobby{
+		clients: make(map[string]*lobbyClient),
+		parties: make(map[string]*party),
+	}
+}
+
+// identify проверяет пароль и имя клиента из join_room-подобного запроса
+func (s *Server) identify(client *lobbyClient, req protocol.JoinRoom) error {
+	identity, err := s.authenticate(req)
+	if err != nil {
+		return err
+	}
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	client.name = identity.Name
+	client.identified = true
+	return nil
+}
+
+// enterLobby делает клиента видимым для приглашений по имени
+func (s *Server) enterLobby(client *lobbyClient, req protocol.JoinRoom) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errNoLobbyName
+	}
+	identity, err := s.authenticate(req)
+	if err != nil {
+		return err
+	}
+	// Так же имя почистит комната, и группа узнает своих по нему
+	name := sanitizeName(identity.Name, 0)
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	if client.party != nil {
+		return errors.New("leave the party before changing the name")
+	}
+	if other, ok := s.lobby.clients[name]; ok && other != client {
+		return fmt.Errorf("name %q is already taken in the lobby", name)
+	}
+	if client.name != "" && s.lobby.clients[client.name] == client {
+		delete(s.lobby.clients, client.name)
+	}
+	client.name = name
+	client.identified = true
+	s.lobby.clients[name] = client
+	return nil
+}
+
+// leaveLobby убирает клиента из лобби, группы и очереди: он вошел в
+// комнату или отключился
+func (s *Server) leaveLobby(client *lobbyClient) {
+	s.dequeue(client)
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	s.removeFromParty(client)
+	if s.lobby.clients[client.name] == client {
+		delete(s.lobby.clients, client.name)
+	}
+}
+
+// createParty создает группу с клиентом во главе
+func (s *Server) createParty(client *lobbyClient) error {
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	if s.lobby.clients[client.name] != client {
+		return errNoLobbyName
+	}
+	if client.party != nil {
+		return errors.New("already in a party")
+	}
+	if client.ticket != nil && client.ticket.waiting() {
+		return errAlreadyQueued
+	}
+	p := &party{code: s.newPartyCode(), members: []*lobbyClient{client}}
+	s.lobby.parties[p.code] = p
+	client.party = p
+	netLog.Debug("Party created", "code", p.code, "leader", client.name)
+	s.sendParty(p)
+	return nil
+}
+
+// joinParty добавляет клиента в группу по коду
+func (s *Server) joinParty(client *lobbyClient, code string) error {
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	if s.lobby.clients[client.name] != client {
+		return errNoLobbyName
+	}
+	p, ok := s.lobby.parties[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return fmt.Errorf("party %q not found", code)
+	}
+	if client.party == p {
+		return nil
+	}
+	if client.party != nil {
+		return errors.New("leave your party first")
+	}
+	if client.ticket != nil && client.ticket.waiting() {
+		return errAlreadyQueued
+	}
+	if len(p.members) >= s.maxPartySize() {
+		return fmt.Errorf("party %s is full (%d)", p.code, s.maxPartySize())
+	}
+	// Место в очереди занято под старый состав
+	s.cancelPartyQueue(p)
+	p.members = append(p.members, client)
+	client.party = p
+	s.sendParty(p)
+	return nil
+}
+
+// leaveParty выводит клиента из группы по его просьбе
+func (s *Server) leaveParty(client *lobbyClient) {
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	if client.party != nil {
+		s.removeFromParty(client)
+		client.send(protocol.MsgParty, protocol.Party{})
+	}
+}
+
+// invite отправляет игроку из лобби приглашение в группу клиента. Без
+// группы она создается.
+func (s *Server) invite(client *lobbyClient, name string) error {
+	s.lobby.mu.Lock()
+	if s.lobby.clients[client.name] != client {
+		s.lobby.mu.Unlock()
+		return errNoLobbyName
+	}
+	hasParty := client.party != nil
+	s.lobby.mu.Unlock()
+	if !hasParty {
+		if err := s.createParty(client); err != nil {
+			return err
+		}
+	}
+
+	s.lobby.mu.Lock()
+	defer s.lobby.mu.Unlock()
+	p := client.party
+	if p == nil {
+		return errors.New("not in a party")
+	}
+	target, ok := s.lobby.clients[sanitizeName(name, 0)]
+	if !ok {
+		return fmt.Errorf("player %q is not in the lobby", name)
+	}
+	if target.party == p {
+		return fmt.Errorf("%s is already in the party", target.name)
+	}
+	target.send(protocol.MsgPartyInvite, protocol.PartyInvite{Code: p.code, From: client.name})
+	return nil
+}
+
+// removeFromParty убирает клиента из его группы. Лидерство переходит к
+// следующему, пустая группа удаляется. Вызывается под lobby.mu.
+func (s *Server) removeFromParty(client *lobbyClient) {
+	p := client.party
+	if p == nil {
+		return
+	}
+	s.cancelPartyQueue(p)
+	p.members = slices.DeleteFunc(p.members, func(m *lobbyClient) bool { return m == client })
+	client.party = nil
+	if len(p.members) == 0 {
+		delete(s.lobby.parties, p.code)
+		netLog.Debug("Party disbanded", "code", p.code)
+		return
+	}
+	s.sendParty(p)
+}
+
+// cancelPartyQueue снимает группу с очереди, если она еще ждет подбора.
+// Вызывается под lobby.mu.
+func (s *Server) cancelPartyQueue(p *party) bool {
+	leader := p.members[0]
+	if t := leader.ticket; t != nil && s.removeTicket(t) {
+		leader.ticket = nil
+		return true
+	}
+	return false
+}
+
+// sendParty рассылает состав группы ее участникам. Вызывается под lobby.mu.
+func (s *Server) sendParty(p *party) {
+	info := protocol.Party{Code: p.code, Leader: p.members[0].name}
+	for _, member := range p.members {
+		info.Members = append(info.Members, member.name)
+	}
+	if t := p.members[0].ticket; t != nil && t.waiting() {
+		info.Queued = true
+	}
+	for _, member := range p.members {
+		member.send(protocol.MsgParty, info)
+	}
+}
+
+// maxPartySize - группа должна помещаться в комнату из очереди
+func (s *Server) maxPartySize() int {
+	if s.cfg.MatchSize > 0 {
+		return min(MaxPartySize, s.cfg.MatchSize)
+	}
+	return MaxPartySize
+}
+
+// newPartyCode придумывает код, которого еще нет. Вызывается под lobby.mu.
+func (s *Server) newPartyCode() string {
+	for {
+		buf := make([]byte, PartyCodeLength)
+		rand.Read(buf)
+		for i, b := range buf {
+			buf[i] = partyCodeChars[int(b)%len(partyCodeChars)]
+		}
+		if _, ok := s.lobby.parties[string(buf)]; !ok {
+			return string(buf)
+		}
+	}
+}
+
+// expectParties запоминает, кто из будущих игроков комнаты в одной группе
+func (r *Room) expectParties(parties map[string]string) {
+	r.do(func() {
+		for name, code := range parties {
+			r.partyOf[name] = code
+		}
+	})
+}
+
+// partyTeam - команда, в которой уже играет кто-то из группы игрока, 0 -
+// никого. Вызывается в горутине комнаты.
+func (r *Room) partyTeam(player *PlayerState) int {
+	if player.Party == "" {
+		return 0
+	}
+	for _, other := range r.worldState.Players {
+		if other != player && other.Party == player.Party && other.Team != 0 {
+			return other.Team
+		}
+	}
+	return 0
+}
+
+// Ввод на экране выбора комнаты
+const (
+	partyInputNone = iota
+	partyInputInvite
+	partyInputJoin
+)
+
+// Клавиши группы на экране выбора комнаты
+const (
+	PartyKey       = ebiten.KeyP // Создать группу или выйти из нее
+	PartyInviteKey = ebiten.KeyI
+	PartyJoinKey   = ebiten.KeyJ // Войти по коду
+	PartyAcceptKey = ebiten.KeyA // Принять последнее приглашение
+)
+
+// partyBrowser - группа на экране выбора комнаты
+type partyBrowser struct {
+	info   protocol.Party
+	invite *protocol.PartyInvite
+	input  int
+	text   string
+}
+
+// sendLobby отправляет серверу запрос лобби. Вызывается в игровом цикле.
+func (g *Game) sendLobby(msgType string, data interface{}) {
+	if g.clientConn == nil {
+		return
+	}
+	if err := protocol.NewEncoder(g.clientConn).Encode(msgType, data); err != nil {
+		clientLog.Error("Error sending lobby request", "type", msgType, "err", err)
+	}
+}
+
+// enterLobby представляется серверу, чтобы можно было собрать группу.
+// Без имени группы недоступны. Вызывается в игровом цикле.
+func (g *Game) enterLobby() {
+	g.browser.party = partyBrowser{}
+	if strings.TrimSpace(g.cfg.Name) == "" {
+		return
+	}
+	g.sendLobby(protocol.MsgEnterLobby, protocol.JoinRoom{Name: g.cfg.Name, Password: g.cfg.Password, Version: protocol.Version})
+}
+
+// updateParty обрабатывает клавиши группы. true - идет ввод текста, и
+// остальные клавиши экрана не нужны. Вызывается в игровом цикле.
+func (g *Game) updateParty() bool {
+	p := &g.browser.party
+	if p.input != partyInputNone {
+		for _, c := range ebiten.AppendInputChars(nil) {
+			if len(p.text) < MaxNameLength {
+				p.text += string(c)
+			}
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) && len(p.text) > 0 {
+			p.text = p.text[:len(p.text)-1]
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			p.input = partyInputNone
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) && p.text != "" {
+			if p.input == partyInputInvite {
+				g.sendLobby(protocol.MsgInviteParty, protocol.PartyRequest{Name: p.text})
+			} else {
+				g.sendLobby(protocol.MsgJoinParty, protocol.PartyRequest{Code: p.text})
+			}
+			p.input = partyInputNone
+		}
+		return true
+	}
+
+	switch {
+	case inpututil.IsKeyJustPressed(PartyKey):
+		if p.info.Code != "" {
+			g.sendLobby(protocol.MsgLeaveParty, struct{}{})
+		} else {
+			g.sendLobby(protocol.MsgCreateParty, struct{}{})
+		}
+	case inpututil.IsKeyJustPressed(PartyInviteKey):
+		p.input, p.text = partyInputInvite, ""
+	case inpututil.IsKeyJustPressed(PartyJoinKey):
+		p.input, p.text = partyInputJoin, ""
+	case inpututil.IsKeyJustPressed(PartyAcceptKey) && p.invite != nil:
+		if p.info.Code != "" {
+			g.sendLobby(protocol.MsgLeaveParty, struct{}{})
+		}
+		g.sendLobby(protocol.MsgJoinParty, protocol.PartyRequest{Code: p.invite.Code})
+		p.invite = nil
+	}
+	return false
+}
+
+// partyChanged принимает новый состав группы. Вызывается в игровом цикле.
+func (g *Game) partyChanged(party protocol.Party) {
+	b := &g.browser
+	// Группу сняли с очереди, когда ее состав изменился
+	if b.party.info.Queued && !party.Queued {
+		b.queued = time.Time{}
+	}
+	if party.Queued && b.queued.IsZero() {
+		b.queued = time.Now()
+	}
+	b.party.info = party
+}
+
+// drawParty рисует группу, приглашение и поле ввода под списком комнат
+func (g *Game) drawParty(screen *ebiten.Image) {
+	p := g.browser.party
+	y := ScreenHeight - 100
+	switch {
+	case p.info.Code != "":
+		members := make([]string, len(p.info.Members))
+		for i, name := range p.info.Members {
+			members[i] = name
+			if name == p.info.Leader {
+				members[i] += " " + tr("(leader)")
+			}
+		}
+		drawText(screen, trf("Party %s: %s", p.info.Code, strings.Join(members, ", ")), 100, y)
+	case strings.TrimSpace(g.cfg.Name) == "":
+		drawText(screen, tr("Enter a name in the main menu to use parties"), 100, y)
+	}
+	if p.invite != nil {
+		drawText(screen, trf("%s invites you to party %s - A to accept", p.invite.From, p.invite.Code), 100, y+15)
+	}
+	switch p.input {
+	case partyInputInvite:
+		drawText(screen, trf("Invite player: %s_", p.text), 100, y+30)
+	case partyInputJoin:
+		drawText(screen, trf("Party code: %s_", p.text), 100, y+30)
+	}
+}
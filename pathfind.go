@@ -0,0 +1,241 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"time"
+)
+
+// Поиск пути ботов в обход стен: A* по сетке клеток, проходимых для круга
+// игрока. Путь запоминается и перестраивается, когда цель заметно
+// сдвинулась или путь устарел.
+const (
+	NavCellSize       = 20              // Сторона клетки сетки пути
+	NavReplanDistance = 2 * NavCellSize // На сколько должна сдвинуться цель, чтобы искать путь заново
+	NavReplanInterval = time.Second     // Путь старше этого ищется заново
+	NavMaxExpanded    = 20000           // Сколько клеток A* раскрывает, прежде чем сдаться
+)
+
+// navGrid - клетки мира, в которые не помещается игрок
+type navGrid struct {
+	cols, rows int
+	blocked    []bool
+}
+
+// newNavGrid возвращает nil, если на карте нет стен и ходить можно напрямую
+func newNavGrid(cfg Config) *navGrid {
+	if cfg.Map == nil || len(cfg.Map.Obstacles) == 0 {
+		return nil
+	}
+	obstacles := cfg.Map.Obstacles
+	n := &navGrid{
+		cols: int(math.Ceil(cfg.WorldWidth / NavCellSize)),
+		rows: int(math.Ceil(cfg.WorldHeight / NavCellSize)),
+	}
+	n.blocked = make([]bool, n.cols*n.rows)
+	for y := 0; y < n.rows; y++ {
+		for x := 0; x < n.cols; x++ {
+			center := n.center(x, y)
+			for _, obstacle := range obstacles {
+				if obstacle.expand(PlayerRadius).contains(center) {
+					n.blocked[y*n.cols+x] = true
+					break
+				}
+			}
+		}
+	}
+	return n
+}
+
+// expand возвращает стену, расширенную на radius со всех сторон
+func (o Obstacle) expand(radius float64) Obstacle {
+	return Obstacle{X: o.X - radius, Y: o.Y - radius, Width: o.Width + 2*radius, Height: o.Height + 2*radius}
+}
+
+func (n *navGrid) cell(p Point) (int, int) {
+	x := int(p.X / NavCellSize)
+	y := int(p.Y / NavCellSize)
+	return max(0, min(x, n.cols-1)), max(0, min(y, n.rows-1))
+}
+
+func (n *navGrid) center(x, y int) Point {
+	return Point{X: (float64(x) + 0.5) * NavCellSize, Y: (float64(y) + 0.5) * NavCellSize}
+}
+
+func (n *navGrid) free(x, y int) bool {
+	return x >= 0 && y >= 0 && x < n.cols && y < n.rows && !n.blocked[y*n.cols+x]
+}
+
+// nearestFree ищет ближайшую к клетке свободную: вытолкнутый из стены игрок
+// стоит на ее краю, и его клетка может оказаться занятой
+func (n *navGrid) nearestFree(x, y int) (int, int, bool) {
+	for radius := 0; radius <= 3; radius++ {
+		for dy := -radius; dy <= radius; dy++ {
+			for dx := -radius; dx <= radius; dx++ {
+				if max(abs(dx), abs(dy)) == radius && n.free(x+dx, y+dy) {
+					return x + dx, y + dy, true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// findPath возвращает центры клеток от from до to, последняя точка - сам
+// to. nil - пути нет.
+func (n *navGrid) findPath(from, to Point) []Point {
+	sx, sy, ok := n.nearestFree(n.cell(from))
+	if !ok {
+		return nil
+	}
+	gx, gy, ok := n.nearestFree(n.cell(to))
+	if !ok {
+		return nil
+	}
+	start, goal := sy*n.cols+sx, gy*n.cols+gx
+
+	heuristic := func(i int) float64 {
+		dx, dy := float64(abs(i%n.cols-gx)), float64(abs(i/n.cols-gy))
+		return math.Max(dx, dy) + (math.Sqrt2-1)*math.Min(dx, dy)
+	}
+	cost := map[int]float64{start: 0}
+	came := map[int]int{}
+	open := &navQueue{{cell: start, priority: heuristic(start)}}
+	closed := map[int]bool{}
+	for open.Len() > 0 && len(closed) < NavMaxExpanded {
+		current := heap.Pop(open).(navNode).cell
+		if current == goal {
+			return n.reconstruct(came, start, goal, to)
+		}
+		if closed[current] {
+			continue
+		}
+		closed[current] = true
+		cx, cy := current%n.cols, current/n.cols
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx == 0 && dy == 0) || !n.free(cx+dx, cy+dy) {
+					continue
+				}
+				// По диагонали мимо угла стены не срезаем
+				if dx != 0 && dy != 0 && (!n.free(cx+dx, cy) || !n.free(cx, cy+dy)) {
+					continue
+				}
+				next := (cy+dy)*n.cols + cx + dx
+				step := 1.0
+				if dx != 0 && dy != 0 {
+					step = math.Sqrt2
+				}
+				newCost := cost[current] + step
+				if old, seen := cost[next]; seen && old <= newCost {
+					continue
+				}
+				cost[next] = newCost
+				came[next] = current
+				heap.Push(open, navNode{cell: next, priority: newCost + heuristic(next)})
+			}
+		}
+	}
+	return nil
+}
+
+func (n *navGrid) reconstruct(came map[int]int, start, goal int, to Point) []Point {
+	path := []Point{to}
+	for cell := came[goal]; cell != start; cell = came[cell] {
+		path = append(path, n.center(cell%n.cols, cell/n.cols))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+type navNode struct {
+	cell     int
+	priority float64
+}
+
+// navQueue - открытый список A*, сверху клетка с наименьшей оценкой
+type navQueue []navNode
+
+func (q navQueue) Len() int            { return len(q) }
+func (q navQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q navQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *navQueue) Push(x interface{}) { *q = append(*q, x.(navNode)) }
+func (q *navQueue) Pop() interface{} {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// walkable сообщает, пройдет ли игрок по прямой из a в b. Стены
+// расширяются чуть меньше радиуса: вытолкнутый из стены игрок стоит
+// ровно на расширенной границе.
+func (r *Room) walkable(a, b Point) bool {
+	for _, obstacle := range r.obstacles() {
+		if obstacle.expand(PlayerRadius-1).blocks(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// botPath - запомненный путь бота
+type botPath struct {
+	points  []Point // Оставшиеся точки, последняя - цель
+	goal    Point   // Куда шла цель, когда путь строился
+	planned time.Time
+}
+
+// steerBot направляет бота к goal: напрямую, если стены не мешают, иначе
+// по запомненному пути. Вызывается в горутине комнаты.
+func (r *Room) steerBot(bot *Bot, player *PlayerState, goal Point, now time.Time) {
+	if r.nav == nil || r.walkable(player.Position, goal) {
+		bot.Path = nil
+		r.botMove(player, direction(player.Position, goal))
+		return
+	}
+	path := bot.Path
+	if path == nil || now.Sub(path.planned) >= NavReplanInterval ||
+		math.Hypot(goal.X-path.goal.X, goal.Y-path.goal.Y) > NavReplanDistance {
+		path = &botPath{points: r.nav.findPath(player.Position, goal), goal: goal, planned: now}
+		bot.Path = path
+		if len(path.points) == 0 {
+			r.botLog.Debug("Bot found no path", "player_id", player.ID, "goal", goal)
+		}
+	}
+	// Пути нет - ждем следующего поиска на месте
+	if len(path.points) == 0 {
+		r.botMove(player, Point{})
+		return
+	}
+	// Идем к самой дальней точке, до которой видно прямую дорогу: так путь
+	// по клеткам спрямляется
+	next := 0
+	for next+1 < len(path.points) && r.walkable(player.Position, path.points[next+1]) {
+		next++
+	}
+	path.points = path.points[next:]
+	if math.Hypot(path.points[0].X-player.Position.X, path.points[0].Y-player.Position.Y) < NavCellSize/2 && len(path.points) > 1 {
+		path.points = path.points[1:]
+	}
+	r.botMove(player, direction(player.Position, path.points[0]))
+}
+
+// direction - единичный вектор из a в b, нулевой при совпадении
+func direction(a, b Point) Point {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	dist := math.Hypot(dx, dy)
+	if dist == 0 {
+		return Point{}
+	}
+	return Point{X: dx / dist, Y: dy / dist}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"net/http"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Пауза частного матча. Ставит и снимает ее админ запросами
+//
+//	POST /admin/rooms/{room}/pause
+//	POST /admin/rooms/{room}/resume
+//
+// или хозяин игры клавишей паузы. На паузе часы комнаты стоят: таймер
+// матча, перезарядки и эффекты не идут, а первый тик после паузы получает
+// обычный deltaTime, а не всю длину паузы.
+const (
+	EventPause  = "match_paused"
+	EventResume = "match_resumed"
+)
+
+// pausableClock - часы комнаты, которые можно остановить. Время
+// симуляции отстает от настоящего на суммарную длину пауз.
+type pausableClock struct {
+	Clock
+	pausedAt time.Time     // Нулевое - часы идут
+	offset   time.Duration // Сколько длились прошлые паузы
+}
+
+func (c *pausableClock) Now() time.Time {
+	if !c.pausedAt.IsZero() {
+		return c.pausedAt.Add(-c.offset)
+	}
+	return c.Clock.Now().Add(-c.offset)
+}
+
+func (c *pausableClock) pause() {
+	c.pausedAt = c.Clock.Now()
+}
+
+func (c *pausableClock) resume() {
+	c.offset += c.Clock.Now().Sub(c.pausedAt)
+	c.pausedAt = time.Time{}
+}
+
+// setPaused ставит комнату на паузу или снимает с нее. by - кто это
+// сделал, для лога. false - комната уже в этом состоянии или остановлена.
+func (r *Room) setPaused(paused bool, by string) bool {
+	changed := false
+	r.do(func() {
+		if r.worldState.Paused == paused {
+			return
+		}
+		changed = true
+		event := EventResume
+		if paused {
+			event = EventPause
+			r.clock.pause()
+		} else {
+			r.clock.resume()
+		}
+		r.worldState.Paused = paused
+		r.logEvent(r.clock.Now(), event, map[string]interface{}{"by": by})
+		r.log.Info("Match pause", "paused", paused, "by", by)
+	})
+	return changed
+}
+
+// handlePause - POST /admin/rooms/{room}/pause и /resume
+func (s *Server) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.adminAuthorized(w, r) {
+			return
+		}
+		room, err := s.findRoom(r.PathValue("room"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if !room.setPaused(paused, "admin") {
+			http.Error(w, "already in this state", http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// togglePause ставит на паузу комнату, в которой играет клиент, или
+// снимает с нее. Работает только в тренировке и у хозяина игры.
+// Вызывается в игровом цикле.
+func (g *Game) togglePause() {
+	room := g.practice
+	if g.hosting != nil {
+		room, _ = g.hosting.findRoom(g.room)
+	}
+	if room == nil {
+		return
+	}
+	room.setPaused(!g.worldState.Paused, "host")
+}
+
+// drawPauseOverlay затемняет мир, пока комната на паузе
+func (g *Game) drawPauseOverlay(screen *ebiten.Image, hud hudLayout) {
+	if !g.worldState.Paused {
+		return
+	}
+	ebitenutil.DrawRect(screen, 0, 0, float64(hud.w), float64(hud.h), color.RGBA{0, 0, 0, 120})
+	paused := tr("PAUSED")
+	drawText(screen, paused, hud.centerText(paused), hud.h/2-20)
+	if g.practice != nil || g.hosting != nil {
+		text := trf("Press %s to resume", g.keys[InputPause])
+		drawText(screen, text, hud.centerText(text), hud.h/2)
+	}
+}
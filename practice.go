@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"meatgrinder/protocol"
+)
+
+// PracticeKey запускает тренировку с ботами без сервера
+const PracticeKey = ebiten.KeyF2
+
+const PracticeRoom = "practice"
+
+// startPractice поднимает комнату с настройками cfg внутри клиента и
+// подключается к ней через net.Pipe: комната работает так же, как на
+// сервере, но сеть не нужна. Вызывается в игровом цикле.
+func (g *Game) startPractice(cfg Config) {
+	cfg.Password = ""
+	cfg.ProfilesPath = ""
+	cfg.MinPlayers = 1
+	room := NewRoom(PracticeRoom, cfg, &idAllocator{}, nil, nil, nil)
+
+	clientSide, serverSide := net.Pipe()
+	go room.serveClient(serverSide, protocol.NewDecoder(serverSide), newRateLimiter(time.Now()), g.connect.name, "")
+	clientLog.Info("Started offline practice")
+
+	g.practice = room
+	g.clientConn = clientSide
+	g.cfg.Name = g.connect.name
+	g.connect.err = ""
+	g.resetWorld()
+	g.scene = lobbyScene{}
+	go g.clientReceive(clientSide)
+}
+
+// stopPractice останавливает комнату тренировки, если она запущена.
+// Вызывается в игровом цикле.
+func (g *Game) stopPractice() {
+	if g.practice == nil {
+		return
+	}
+	g.practice.Close()
+	g.practice = nil
+	clientLog.Info("Stopped offline practice")
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"meatgrinder/protocol"
+)
+
+// Profile - статистика игрока за все матчи, ключ - имя игрока
+type Profile struct {
+	Name          string         `json:"name"`
+	Kills         int            `json:"kills"`
+	Deaths        int            `json:"deaths"`
+	MatchesPlayed int            `json:"matches_played"`
+	Wins          int            `json:"wins"`
+	Rating        float64        `json:"rating"`
+	ClassPlays    map[string]int `json:"class_plays"` // Класс -> сыгранные матчи
+}
+
+// FavoriteClass - класс, которым сыграно больше всего матчей
+func (p *Profile) FavoriteClass() string {
+	favorite, most := "", 0
+	for class := 0; class < TotalClasses; class++ {
+		if n := p.ClassPlays[ClassNames[class]]; n > most {
+			favorite, most = ClassNames[class], n
+		}
+	}
+	return favorite
+}
+
+func (p *Profile) message() protocol.Profile {
+	return protocol.Profile{
+		Name:          p.Name,
+		Kills:         p.Kills,
+		Deaths:        p.Deaths,
+		MatchesPlayed: p.MatchesPlayed,
+		FavoriteClass: p.FavoriteClass(),
+		Rating:        int(math.Round(p.Rating)),
+	}
+}
+
+// ProfileDelta - изменение профиля, которое комната накопила с прошлого
+// сохранения. Хранилище прибавляет его к записи, а не заменяет ее, поэтому
+// две сессии под одним именем в разных комнатах не затирают друг другу
+// статистику.
+type ProfileDelta struct {
+	Kills         int
+	Deaths        int
+	MatchesPlayed int
+	Wins          int
+	Rating        float64
+	ClassPlays    map[string]int
+}
+
+func (d *ProfileDelta) empty() bool {
+	return d.Kills == 0 && d.Deaths == 0 && d.MatchesPlayed == 0 && d.Wins == 0 &&
+		d.Rating == 0 && len(d.ClassPlays) == 0
+}
+
+// add прибавляет other к d
+func (d *ProfileDelta) add(other ProfileDelta) {
+	d.Kills += other.Kills
+	d.Deaths += other.Deaths
+	d.MatchesPlayed += other.MatchesPlayed
+	d.Wins += other.Wins
+	d.Rating += other.Rating
+	for class, n := range other.ClassPlays {
+		if d.ClassPlays == nil {
+			d.ClassPlays = make(map[string]int)
+		}
+		d.ClassPlays[class] += n
+	}
+}
+
+// apply прибавляет изменение к профилю
+func (p *Profile) apply(d ProfileDelta) {
+	p.Kills += d.Kills
+	p.Deaths += d.Deaths
+	p.MatchesPlayed += d.MatchesPlayed
+	p.Wins += d.Wins
+	p.Rating += d.Rating
+	for class, n := range d.ClassPlays {
+		if p.ClassPlays == nil {
+			p.ClassPlays = make(map[string]int)
+		}
+		p.ClassPlays[class] += n
+	}
+}
+
+// ProfileStore хранит профили между запусками сервера
+type ProfileStore interface {
+	// Load возвращает профиль игрока или новый пустой, если его еще нет
+	Load(name string) (*Profile, error)
+	// Update прибавляет delta к профилю игрока. Запись может пройти позже,
+	// в фоне: комната не ждет диска.
+	Update(name string, delta ProfileDelta) error
+	// All возвращает все сохраненные профили, для таблицы лидеров
+	All() ([]Profile, error)
+	// Close дописывает отложенные изменения и закрывает хранилище
+	Close() error
+}
+
+// Сколько изменений профилей может ждать записи. Когда очередь полна,
+// Update ждет места.
+const ProfileWriteQueue = 1024
+
+var (
+	profilesBucket = []byte("profiles")
+
+	errProfilesClosed = errors.New("profile store is closed")
+)
+
+// profileUpdate - изменение одного профиля в очереди записи
+type profileUpdate struct {
+	name  string
+	delta ProfileDelta
+}
+
+// BoltProfileStore хранит профили в файле BoltDB, по записи на игрока.
+// Изменения пишет отдельная горутина: все, что накопилось в очереди,
+// уходит одной транзакцией.
+type BoltProfileStore struct {
+	db      *bolt.DB
+	updates chan profileUpdate
+	done    chan struct{} // Закрывается, когда очередь записана
+
+	mu     sync.RWMutex // Защищает closed и отправку в updates
+	closed bool
+}
+
+// OpenBoltProfileStore открывает базу профилей. JSON-файл с профилями от
+// прошлых версий сервера переносится в базу, а сам остается рядом с
+// расширением .json.
+func OpenBoltProfileStore(path string) (*BoltProfileStore, error) {
+	if err := importLegacyProfiles(path); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(profilesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s := &BoltProfileStore{
+		db:      db,
+		updates: make(chan profileUpdate, ProfileWriteQueue),
+		done:    make(chan struct{}),
+	}
+	go s.writeUpdates()
+	return s, nil
+}
+
+// importLegacyProfiles переносит профили, если по пути лежит JSON-файл
+// прошлых версий. База собирается в path.import и встает на место файла
+// только после записи, а файл сохраняется копией в path.json. Если перенос
+// оборвется, JSON останется на месте и перенос повторится при следующем
+// запуске.
+func importLegacyProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil
+	}
+	var profiles map[string]Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return err
+	}
+
+	tmp := path + ".import"
+	// Недописанная база от оборванного переноса
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	db, err := bolt.Open(tmp, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(profilesBucket)
+		if err != nil {
+			return err
+		}
+		for name, p := range profiles {
+			// Профили до появления рейтинга начинают с начального
+			if p.Rating == 0 {
+				p.Rating = DefaultRating
+			}
+			if err := putProfile(bucket, &p); err != nil {
+				return fmt.Errorf("profile %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.WriteFile(path+".json", data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	netLog.Info("Profiles imported from JSON", "path", path+".json", "profiles", len(profiles))
+	return nil
+}
+
+func getProfile(bucket *bolt.Bucket, name string) (*Profile, error) {
+	p := &Profile{Name: name, Rating: DefaultRating}
+	data := bucket.Get([]byte(name))
+	if data == nil {
+		return p, nil
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	return p, nil
+}
+
+func putProfile(bucket *bolt.Bucket, p *Profile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(p.Name), data)
+}
+
+func (s *BoltProfileStore) Load(name string) (*Profile, error) {
+	var p *Profile
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		p, err = getProfile(tx.Bucket(profilesBucket), name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.ClassPlays == nil {
+		p.ClassPlays = make(map[string]int)
+	}
+	return p, nil
+}
+
+func (s *BoltProfileStore) Update(name string, delta ProfileDelta) error {
+	if delta.empty() {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errProfilesClosed
+	}
+	s.updates <- profileUpdate{name: name, delta: delta}
+	return nil
+}
+
+// writeUpdates записывает изменения из очереди, пока ее не закроют
+func (s *BoltProfileStore) writeUpdates() {
+	defer close(s.done)
+	for update := range s.updates {
+		batch := []profileUpdate{update}
+	drain:
+		for len(batch) < ProfileWriteQueue {
+			select {
+			case update, ok := <-s.updates:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, update)
+			default:
+				break drain
+			}
+		}
+		if err := s.write(batch); err != nil {
+			netLog.Error("Error saving profiles", "profiles", len(batch), "err", err)
+		}
+	}
+}
+
+// write прибавляет изменения к профилям одной транзакцией
+func (s *BoltProfileStore) write(batch []profileUpdate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(profilesBucket)
+		for _, update := range batch {
+			p, err := getProfile(bucket, update.name)
+			if err != nil {
+				return err
+			}
+			p.apply(update.delta)
+			if err := putProfile(bucket, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltProfileStore) All() ([]Profile, error) {
+	var profiles []Profile
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(profilesBucket).ForEach(func(name, data []byte) error {
+			var p Profile
+			if err := json.Unmarshal(data, &p); err != nil {
+				return fmt.Errorf("profile %s: %w", name, err)
+			}
+			profiles = append(profiles, p)
+			return nil
+		})
+	})
+	return profiles, err
+}
+
+func (s *BoltProfileStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.updates)
+	s.mu.Unlock()
+	<-s.done
+	return s.db.Close()
+}
+
+// loadProfile загружает профиль вошедшего игрока. Вызывается в горутине комнаты.
+func (r *Room) loadProfile(player *PlayerState) {
+	if r.profiles == nil {
+		return
+	}
+	profile, err := r.profiles.Load(player.Name)
+	if err != nil {
+		r.log.Error("Error loading profile", "name", player.Name, "err", err)
+		return
+	}
+	r.playerProfiles[player.ID] = profile
+	r.profileDeltas[player.ID] = &ProfileDelta{}
+}
+
+// changeProfile меняет загруженный профиль игрока и запоминает изменение
+// до следующего saveProfile. Вызывается в горутине комнаты.
+func (r *Room) changeProfile(playerID int, delta ProfileDelta) {
+	profile, ok := r.playerProfiles[playerID]
+	if !ok {
+		return
+	}
+	profile.apply(delta)
+	r.profileDeltas[playerID].add(delta)
+}
+
+// saveProfile отдает хранилищу изменения профиля игрока с прошлого
+// сохранения. Сама запись идет в фоне. Вызывается в горутине комнаты.
+func (r *Room) saveProfile(playerID int) {
+	profile, ok := r.playerProfiles[playerID]
+	if !ok {
+		return
+	}
+	delta := r.profileDeltas[playerID]
+	if err := r.profiles.Update(profile.Name, *delta); err != nil {
+		r.log.Error("Error saving profile", "name", profile.Name, "err", err)
+	}
+	*delta = ProfileDelta{}
+}
+
+// recordDeath учитывает смерть в профилях убийцы и жертвы. Вызывается в горутине комнаты.
+func (r *Room) recordDeath(victimID, killerID int) {
+	r.changeProfile(victimID, ProfileDelta{Deaths: 1})
+	if killerID != victimID {
+		r.changeProfile(killerID, ProfileDelta{Kills: 1})
+	}
+}
+
+// recordMatchPlayed засчитывает сыгранный матч всем игрокам с профилем,
+// победителям - победу, пересчитывает рейтинг и сохраняет профили.
+// Вызывается в горутине комнаты.
+func (r *Room) recordMatchPlayed() {
+	winners := r.matchWinners()
+	r.updateRatings(winners)
+	for _, id := range sortedIDs(r.playerProfiles) {
+		player, ok := r.worldState.Players[id]
+		if !ok {
+			continue
+		}
+		delta := ProfileDelta{
+			MatchesPlayed: 1,
+			ClassPlays:    map[string]int{ClassNames[player.Class]: 1},
+		}
+		if winners[id] {
+			delta.Wins = 1
+		}
+		r.changeProfile(id, delta)
+		r.saveProfile(id)
+	}
+}
+
+// sendProfile отвечает клиенту его профилем
+func (r *Room) sendProfile(client *clientConnection) {
+	var msg []byte
+	err := errors.New("room is closed")
+	r.do(func() {
+		if profile, ok := r.playerProfiles[client.playerID]; ok {
+			msg, err = protocol.Marshal(protocol.MsgProfile, profile.message())
+		} else {
+			msg, err = protocol.Marshal(protocol.MsgError, protocol.Error{Message: "profiles are disabled"})
+		}
+	})
+	if err != nil {
+		netLog.Error("Error sending profile", "err", err)
+		return
+	}
+	client.enqueue(msg)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Опыт и уровни внутри матча. С началом нового матча все снова первого уровня.
+const (
+	EventLevelUp = "level_up"
+
+	XPPerDamage = 1.0  // Опыт за единицу нанесенного урона
+	XPPerKill   = 50.0 // Опыт за убийство
+	MaxLevel    = 10
+
+	LevelDamageBonus = 0.05 // +5% урона за каждый уровень после первого
+	LevelSpeedBonus  = 0.02 // +2% скорости бега за каждый уровень после первого
+
+	LevelUpBannerTime = 1500 * time.Millisecond
+)
+
+// xpToNextLevel - сколько опыта нужно, чтобы перейти с level на следующий
+func xpToNextLevel(level int) float64 {
+	return 100 * float64(level)
+}
+
+// levelDamageMultiplier - прибавка к урону от уровня
+func levelDamageMultiplier(p *PlayerState) float64 {
+	return 1 + LevelDamageBonus*float64(max(p.Level, 1)-1)
+}
+
+// levelSpeedMultiplier - прибавка к скорости бега от уровня
+func levelSpeedMultiplier(p *PlayerState) float64 {
+	return 1 + LevelSpeedBonus*float64(max(p.Level, 1)-1)
+}
+
+// awardXP начисляет опыт и повышает уровень. Вызывается в горутине комнаты.
+func (r *Room) awardXP(player *PlayerState, xp float64, now time.Time) {
+	if xp <= 0 || player.Level >= MaxLevel {
+		return
+	}
+	player.XP += xp
+	for player.Level < MaxLevel && player.XP >= xpToNextLevel(player.Level) {
+		player.XP -= xpToNextLevel(player.Level)
+		player.Level++
+		r.logEvent(now, EventLevelUp, map[string]interface{}{
+			"player_id": player.ID,
+			"level":     player.Level,
+		})
+	}
+	if player.Level >= MaxLevel {
+		player.XP = 0
+	}
+}
+
+// drawLevel рисует уровень и полосу опыта своего игрока над полосами
+// здоровья и ресурса
+func (g *Game) drawLevel(screen *ebiten.Image, hud hudLayout) {
+	player, ok := g.worldState.Players[g.playerID]
+	if !ok {
+		return
+	}
+	const left, width, height = HUDMargin, 200, 6
+	top := float64(hud.h - 2*12 - 16 - height - 18)
+	label := trf("Level %d", player.Level)
+	fill := 1.0
+	if player.Level < MaxLevel {
+		fill = player.XP / xpToNextLevel(player.Level)
+		label += fmt.Sprintf("  %d/%d XP", int(player.XP), int(xpToNextLevel(player.Level)))
+	}
+	drawText(screen, label, left, int(top)-14)
+	drawBar(screen, left, top, width, height, fill, color.RGBA{160, 90, 255, 255})
+
+	if time.Since(g.levelUpAt) < LevelUpBannerTime {
+		text := trf("LEVEL UP! Level %d", player.Level)
+		drawText(screen, text, hud.centerText(text), hud.h/2-80)
+	}
+}
+
+// drawLevelBadge рисует уровень игрока в кружке слева от имени
+func drawLevelBadge(screen *ebiten.Image, player *PlayerState, x, y float64) {
+	ebitenutil.DrawCircle(screen, x, y, 7, color.RGBA{160, 90, 255, 220})
+	text := fmt.Sprint(player.Level)
+	drawText(screen, text, int(x)-3*len(text), int(math.Round(y))-8)
+}
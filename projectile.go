@@ -0,0 +1,163 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+
+	"meatgrinder/protocol"
+)
+
+const (
+	FireballSpeed         = 450.0 // Пикселей в секунду
+	ProjectileRadius      = 6.0
+	ProjectileRangeFactor = 1.5 // Снаряд летит дальше радиуса атаки во столько раз
+)
+
+// Projectile - летящий снаряд. Попадает в первого, кого коснется, а не
+// обязательно в того, в кого целились.
+type Projectile struct {
+	ID        int     `json:"id"`
+	OwnerID   int     `json:"owner_id"`
+	Attack    string  `json:"attack"`
+	Position  Point   `json:"position"`
+	Velocity  Point   `json:"velocity"`
+	Traveled  float64 `json:"-"`
+	MaxRange  float64 `json:"-"`
+	OwnerTeam int     `json:"-"` // По своим снаряд пролетает насквозь
+}
+
+// launchProjectile выпускает снаряд в текущую позицию цели. Вызывается в горутине комнаты.
+func (r *Room) launchProjectile(attacker, target *PlayerState, spec AttackSpec, now time.Time) {
+	aim := r.botAim(attacker, target.Position)
+	dx, dy := aim.X-attacker.Position.X, aim.Y-attacker.Position.Y
+	dist := math.Hypot(dx, dy)
+	if dist == 0 {
+		// Цель стоит вплотную: снаряду некуда лететь, попадание сразу
+		r.resolveHit(attacker, target, target.Position, 0, spec, now)
+		return
+	}
+	projectile := &Projectile{
+		ID:        r.nextProjectileID,
+		OwnerID:   attacker.ID,
+		Attack:    spec.Name,
+		Position:  attacker.Position,
+		Velocity:  Point{X: dx / dist * spec.ProjectileSpeed, Y: dy / dist * spec.ProjectileSpeed},
+		MaxRange:  spec.Range * ProjectileRangeFactor,
+		OwnerTeam: attacker.Team,
+	}
+	r.nextProjectileID++
+	r.worldState.Projectiles[projectile.ID] = projectile
+
+	r.push(protocol.MsgAttack, protocol.Attack{
+		AttackerID:   attacker.ID,
+		TargetID:     target.ID,
+		Attack:       spec.Name,
+		From:         attacker.Position.message(),
+		To:           aim.message(),
+		SplashRadius: spec.SplashRadius,
+		Projectile:   true,
+	}, attacker.ID, target.ID)
+}
+
+// updateProjectiles двигает снаряды и взрывает их о первого задетого игрока,
+// о стену, о край мира или в конце полета. Вызывается в горутине комнаты.
+func (r *Room) updateProjectiles(deltaTime float64, now time.Time) {
+	if len(r.worldState.Projectiles) == 0 {
+		return
+	}
+	r.rebuildGrid()
+	for _, id := range sortedIDs(r.worldState.Projectiles) {
+		projectile := r.worldState.Projectiles[id]
+		owner, ok := r.worldState.Players[projectile.OwnerID]
+		if !ok {
+			delete(r.worldState.Projectiles, id)
+			continue
+		}
+		from := projectile.Position
+		to := Point{X: from.X + projectile.Velocity.X*deltaTime, Y: from.Y + projectile.Velocity.Y*deltaTime}
+		step := math.Hypot(to.X-from.X, to.Y-from.Y)
+
+		// Ближайшее по пути препятствие: игрок или стена
+		hitT := math.Inf(1)
+		var hitPlayer *PlayerState
+		reach := PlayerRadius + ProjectileRadius
+		r.grid.query(to, step+reach, func(playerID int) {
+			player := r.worldState.Players[playerID]
+			if playerID == owner.ID || player.Eliminated || (projectile.OwnerTeam != 0 && player.Team == projectile.OwnerTeam) {
+				return
+			}
+			t, ok := segmentCircleHit(from, to, player.Position, reach)
+			if ok && (t < hitT || (t == hitT && playerID < hitPlayer.ID)) {
+				hitT, hitPlayer = t, player
+			}
+		})
+		for _, obstacle := range r.obstacles() {
+			if t, ok := obstacle.intersect(from, to); ok && t < hitT {
+				hitT, hitPlayer = t, nil
+			}
+		}
+
+		exploded := !math.IsInf(hitT, 1)
+		if exploded {
+			to = Point{X: from.X + (to.X-from.X)*hitT, Y: from.Y + (to.Y-from.Y)*hitT}
+		}
+		projectile.Position = to
+		projectile.Traveled += math.Hypot(to.X-from.X, to.Y-from.Y)
+		outside := to.X < 0 || to.Y < 0 || to.X > r.cfg.WorldWidth || to.Y > r.cfg.WorldHeight
+		if !exploded && !outside && projectile.Traveled < projectile.MaxRange {
+			continue
+		}
+
+		delete(r.worldState.Projectiles, id)
+		spec := balance().Attacks[owner.Class]
+		// Взрыв на игроке выдает его место
+		var about []int
+		if hitPlayer != nil {
+			about = append(about, hitPlayer.ID)
+		}
+		r.push(protocol.MsgImpact, protocol.Impact{
+			ProjectileID: projectile.ID,
+			Attack:       projectile.Attack,
+			Position:     to.message(),
+			SplashRadius: spec.SplashRadius,
+		}, about...)
+		r.resolveHit(owner, hitPlayer, to, projectile.Traveled, spec, now)
+	}
+}
+
+// segmentCircleHit возвращает долю отрезка a-b, на которой он входит в круг
+func segmentCircleHit(a, b, center Point, radius float64) (float64, bool) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	fx, fy := a.X-center.X, a.Y-center.Y
+	c := fx*fx + fy*fy - radius*radius
+	if c <= 0 {
+		return 0, true // Начало отрезка уже внутри
+	}
+	qa := dx*dx + dy*dy
+	if qa == 0 {
+		return 0, false
+	}
+	qb := 2 * (fx*dx + fy*dy)
+	disc := qb*qb - 4*qa*c
+	if disc < 0 {
+		return 0, false
+	}
+	t := (-qb - math.Sqrt(disc)) / (2 * qa)
+	return t, t >= 0 && t <= 1
+}
+
+// drawProjectiles рисует снаряды, продвигая их от последнего снимка
+func (g *Game) drawProjectiles(screen *ebiten.Image) {
+	elapsed := time.Since(g.stateReceived).Seconds()
+	for _, projectile := range g.worldState.Projectiles {
+		pos := g.camera.toScreen(Point{
+			X: projectile.Position.X + projectile.Velocity.X*elapsed,
+			Y: projectile.Position.Y + projectile.Velocity.Y*elapsed,
+		})
+		ebitenutil.DrawCircle(screen, pos.X, pos.Y, ProjectileRadius, color.RGBA{255, 160, 40, 255})
+	}
+}
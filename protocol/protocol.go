@@ -0,0 +1,559 @@
+// Package protocol описывает конверт сетевых сообщений между клиентом и сервером.
+//
+// Каждое сообщение - это JSON-объект {"message_type": ..., "data": ...},
+// по одному на строку. Поле data остается сырым (json.RawMessage) до тех пор,
+// пока получатель не узнает тип сообщения и не разберет его в нужную структуру,
+// поэтому некорректный ввод превращается в ошибку, а не в панику.
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Версия протокола растет с каждым несовместимым изменением сообщений.
+// MinVersion - самая старая версия собеседника, с которой эта сборка еще
+// умеет говорить. Клиент сообщает версию при входе (JoinRoom), сервер - в
+// init. Версия 0 - сборка, выпущенная до появления версий.
+const (
+	Version    = 2 // 2: карта приходит по имени и хешу, см. MapRef
+	MinVersion = 2
+)
+
+// Типы сообщений
+const (
+	MsgInit   = "init"   // сервер -> клиент: назначенный ID игрока
+	MsgRules  = "rules"  // сервер -> клиент: правила изменились после перезагрузки баланса
+	MsgState  = "state"  // сервер -> клиент: состояние мира
+	MsgAction = "action" // клиент -> сервер: действие игрока
+	MsgError  = "error"  // сервер -> клиент: запрос отклонен
+
+	MsgJoinRoom   = "join_room"   // клиент -> сервер: войти в существующую комнату
+	MsgCreateRoom = "create_room" // клиент -> сервер: создать комнату и войти в нее
+	MsgListRooms  = "list_rooms"  // клиент -> сервер: запросить список комнат до входа
+	MsgRoomList   = "room_list"   // сервер -> клиент: список комнат
+
+	MsgResync     = "resync"      // клиент -> сервер: прислать полный снимок вне очереди
+	MsgGetProfile = "get_profile" // клиент -> сервер: запросить свой профиль
+	MsgProfile    = "profile"     // сервер -> клиент: профиль игрока
+
+	MsgGetLeaderboard = "get_leaderboard" // клиент -> сервер: запросить таблицу лидеров до входа
+	MsgLeaderboard    = "leaderboard"     // сервер -> клиент: таблица лидеров
+
+	MsgJoinQueue      = "join_queue"      // клиент -> сервер: встать в очередь подбора матча, данные как у join_room
+	MsgLeaveQueue     = "leave_queue"     // клиент -> сервер: выйти из очереди подбора
+	MsgQueueStatus    = "queue_status"    // сервер -> клиент: игрок встал в очередь
+	MsgRoomAssignment = "room_assignment" // сервер -> клиент: матч подобран, можно входить в комнату
+
+	MsgEnterLobby  = "enter_lobby"  // клиент -> сервер: представиться до входа в комнату, данные как у join_room
+	MsgCreateParty = "create_party" // клиент -> сервер: создать группу
+	MsgJoinParty   = "join_party"   // клиент -> сервер: войти в группу по коду
+	MsgLeaveParty  = "leave_party"  // клиент -> сервер: выйти из группы
+	MsgInviteParty = "invite_party" // клиент -> сервер: пригласить в группу игрока из лобби
+	MsgParty       = "party"        // сервер -> клиент: состав группы после любого изменения
+	MsgPartyInvite = "party_invite" // сервер -> клиент: приглашение в группу
+
+	MsgPing = "ping" // сервер -> клиент: проверка связи, клиент сразу отвечает pong
+	MsgPong = "pong" // клиент -> сервер: ответ на ping
+
+	MsgAttack = "attack" // сервер -> клиент: кто-то атаковал, для эффектов
+	MsgDamage = "damage" // сервер -> клиент: игрок получил урон от атаки
+	MsgImpact = "impact" // сервер -> клиент: снаряд взорвался
+	MsgEvent  = "event"  // сервер -> клиент: игровое событие из лога комнаты
+
+	MsgMatchSummary = "match_summary" // сервер -> клиент: итоги матча при его конце
+	MsgMatchStats   = "match_stats"   // сервер -> клиент: статистика идущего матча для таблицы по Tab
+
+	MsgMapChange = "map_change" // сервер -> клиент: комната перешла на другую карту
+	MsgGetMap    = "get_map"    // клиент -> сервер: прислать карту, которой нет в кеше клиента
+	MsgMapData   = "map_data"   // сервер -> клиент: карта целиком
+	MsgVoteMap   = "vote_map"   // клиент -> сервер: голос за следующую карту на экране итогов
+
+	MsgEntityEnter = "entity_enter" // сервер -> клиент: игроки появились в радиусе обзора
+	MsgEntityLeave = "entity_leave" // сервер -> клиент: игроки пропали из радиуса обзора
+)
+
+// Message - конверт сообщения
+type Message struct {
+	Type string          `json:"message_type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Init - ответ сервера на успешный вход в комнату
+type Init struct {
+	Version    int    `json:"version"` // Версия протокола сервера
+	PlayerID   int    `json:"player_id"`
+	ServerMode bool   `json:"server_mode"`
+	Room       string `json:"room"`
+	// Размер мира комнаты
+	WorldWidth  float64 `json:"world_width"`
+	WorldHeight float64 `json:"world_height"`
+	// Карта комнаты, nil - пустой мир. Саму карту клиент берет из кеша
+	// или запрашивает get_map.
+	Map *MapRef `json:"map,omitempty"`
+	// Радиус обзора, дальше клиент рисует туман. 0 - обзор не ограничен.
+	ViewRadius float64 `json:"view_radius,omitempty"`
+	// Действующие правила. Клиент берет числа отсюда, а не из своих констант.
+	Rules Rules `json:"rules"`
+	// Секрет этого входа. Клиент предъявляет его в join_room, чтобы после
+	// перезапуска сервера получить своего игрока обратно.
+	ReconnectToken string `json:"reconnect_token,omitempty"`
+}
+
+// Rules - характеристики классов, которые нужны клиенту
+type Rules struct {
+	Classes map[int]ClassRules `json:"classes"`
+}
+
+// ClassRules - характеристики и атака одного класса
+type ClassRules struct {
+	Name          string  `json:"name"`
+	MoveSpeed     float64 `json:"move_speed"`
+	AttackSpeed   float64 `json:"attack_speed"`
+	AttackDamage  float64 `json:"attack_damage"`
+	Resource      string  `json:"resource"`
+	MaxResource   float64 `json:"max_resource"`
+	ResourceRegen float64 `json:"resource_regen"`
+	AttackCost    float64 `json:"attack_cost"`
+	SprintCost    float64 `json:"sprint_cost"`
+	Armor         float64 `json:"armor"`
+	MagicResist   float64 `json:"magic_resist"`
+
+	Attack           string  `json:"attack"`
+	AttackRange      float64 `json:"attack_range"`
+	SplashRadius     float64 `json:"splash_radius,omitempty"`
+	ProjectileSpeed  float64 `json:"projectile_speed,omitempty"`
+	DamageType       int     `json:"damage_type"`
+	ArmorPenetration float64 `json:"armor_penetration,omitempty"`
+}
+
+// Obstacle - стена карты, закрывает проход и обзор
+type Obstacle struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// MapRef - имя карты и хеш ее содержимого. По хешу клиент находит карту
+// в своем кеше.
+type MapRef struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// MapData - карта целиком, ответ на get_map
+type MapData struct {
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash,omitempty"`
+	Width     float64    `json:"width"`
+	Height    float64    `json:"height"`
+	Hazards   []Hazard   `json:"hazards,omitempty"`
+	Obstacles []Obstacle `json:"obstacles,omitempty"`
+}
+
+// MapChange - комната сменила карту между матчами
+type MapChange struct {
+	Map         *MapRef `json:"map,omitempty"`
+	WorldWidth  float64 `json:"world_width"`
+	WorldHeight float64 `json:"world_height"`
+}
+
+// MapVote - голос за следующую карту
+type MapVote struct {
+	Map string `json:"map"`
+}
+
+// Hazard - прямоугольная опасная зона карты
+type Hazard struct {
+	Type   string  `json:"type"` // "lava", "swamp" или "trap"
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// JoinRoom - данные для join_room и create_room
+type JoinRoom struct {
+	Room string `json:"room"`
+	Name string `json:"name,omitempty"` // Отображаемое имя игрока
+
+	Password string `json:"password,omitempty"` // Пароль сервера, если он задан
+	Token    string `json:"token,omitempty"`    // Токен аккаунта, пока не используется
+	Version  int    `json:"version,omitempty"`  // Версия протокола клиента
+
+	ReconnectToken string `json:"reconnect_token,omitempty"` // Из init прошлого входа, см. Init.ReconnectToken
+
+	// Размер мира для create_room, нули - как у сервера
+	WorldWidth  float64 `json:"world_width,omitempty"`
+	WorldHeight float64 `json:"world_height,omitempty"`
+}
+
+// RoomInfo - краткое описание комнаты для списка
+type RoomInfo struct {
+	Name    string `json:"name"`
+	Players int    `json:"players"`
+	Bots    int    `json:"bots"`
+	Mode    string `json:"mode"`
+	Phase   string `json:"phase"`
+	Rating  int    `json:"rating,omitempty"` // Средний рейтинг игроков с профилем
+
+	WorldWidth  float64 `json:"world_width,omitempty"`
+	WorldHeight float64 `json:"world_height,omitempty"`
+}
+
+type RoomList struct {
+	Rooms []RoomInfo `json:"rooms"`
+}
+
+// ServerInfo - запись о сервере на мастер-сервере. Сервер присылает ее
+// в каждом heartbeat, клиенты получают список таких записей.
+type ServerInfo struct {
+	Name      string `json:"name"`
+	Addr      string `json:"addr"` // host:port для подключения; пустой host - адрес, с которого пришел heartbeat
+	Players   int    `json:"players"`
+	Bots      int    `json:"bots"`
+	Rooms     int    `json:"rooms"`
+	Mode      string `json:"mode"`
+	Transport string `json:"transport"`
+	Password  bool   `json:"password,omitempty"` // Нужен пароль для входа
+}
+
+type ServerList struct {
+	Servers []ServerInfo `json:"servers"`
+}
+
+// Point - координаты в мире
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Attack - атака, которую клиент показывает эффектом
+type Attack struct {
+	AttackerID   int     `json:"attacker_id"`
+	TargetID     int     `json:"target_id"`
+	Attack       string  `json:"attack"` // Имя атаки класса, например "slash"
+	From         Point   `json:"from"`
+	To           Point   `json:"to"`
+	SplashRadius float64 `json:"splash_radius,omitempty"`
+	// Атака выпустила снаряд: он придет в состоянии мира, а попадание - в impact
+	Projectile bool `json:"projectile,omitempty"`
+}
+
+// Impact - снаряд попал в игрока, в стену или долетел до конца
+type Impact struct {
+	ProjectileID int     `json:"projectile_id"`
+	Attack       string  `json:"attack"`
+	Position     Point   `json:"position"`
+	SplashRadius float64 `json:"splash_radius,omitempty"`
+}
+
+// Damage - урон, полученный игроком
+type Damage struct {
+	AttackerID int     `json:"attacker_id"`
+	TargetID   int     `json:"target_id"`
+	Amount     float64 `json:"amount"`
+	Position   Point   `json:"position"`
+	Splash     bool    `json:"splash,omitempty"`
+	Crit       bool    `json:"crit,omitempty"`
+}
+
+// EntityVisibility - игроки, которые появились в обзоре или пропали из него
+type EntityVisibility struct {
+	IDs []int `json:"ids"`
+}
+
+// Event - событие из лога комнаты. Data зависит от типа события и
+// совпадает с записью в логе.
+type Event struct {
+	Tick uint64          `json:"tick"`
+	Type string          `json:"event"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Profile - статистика игрока за все время
+type Profile struct {
+	Name          string `json:"name"`
+	Kills         int    `json:"kills"`
+	Deaths        int    `json:"deaths"`
+	MatchesPlayed int    `json:"matches_played"`
+	FavoriteClass string `json:"favorite_class"`
+	Rating        int    `json:"rating"`
+}
+
+// Коды ошибок, на которые клиент реагирует по-особому
+const (
+	ErrCodePasswordRequired = "password_required"
+	ErrCodeBadPassword      = "bad_password"
+	ErrCodeVersionMismatch  = "version_mismatch"
+	ErrCodeInvalidMessage   = "invalid_message"
+)
+
+// Ping - проверка связи. Клиент возвращает его в pong как есть, и сервер
+// по Sent узнает задержку.
+type Ping struct {
+	Sent int64 `json:"sent"` // Время отправки по часам сервера, UnixNano
+}
+
+// Resync - запрос полного снимка и его причина, для лога сервера
+type Resync struct {
+	Reason string `json:"reason,omitempty"`
+	Tick   uint64 `json:"tick,omitempty"` // Шаг последнего принятого снимка
+}
+
+// QueueStatus - ответ на вход в очередь подбора
+type QueueStatus struct {
+	Queued    int `json:"queued"`     // Игроков в очереди вместе с этим
+	MatchSize int `json:"match_size"` // Сколько игроков собирается в одну комнату
+	Rating    int `json:"rating"`     // Рейтинг, по которому игрока подбирают
+}
+
+// RoomAssignment - комната, созданная для подобранной группы. Клиент входит
+// в нее обычным join_room.
+type RoomAssignment struct {
+	Room    string `json:"room"`
+	Players int    `json:"players"` // Размер группы
+	Rating  int    `json:"rating"`  // Средний рейтинг группы
+}
+
+// PartyRequest - данные join_party (Code) и invite_party (Name)
+type PartyRequest struct {
+	Code string `json:"code,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Party - группа игрока. Пустой Code - игрок не в группе.
+type Party struct {
+	Code    string   `json:"code"`
+	Leader  string   `json:"leader,omitempty"`
+	Members []string `json:"members,omitempty"` // Первый - лидер
+	Queued  bool     `json:"queued,omitempty"`  // Лидер поставил группу в очередь подбора
+}
+
+// PartyInvite - приглашение: войти можно join_party с этим кодом
+type PartyInvite struct {
+	Code string `json:"code"`
+	From string `json:"from"`
+}
+
+// Error - отказ сервера. Code пустой у ошибок, которые клиенту достаточно показать.
+type Error struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+
+	// Для version_mismatch: какие версии нужны и где взять новый клиент
+	Version    int    `json:"version,omitempty"`     // Версия протокола отказавшего
+	MinVersion int    `json:"min_version,omitempty"` // Самая старая версия, которую он примет
+	Download   string `json:"download,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CheckVersion проверяет, что собеседник с версией протокола peer
+// понятен этой сборке. Ошибка - *Error с кодом version_mismatch.
+func CheckVersion(peer int) error {
+	if peer >= MinVersion {
+		return nil
+	}
+	return &Error{
+		Code:       ErrCodeVersionMismatch,
+		Message:    fmt.Sprintf("protocol version %d is too old, version %d or newer is required", peer, MinVersion),
+		Version:    Version,
+		MinVersion: MinVersion,
+	}
+}
+
+// AuthFailed сообщает, что отказ связан с паролем
+func (e *Error) AuthFailed() bool {
+	return e.Code == ErrCodePasswordRequired || e.Code == ErrCodeBadPassword
+}
+
+var (
+	// ErrMalformed - сообщение не разобралось. Поток при этом цел, и
+	// следующее сообщение можно читать.
+	ErrMalformed = errors.New("malformed message")
+	ErrEmptyData = fmt.Errorf("%w: empty message data", ErrMalformed)
+	// ErrMessageTooLarge - строка длиннее MaxMessageSize. Где она кончается,
+	// неизвестно, поэтому поток дальше читать нельзя.
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// Decode разбирает данные сообщения в v
+func (m Message) Decode(v interface{}) error {
+	if len(m.Data) == 0 || string(m.Data) == "null" {
+		return fmt.Errorf("%s: %w", m.Type, ErrEmptyData)
+	}
+	if err := json.Unmarshal(m.Data, v); err != nil {
+		return fmt.Errorf("%s: %w: %w", m.Type, ErrMalformed, err)
+	}
+	return nil
+}
+
+// Marshal сериализует сообщение целиком, включая завершающий перевод строки
+func Marshal(msgType string, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(Message{Type: msgType, Data: raw})
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Encoder пишет сообщения в поток
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) Encode(msgType string, data interface{}) error {
+	b, err := Marshal(msgType, data)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+// Самое длинное сообщение, которое примет Decoder. С запасом вмещает
+// полный снимок мира.
+const MaxMessageSize = 1 << 20
+
+// Decoder читает сообщения из потока построчно
+type Decoder struct {
+	r    *bufio.Reader
+	line []byte
+	read int64 // Байт в прочитанных строках
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Next возвращает следующее сообщение, пустые строки пропускаются. Ошибка
+// с ErrMalformed касается только одной строки, после нее можно читать
+// дальше. Остальные ошибки фатальны для потока.
+func (d *Decoder) Next() (Message, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return Message{}, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return Message{}, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		return msg, nil
+	}
+}
+
+// BytesRead - сколько байт потока уже разобрано, вместе с переводами строк
+func (d *Decoder) BytesRead() int64 {
+	return d.read
+}
+
+// readLine читает строку до перевода строки не длиннее MaxMessageSize.
+// Последняя строка потока может обойтись без перевода строки.
+func (d *Decoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		chunk, err := d.r.ReadSlice('\n')
+		d.read += int64(len(chunk))
+		if len(d.line)+len(chunk) > MaxMessageSize {
+			return nil, ErrMessageTooLarge
+		}
+		d.line = append(d.line, chunk...)
+		switch {
+		case err == nil:
+			return d.line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(d.line) > 0:
+			return d.line, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// MatchSummary - итоги закончившегося матча, приходят один раз при его
+// конце. Players отсортированы как таблица результатов.
+type MatchSummary struct {
+	Players []PlayerSummary `json:"players"`
+	MVP     int             `json:"mvp"` // ID лучшего игрока матча, 0 - никого
+}
+
+// PlayerSummary - статистика игрока за матч
+type PlayerSummary struct {
+	PlayerID    int     `json:"player_id"`
+	Name        string  `json:"name"`
+	Class       int     `json:"class"`
+	Bot         bool    `json:"bot"`
+	Score       float64 `json:"score"`
+	Kills       int     `json:"kills"`
+	Deaths      int     `json:"deaths"`
+	DamageDealt float64 `json:"damage_dealt"`
+	DamageTaken float64 `json:"damage_taken"`
+	Healing     float64 `json:"healing"`  // Сколько здоровья восстановил себе
+	Attacks     int     `json:"attacks"`  // Сколько атак начато
+	Hits        int     `json:"hits"`     // Сколько из них попало в основную цель
+	Accuracy    float64 `json:"accuracy"` // Hits/Attacks, 0..1
+}
+
+// MatchStats - сводка статистики идущего матча. Приходит раз в несколько
+// рассылок, пока идет бой, и содержит всех игроков комнаты, даже вне обзора.
+type MatchStats struct {
+	Players []PlayerStats `json:"players"`
+}
+
+// PlayerStats - строка сводки. Короткие имена полей: сводка приходит
+// часто, урон округлен до целых.
+type PlayerStats struct {
+	PlayerID int    `json:"id"`
+	Name     string `json:"n"`
+	Team     int    `json:"t,omitempty"`
+	Bot      bool   `json:"b,omitempty"`
+	Kills    int    `json:"k"`
+	Deaths   int    `json:"d"`
+	Dealt    int    `json:"dd"`
+	Taken    int    `json:"dt"`
+	Healing  int    `json:"h"`
+	Attacks  int    `json:"a"`
+	Hits     int    `json:"hi"`
+}
+
+// Leaderboard - лучшие игроки сервера за все матчи по трем показателям
+type Leaderboard struct {
+	TopKills []LeaderboardEntry `json:"top_kills"`
+	BestKD   []LeaderboardEntry `json:"best_kd"`
+	WinRate  []LeaderboardEntry `json:"win_rate"`
+	Rating   []LeaderboardEntry `json:"rating"`
+}
+
+// LeaderboardEntry - строка таблицы лидеров
+type LeaderboardEntry struct {
+	Name          string  `json:"name"`
+	Kills         int     `json:"kills"`
+	Deaths        int     `json:"deaths"`
+	Wins          int     `json:"wins"`
+	MatchesPlayed int     `json:"matches_played"`
+	KD            float64 `json:"kd"`       // Убийства на смерть, без смертей - просто убийства
+	WinRate       float64 `json:"win_rate"` // Доля выигранных матчей, 0..1
+	Rating        int     `json:"rating"`
+}
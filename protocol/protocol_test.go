@@ -0,0 +1,183 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// Строка, которую Decoder должен прочитать после любой испорченной
+const sentinel = `{"message_type":"ping","data":{"sent":42}}`
+
+// FuzzDecoder кормит декодер произвольным потоком. Декодер не должен
+// паниковать, а после испорченной строки поток должен читаться дальше.
+func FuzzDecoder(f *testing.F) {
+	for _, seed := range []string{
+		sentinel,
+		`{"message_type":"action","data":{"action_type":"move","direction":{"x":1,"y":0}}}`,
+		`{"message_type":"join_room","data":{"room":"main","name":"Vasya","version":2}}`,
+		`{"message_type":"action","data":null}`,
+		`{"message_type":"action"}`,
+		`{"message_type":1,"data":"x"}`,
+		`{"message_type":"action","data":{"action_type":`,
+		"\n\n   \n",
+		"not json",
+		"[]",
+		"\x00\xff\xfe",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Переводы строки внутри data разделили бы ее на несколько сообщений
+		line := bytes.ReplaceAll(data, []byte("\n"), []byte(" "))
+		stream := append(append(line, '\n'), sentinel+"\n"...)
+		d := NewDecoder(bytes.NewReader(stream))
+		if len(line)+1 > MaxMessageSize {
+			// Конец такой строки неизвестен, дальше поток не читается
+			if _, err := d.Next(); !errors.Is(err, ErrMessageTooLarge) {
+				t.Fatalf("Next: %v, want ErrMessageTooLarge", err)
+			}
+			return
+		}
+		// Пустые строки декодер пропускает
+		if len(bytes.TrimSpace(line)) > 0 {
+			msg, err := d.Next()
+			switch {
+			case err == nil:
+				var v interface{}
+				if err := msg.Decode(&v); err != nil && !errors.Is(err, ErrMalformed) {
+					t.Fatalf("Decode: %v, want nil or ErrMalformed", err)
+				}
+			case !errors.Is(err, ErrMalformed):
+				t.Fatalf("Next: %v, want a message or ErrMalformed", err)
+			}
+		}
+		msg, err := d.Next()
+		if err != nil {
+			t.Fatalf("stream not readable after the fuzzed line: %v", err)
+		}
+		checkSentinel(t, msg)
+	})
+}
+
+func checkSentinel(t *testing.T, msg Message) {
+	t.Helper()
+	var ping Ping
+	if msg.Type != MsgPing || msg.Decode(&ping) != nil || ping.Sent != 42 {
+		t.Fatalf("got %q %s instead of the sentinel", msg.Type, msg.Data)
+	}
+}
+
+func TestDecoderMalformedKeepsStream(t *testing.T) {
+	stream := "garbage\n" + `{"message_type":"action","data":` + "\n" + sentinel + "\n"
+	d := NewDecoder(bytes.NewReader([]byte(stream)))
+	for i := 0; i < 2; i++ {
+		if _, err := d.Next(); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("line %d: %v, want ErrMalformed", i+1, err)
+		}
+	}
+	msg, err := d.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSentinel(t, msg)
+	if _, err := d.Next(); err != io.EOF {
+		t.Fatalf("end of stream: %v, want io.EOF", err)
+	}
+}
+
+func TestMessageDecodeEmptyData(t *testing.T) {
+	for _, data := range []string{"", "null"} {
+		var ping Ping
+		err := Message{Type: MsgPong, Data: []byte(data)}.Decode(&ping)
+		if !errors.Is(err, ErrEmptyData) || !errors.Is(err, ErrMalformed) {
+			t.Errorf("data %q: %v, want ErrEmptyData", data, err)
+		}
+	}
+}
+
+// FuzzDecoderStream читает произвольный поток до конца. Каждая ошибка
+// должна быть ErrMalformed (читаем дальше), ErrMessageTooLarge или концом
+// потока, а сообщений не может быть больше, чем строк.
+func FuzzDecoderStream(f *testing.F) {
+	f.Add([]byte(sentinel + "\n" + sentinel))
+	f.Add([]byte("{}\n{\n}\n\n" + sentinel + "\r\n"))
+	f.Add([]byte(`{"message_type":"action","data":{}}` + "\n garbage \n\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(bytes.NewReader(data))
+		lines := bytes.Count(data, []byte("\n")) + 1
+		for i := 0; ; i++ {
+			if i > lines {
+				t.Fatalf("%d messages from %d lines", i, lines)
+			}
+			_, err := d.Next()
+			if err == nil || errors.Is(err, ErrMalformed) {
+				continue
+			}
+			if err != io.EOF && !errors.Is(err, ErrMessageTooLarge) {
+				t.Fatalf("Next: %v", err)
+			}
+			break
+		}
+		if d.BytesRead() > int64(len(data)) {
+			t.Fatalf("read %d bytes of %d", d.BytesRead(), len(data))
+		}
+	})
+}
+
+func TestDecoderMessageTooLarge(t *testing.T) {
+	// Строка ровно в MaxMessageSize вместе с переводом строки еще проходит
+	fits := sentinel + string(bytes.Repeat([]byte(" "), MaxMessageSize-len(sentinel)-1)) + "\n"
+	msg, err := NewDecoder(bytes.NewReader([]byte(fits))).Next()
+	if err != nil {
+		t.Fatalf("message of MaxMessageSize: %v", err)
+	}
+	checkSentinel(t, msg)
+
+	tooLarge := " " + fits
+	if _, err := NewDecoder(bytes.NewReader([]byte(tooLarge))).Next(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("message over MaxMessageSize: %v, want ErrMessageTooLarge", err)
+	}
+
+	// Бесконечная строка без перевода строки не читается в память целиком
+	src := &endless{}
+	if _, err := NewDecoder(src).Next(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("endless line: %v, want ErrMessageTooLarge", err)
+	}
+	if src.read > 2*MaxMessageSize {
+		t.Fatalf("read %d bytes of an endless line", src.read)
+	}
+}
+
+// endless - поток из бесконечной строки пробелов
+type endless struct {
+	read int
+}
+
+func (e *endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	e.read += len(p)
+	return len(p), nil
+}
+
+func TestDecoderBytesRead(t *testing.T) {
+	stream := "\n" + sentinel + "\n  \n" + sentinel
+	d := NewDecoder(bytes.NewReader([]byte(stream)))
+	// Пустые строки перед сообщением тоже разобраны
+	if _, err := d.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.BytesRead(), int64(len(sentinel)+2); got != want {
+		t.Errorf("after the first message: %d, want %d", got, want)
+	}
+	// Последняя строка без перевода строки
+	if _, err := d.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.BytesRead(); got != int64(len(stream)) {
+		t.Errorf("after the last message: %d, want %d", got, len(stream))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "time"
+
+const (
+	MessageRate  = 60.0  // Сообщений в секунду в среднем
+	MessageBurst = 120.0 // Сколько можно прислать разом
+	// Столько отброшенных сообщений за AbuseWindow - и клиент отключается
+	MaxDroppedMessages = 300
+	AbuseWindow        = 10 * time.Second
+
+	EventRateLimited = "rate_limited"
+)
+
+// rateLimiter - token bucket на одно соединение
+type rateLimiter struct {
+	tokens      float64
+	last        time.Time
+	dropped     int
+	windowStart time.Time
+}
+
+func newRateLimiter(now time.Time) *rateLimiter {
+	return &rateLimiter{tokens: MessageBurst, last: now, windowStart: now}
+}
+
+// allow решает, обработать ли очередное сообщение. Второе значение true,
+// если клиент флудит настолько, что его пора отключить.
+func (l *rateLimiter) allow(now time.Time) (bool, bool) {
+	l.tokens += now.Sub(l.last).Seconds() * MessageRate
+	if l.tokens > MessageBurst {
+		l.tokens = MessageBurst
+	}
+	l.last = now
+
+	if now.Sub(l.windowStart) >= AbuseWindow {
+		l.windowStart = now
+		l.dropped = 0
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, false
+	}
+	l.dropped++
+	return false, l.dropped >= MaxDroppedMessages
+}
+
+// firstDrop сообщает, что последнее отброшенное сообщение - первое в окне
+func (l *rateLimiter) firstDrop() bool {
+	return l.dropped == 1
+}
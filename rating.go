@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Рейтинг игрока по Эло хранится в профиле и пересчитывается в конце
+// каждого матча. По нему сервер делит игроков на равные команды, а в
+// списке комнат отдает средний рейтинг - для будущего подбора матчей.
+const (
+	DefaultRating = 1500.0 // Рейтинг нового игрока
+	RatingK       = 32.0   // Насколько сильно один матч меняет рейтинг
+	RatingScale   = 400.0  // Разница рейтингов, при которой сильный выигрывает в 10 раз чаще
+)
+
+// expectedScore - ожидаемый результат игрока с рейтингом a против b: 1 -
+// верная победа, 0 - верное поражение
+func expectedScore(a, b float64) float64 {
+	return 1 / (1 + math.Pow(10, (b-a)/RatingScale))
+}
+
+// playerRating - рейтинг игрока комнаты. У ботов и игроков без профиля
+// рейтинг начальный. Вызывается в горутине комнаты.
+func (r *Room) playerRating(id int) float64 {
+	if profile, ok := r.playerProfiles[id]; ok {
+		return profile.Rating
+	}
+	return DefaultRating
+}
+
+// updateRatings пересчитывает рейтинги игроков с профилем по итогам матча.
+// В командном режиме каждый играет против средней по рейтингу команды
+// соперников, иначе - против каждого участника по месту в таблице
+// результатов. Боты участвуют как соперники с начальным рейтингом, но их
+// рейтинг не хранится. Вызывается в горутине комнаты.
+func (r *Room) updateRatings(winners map[int]bool) {
+	results := r.worldState.Match.Results
+	if len(results) < 2 {
+		return
+	}
+	ratings := make(map[int]float64, len(results))
+	for _, result := range results {
+		ratings[result.PlayerID] = r.playerRating(result.PlayerID)
+	}
+
+	deltas := make(map[int]float64, len(results))
+	if teams := r.teamRatings(ratings); len(teams) == 2 {
+		draw := len(winners) == 0
+		for _, result := range results {
+			player, ok := r.worldState.Players[result.PlayerID]
+			if !ok || player.Team == 0 {
+				continue
+			}
+			opponents := teams[TeamRed]
+			if player.Team == TeamRed {
+				opponents = teams[TeamBlue]
+			}
+			score := 0.0
+			switch {
+			case draw:
+				score = 0.5
+			case winners[player.ID]:
+				score = 1
+			}
+			deltas[player.ID] = RatingK * (score - expectedScore(ratings[player.ID], opponents))
+		}
+	} else {
+		// Каждая пара участников - отдельная встреча, выше в таблице -
+		// победа. K делится на число соперников, чтобы размер комнаты не
+		// раскачивал рейтинг.
+		k := RatingK / float64(len(results)-1)
+		for i, a := range results {
+			for j, b := range results {
+				if i == j {
+					continue
+				}
+				score := 0.5
+				if a.Score != b.Score {
+					score = 0
+					if i < j {
+						score = 1
+					}
+				}
+				deltas[a.PlayerID] += k * (score - expectedScore(ratings[a.PlayerID], ratings[b.PlayerID]))
+			}
+		}
+	}
+
+	for id, delta := range deltas {
+		if profile, ok := r.playerProfiles[id]; ok {
+			r.changeProfile(id, ProfileDelta{Rating: delta})
+			r.log.Debug("Rating updated", "name", profile.Name, "rating", math.Round(profile.Rating), "delta", math.Round(delta))
+		}
+	}
+}
+
+// teamRatings - средний рейтинг каждой команды. Пусто, если режим не
+// командный.
+func (r *Room) teamRatings(ratings map[int]float64) map[int]float64 {
+	sums := make(map[int]float64)
+	sizes := make(map[int]int)
+	for id, rating := range ratings {
+		if player, ok := r.worldState.Players[id]; ok && player.Team != 0 {
+			sums[player.Team] += rating
+			sizes[player.Team]++
+		}
+	}
+	for team := range sums {
+		sums[team] /= float64(sizes[team])
+	}
+	return sums
+}
+
+// balanceTeams делит игроков на две команды с близкой суммой рейтинга.
+// Группа из очереди подбора не разделяется: она распределяется целиком,
+// как один игрок с суммой рейтингов. Сначала большие группы, затем по
+// убыванию рейтинга каждый идет в более слабую команду, пока в ней есть
+// место. Без групп размеры команд отличаются не больше чем на одного.
+// Вызывается в горутине комнаты.
+func (r *Room) balanceTeams() {
+	type unit struct {
+		ids    []int
+		rating float64
+	}
+	var units []*unit
+	parties := make(map[string]*unit)
+	for _, id := range sortedIDs(r.worldState.Players) {
+		code := r.worldState.Players[id].Party
+		u, ok := parties[code]
+		if !ok || code == "" {
+			u = &unit{}
+			units = append(units, u)
+			if code != "" {
+				parties[code] = u
+			}
+		}
+		u.ids = append(u.ids, id)
+		u.rating += r.playerRating(id)
+	}
+	sort.SliceStable(units, func(i, j int) bool {
+		if len(units[i].ids) != len(units[j].ids) {
+			return len(units[i].ids) > len(units[j].ids)
+		}
+		return units[i].rating > units[j].rating
+	})
+
+	limit := (len(r.worldState.Players) + 1) / 2
+	sums := make(map[int]float64)
+	sizes := make(map[int]int)
+	for _, u := range units {
+		weak, strong := TeamRed, TeamBlue
+		if sums[TeamBlue] < sums[TeamRed] {
+			weak, strong = TeamBlue, TeamRed
+		}
+		team := weak
+		if sizes[weak]+len(u.ids) > limit && sizes[strong]+len(u.ids) <= limit {
+			team = strong
+		}
+		for _, id := range u.ids {
+			r.worldState.Players[id].Team = team
+		}
+		sums[team] += u.rating
+		sizes[team] += len(u.ids)
+	}
+}
+
+// averageRating - средний рейтинг подключенных игроков с профилем, 0 -
+// таких нет. Вызывается в горутине комнаты.
+func (r *Room) averageRating() int {
+	sum, count := 0.0, 0
+	for id := range r.playerConnections {
+		if profile, ok := r.playerProfiles[id]; ok {
+			sum += profile.Rating
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return int(math.Round(sum / float64(count)))
+}
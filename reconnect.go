@@ -0,0 +1,117 @@
+package main
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Переподключение посреди матча. Если соединение с сервером оборвалось во
+// время игры, клиент не уходит в главное меню, а RestoreReconnectWindow
+// пытается подключиться заново и войти в ту же комнату с токеном из init.
+// Сервер, перезапущенный с -state-file, вернет игроку его персонажа.
+const (
+	ReconnectDelay = 2 * time.Second
+	ReconnectKey   = ebiten.KeyBackspace // Отменить переподключение
+)
+
+// reconnecting - попытки переподключения, общие для копий сцены
+type reconnecting struct {
+	address string
+	room    string
+	until   time.Time // Когда перестать пытаться
+	next    time.Time // Следующая попытка
+	dialing bool
+	err     error // Почему оборвалось или не удалась последняя попытка
+}
+
+// reconnectScene - ожидание переподключения поверх последнего кадра мира
+type reconnectScene struct {
+	*reconnecting
+}
+
+// startReconnect переходит к переподключению, если оборвалась игра на
+// сервере. false - переподключаться некуда. Вызывается в игровом цикле.
+func (g *Game) startReconnect(err error) bool {
+	if g.practice != nil || g.hosting != nil || g.editor.testing || g.room == "" {
+		return false
+	}
+	switch g.scene.(type) {
+	case playScene, deadScene, resultsScene:
+	default:
+		return false
+	}
+	clientLog.Warn("Connection lost, reconnecting", "room", g.room, "err", err)
+	now := time.Now()
+	g.scene = reconnectScene{&reconnecting{
+		address: g.serverAddr,
+		room:    g.room,
+		until:   now.Add(RestoreReconnectWindow),
+		next:    now,
+		err:     err,
+	}}
+	return true
+}
+
+func (s reconnectScene) Update(g *Game) {
+	if inpututil.IsKeyJustPressed(ReconnectKey) {
+		g.giveUpReconnect(s.reconnecting)
+		return
+	}
+	now := time.Now()
+	if s.dialing || now.Before(s.next) {
+		return
+	}
+	if now.After(s.until) {
+		g.giveUpReconnect(s.reconnecting)
+		return
+	}
+	s.dialing = true
+	go g.redial(s.reconnecting)
+}
+
+// redial делает одну попытку в фоне и, если она удалась, входит в комнату
+func (g *Game) redial(rc *reconnecting) {
+	conn, err := dialTransport(g.cfg.Transport, rc.address)
+	g.post(func() {
+		rc.dialing = false
+		rc.next = time.Now().Add(ReconnectDelay)
+		// Переподключение успели отменить
+		if s, ok := g.scene.(reconnectScene); !ok || s.reconnecting != rc {
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			clientLog.Debug("Reconnect attempt failed", "addr", rc.address, "err", err)
+			rc.err = err
+			return
+		}
+		clientLog.Info("Reconnected", "addr", rc.address, "room", rc.room)
+		g.clientConn = conn
+		g.resetWorld()
+		g.scene = lobbyScene{}
+		g.joinRoom(rc.room, false)
+		go g.clientReceive(conn)
+	})
+}
+
+// giveUpReconnect возвращает в главное меню. Вызывается в игровом цикле.
+func (g *Game) giveUpReconnect(rc *reconnecting) {
+	g.scene = menuScene{}
+	g.connect.err = trf("Disconnected: %v", rc.err)
+	g.resetWorld()
+}
+
+func (s reconnectScene) Draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+	ebitenutil.DrawRect(screen, 0, 0, ScreenWidth, ScreenHeight, color.RGBA{0, 0, 0, 160})
+	left := max(0, int(time.Until(s.until).Seconds()))
+	drawCentered(screen, trf("Connection lost, reconnecting to %s...", s.address), ScreenHeight/2-20)
+	drawCentered(screen, trf("Giving up in %d s", left), ScreenHeight/2)
+	drawCentered(screen, tr("Backspace - cancel"), ScreenHeight/2+20)
+}
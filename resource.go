@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Ресурсы классов
+const (
+	ResourceMana    = "mana"    // Маг тратит на атаки
+	ResourceStamina = "stamina" // Воин тратит на рывок
+)
+
+var ResourceColors = map[string]color.RGBA{
+	ResourceMana:    {60, 120, 255, 255},
+	ResourceStamina: {230, 200, 40, 255},
+}
+
+// maxResource - запас ресурса класса
+func maxResource(p *PlayerState) float64 {
+	return balance().Classes[p.Class].MaxResource
+}
+
+// updateResources списывает ресурс за рывок, а у остальных игроков
+// восстанавливает его. Вызывается в горутине комнаты.
+func (r *Room) updateResources(deltaTime float64) {
+	for _, player := range r.worldState.Players {
+		stats := balance().Classes[player.Class]
+		if sprintActive(player) {
+			player.Resource = math.Max(0, player.Resource-stats.SprintCost*deltaTime)
+			continue
+		}
+		player.Resource = math.Min(stats.MaxResource, player.Resource+stats.ResourceRegen*deltaTime)
+	}
+}
+
+// spendResource списывает amount, если ресурса хватает
+func spendResource(p *PlayerState, amount float64) bool {
+	if p.Resource < amount {
+		return false
+	}
+	p.Resource -= amount
+	return true
+}
+
+// drawResourceBars рисует здоровье и ресурс своего игрока в левом нижнем углу
+func (g *Game) drawResourceBars(screen *ebiten.Image, hud hudLayout) {
+	player, ok := g.worldState.Players[g.playerID]
+	if !ok {
+		return
+	}
+	const left, width, height = HUDMargin, 200, 12
+	top := float64(hud.h - 2*height - 16)
+	g.drawHealthBar(screen, player, left, top, width, height, time.Now())
+	drawText(screen, trf("HP %d", int(player.Health)), left+4, int(top)-2)
+
+	stats, ok := g.rules.Classes[player.Class]
+	if !ok || stats.MaxResource <= 0 {
+		return
+	}
+	top += height + 4
+	drawBar(screen, left, top, width, height, player.Resource/stats.MaxResource, ResourceColors[stats.Resource])
+	drawText(screen, fmt.Sprintf("%s %d", tr(stats.Resource), int(player.Resource)), left+4, int(top)-2)
+}
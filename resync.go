@@ -0,0 +1,89 @@
+package main
+
+import (
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// Клиент просит полный снимок, если отстал или видит в состоянии то, чего
+// быть не может. Запросы не чаще ResyncCooldown.
+const (
+	ResyncMissedStates = 30 // Пропущенных снимков подряд, после которых клиент считает себя отставшим
+	ResyncCooldown     = 2 * time.Second
+)
+
+// sendSnapshot отправляет клиенту текущее состояние. Обзор сбрасывается,
+// поэтому все видимые игроки заново приходят в entity_enter.
+// Снимок идет через горутину рассылки, чтобы не обогнать уже снятые.
+func (r *Room) sendSnapshot(client *clientConnection) {
+	r.do(func() {
+		snapshot, err := r.snapshotState()
+		if err != nil {
+			r.log.Error("Error encoding state", "err", err)
+			return
+		}
+		client.visible = nil
+		r.rebuildGrid()
+		frame := stateFrame{client: client, resync: true}
+		frame.view, frame.visibility = r.visibleView(client)
+		r.queueBroadcast(broadcastJob{snapshot: snapshot, frames: []stateFrame{frame}})
+	})
+}
+
+// countMissedStates считает снимки, пропавшие перед state. Снимок, перед
+// которым ничего не пропало, обнуляет счет: редкие потери на плохой сети
+// не копятся до resync. Пропущенные сервером из-за предела трафика
+// (skipped) не потеряны. Вызывается в игровом цикле до замены worldState.
+func (g *Game) countMissedStates(state WorldState) {
+	if g.worldState.Seq == 0 {
+		return
+	}
+	if gap := state.Seq - g.worldState.Seq - 1; gap > state.Skipped {
+		g.missedStates += gap - state.Skipped
+	} else {
+		g.missedStates = 0
+	}
+}
+
+// resyncReason проверяет только что принятый снимок. Пустая строка - все в
+// порядке. Вызывается в игровом цикле.
+func (g *Game) resyncReason(state WorldState) string {
+	if g.missedStates >= ResyncMissedStates {
+		return "missed states"
+	}
+	self, ok := state.Players[g.playerID]
+	if !ok {
+		return "own player missing"
+	}
+	// При ограниченном обзоре цель может быть просто не видна
+	if g.viewRadius > 0 || len(g.obstacles) > 0 {
+		return ""
+	}
+	if _, ok := state.Players[self.Target]; self.Target != 0 && !ok {
+		return "unknown target"
+	}
+	for _, minion := range state.Minions {
+		if _, ok := state.Players[minion.OwnerID]; !ok {
+			return "unknown minion owner"
+		}
+	}
+	return ""
+}
+
+// requestResync просит у сервера полный снимок. Вызывается в игровом цикле.
+func (g *Game) requestResync(reason string, now time.Time) {
+	if g.clientConn == nil || now.Sub(g.lastResync) < ResyncCooldown {
+		return
+	}
+	g.lastResync = now
+	clientLog.Info("Requesting resync", "reason", reason)
+	req := protocol.Resync{Reason: reason, Tick: g.worldState.Tick}
+	if err := protocol.NewEncoder(g.clientConn).Encode(protocol.MsgResync, req); err != nil {
+		clientLog.Error("Error requesting resync", "err", err)
+		return
+	}
+	// Снимок придет с уже виденным номером, его нельзя отбросить
+	g.worldState.Seq = 0
+	g.missedStates = 0
+}
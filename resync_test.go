@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+// До resync копятся только потери подряд, а пропущенные из-за предела
+// трафика снимки потерями не считаются
+func TestCountMissedStates(t *testing.T) {
+	g := &Game{}
+	receive := func(seq, skipped uint64) uint64 {
+		t.Helper()
+		state := WorldState{Seq: seq, Skipped: skipped}
+		g.countMissedStates(state)
+		g.worldState = state
+		return g.missedStates
+	}
+	receive(1, 0)
+	if got := receive(4, 0); got != 2 {
+		t.Fatalf("after a gap of 2: %d", got)
+	}
+	if got := receive(10, 0); got != 7 {
+		t.Fatalf("after another gap of 5: %d", got)
+	}
+	if got := receive(11, 0); got != 0 {
+		t.Fatalf("after an in-order state: %d", got)
+	}
+	if got := receive(20, 8); got != 0 {
+		t.Fatalf("after throttled states: %d", got)
+	}
+	if got := receive(25, 2); got != 2 {
+		t.Fatalf("after 2 throttled and 2 lost: %d", got)
+	}
+}
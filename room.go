@@ -0,0 +1,984 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// idAllocator выдает ID игроков, уникальные в пределах всего сервера,
+// чтобы метрики и логи разных комнат не путались
+type idAllocator struct {
+	last atomic.Int64
+}
+
+func (a *idAllocator) next() int {
+	return int(a.last.Add(1))
+}
+
+// Room - отдельная арена со своим миром, ботами, матчем и циклом тиков.
+// Состояние комнаты принадлежит горутине run: другие горутины не трогают
+// его напрямую, а передают ей команды через do.
+type Room struct {
+	name             string
+	cfg              Config
+	ids              *idAllocator
+	worldState       WorldState
+	logEntries       []LogEntry
+	lastUpdateTime   time.Time     // Время последнего шага симуляции
+	stepDuration     time.Duration // Длина шага симуляции, 1/TickRate
+	clock            *pausableClock
+	rng              *rand.Rand
+	tick             uint64
+	broadcastSeq     uint64 // Номер последней рассылки состояния
+	nextItemID       int
+	nextProjectileID int
+	nextMinionID     int
+	lastItemSpawn    time.Time
+	lastPing         time.Time // Когда клиентам последний раз ушел ping
+
+	playerConnections map[int]*clientConnection
+	bots              map[int]*Bot        // ID игрока -> бот
+	inputs            map[int]*inputQueue // ID игрока -> необработанный ввод
+	speedViolations   map[int]int         // ID игрока -> число нарушений скорости
+	profiles          ProfileStore        // nil - профили не сохраняются
+	playerProfiles    map[int]*Profile    // ID игрока -> загруженный профиль
+	partyOf           map[string]string   // Имя игрока -> код группы, присланной очередью подбора
+	mapVotes          map[int]string      // ID игрока -> карта, за которую он голосует
+	rotation          int                 // Номер текущей карты в пуле -map, см. rotateMap
+	outbox            []outboxMessage     // События тика, уходят вместе с состоянием тем, кому видны
+	grid              *spatialGrid        // Игроки по ячейкам, для поиска соседей
+	nav               *navGrid            // Клетки для поиска пути ботов, nil - стен нет
+	damage            []DamageModifier    // Конвейер расчета урона
+	mode              GameMode            // Правила матча
+	webhooks          *webhookNotifier    // nil - уведомления не отправляются
+	exporter          *eventExporter      // nil - лог не выгружается
+	log               *slog.Logger        // Лог симуляции с именем комнаты
+	botLog            *slog.Logger        // Лог решений ботов
+	firstBlood        bool                // В этом матче уже было убийство
+	ticks             *tickLog            // Журнал тиков для отладки, nil без -debug-ticks
+	seats             map[int]bool        // Восстановленные из снимка люди, ждущие своих клиентов
+	seatsExpire       time.Time           // Когда незанятые места освобождаются, см. expireSeats
+
+	// ID игрока -> изменения профиля, еще не отданные хранилищу, см. changeProfile
+	profileDeltas map[int]*ProfileDelta
+
+	created    time.Time
+	commands   chan func()       // Команды для горутины комнаты, см. do
+	broadcasts chan broadcastJob // Снимки для горутины рассылки
+	stop       chan struct{}
+	stopped    chan struct{} // Закрывается, когда run вышла
+	stopOnce   sync.Once
+}
+
+// NewRoom создает комнату и запускает ее цикл тиков
+func NewRoom(name string, cfg Config, ids *idAllocator, profiles ProfileStore, webhooks *webhookNotifier, exporter *eventExporter) *Room {
+	r := newRoom(name, cfg, ids, realClock{}, newRNG(cfg.Seed))
+	r.profiles = profiles
+	r.webhooks = webhooks
+	r.exporter = exporter
+	r.start()
+	return r
+}
+
+// start запускает фоновые горутины комнаты
+func (r *Room) start() {
+	go r.spawnBots()
+	go r.run()
+	go r.runBroadcasts()
+}
+
+// newRoom создает комнату без фоновых горутин: время и случайность задаются
+// снаружи, а тики продвигаются вызовом step. Одинаковые часы и зерно дают
+// одинаковый ход игры.
+func newRoom(name string, cfg Config, ids *idAllocator, clock Clock, rng *rand.Rand) *Room {
+	now := clock.Now()
+	stepDuration := time.Second / time.Duration(cfg.TickRate)
+	return &Room{
+		name:   name,
+		cfg:    cfg,
+		ids:    ids,
+		clock:  &pausableClock{Clock: clock},
+		rng:    rng,
+		log:    simLog.With("room", name),
+		botLog: botLog.With("room", name),
+		worldState: WorldState{
+			Players: make(map[int]*PlayerState),
+			Items:   make(map[int]*Item),
+			Match:   MatchInfo{Phase: MatchWaiting},
+
+			Projectiles: make(map[int]*Projectile),
+			Minions:     make(map[int]*Minion),
+		},
+		logEntries:        make([]LogEntry, 0),
+		lastUpdateTime:    now.Add(-stepDuration / 2), // Полшага запаса, см. step
+		stepDuration:      stepDuration,
+		nextItemID:        1,
+		nextProjectileID:  1,
+		nextMinionID:      1,
+		lastItemSpawn:     now,
+		playerConnections: make(map[int]*clientConnection),
+		bots:              make(map[int]*Bot),
+		inputs:            make(map[int]*inputQueue),
+		speedViolations:   make(map[int]int),
+		playerProfiles:    make(map[int]*Profile),
+		profileDeltas:     make(map[int]*ProfileDelta),
+		partyOf:           make(map[string]string),
+		grid:              newSpatialGrid(GridCellSize),
+		nav:               newNavGrid(cfg),
+		damage:            DefaultDamagePipeline(),
+		ticks:             newTickLog(cfg.DebugTicks),
+		mode:              newGameMode(cfg.Mode, cfg),
+		created:           now,
+		commands:          make(chan func()),
+		broadcasts:        make(chan broadcastJob, BroadcastQueueSize),
+		stop:              make(chan struct{}),
+		stopped:           make(chan struct{}),
+	}
+}
+
+// run - цикл тиков комнаты. Между тиками выполняет команды других горутин.
+func (r *Room) run() {
+	defer close(r.stopped)
+	budget := newTickBudget(r.name, r.cfg.TickRate, r.cfg.BroadcastRate, r.cfg.AdaptiveBroadcast)
+	ticker := time.NewTicker(budget.budget)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case command := <-r.commands:
+			command()
+			continue
+		case <-r.stop:
+			metrics.ForgetRoom(r.name)
+			return
+		}
+		start := time.Now()
+		fromTick := r.tick
+		r.step()
+		simulated := time.Now()
+		if budget.shouldBroadcast() {
+			r.broadcastState()
+		}
+		end := time.Now()
+		metrics.ObserveTick(end.Sub(start))
+		budget.observe(simulated.Sub(start), end.Sub(simulated), end)
+		r.recordTick(fromTick, simulated.Sub(start), end.Sub(simulated), end)
+		metrics.SetPlayers(r.name, len(r.playerConnections), len(r.bots))
+		r.watchConnections(end)
+		r.expireSeats(end)
+	}
+}
+
+// do выполняет command в горутине комнаты и ждет ее завершения. false -
+// комната уже остановлена, и command не выполнялась. Из самой горутины
+// комнаты вызывать нельзя.
+func (r *Room) do(command func()) bool {
+	done := make(chan struct{})
+	select {
+	case r.commands <- func() {
+		defer close(done)
+		command()
+	}:
+		<-done
+		return true
+	case <-r.stopped:
+		return false
+	}
+}
+
+// Close останавливает цикл тиков и отключает всех клиентов
+func (r *Room) Close() {
+	r.stopOnce.Do(func() {
+		r.do(func() {
+			for _, client := range r.playerConnections {
+				client.Close()
+			}
+		})
+		close(r.stop)
+	})
+}
+
+// info возвращает описание комнаты для списка комнат
+func (r *Room) info() protocol.RoomInfo {
+	info := protocol.RoomInfo{Name: r.name}
+	r.do(func() {
+		info.Mode = r.mode.Name()
+		info.Players = len(r.playerConnections)
+		info.Bots = len(r.bots)
+		info.Phase = r.worldState.Match.Phase
+		info.Rating = r.averageRating()
+		info.WorldWidth, info.WorldHeight = r.cfg.WorldWidth, r.cfg.WorldHeight
+	})
+	return info
+}
+
+// humanCount возвращает количество подключенных игроков вместе с теми,
+// кого комната ждет после горячего перезапуска
+func (r *Room) humanCount() int {
+	var count int
+	r.do(func() {
+		count = len(r.playerConnections) + len(r.seats)
+	})
+	return count
+}
+
+// serveClient добавляет игрока в комнату и обрабатывает его сообщения
+// до отключения. reconnectToken - из join_room, по нему игрок получает
+// свое место после перезапуска сервера, см. claimSeat.
+func (r *Room) serveClient(conn net.Conn, decoder *protocol.Decoder, limiter *rateLimiter, name, reconnectToken string) {
+	playerID, ok := r.addPlayer(name, reconnectToken)
+	if !ok {
+		conn.Close()
+		return
+	}
+	client := newClientConnection(conn, playerID, r.cfg.ClientBandwidth)
+	defer client.Close()
+	defer func() {
+		if v := recover(); v != nil {
+			netLog.Error("Panic while serving player", "player_id", playerID, "panic", v, "stack", string(debug.Stack()))
+			r.removePlayer(playerID)
+		}
+	}()
+
+	// init должен уйти раньше, чем соединение начнет получать рассылку
+	r.sendInitialState(client)
+
+	r.do(func() {
+		r.playerConnections[playerID] = client
+	})
+
+	var invalid invalidMessages
+	for {
+		msg, err := decoder.Next()
+		if err != nil && !errors.Is(err, protocol.ErrMalformed) {
+			netLog.Debug("Error decoding message", "player_id", playerID, "err", err)
+			r.removePlayer(playerID)
+			return
+		}
+		metrics.MessageReceived()
+		client.received(decoder.BytesRead())
+		client.heard(time.Now())
+		if ok, abusive := limiter.allow(time.Now()); !ok {
+			if limiter.firstDrop() {
+				r.rateLimited(playerID)
+			} else {
+				metrics.Event(EventRateLimited)
+			}
+			if abusive {
+				r.kickFlooder(playerID)
+				r.removePlayer(playerID)
+				return
+			}
+			continue
+		}
+
+		if err == nil {
+			switch msg.Type {
+			case protocol.MsgGetProfile:
+				r.sendProfile(client)
+			case protocol.MsgResync:
+				// Старые клиенты причину не присылают
+				var req protocol.Resync
+				if msg.Decode(&req) == nil {
+					r.logResync(playerID, req)
+				}
+				r.sendSnapshot(client)
+			case protocol.MsgGetMap:
+				var ref protocol.MapRef
+				if err = msg.Decode(&ref); err == nil {
+					r.sendMap(client, ref)
+				}
+			case protocol.MsgVoteMap:
+				var vote protocol.MapVote
+				if err = msg.Decode(&vote); err == nil {
+					if voteErr := r.voteMap(playerID, vote.Map); voteErr != nil {
+						netLog.Debug("Map vote rejected", "player_id", playerID, "err", voteErr)
+					}
+				}
+			case protocol.MsgPong:
+				var ping protocol.Ping
+				if err = msg.Decode(&ping); err == nil {
+					client.pong(ping.Sent, time.Now())
+				}
+			case protocol.MsgAction:
+				var action PlayerAction
+				if err = msg.Decode(&action); err == nil {
+					if err = validateAction(action); err == nil {
+						r.queueAction(playerID, action)
+					}
+				}
+			default:
+				err = fmt.Errorf("%w: unknown message type %q", protocol.ErrMalformed, msg.Type)
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		netLog.Warn("Invalid message from player", "player_id", playerID, "err", err)
+		rejection, disconnect := invalid.reject(err)
+		if reply, err := protocol.Marshal(protocol.MsgError, rejection); err == nil {
+			client.enqueue(reply)
+		}
+		if disconnect {
+			r.kickInvalid(playerID)
+			r.removePlayer(playerID)
+			return
+		}
+	}
+}
+
+// steerToDestination направляет игрока к точке, заданной кликом, и
+// останавливает его по прибытии. Вызывается в горутине комнаты.
+func (r *Room) steerToDestination(player *PlayerState, deltaTime float64) {
+	dest := *player.Destination
+	dx, dy := dest.X-player.Position.X, dest.Y-player.Position.Y
+	dist := math.Hypot(dx, dy)
+	if dist <= maxStep(player, deltaTime) {
+		player.Position = dest
+		player.Destination = nil
+		player.MovingDirection = Point{}
+		return
+	}
+	player.MovingDirection = Point{X: dx / dist, Y: dy / dist}
+}
+
+// insideWorld сообщает, лежит ли p в границах мира комнаты
+func (r *Room) insideWorld(p Point) bool {
+	return p.X >= 0 && p.Y >= 0 && p.X <= r.cfg.WorldWidth && p.Y <= r.cfg.WorldHeight
+}
+
+// randomPosition возвращает случайную точку мира комнаты
+func (r *Room) randomPosition() Point {
+	return r.randomPositionIn(0, r.cfg.WorldWidth)
+}
+
+// randomPositionIn возвращает случайную точку вне стен в полосе мира
+// from..to по X
+func (r *Room) randomPositionIn(from, to float64) Point {
+	var p Point
+	for attempt := 0; attempt < MaxSpawnAttempts; attempt++ {
+		p = Point{X: from + r.rng.Float64()*(to-from), Y: r.rng.Float64() * r.cfg.WorldHeight}
+		if !r.insideObstacle(p) {
+			break
+		}
+	}
+	return p
+}
+
+// closestEnemy возвращает ID ближайшего к from живого противника self,
+// 0 - противников нет. Вызывается в горутине комнаты.
+func (r *Room) closestEnemy(self *PlayerState, from Point) int {
+	closestDist := math.MaxFloat64
+	closestID := 0
+	for _, id := range sortedIDs(r.worldState.Players) {
+		target := r.worldState.Players[id]
+		if id == self.ID || target.Eliminated || (target.Team != 0 && target.Team == self.Team) || hiddenFrom(self, target) {
+			continue
+		}
+		if dist := math.Hypot(from.X-target.Position.X, from.Y-target.Position.Y); dist < closestDist {
+			closestDist, closestID = dist, id
+		}
+	}
+	return closestID
+}
+
+// События, которые рассылаются клиентам, остальные только пишутся в лог
+var broadcastEvents = map[string]bool{
+	EventPlayerJoined:  true,
+	EventPlayerLeft:    true,
+	EventPlayerAttack:  true,
+	EventSplashDamage:  true,
+	EventPlayerDeath:   true,
+	EventPlayerRespawn: true,
+	EventLevelUp:       true,
+	EventItemPickedUp:  true,
+	EventFlag:          true,
+	EventPause:         true,
+	EventResume:        true,
+}
+
+// logEvent добавляет запись в лог игровых событий. Вызывается в горутине комнаты.
+func (r *Room) logEvent(timestamp time.Time, eventType string, data map[string]interface{}) {
+	entry := LogEntry{
+		Tick:      r.tick,
+		Timestamp: timestamp,
+		EventType: eventType,
+		Data:      data,
+	}
+	r.logEntries = append(r.logEntries, entry)
+	r.exporter.export(r.name, entry)
+	metrics.Event(eventType)
+
+	if !broadcastEvents[eventType] {
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		r.log.Error("Error encoding event", "event", eventType, "err", err)
+		return
+	}
+	event := protocol.Event{Tick: r.tick, Type: eventType, Data: raw}
+
+	// Позиция в событии игрока выдала бы, где он стоит: тем, кто его не
+	// видит, событие уходит без нее
+	playerID, ok := data["player_id"].(int)
+	if _, hasPosition := data["position"]; !ok || !hasPosition {
+		r.push(protocol.MsgEvent, event)
+		return
+	}
+	hidden := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if key != "position" {
+			hidden[key] = value
+		}
+	}
+	if raw, err = json.Marshal(hidden); err != nil {
+		r.log.Error("Error encoding event", "event", eventType, "err", err)
+		return
+	}
+	r.pushHidden(protocol.MsgEvent, event, protocol.Event{Tick: r.tick, Type: eventType, Data: raw}, playerID)
+}
+
+// Добавим функцию для создания ботов
+func (r *Room) spawnBots() {
+	time.Sleep(2 * time.Second) // Ждем немного для подключения реальных игроков
+
+	r.do(r.addBots)
+}
+
+// addBots добавляет недостающих ботов. Вызывается в горутине комнаты.
+func (r *Room) addBots() {
+	now := r.clock.Now()
+
+	// Проверяем текущее количество ботов
+	currentBots := len(r.bots)
+	if currentBots >= MaxBots {
+		return
+	}
+
+	// Создаем только недостающее количество ботов
+	for i := 0; i < MaxBots-currentBots; i++ {
+		botID := r.ids.next()
+
+		// Случайный класс и позиция
+		playerClass := r.rng.Intn(TotalClasses)
+		pos := r.spawnPosition(nil)
+
+		r.worldState.Players[botID] = &PlayerState{
+			ID:              botID,
+			Name:            r.botName(botID),
+			Class:           playerClass,
+			Position:        pos,
+			Health:          PlayerMaxHealth,
+			Resource:        balance().Classes[playerClass].MaxResource,
+			Level:           1,
+			Target:          0,
+			LastAttackTime:  now,
+			MovingDirection: Point{X: 0, Y: 0},
+			Bot:             true,
+		}
+		r.bots[botID] = &Bot{
+			NextDecision: now,
+			Brain:        botBrains[playerClass],
+		}
+		r.botLog.Debug("Bot added", "player_id", botID, "class", ClassNames[playerClass])
+	}
+}
+
+// addPlayer добавляет игрока. false - комната уже остановлена.
+func (r *Room) addPlayer(name, reconnectToken string) (int, bool) {
+	var playerID int
+	ok := r.do(func() {
+		if id, ok := r.claimSeat(reconnectToken); ok {
+			playerID = id
+			return
+		}
+		playerID = r.spawnPlayer(name)
+	})
+	return playerID, ok
+}
+
+// spawnPlayer создает игрока и возвращает его ID. Вызывается в горутине
+// комнаты.
+func (r *Room) spawnPlayer(name string) int {
+	now := r.clock.Now()
+	playerID := r.ids.next()
+
+	// Random class
+	playerClass := r.rng.Intn(TotalClasses)
+
+	// Random position
+	pos := r.spawnPosition(nil)
+
+	name = sanitizeName(name, playerID)
+	r.worldState.Players[playerID] = &PlayerState{
+		ID:              playerID,
+		Name:            name,
+		Class:           playerClass,
+		Position:        pos,
+		Health:          PlayerMaxHealth,
+		Resource:        balance().Classes[playerClass].MaxResource,
+		Level:           1,
+		Target:          0, // No target by default
+		LastAttackTime:  now,
+		MovingDirection: Point{X: 0, Y: 0},
+		Party:           r.partyOf[name],
+		ReconnectToken:  newReconnectToken(),
+	}
+	r.loadProfile(r.worldState.Players[playerID])
+
+	r.logEvent(now, EventPlayerJoined, map[string]interface{}{
+		"player_id": playerID,
+		"name":      name,
+		"class":     ClassNames[playerClass],
+		"position":  pos,
+	})
+	r.notify(WebhookPlayerJoined, name+" joined as "+ClassNames[playerClass], map[string]interface{}{
+		"player_id": playerID,
+		"name":      name,
+		"class":     ClassNames[playerClass],
+	})
+	r.log.Info("Player joined", "player_id", playerID, "name", name, "class", ClassNames[playerClass], "position", pos)
+	return playerID
+}
+
+func (r *Room) removePlayer(playerID int) {
+	r.do(func() {
+		r.dropPlayer(playerID)
+	})
+}
+
+// dropPlayer убирает игрока из комнаты. Вызывается в горутине комнаты.
+func (r *Room) dropPlayer(playerID int) {
+	if _, ok := r.worldState.Players[playerID]; !ok {
+		return
+	}
+	r.logEvent(r.clock.Now(), EventPlayerLeft, map[string]interface{}{
+		"player_id": playerID,
+	})
+	delete(r.worldState.Players, playerID)
+	r.dismissMinions(playerID)
+	delete(r.playerConnections, playerID)
+	delete(r.inputs, playerID)
+	delete(r.speedViolations, playerID)
+	r.saveProfile(playerID)
+	delete(r.playerProfiles, playerID)
+	delete(r.profileDeltas, playerID)
+	metrics.ForgetClient(playerID)
+	r.log.Info("Player disconnected", "player_id", playerID)
+}
+
+// applyAction применяет одно действие игрока. Вызывается в горутине комнаты.
+func (r *Room) applyAction(player *PlayerState, action PlayerAction) {
+	switch action.ActionType {
+	case "move":
+		direction, ok := validateDirection(action.Direction)
+		if !ok {
+			r.reportSpeedViolation(player, "direction")
+		}
+		player.MovingDirection = direction
+		player.Destination = nil
+	case "move_to":
+		if math.IsNaN(action.Target.X) || math.IsNaN(action.Target.Y) {
+			r.reportSpeedViolation(player, "destination")
+			return
+		}
+		destination := Point{
+			X: math.Max(0, math.Min(action.Target.X, r.cfg.WorldWidth)),
+			Y: math.Max(0, math.Min(action.Target.Y, r.cfg.WorldHeight)),
+		}
+		player.Destination = &destination
+	case "attack":
+		player.Target = action.AttackTarget
+	case "cancel_attack":
+		r.clearTarget(player, TargetCancelled)
+	case "sprint":
+		// Ресурс проверяется каждый тик в updateResources
+		player.Sprinting = action.Sprint
+	case "summon":
+		if r.worldState.Match.Phase == MatchActive && !r.worldState.Paused {
+			r.summonMinions(player, r.clock.Now())
+		}
+	case "stealth":
+		if r.worldState.Match.Phase == MatchActive && !r.worldState.Paused {
+			r.stealth(player, r.clock.Now())
+		}
+	default:
+		netLog.Warn("Unknown action", "action", action.ActionType, "player_id", player.ID)
+	}
+}
+
+// step догоняет часы комнаты шагами симуляции фиксированной длины. Остаток
+// меньше шага ждет следующего вызова, поэтому ход игры не зависит от
+// дрожания тикера, а зависание процесса не телепортирует игроков. Часы
+// опережают симуляцию примерно на полшага: тикер дрожит на доли
+// миллисекунды, и без этого запаса тики шли бы то по 0, то по 2 шага.
+func (r *Room) step() {
+	// На паузе мир стоит, но ввод не копится, а снимки с флагом паузы
+	// продолжают уходить клиентам. Часы на паузе тоже стоят.
+	if r.worldState.Paused {
+		r.tick++
+		r.drainInputs()
+		return
+	}
+	now := r.clock.Now()
+	// После GC, остановки в отладчике или перегрузки не догоняем все
+	// пропущенное, а выбрасываем лишнее и делаем один шаг
+	if behind := now.Sub(r.lastUpdateTime); behind > MaxCatchUpSteps*r.stepDuration {
+		r.log.Warn("Simulation fell behind, skipping time", "behind", behind)
+		r.lastUpdateTime = now.Add(-r.stepDuration - r.stepDuration/2)
+	}
+	for now.Sub(r.lastUpdateTime) >= r.stepDuration {
+		r.lastUpdateTime = r.lastUpdateTime.Add(r.stepDuration)
+		r.tick++
+		r.updateGameState(r.tick, r.lastUpdateTime)
+	}
+}
+
+// updateGameState продвигает мир к моменту now. Все случайные решения берутся
+// из r.rng, а сущности обходятся в порядке ID, поэтому при одинаковых входных
+// данных результат одинаков. Вызывается в горутине комнаты.
+func (r *Room) updateGameState(tick uint64, now time.Time) {
+	deltaTime := r.stepDuration.Seconds()
+
+	// Боты решают первыми: их действия встают в очереди ввода и
+	// применяются вместе с действиями людей
+	r.updateBots(deltaTime, now)
+	r.drainInputs()
+	r.updateMatch(deltaTime, now)
+	combat := r.worldState.Match.Phase == MatchActive
+
+	// Эффекты состояния: длительность, горение
+	r.tickEffects(deltaTime, now)
+	r.updateResources(deltaTime)
+	if combat {
+		r.updateZone(deltaTime)
+		r.applyHazards(deltaTime)
+	}
+
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		// Выбывшие ждут следующего раунда и в мире не участвуют
+		if player.Eliminated {
+			continue
+		}
+		// Movement
+		if player.Destination != nil {
+			r.steerToDestination(player, deltaTime)
+		}
+		if player.MovingDirection.X != 0 || player.MovingDirection.Y != 0 {
+			from := player.Position
+			speed := balance().Classes[player.Class].MoveSpeed * moveSpeedMultiplier(player)
+			player.Position.X += player.MovingDirection.X * speed * deltaTime
+			player.Position.Y += player.MovingDirection.Y * speed * deltaTime
+			r.checkDisplacement(player, from, deltaTime)
+			r.clampToWorld(player)
+		}
+		// Отбрасывание не ограничивается скоростью класса, поэтому идет
+		// после проверки перемещения
+		r.updateKnockback(player, deltaTime)
+
+		// Attack: урон наносится только во время боя, в лобби можно лишь бегать
+		if targetPlayer := r.currentTarget(player); targetPlayer != nil && combat {
+			// Без маны маг не атакует, пока она не восстановится
+			if now.Sub(player.LastAttackTime).Seconds() >= 1.0/PlayerAttackSpeed &&
+				spendResource(player, balance().Classes[player.Class].AttackCost) {
+				r.performAttack(tick, player, targetPlayer, now)
+				player.LastAttackTime = now
+			}
+		}
+	}
+
+	// Снаряды летят после атак этого тика
+	if combat {
+		r.updateProjectiles(deltaTime, now)
+		r.updateMinions(deltaTime, now)
+	}
+
+	// Предметы: появление и подбор
+	if combat {
+		r.spawnItems(now)
+		r.pickupItems(now)
+	}
+
+	// Respawn dead players
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		if player.Health <= 0 && !player.Eliminated {
+			r.log.Debug("Player died", "player_id", id)
+
+			// Имена и классы в событии нужны клиентам, у которых игроки
+			// вне радиуса обзора
+			killerID := player.LastDamagedBy
+			death := map[string]interface{}{
+				"player_id": id,
+				"killer_id": killerID,
+				"position":  player.Position,
+				"name":      player.Name,
+				"class":     player.Class,
+				"bot":       player.Bot,
+			}
+			var killer *PlayerState
+			if k, ok := r.worldState.Players[killerID]; ok && killerID != id {
+				killer = k
+				killer.Kills++
+				r.awardXP(killer, XPPerKill, now)
+				death["killer_name"] = killer.Name
+				death["killer_class"] = killer.Class
+				death["killer_bot"] = killer.Bot
+				if !r.firstBlood && r.worldState.Match.Phase == MatchActive {
+					r.firstBlood = true
+					r.notify(WebhookFirstBlood, killer.Name+" drew first blood on "+player.Name, map[string]interface{}{
+						"killer_id":   killer.ID,
+						"killer_name": killer.Name,
+						"victim_id":   id,
+						"victim_name": player.Name,
+					})
+				}
+			}
+			player.Deaths++
+			player.LastDamagedBy = 0
+			r.dismissMinions(id)
+			r.forgetTarget(id)
+			r.forgetThreat(id)
+			r.recordDeath(id, killerID)
+
+			r.logEvent(now, EventPlayerDeath, death)
+
+			// Режим решает, возродится ли игрок сразу, а в овертайме
+			// без возрождений не возрождается никто
+			respawn := r.mode.OnDeath(r, player, killer, now)
+			if !respawn || r.worldState.Match.SuddenDeath == SuddenDeathNoRespawn {
+				player.Eliminated = true
+				player.Target = 0
+				player.MovingDirection = Point{}
+				player.Destination = nil
+				player.Effects = nil
+				player.Knockback = nil
+				r.log.Debug("Player eliminated", "player_id", id)
+				continue
+			}
+
+			// Respawn
+			r.resetPlayer(player)
+
+			r.logEvent(now, EventPlayerRespawn, map[string]interface{}{
+				"player_id": id,
+				"position":  player.Position,
+			})
+
+			r.log.Debug("Player respawned", "player_id", id, "position", player.Position)
+		}
+	}
+	r.updateCooldowns(now)
+}
+
+func (r *Room) performAttack(tick uint64, attacker *PlayerState, target *PlayerState, now time.Time) {
+	spec := balance().Attacks[attacker.Class]
+	attacker.Stats.Attacks++
+	r.breakStealth(attacker, now)
+	// Снаряд летит в точку, где цель стоит сейчас, и может промахнуться
+	if spec.ProjectileSpeed > 0 {
+		r.launchProjectile(attacker, target, spec, now)
+		return
+	}
+	r.push(protocol.MsgAttack, protocol.Attack{
+		AttackerID:   attacker.ID,
+		TargetID:     target.ID,
+		Attack:       spec.Name,
+		From:         attacker.Position.message(),
+		To:           target.Position.message(),
+		SplashRadius: spec.SplashRadius,
+	}, attacker.ID, target.ID)
+	dist := math.Hypot(attacker.Position.X-target.Position.X, attacker.Position.Y-target.Position.Y)
+	r.resolveHit(attacker, target, target.Position, dist, spec, now)
+}
+
+// resolveHit наносит урон основной цели и всем в радиусе взрыва вокруг
+// impact. target равен nil, если снаряд ни в кого не попал. dist - с какого
+// расстояния нанесен удар, от него зависит урон.
+func (r *Room) resolveHit(attacker, target *PlayerState, impact Point, dist float64, spec AttackSpec, now time.Time) {
+	// Урон считает конвейер модификаторов, здесь только его применение
+	damageType := spec.DamageType
+	crit := false
+	targetID := 0
+	if target != nil {
+		targetID = target.ID
+		hit := Hit{Attacker: attacker, Target: target, Spec: spec, Distance: dist}
+		finalDamage := damagePlayer(target, r.calculateDamage(&hit))
+		crit = hit.Crit
+		attacker.Stats.Hits++
+		recordDamage(attacker, target, finalDamage)
+		target.LastDamagedBy = attacker.ID
+		r.addThreat(target, attacker.ID, finalDamage)
+		r.awardXP(attacker, finalDamage*XPPerDamage, now)
+		r.applyOnHitEffect(attacker, target, spec, now)
+		// Основную цель отбрасывает от атакующего, задетых по области - от точки взрыва
+		applyKnockback(target, attacker.Position, spec.Knockback)
+
+		r.logEvent(now, EventPlayerAttack, map[string]interface{}{
+			"attacker_id": attacker.ID,
+			"target_id":   target.ID,
+			"attack":      spec.Name,
+			"damage":      finalDamage,
+			"damage_type": damageType,
+			"blocked":     hit.Blocked,
+			"crit":        hit.Crit,
+		})
+		r.log.Debug("Player attacked", "attacker_id", attacker.ID, "target_id", target.ID, "damage", finalDamage)
+		r.push(protocol.MsgDamage, protocol.Damage{
+			AttackerID: attacker.ID,
+			TargetID:   target.ID,
+			Amount:     finalDamage,
+			Position:   target.Position.message(),
+			Crit:       hit.Crit,
+		}, target.ID)
+	}
+
+	// Урон по области есть только у атак с радиусом (например, огненный шар мага)
+	if spec.SplashRadius <= 0 {
+		return
+	}
+	for _, id := range sortedIDs(r.worldState.Players) {
+		other := r.worldState.Players[id]
+		if other.ID == targetID || other.ID == attacker.ID || other.Eliminated {
+			continue
+		}
+
+		dist := math.Hypot(impact.X-other.Position.X, impact.Y-other.Position.Y)
+		if dist < spec.SplashRadius {
+			splash := Hit{Attacker: attacker, Target: other, Spec: spec, Distance: dist, Splash: true, Crit: crit}
+			splashDamage := damagePlayer(other, r.calculateDamage(&splash))
+			recordDamage(attacker, other, splashDamage)
+			other.LastDamagedBy = attacker.ID
+			r.addThreat(other, attacker.ID, splashDamage)
+			r.awardXP(attacker, splashDamage*XPPerDamage, now)
+			applyKnockback(other, impact, spec.Knockback)
+
+			r.logEvent(now, EventSplashDamage, map[string]interface{}{
+				"attacker_id":   attacker.ID,
+				"target_id":     other.ID,
+				"attack":        spec.Name,
+				"damage":        splashDamage,
+				"damage_type":   damageType,
+				"blocked":       splash.Blocked,
+				"splash_radius": spec.SplashRadius,
+			})
+			r.log.Debug("Splash damage", "attacker_id", attacker.ID, "target_id", other.ID, "damage", splashDamage)
+			r.push(protocol.MsgDamage, protocol.Damage{
+				AttackerID: attacker.ID,
+				TargetID:   other.ID,
+				Amount:     splashDamage,
+				Position:   other.Position.message(),
+				Splash:     true,
+				Crit:       splash.Crit,
+			}, other.ID)
+		}
+	}
+}
+
+// broadcastState снимает состояние и обзор игроков и передает их горутине
+// рассылки: сборка сообщений и постановка в очереди соединений идут уже
+// без симуляции.
+func (r *Room) broadcastState() {
+	r.broadcastSeq++
+	r.worldState.Tick = r.tick
+	r.worldState.Seq = r.broadcastSeq
+	r.pushMatchStats()
+
+	snapshot, err := r.snapshotState()
+	if err != nil {
+		r.log.Error("Error encoding state", "err", err)
+		return
+	}
+	if r.limitsVisibility() {
+		r.rebuildGrid()
+	}
+	job := broadcastJob{snapshot: snapshot, outbox: r.outbox, frames: make([]stateFrame, 0, len(r.playerConnections))}
+	check := desyncCheck(r.broadcastSeq)
+	for _, client := range r.playerConnections {
+		view, visibility := r.visibleView(client)
+		frame := stateFrame{client: client, view: view, visibility: visibility}
+		if check {
+			frame.checksum = r.worldState.viewChecksum(view)
+		}
+		job.frames = append(job.frames, frame)
+	}
+	r.outbox = nil
+	r.queueBroadcast(job)
+}
+
+// push ставит событие в очередь на рассылку игрокам комнаты. Если событие
+// выдает место игроков about, его получат только те, кто их видит, см.
+// visibleView. Вызывается в горутине комнаты.
+func (r *Room) push(msgType string, data interface{}, about ...int) {
+	msg, err := protocol.Marshal(msgType, data)
+	if err != nil {
+		r.log.Error("Error encoding message", "type", msgType, "err", err)
+		return
+	}
+	r.outbox = append(r.outbox, outboxMessage{data: msg, about: about})
+}
+
+// pushHidden - push, но тем, кто не видит игроков about, вместо data уходит
+// hidden
+func (r *Room) pushHidden(msgType string, data, hidden interface{}, about ...int) {
+	msg, err := protocol.Marshal(msgType, data)
+	if err != nil {
+		r.log.Error("Error encoding message", "type", msgType, "err", err)
+		return
+	}
+	hiddenMsg, err := protocol.Marshal(msgType, hidden)
+	if err != nil {
+		r.log.Error("Error encoding message", "type", msgType, "err", err)
+		return
+	}
+	r.outbox = append(r.outbox, outboxMessage{data: msg, about: about, hidden: hiddenMsg})
+}
+
+// pushRules рассылает игрокам комнаты новые правила
+func (r *Room) pushRules(rules protocol.Rules) {
+	r.do(func() {
+		r.push(protocol.MsgRules, rules)
+	})
+}
+
+func (r *Room) sendInitialState(client *clientConnection) {
+	initialState := protocol.Init{
+		Version:    protocol.Version,
+		PlayerID:   client.playerID,
+		ServerMode: true,
+		Room:       r.name,
+		ViewRadius: r.cfg.ViewRadius,
+		Rules:      balance().rules(),
+	}
+	// Карта и размер мира меняются между матчами
+	r.do(func() {
+		initialState.Map = r.mapRef()
+		initialState.WorldWidth, initialState.WorldHeight = r.cfg.WorldWidth, r.cfg.WorldHeight
+		if player, ok := r.worldState.Players[client.playerID]; ok {
+			initialState.ReconnectToken = player.ReconnectToken
+		}
+	})
+	initMsg, err := protocol.Marshal(protocol.MsgInit, initialState)
+	if err != nil {
+		r.log.Error("Error encoding init", "err", err)
+		return
+	}
+	client.enqueue(initMsg)
+	r.sendSnapshot(client)
+
+	netLog.Debug("Sent initial state", "player_id", client.playerID)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScenarioMovement(t *testing.T) {
+	s := newScenario(t, testConfig(),
+		scenarioPlayer{Name: "runner", Class: WarriorClass, Position: Point{X: 400, Y: 400}},
+		scenarioPlayer{Name: "edge", Class: MageClass, Position: Point{X: DefaultWorldWidth - 10, Y: 600}},
+	)
+	s.act("runner", PlayerAction{ActionType: "move", Direction: Point{X: 1, Y: 0}})
+	s.act("edge", PlayerAction{ActionType: "move", Direction: Point{X: 1, Y: 0}})
+	ticks := s.room.cfg.TickRate
+	s.advance(ticks)
+
+	runner := s.player("runner")
+	step := balance().Classes[WarriorClass].MoveSpeed * s.room.stepDuration.Seconds()
+	want := 400 + step*float64(ticks)
+	if math.Abs(runner.Position.X-want) > 1e-6 || runner.Position.Y != 400 {
+		t.Errorf("runner at %v, want (%g, 400)", runner.Position, want)
+	}
+	// Край мира не пускает дальше
+	if edge := s.player("edge"); edge.Position.X != DefaultWorldWidth {
+		t.Errorf("edge runner at %v, want x clamped to %d", edge.Position, DefaultWorldWidth)
+	}
+
+	// Вектор длиннее единицы не ускоряет
+	s.act("runner", PlayerAction{ActionType: "move", Direction: Point{X: 0, Y: 50}})
+	s.advance(ticks)
+	if dy := runner.Position.Y - 400; math.Abs(dy-step*float64(ticks)) > 1e-6 {
+		t.Errorf("runner moved %g with a long direction, want %g", dy, step*float64(ticks))
+	}
+}
+
+func TestScenarioAttackKillAndRespawn(t *testing.T) {
+	s := newScenario(t, testConfig(),
+		scenarioPlayer{Name: "warrior", Class: WarriorClass},
+		scenarioPlayer{Name: "mage", Class: MageClass},
+	)
+	s.startMatch()
+	warrior, mage := s.player("warrior"), s.player("mage")
+	warrior.Position = Point{X: 500, Y: 500}
+	mage.Position = Point{X: 530, Y: 500}
+	mage.Health = 5
+
+	s.act("warrior", PlayerAction{ActionType: "attack", AttackTarget: mage.ID})
+	s.advance(1)
+
+	if warrior.Kills != 1 || mage.Deaths != 1 {
+		t.Fatalf("kills %d, deaths %d after a lethal hit", warrior.Kills, mage.Deaths)
+	}
+	deaths := s.events(EventPlayerDeath)
+	if len(deaths) != 1 || deaths[0].Data["killer_id"] != warrior.ID || deaths[0].Data["player_id"] != mage.ID {
+		t.Fatalf("death events %v", deaths)
+	}
+	// В ffa погибший сразу возрождается целым и ничьей целью больше не
+	// считается
+	if mage.Health != PlayerMaxHealth || mage.Eliminated {
+		t.Errorf("mage after respawn: health %g, eliminated %v", mage.Health, mage.Eliminated)
+	}
+	if len(s.events(EventPlayerRespawn)) != 1 {
+		t.Errorf("respawn events %v", s.events(EventPlayerRespawn))
+	}
+	if warrior.Target != 0 {
+		t.Errorf("warrior still targets %d", warrior.Target)
+	}
+}
+
+func TestScenarioAttackDamage(t *testing.T) {
+	s := newScenario(t, testConfig(),
+		scenarioPlayer{Name: "warrior", Class: WarriorClass},
+		scenarioPlayer{Name: "dummy", Class: WarriorClass},
+	)
+	s.startMatch()
+	warrior, dummy := s.player("warrior"), s.player("dummy")
+	warrior.Position = Point{X: 500, Y: 500}
+	dummy.Position = Point{X: 500 + AttackRangeWarrior - 1, Y: 500}
+
+	s.act("warrior", PlayerAction{ActionType: "attack", AttackTarget: dummy.ID})
+	s.advance(1)
+
+	attacks := s.events(EventPlayerAttack)
+	if len(attacks) != 1 || attacks[0].Data["target_id"] != dummy.ID {
+		t.Fatalf("attack events %v", attacks)
+	}
+	damage := attacks[0].Data["damage"].(float64)
+	if damage <= 0 || damage >= balance().Classes[WarriorClass].AttackDamage*CritMultiplier {
+		t.Errorf("warrior dealt %g to a warrior", damage)
+	}
+	if got := PlayerMaxHealth - dummy.Health; math.Abs(got-damage) > 1e-9 {
+		t.Errorf("dummy lost %g health, the event says %g", got, damage)
+	}
+	// Броня воина режет физический урон
+	if blocked, _ := attacks[0].Data["blocked"].(float64); blocked <= 0 {
+		t.Errorf("armor blocked %g", blocked)
+	}
+}
+
+func TestScenarioMatchPhases(t *testing.T) {
+	cfg := testConfig()
+	cfg.MatchDuration = 2 * time.Second
+	cfg.SuddenDeath = SuddenDeathOff
+	s := newScenario(t, cfg)
+	if phase := s.room.worldState.Match.Phase; phase != MatchWaiting {
+		t.Fatalf("empty room in phase %s", phase)
+	}
+	s.advanceTime(time.Second)
+	if phase := s.room.worldState.Match.Phase; phase != MatchWaiting {
+		t.Fatalf("empty room went to %s", phase)
+	}
+
+	s.add(scenarioPlayer{Name: "solo", Class: MageClass, Position: Point{X: 300, Y: 300}})
+	s.advance(1)
+	if phase := s.room.worldState.Match.Phase; phase != MatchCountdown {
+		t.Fatalf("phase %s after a player joined, want countdown", phase)
+	}
+	s.advanceTime(time.Duration(CountdownDuration * float64(time.Second)))
+	if phase := s.room.worldState.Match.Phase; phase != MatchActive {
+		t.Fatalf("phase %s after the countdown, want active", phase)
+	}
+	s.advanceTime(cfg.MatchDuration)
+	if phase := s.room.worldState.Match.Phase; phase != MatchEnded {
+		t.Fatalf("phase %s after the match time, want ended", phase)
+	}
+	if results := s.room.worldState.Match.Results; len(results) != 1 || results[0].PlayerID != s.ids["solo"] {
+		t.Errorf("results %v", results)
+	}
+	s.advanceTime(time.Duration(ResultsDuration * float64(time.Second)))
+	if phase := s.room.worldState.Match.Phase; phase != MatchWaiting {
+		t.Fatalf("phase %s after the results, want waiting", phase)
+	}
+
+	var phases []string
+	for _, entry := range s.events(EventMatchPhase) {
+		phases = append(phases, entry.Data["phase"].(string))
+	}
+	want := []string{MatchCountdown, MatchActive, MatchEnded, MatchWaiting}
+	if len(phases) != len(want) {
+		t.Fatalf("phase events %v, want %v", phases, want)
+	}
+	for i := range want {
+		if phases[i] != want[i] {
+			t.Fatalf("phase events %v, want %v", phases, want)
+		}
+	}
+}
+
+// Отсчет прерывается, если игроков стало меньше -min-players
+func TestScenarioCountdownAborts(t *testing.T) {
+	cfg := testConfig()
+	cfg.MinPlayers = 2
+	s := newScenario(t, cfg,
+		scenarioPlayer{Name: "a", Class: MageClass, Position: Point{X: 300, Y: 300}},
+		scenarioPlayer{Name: "b", Class: MageClass, Position: Point{X: 900, Y: 900}},
+	)
+	s.advance(1)
+	if phase := s.room.worldState.Match.Phase; phase != MatchCountdown {
+		t.Fatalf("phase %s with two players, want countdown", phase)
+	}
+	s.room.removePlayer(s.ids["b"])
+	s.advance(1)
+	if phase := s.room.worldState.Match.Phase; phase != MatchWaiting {
+		t.Fatalf("phase %s after a player left, want waiting", phase)
+	}
+}
+
+// Овертайм без возрождений кончается, когда живые остались у одной
+// стороны: в командах - у одной команды, без команд - один игрок
+func TestOvertimeDecided(t *testing.T) {
+	r, _ := newTestRoom(testConfig())
+	r.worldState.Match.SuddenDeath = SuddenDeathNoRespawn
+	r.worldState.Players = map[int]*PlayerState{
+		1: {ID: 1, Team: 1},
+		2: {ID: 2, Team: 1},
+		3: {ID: 3, Team: 2},
+		4: {ID: 4, Team: 2},
+	}
+	if r.overtimeDecided() {
+		t.Fatal("decided with both teams alive")
+	}
+	r.worldState.Players[3].Eliminated = true
+	r.worldState.Players[4].Eliminated = true
+	if !r.overtimeDecided() {
+		t.Fatal("not decided after a team was wiped out")
+	}
+
+	for _, player := range r.worldState.Players {
+		player.Team, player.Eliminated = 0, true
+	}
+	r.worldState.Players[1].Eliminated = false
+	r.worldState.Players[2].Eliminated = false
+	if r.overtimeDecided() {
+		t.Fatal("decided with two players alive without teams")
+	}
+	r.worldState.Players[2].Eliminated = true
+	if !r.overtimeDecided() {
+		t.Fatal("not decided with one player alive")
+	}
+}
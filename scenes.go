@@ -0,0 +1,152 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Сколько показываем экран смерти
+const DeathScreenDuration = 2 * time.Second
+
+// Scene - экран клиента со своей обработкой ввода и отрисовкой. Update и
+// Draw вызываются в игровом цикле.
+type Scene interface {
+	Update(g *Game)
+	Draw(g *Game, screen *ebiten.Image)
+}
+
+// menuScene - главное меню с подключением к серверу
+type menuScene struct{}
+
+func (menuScene) Update(g *Game) {
+	if g.servers.active {
+		g.updateServerBrowser()
+		return
+	}
+	if g.leaderboard.active {
+		g.updateLeaderboard()
+		return
+	}
+	g.updateConnectMenu()
+}
+
+func (menuScene) Draw(g *Game, screen *ebiten.Image) {
+	if g.servers.active {
+		g.drawServerBrowser(screen)
+		return
+	}
+	if g.leaderboard.active {
+		g.drawLeaderboard(screen)
+		return
+	}
+	g.drawConnectMenu(screen)
+}
+
+// editorScene - редактор карт
+type editorScene struct{}
+
+func (editorScene) Update(g *Game) {
+	g.updateEditor()
+}
+
+func (editorScene) Draw(g *Game, screen *ebiten.Image) {
+	g.drawEditor(screen)
+}
+
+// lobbyScene - выбор комнаты и ожидание init от сервера
+type lobbyScene struct{}
+
+func (lobbyScene) Update(g *Game) {
+	if g.browser.active {
+		g.updateRoomBrowser()
+	}
+}
+
+func (lobbyScene) Draw(g *Game, screen *ebiten.Image) {
+	if g.browser.active {
+		g.drawRoomBrowser(screen)
+		return
+	}
+	text := tr("Joining room...")
+	drawText(screen, text, ScreenWidth/2-textWidth(text)/2, ScreenHeight/2)
+}
+
+// updateHUDKeys - общие для игровых сцен камера, громкость, журнал боя и
+// экран клавиш.
+// Вызывается в игровом цикле.
+func (g *Game) updateHUDKeys() {
+	g.updateCamera()
+	g.updateVolumeKeys()
+	g.updateCombatLog()
+	if inpututil.IsKeyJustPressed(KeyBindingsScreenKey) {
+		g.keyScreen.active = true
+	}
+	if g.keys.JustPressed(InputPause) {
+		g.togglePause()
+	}
+}
+
+// playScene - сама игра
+type playScene struct{}
+
+func (playScene) Update(g *Game) {
+	g.updateHUDKeys()
+	if g.worldState.Match.Phase == MatchEnded {
+		g.scene = resultsScene{}
+		return
+	}
+	// На паузе ввод не отправляется, изменения клавиш уйдут после нее
+	if g.worldState.Paused {
+		return
+	}
+	g.handleInput()
+}
+
+func (playScene) Draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+	g.drawHUD(screen)
+}
+
+// deadScene - экран смерти поверх мира, ввод не принимается
+type deadScene struct {
+	recap deathRecap
+	until time.Time
+}
+
+func (s deadScene) Update(g *Game) {
+	g.updateHUDKeys()
+	switch {
+	case g.worldState.Match.Phase == MatchEnded:
+		g.scene = resultsScene{}
+	case !time.Now().Before(s.until):
+		g.scene = playScene{}
+	}
+}
+
+func (s deadScene) Draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+	g.drawHUD(screen)
+	drawDeathRecap(screen, s.recap, s.until)
+}
+
+// resultsScene - таблица результатов после матча
+type resultsScene struct{}
+
+func (resultsScene) Update(g *Game) {
+	g.updateHUDKeys()
+	g.updateMapVote()
+	if g.worldState.Match.Phase != MatchEnded {
+		g.matchSummary = nil
+		g.matchStats = nil
+		g.scene = playScene{}
+	}
+}
+
+func (resultsScene) Draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+	g.drawHUD(screen)
+	g.drawMatchResults(screen)
+	g.drawMapVote(screen)
+}
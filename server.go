@@ -0,0 +1,441 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+const (
+	DefaultRoom         = "main" // Комната, которая существует всегда
+	ServerAddr          = ":8080"
+	RoomCleanupInterval = 10 * time.Second
+
+	// Границы размера мира, который можно заказать при создании комнаты
+	MinWorldSize = 400.0
+	MaxWorldSize = 8000.0
+)
+
+var roomNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,24}$`)
+
+// Server принимает подключения и распределяет игроков по комнатам
+type Server struct {
+	mu         sync.Mutex
+	cfg        Config
+	ids        idAllocator
+	rooms      map[string]*Room
+	profiles   ProfileStore
+	webhooks   *webhookNotifier
+	exporter   *eventExporter
+	auth       Authenticator
+	matchmaker *matchmaker // nil без -match-size
+	lobby      *lobby      // Подключенные, но еще не вошедшие в комнату
+	ln         net.Listener
+	done       chan struct{} // Закрывается в Close
+}
+
+func NewServer(cfg Config) *Server {
+	s := &Server{
+		cfg:   cfg,
+		rooms: make(map[string]*Room),
+		lobby: newLobby(),
+		auth:  passwordAuth{password: cfg.Password},
+		done:  make(chan struct{}),
+
+		webhooks: newWebhookNotifier(cfg.Webhooks),
+	}
+	if cfg.ProfilesPath != "" {
+		store, err := OpenBoltProfileStore(cfg.ProfilesPath)
+		if err != nil {
+			fatal(netLog, "Error opening profiles", "err", err)
+		}
+		s.profiles = store
+	}
+	if cfg.BalancePath != "" {
+		if err := s.reloadBalance(); err != nil {
+			fatal(simLog, "Error loading balance", "err", err)
+		}
+	}
+	exporter, err := newEventExporter(cfg)
+	if err != nil {
+		fatal(netLog, "Error opening event export", "err", err)
+	}
+	s.exporter = exporter
+	if cfg.CaptureDir != "" {
+		if err := os.MkdirAll(cfg.CaptureDir, 0o755); err != nil {
+			fatal(netLog, "Error creating capture directory", "err", err)
+		}
+	}
+	if cfg.MatchSize > 0 {
+		s.matchmaker = newMatchmaker(cfg.MatchSize)
+	}
+	if cfg.StateFile != "" {
+		if err := s.restoreState(); err != nil {
+			fatal(simLog, "Error restoring state", "path", cfg.StateFile, "err", err)
+		}
+	}
+	if _, ok := s.rooms[DefaultRoom]; !ok {
+		s.rooms[DefaultRoom] = NewRoom(DefaultRoom, cfg, &s.ids, s.profiles, s.webhooks, s.exporter)
+	}
+	return s
+}
+
+// --- Server Logic ---
+func (s *Server) Start() {
+	ln, err := listen(s.cfg.Transport, ServerAddr)
+	if err != nil {
+		fatal(netLog, "Error listening", "err", err)
+	}
+	if s.cfg.HTTPAddr != "" {
+		go s.serveHTTP(s.cfg.HTTPAddr)
+	}
+	if s.cfg.BalancePath != "" {
+		go s.watchBalanceReload()
+	}
+	if s.cfg.StateFile != "" {
+		go s.watchShutdown()
+	}
+	s.serve(ln)
+}
+
+// serve принимает подключения, пока ln не закроют
+func (s *Server) serve(ln net.Listener) {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	defer ln.Close()
+	netLog.Info("Server listening", "addr", ln.Addr().String(), "transport", s.cfg.Transport)
+	go s.cleanupRooms()
+	if s.matchmaker != nil {
+		go s.runMatchmaker()
+	}
+	if s.cfg.MasterURL != "" {
+		go s.registerWithMaster()
+	}
+
+	var accepted uint64
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			netLog.Error("Error accepting connection", "err", err)
+			continue
+		}
+		netLog.Debug("Accepted new client", "remote", conn.RemoteAddr().String())
+		accepted++
+		if s.cfg.CaptureDir != "" {
+			conn = s.capture(conn, accepted)
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient ждет от клиента join_room или create_room и передает
+// соединение выбранной комнате. До входа клиент может запрашивать список
+// комнат, собирать группу и ждать подбора матча в очереди.
+func (s *Server) handleClient(conn net.Conn) {
+	defer conn.Close()
+	defer recoverClient(conn)
+
+	decoder := protocol.NewDecoder(conn)
+	client := newLobbyClient(conn)
+	defer s.leaveLobby(client)
+	limiter := newRateLimiter(time.Now())
+	authFailures := 0
+	var invalid invalidMessages
+	for {
+		s.setLobbyDeadline(client)
+		msg, err := decoder.Next()
+		if err != nil && !errors.Is(err, protocol.ErrMalformed) {
+			netLog.Debug("Error decoding handshake", "remote", conn.RemoteAddr().String(), "err", err)
+			return
+		}
+		metrics.MessageReceived()
+		if ok, abusive := limiter.allow(time.Now()); !ok {
+			metrics.Event(EventRateLimited)
+			if abusive {
+				netLog.Warn("Disconnecting client: handshake flood", "remote", conn.RemoteAddr().String())
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if !rejectInvalid(client, &invalid, err) {
+				return
+			}
+			continue
+		}
+
+		var room *Room
+		var req protocol.JoinRoom
+		var identity Identity
+		switch msg.Type {
+		case protocol.MsgListRooms:
+			client.send(protocol.MsgRoomList, s.roomList())
+			continue
+		case protocol.MsgGetLeaderboard:
+			var board protocol.Leaderboard
+			if board, err = s.leaderboard(); err == nil {
+				client.send(protocol.MsgLeaderboard, board)
+				continue
+			}
+		case protocol.MsgEnterLobby:
+			if err = msg.Decode(&req); err == nil {
+				err = s.enterLobby(client, req)
+			}
+		case protocol.MsgCreateParty:
+			err = s.createParty(client)
+		case protocol.MsgJoinParty, protocol.MsgInviteParty:
+			var party protocol.PartyRequest
+			if err = msg.Decode(&party); err != nil {
+				break
+			}
+			if msg.Type == protocol.MsgJoinParty {
+				err = s.joinParty(client, party.Code)
+			} else {
+				err = s.invite(client, party.Name)
+			}
+		case protocol.MsgLeaveParty:
+			s.leaveParty(client)
+			continue
+		case protocol.MsgJoinQueue:
+			if !client.identified {
+				if err = msg.Decode(&req); err == nil {
+					err = s.identify(client, req)
+				}
+			}
+			if err == nil {
+				err = s.enqueue(client)
+			}
+		case protocol.MsgLeaveQueue:
+			s.dequeue(client)
+			continue
+		case protocol.MsgJoinRoom, protocol.MsgCreateRoom:
+			if err = msg.Decode(&req); err != nil {
+				break
+			}
+			if identity, err = s.authenticate(req); err == nil {
+				if msg.Type == protocol.MsgCreateRoom {
+					room, err = s.createRoom(req.Room, req.WorldWidth, req.WorldHeight)
+				} else {
+					room, err = s.findRoom(req.Room)
+				}
+			}
+		default:
+			err = fmt.Errorf("%w: unexpected message %q before joining a room", protocol.ErrMalformed, msg.Type)
+		}
+
+		if errors.Is(err, protocol.ErrMalformed) {
+			if !rejectInvalid(client, &invalid, err) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			netLog.Info("Rejected client", "remote", conn.RemoteAddr().String(), "err", err)
+			rejection := &protocol.Error{Message: err.Error()}
+			errors.As(err, &rejection)
+			client.send(protocol.MsgError, rejection)
+			if rejection.Code == protocol.ErrCodeVersionMismatch {
+				// Дальше клиент нас все равно не поймет
+				return
+			}
+			if rejection.AuthFailed() {
+				authFailures++
+				if authFailures >= MaxAuthFailures {
+					netLog.Warn("Disconnecting client: too many wrong passwords", "remote", conn.RemoteAddr().String())
+					return
+				}
+			}
+			continue
+		}
+		if room == nil {
+			continue
+		}
+
+		// В комнате за соединением следит watchConnections. Дедлайн снимается
+		// после выхода из лобби: снятие группы с очереди его возвращает.
+		s.leaveLobby(client)
+		conn.SetReadDeadline(time.Time{})
+		room.serveClient(conn, decoder, limiter, identity.Name, req.ReconnectToken)
+		return
+	}
+}
+
+// rejectInvalid отвечает ошибкой на сообщение, которое сервер не понял до
+// входа в комнату. false - таких сообщений слишком много, соединение пора
+// закрыть.
+func rejectInvalid(client *lobbyClient, invalid *invalidMessages, err error) bool {
+	netLog.Info("Invalid message from client", "remote", client.conn.RemoteAddr().String(), "err", err)
+	rejection, disconnect := invalid.reject(err)
+	client.send(protocol.MsgError, rejection)
+	if disconnect {
+		netLog.Warn("Disconnecting client: too many invalid messages", "remote", client.conn.RemoteAddr().String())
+		return false
+	}
+	return true
+}
+
+// recoverClient не дает панике в обработке одного клиента уронить весь
+// сервер: соединение закрывается, остальные играют дальше
+func recoverClient(conn net.Conn) {
+	if v := recover(); v != nil {
+		netLog.Error("Panic while serving client", "remote", conn.RemoteAddr().String(), "panic", v, "stack", string(debug.Stack()))
+	}
+}
+
+func (s *Server) roomList() protocol.RoomList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := protocol.RoomList{Rooms: make([]protocol.RoomInfo, 0, len(s.rooms))}
+	for _, room := range s.rooms {
+		list.Rooms = append(list.Rooms, room.info())
+	}
+	sort.Slice(list.Rooms, func(i, j int) bool {
+		return list.Rooms[i].Name < list.Rooms[j].Name
+	})
+	return list
+}
+
+func (s *Server) findRoom(name string) (*Room, error) {
+	if name == "" {
+		name = DefaultRoom
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[name]
+	if !ok {
+		return nil, fmt.Errorf("room %q not found", name)
+	}
+	return room, nil
+}
+
+func (s *Server) createRoom(name string, width, height float64) (*Room, error) {
+	if !roomNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid room name %q", name)
+	}
+	cfg, err := s.roomConfig(width, height)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[name]; ok {
+		return nil, fmt.Errorf("room %q already exists", name)
+	}
+	if len(s.rooms) >= s.cfg.MaxRooms {
+		return nil, fmt.Errorf("room limit reached (%d)", s.cfg.MaxRooms)
+	}
+	room := NewRoom(name, cfg, &s.ids, s.profiles, s.webhooks, s.exporter)
+	s.rooms[name] = room
+	netLog.Info("Room created", "room", name, "width", cfg.WorldWidth, "height", cfg.WorldHeight)
+	return room, nil
+}
+
+// roomConfig - настройки новой комнаты с миром width x height. Нули -
+// размер сервера. Карта задает размер сама, поменять его нельзя.
+func (s *Server) roomConfig(width, height float64) (Config, error) {
+	cfg := s.cfg
+	if width == 0 && height == 0 {
+		return cfg, nil
+	}
+	if cfg.Map != nil {
+		return cfg, errors.New("world size is fixed by the server map")
+	}
+	if !(width >= MinWorldSize && width <= MaxWorldSize && height >= MinWorldSize && height <= MaxWorldSize) {
+		return cfg, fmt.Errorf("world size %gx%g must be between %g and %g", width, height, MinWorldSize, MaxWorldSize)
+	}
+	cfg.WorldWidth, cfg.WorldHeight = width, height
+	return cfg, nil
+}
+
+// cleanupRooms закрывает опустевшие комнаты, кроме комнаты по умолчанию
+func (s *Server) cleanupRooms() {
+	ticker := time.NewTicker(RoomCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		s.mu.Lock()
+		for name, room := range s.rooms {
+			// Свежесозданной комнате даем время дождаться создателя
+			if name == DefaultRoom || time.Since(room.created) < RoomCleanupInterval || room.humanCount() > 0 {
+				continue
+			}
+			room.Close()
+			delete(s.rooms, name)
+			netLog.Info("Room closed", "room", name)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close перестает принимать подключения и закрывает все комнаты
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for name, room := range s.rooms {
+		room.Close()
+		delete(s.rooms, name)
+	}
+	s.exporter.Close()
+	if s.profiles != nil {
+		if err := s.profiles.Close(); err != nil {
+			netLog.Error("Error closing profiles", "err", err)
+		}
+	}
+	netLog.Info("Server stopped")
+}
+
+// adminAuthorized проверяет пароль административного запроса и отвечает
+// 403, если он неверный. Если у сервера есть пароль, он передается в
+// заголовке X-Server-Password.
+func (s *Server) adminAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.Password != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Server-Password")), []byte(s.cfg.Password)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// serveHTTP запускает HTTP-сервер с метриками для Prometheus и
+// административными запросами
+func (s *Server) serveHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/admin/reload-balance", s.handleBalanceReload)
+	mux.HandleFunc("POST /admin/rooms/{room}/pause", s.handlePause(true))
+	mux.HandleFunc("POST /admin/rooms/{room}/resume", s.handlePause(false))
+	mux.HandleFunc("GET /admin/rooms/{room}/ticks", s.handleTicks)
+	mux.HandleFunc("GET /admin/rooms/{room}/clients", s.handleClients)
+	s.registerAPI(mux)
+	netLog.Info("HTTP server listening", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		netLog.Error("HTTP server error", "err", err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// testClient - клиент поверх настоящего соединения с сервером
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	enc  *protocol.Encoder
+	dec  *protocol.Decoder
+}
+
+// startTestServer поднимает сервер на свободном порту 127.0.0.1 и
+// останавливает его в конце теста
+func startTestServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(cfg)
+	go s.serve(ln)
+	t.Cleanup(s.Close)
+	return s, ln.Addr().String()
+}
+
+func dialTestClient(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, enc: protocol.NewEncoder(conn), dec: protocol.NewDecoder(conn)}
+}
+
+func (c *testClient) send(msgType string, data interface{}) {
+	c.t.Helper()
+	if err := c.enc.Encode(msgType, data); err != nil {
+		c.t.Fatalf("send %s: %v", msgType, err)
+	}
+}
+
+// await читает сообщения, пока не придет msgType, для которого match
+// вернет true. Остальное пропускается.
+func (c *testClient) await(msgType string, match func(protocol.Message) bool) protocol.Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := c.dec.Next()
+		if errors.Is(err, protocol.ErrMalformed) {
+			continue
+		}
+		if err != nil {
+			c.t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		if msg.Type == msgType && (match == nil || match(msg)) {
+			return msg
+		}
+	}
+}
+
+// join входит в комнату по умолчанию и возвращает init
+func (c *testClient) join(name string) protocol.Init {
+	c.t.Helper()
+	c.send(protocol.MsgJoinRoom, protocol.JoinRoom{Room: DefaultRoom, Name: name, Version: protocol.Version})
+	var init protocol.Init
+	if err := c.await(protocol.MsgInit, nil).Decode(&init); err != nil {
+		c.t.Fatal(err)
+	}
+	return init
+}
+
+// state ждет снимок мира, в котором выполняется match
+func (c *testClient) state(match func(WorldState) bool) WorldState {
+	c.t.Helper()
+	var state WorldState
+	c.await(protocol.MsgState, func(msg protocol.Message) bool {
+		state = WorldState{}
+		return msg.Decode(&state) == nil && match(state)
+	})
+	return state
+}
+
+func TestServerJoinMoveAttack(t *testing.T) {
+	s, addr := startTestServer(t, testConfig())
+	attacker, victim := dialTestClient(t, addr), dialTestClient(t, addr)
+	a := attacker.join("attacker")
+	v := victim.join("victim")
+	if a.PlayerID == 0 || v.PlayerID == 0 || a.PlayerID == v.PlayerID {
+		t.Fatalf("player ids %d and %d", a.PlayerID, v.PlayerID)
+	}
+	if a.Room != DefaultRoom || a.Version != protocol.Version {
+		t.Fatalf("init %+v", a)
+	}
+
+	// Отсчет не ждем: бой начинается сразу, оба воины и стоят рядом
+	room, err := s.findRoom(DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.do(func() {
+		room.startMatch()
+		room.setMatchPhase(MatchActive, room.cfg.MatchDuration.Seconds(), room.clock.Now())
+		for id, pos := range map[int]Point{a.PlayerID: {X: 500, Y: 500}, v.PlayerID: {X: 530, Y: 500}} {
+			player := room.worldState.Players[id]
+			player.Class = WarriorClass
+			player.Position = pos
+		}
+	})
+
+	attacker.send(protocol.MsgAction, PlayerAction{Seq: 1, ActionType: "move", Direction: Point{X: 0, Y: 1}})
+	// До перестановки в снимках старые позиции, поэтому ждем x = 500
+	attacker.state(func(state WorldState) bool {
+		me := state.Players[a.PlayerID]
+		return me != nil && me.Position.X == 500 && me.Position.Y > 500
+	})
+
+	attacker.send(protocol.MsgAction, PlayerAction{Seq: 2, ActionType: "move", Direction: Point{}})
+	attacker.send(protocol.MsgAction, PlayerAction{Seq: 3, ActionType: "attack", AttackTarget: v.PlayerID})
+	var damage protocol.Damage
+	victim.await(protocol.MsgDamage, func(msg protocol.Message) bool {
+		return msg.Decode(&damage) == nil && damage.AttackerID == a.PlayerID
+	})
+	if damage.TargetID != v.PlayerID || damage.Amount <= 0 {
+		t.Errorf("damage %+v", damage)
+	}
+	var event protocol.Event
+	attacker.await(protocol.MsgEvent, func(msg protocol.Message) bool {
+		return msg.Decode(&event) == nil && event.Type == EventPlayerAttack
+	})
+	hurt := victim.state(func(state WorldState) bool {
+		me := state.Players[v.PlayerID]
+		return me != nil && me.Health < PlayerMaxHealth
+	})
+	if health := hurt.Players[v.PlayerID].Health; health > PlayerMaxHealth-damage.Amount+1e-9 {
+		t.Errorf("victim health %g after %g damage", health, damage.Amount)
+	}
+}
+
+func TestServerRejectsJoin(t *testing.T) {
+	cfg := testConfig()
+	cfg.Password = "secret"
+	_, addr := startTestServer(t, cfg)
+
+	reject := func(c *testClient, req protocol.JoinRoom) protocol.Error {
+		t.Helper()
+		c.send(protocol.MsgJoinRoom, req)
+		var rejection protocol.Error
+		if err := c.await(protocol.MsgError, nil).Decode(&rejection); err != nil {
+			t.Fatal(err)
+		}
+		return rejection
+	}
+
+	c := dialTestClient(t, addr)
+	join := protocol.JoinRoom{Room: DefaultRoom, Name: "guest", Version: protocol.Version}
+	if got := reject(c, join); got.Code != protocol.ErrCodePasswordRequired {
+		t.Errorf("no password: %+v", got)
+	}
+	join.Password = "guess"
+	if got := reject(c, join); got.Code != protocol.ErrCodeBadPassword {
+		t.Errorf("wrong password: %+v", got)
+	}
+	// Неверный пароль не закрывает соединение, пока попыток меньше MaxAuthFailures
+	join.Password = "secret"
+	c.send(protocol.MsgJoinRoom, join)
+	c.await(protocol.MsgInit, nil)
+
+	// Старый клиент получает ошибку версии, и сервер закрывает соединение
+	old := dialTestClient(t, addr)
+	join.Version = protocol.MinVersion - 1
+	if got := reject(old, join); got.Code != protocol.ErrCodeVersionMismatch || got.MinVersion != protocol.MinVersion {
+		t.Errorf("old version: %+v", got)
+	}
+	old.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := old.dec.Next(); err == nil {
+		t.Error("connection still open after a version mismatch")
+	}
+
+	// С -download-url в отказе есть ссылка на новый клиент
+	s := &Server{cfg: Config{DownloadURL: "https://example.com/get"}, auth: passwordAuth{}}
+	_, err := s.authenticate(join)
+	var mismatch *protocol.Error
+	if !errors.As(err, &mismatch) || mismatch.Download != "https://example.com/get" {
+		t.Errorf("mismatch with a download url: %v", err)
+	}
+}
+
+// Группа в очереди ждет дольше ClientTimeout: участники молчат, но остаются
+// подключенными и получают назначение вместе с лидером
+func TestServerPartyWaitsInQueue(t *testing.T) {
+	cfg := testConfig()
+	cfg.MatchSize = 3
+	cfg.MaxRooms = 2
+	cfg.ClientTimeout = 200 * time.Millisecond
+	_, addr := startTestServer(t, cfg)
+
+	leader, member := dialTestClient(t, addr), dialTestClient(t, addr)
+	enter := func(c *testClient, name string) {
+		t.Helper()
+		c.send(protocol.MsgEnterLobby, protocol.JoinRoom{Name: name, Version: protocol.Version})
+	}
+	enter(leader, "leader")
+	leader.send(protocol.MsgCreateParty, nil)
+	var party protocol.Party
+	if err := leader.await(protocol.MsgParty, nil).Decode(&party); err != nil {
+		t.Fatal(err)
+	}
+	enter(member, "member")
+	member.send(protocol.MsgJoinParty, protocol.PartyRequest{Code: party.Code})
+	member.await(protocol.MsgParty, func(msg protocol.Message) bool {
+		return msg.Decode(&party) == nil && len(party.Members) == 2
+	})
+
+	leader.send(protocol.MsgJoinQueue, nil)
+	member.await(protocol.MsgParty, func(msg protocol.Message) bool {
+		return msg.Decode(&party) == nil && party.Queued
+	})
+	time.Sleep(3 * cfg.ClientTimeout)
+
+	solo := dialTestClient(t, addr)
+	solo.send(protocol.MsgJoinQueue, protocol.JoinRoom{Name: "solo", Version: protocol.Version})
+	for _, c := range []*testClient{leader, member, solo} {
+		var assignment protocol.RoomAssignment
+		if err := c.await(protocol.MsgRoomAssignment, nil).Decode(&assignment); err != nil {
+			t.Fatal(err)
+		}
+		if assignment.Players != 3 {
+			t.Errorf("assignment %+v", assignment)
+		}
+	}
+}
+
+// Флуд оставляет в логе комнаты одну запись rate_limited за окно и
+// отключение, а не запись на каждое отброшенное сообщение
+func TestServerFloodLoggedOnce(t *testing.T) {
+	s, addr := startTestServer(t, testConfig())
+	c := dialTestClient(t, addr)
+	id := c.join("flooder").PlayerID
+	go io.Copy(io.Discard, c.conn)
+	for i := 0; i < int(MessageBurst)+MaxDroppedMessages+50; i++ {
+		if c.enc.Encode(protocol.MsgPong, protocol.Ping{}) != nil {
+			break
+		}
+	}
+
+	room, err := s.findRoom(DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(eventType string) int {
+		n := 0
+		room.do(func() {
+			for _, entry := range room.logEntries {
+				if entry.EventType == eventType && entry.Data["player_id"] == id {
+					n++
+				}
+			}
+		})
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for count(EventPlayerKicked) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("flooder was not kicked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := count(EventRateLimited); n != 1 {
+		t.Errorf("%d rate_limited entries, want 1", n)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+
+	"meatgrinder/protocol"
+)
+
+// ServerListKey открывает список публичных серверов из -master-url
+const ServerListKey = ebiten.KeyF4
+
+// serverBrowser - список серверов с мастер-сервера в главном меню
+type serverBrowser struct {
+	active   bool
+	loading  bool
+	servers  []protocol.ServerInfo
+	selected int
+	err      string
+}
+
+// refreshServerList загружает список в фоне. Вызывается в игровом цикле.
+func (g *Game) refreshServerList() {
+	b := &g.servers
+	if b.loading {
+		return
+	}
+	if g.cfg.MasterURL == "" {
+		b.err = tr("no master server configured (-master-url)")
+		return
+	}
+	b.loading = true
+	b.err = ""
+	go func() {
+		servers, err := fetchServerList(g.cfg.MasterURL)
+		g.post(func() {
+			b.loading = false
+			if err != nil {
+				clientLog.Warn("Error fetching server list", "err", err)
+				b.err = err.Error()
+				return
+			}
+			b.servers = servers
+			if b.selected >= len(servers) {
+				b.selected = 0
+			}
+		})
+	}()
+}
+
+// updateServerBrowser: Enter подключается к выбранному серверу, F4 или
+// Backspace возвращают к полям меню. Вызывается в игровом цикле.
+func (g *Game) updateServerBrowser() {
+	b := &g.servers
+	if inpututil.IsKeyJustPressed(ServerListKey) || inpututil.IsKeyJustPressed(ebiten.KeyBackspace) {
+		b.active = false
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		g.refreshServerList()
+	}
+	if len(b.servers) == 0 {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		b.selected = (b.selected + len(b.servers) - 1) % len(b.servers)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		b.selected = (b.selected + 1) % len(b.servers)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		server := b.servers[b.selected]
+		if server.Transport != "" && server.Transport != g.cfg.Transport {
+			b.err = trf("%s uses %s, start the client with -transport %s", server.Name, server.Transport, server.Transport)
+			return
+		}
+		b.active = false
+		g.connect.address = server.Addr
+		if server.Password && g.connect.password == "" {
+			// Без пароля все равно откажут, сразу ставим курсор в поле пароля
+			g.connect.field = FieldPassword
+			return
+		}
+		g.connect.connecting = true
+		g.connect.err = ""
+		go g.dial(server.Addr, g.connect.name, g.connect.password)
+	}
+}
+
+func (g *Game) drawServerBrowser(screen *ebiten.Image) {
+	b := g.servers
+	drawCentered(screen, tr("PUBLIC SERVERS"), 20)
+	drawCentered(screen, tr("Up/Down - select, Enter - connect, R - refresh, F4 - back"), 40)
+	drawText(screen, fmt.Sprintf("%-24s %-22s %7s %5s %6s", tr("Server"), tr("Address"), tr("Players"), tr("Bots"), tr("Mode")), 60, roomListTop-roomListRowHeight)
+
+	switch {
+	case b.loading:
+		drawText(screen, tr("Loading server list..."), 60, roomListTop)
+	case len(b.servers) == 0 && b.err == "":
+		drawText(screen, tr("No servers online"), 60, roomListTop)
+	}
+	for i, server := range b.servers {
+		y := roomListTop + i*roomListRowHeight
+		if i == b.selected {
+			ebitenutil.DrawRect(screen, 55, float64(y), 600, roomListRowHeight, color.RGBA{255, 255, 255, 40})
+		}
+		name := server.Name
+		if server.Password {
+			name += " *"
+		}
+		line := fmt.Sprintf("%-24s %-22s %7d %5d %6s", name, server.Addr, server.Players, server.Bots, server.Mode)
+		drawText(screen, line, 60, y+2)
+	}
+
+	if b.err != "" {
+		drawText(screen, trf("Error: %s", b.err), 60, ScreenHeight-40)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Settings - локальные настройки клиента, переживают перезапуск
+type Settings struct {
+	Volume      float64 `json:"volume"`       // Общая громкость 0..1
+	MusicVolume float64 `json:"music_volume"` // Громкость музыки относительно общей
+	Muted       bool    `json:"muted"`
+
+	WindowWidth  int  `json:"window_width"`
+	WindowHeight int  `json:"window_height"`
+	Fullscreen   bool `json:"fullscreen"`
+	VSync        bool `json:"vsync"`
+	FPSCap       int  `json:"fps_cap"` // Наибольшее число кадров в секунду
+
+	Language string `json:"language"` // Язык интерфейса, см. locales
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		Volume:       0.8,
+		MusicVolume:  0.5,
+		WindowWidth:  ScreenWidth,
+		WindowHeight: ScreenHeight,
+		VSync:        true,
+		FPSCap:       ebiten.DefaultTPS,
+		Language:     DefaultLocale,
+	}
+}
+
+// configPath - файл клиента в каталоге конфигурации пользователя
+// (~/.config/meatgrinder на Linux)
+func configPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "meatgrinder", name), nil
+}
+
+// LoadSettings читает настройки. Если файла нет или он испорчен,
+// возвращаются настройки по умолчанию.
+func LoadSettings() Settings {
+	settings := DefaultSettings()
+	path, err := configPath("settings.json")
+	if err != nil {
+		clientLog.Error("Error locating settings", "err", err)
+		return settings
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings
+	}
+	if err != nil {
+		clientLog.Error("Error reading settings", "err", err)
+		return settings
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		clientLog.Error("Error parsing settings", "path", path, "err", err)
+		return DefaultSettings()
+	}
+	return settings
+}
+
+func (s Settings) Save() error {
+	path, err := configPath("settings.json")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Clock - источник времени симуляции. Комната никогда не вызывает time.Now()
+// напрямую, поэтому тесты и повторы могут подставить свои часы.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock - часы, которые двигаются только вызовом Advance
+type ManualClock struct {
+	now time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newRNG создает генератор случайных чисел комнаты. Нулевое зерно
+// означает случайное зерно от текущего времени.
+func newRNG(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// sortedIDs возвращает ключи карты по возрастанию. Порядок обхода карт в Go
+// случаен, а от порядка зависят вызовы RNG и исход боя, поэтому симуляция
+// обходит сущности только так.
+func sortedIDs[V any](m map[int]V) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
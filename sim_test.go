@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Комнаты в тестах пишут в лог каждый вход и смерть
+	if err := setupLogging("error", "", false); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testConfig - настройки комнаты как у сервера по умолчанию, с постоянным
+// зерном
+func testConfig() Config {
+	return Config{
+		MinPlayers:    1,
+		Seed:          1,
+		WorldWidth:    DefaultWorldWidth,
+		WorldHeight:   DefaultWorldHeight,
+		MapRotation:   MapRotationCycle,
+		ViewRadius:    DefaultViewRadius,
+		Transport:     TransportTCP,
+		Mode:          GameModeFFA,
+		MatchDuration: DefaultMatchDuration,
+		SuddenDeath:   SuddenDeathNoRespawn,
+		BotTimeout:    DefaultBotTimeout,
+		TickRate:      TickRate,
+		BroadcastRate: TickRate,
+		AFKTimeout:    DefaultAFKTimeout,
+		ClientTimeout: DefaultClientTimeout,
+	}
+}
+
+// newTestRoom создает комнату без фоновых горутин. Время идет только по
+// advance, случайность - из зерна cfg.Seed.
+func newTestRoom(cfg Config) (*Room, *ManualClock) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	return newRoom("test", cfg, &idAllocator{}, clock, newRNG(cfg.Seed)), clock
+}
+
+// advance продвигает комнату на ticks шагов симуляции
+func advance(r *Room, clock *ManualClock, ticks int) {
+	for i := 0; i < ticks; i++ {
+		clock.Advance(r.stepDuration)
+		r.step()
+	}
+}
+
+// scenarioPlayer - игрок в исходном состоянии сценария. Нулевое здоровье
+// означает полное.
+type scenarioPlayer struct {
+	Name     string
+	Class    int
+	Position Point
+	Health   float64
+	Team     int
+}
+
+// scenario - комната с заданными игроками. Время в ней идет только по
+// advance, а ввод приходит так же, как от клиентов, через queueAction.
+// Каждый игрок подключен через net.Pipe, поэтому для матча это живые
+// люди.
+type scenario struct {
+	t     *testing.T
+	room  *Room
+	clock *ManualClock
+	ids   map[string]int
+}
+
+func newScenario(t *testing.T, cfg Config, players ...scenarioPlayer) *scenario {
+	t.Helper()
+	r, clock := newTestRoom(cfg)
+	s := &scenario{t: t, room: r, clock: clock, ids: make(map[string]int)}
+	// Команды других горутин (queueAction, Close) выполняются между
+	// шагами, как в run, только без тикера
+	go func() {
+		defer close(r.stopped)
+		for {
+			select {
+			case command := <-r.commands:
+				command()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	t.Cleanup(r.Close)
+	for _, p := range players {
+		s.add(p)
+	}
+	return s
+}
+
+// add подключает игрока и ставит его в исходное состояние
+func (s *scenario) add(p scenarioPlayer) *PlayerState {
+	s.t.Helper()
+	r := s.room
+	id, ok := r.addPlayer(p.Name, "")
+	if !ok {
+		s.t.Fatalf("player %s did not join", p.Name)
+	}
+	server, client := net.Pipe()
+	go io.Copy(io.Discard, client)
+	r.do(func() {
+		r.playerConnections[id] = newClientConnection(server, id, 0)
+		player := r.worldState.Players[id]
+		player.Class = p.Class
+		player.Position = p.Position
+		player.Health = PlayerMaxHealth
+		if p.Health != 0 {
+			player.Health = p.Health
+		}
+		player.Resource = maxResource(player)
+		player.Team = p.Team
+	})
+	s.ids[p.Name] = id
+	return s.player(p.Name)
+}
+
+// player - состояние игрока. Менять его можно только между шагами.
+func (s *scenario) player(name string) *PlayerState {
+	s.t.Helper()
+	player, ok := s.room.worldState.Players[s.ids[name]]
+	if !ok {
+		s.t.Fatalf("no player %s", name)
+	}
+	return player
+}
+
+// act отправляет действие игрока, оно применится на следующем шаге
+func (s *scenario) act(name string, action PlayerAction) {
+	s.room.queueAction(s.ids[name], action)
+}
+
+func (s *scenario) advance(ticks int) {
+	advance(s.room, s.clock, ticks)
+}
+
+// advanceTime продвигает комнату на d, округляя до целых шагов вверх
+func (s *scenario) advanceTime(d time.Duration) {
+	s.advance(int((d + s.room.stepDuration - 1) / s.room.stepDuration))
+}
+
+// startMatch проматывает лобби и отсчет до начала боя
+func (s *scenario) startMatch() {
+	s.t.Helper()
+	for i := 0; s.room.worldState.Match.Phase != MatchActive; i++ {
+		if i > s.room.cfg.TickRate*int(CountdownDuration+1) {
+			s.t.Fatalf("match did not start, phase %s", s.room.worldState.Match.Phase)
+		}
+		s.advance(1)
+	}
+}
+
+// events - записи лога комнаты с типом eventType по порядку
+func (s *scenario) events(eventType string) []LogEntry {
+	var entries []LogEntry
+	for _, entry := range s.room.logEntries {
+		if entry.EventType == eventType {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
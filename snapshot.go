@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// Горячий перезапуск сервера (-state-file). По SIGTERM или SIGINT сервер
+// сохраняет комнаты целиком - мир, ботов, матч и состояние режима - и
+// выходит, а при запуске поднимает их из файла и удаляет его. Перед
+// сохранением комнаты ставятся на паузу, чтобы снимки всех комнат были
+// сделаны в один момент. Часы комнаты продолжают идти с момента сохранения,
+// поэтому перезарядки, таймеры и время матча не сдвигаются. Людей комната
+// ждет RestoreReconnectWindow: клиент, предъявивший в join_room токен из
+// своего init, получает своего игрока обратно, а не занятые за это время
+// места освобождаются. По одному имени место не отдается: имя может
+// назвать кто угодно.
+const (
+	RestoreReconnectWindow = time.Minute
+	ReconnectTokenBytes    = 16
+	snapshotVersion        = 1
+)
+
+// serverSnapshot - содержимое -state-file. Формат gob, а не JSON: в снимок
+// входят и поля, которые клиентам не отправляются (json:"-").
+type serverSnapshot struct {
+	Version int
+	Rooms   [][]byte // roomSnapshot каждой комнаты
+}
+
+// roomSnapshot - комната в файле
+type roomSnapshot struct {
+	Name        string
+	WorldWidth  float64
+	WorldHeight float64
+	Map         string    // Имя карты из пула, пустое - без карты
+	MapIndex    int       // Место карты в ротации, имена в пуле могут повторяться
+	SavedAt     time.Time // Часы комнаты в момент сохранения
+
+	Tick             uint64
+	BroadcastSeq     uint64
+	World            WorldState
+	Bots             map[int]botSnapshot
+	NextItemID       int
+	NextProjectileID int
+	NextMinionID     int
+	LastItemSpawn    time.Time
+	FirstBlood       bool
+	MapVotes         map[int]string
+	Zone             *zoneSnapshot
+	Mode             string
+	ModeState        []byte // См. modeState, пусто у режимов без состояния
+}
+
+// botSnapshot - то, что бот помнит между решениями. Путь не сохраняется:
+// бот найдет его заново.
+type botSnapshot struct {
+	NextDecision time.Time
+	Focus        int
+	Approach     float64
+	Threat       map[int]float64
+}
+
+// zoneSnapshot - неэкспортируемые поля зоны, без них не доиграть сжатие
+type zoneSnapshot struct {
+	InitialRadius float64
+	FromCenter    Point
+	FromRadius    float64
+}
+
+// modeState - режим, у которого есть состояние матча сверх WorldState
+type modeState interface {
+	saveState() ([]byte, error)
+	restoreState(data []byte) error
+}
+
+// snapshot сохраняет комнату. Профили людей записываются тут же: после
+// остановки комнаты removePlayer их уже не сохранит. resume - комнату
+// поставили на паузу только ради снимка, после перезапуска она играет
+// дальше. Вызывается в горутине комнаты.
+func (r *Room) snapshot(resume bool) ([]byte, error) {
+	snap := roomSnapshot{
+		Name:             r.name,
+		WorldWidth:       r.cfg.WorldWidth,
+		WorldHeight:      r.cfg.WorldHeight,
+		MapIndex:         r.rotation,
+		SavedAt:          r.clock.Now(),
+		Tick:             r.tick,
+		BroadcastSeq:     r.broadcastSeq,
+		World:            r.worldState,
+		Bots:             make(map[int]botSnapshot, len(r.bots)),
+		NextItemID:       r.nextItemID,
+		NextProjectileID: r.nextProjectileID,
+		NextMinionID:     r.nextMinionID,
+		LastItemSpawn:    r.lastItemSpawn,
+		FirstBlood:       r.firstBlood,
+		MapVotes:         r.mapVotes,
+		Mode:             r.mode.Name(),
+	}
+	if r.cfg.Map != nil {
+		snap.Map = r.cfg.Map.Name
+	}
+	if resume {
+		snap.World.Paused = false
+	}
+	for id, bot := range r.bots {
+		snap.Bots[id] = botSnapshot{NextDecision: bot.NextDecision, Focus: bot.Focus, Approach: bot.Approach, Threat: bot.Threat}
+	}
+	if zone := r.worldState.Zone; zone != nil {
+		snap.Zone = &zoneSnapshot{InitialRadius: zone.initialRadius, FromCenter: zone.fromCenter, FromRadius: zone.fromRadius}
+	}
+	if mode, ok := r.mode.(modeState); ok {
+		state, err := mode.saveState()
+		if err != nil {
+			return nil, fmt.Errorf("mode %s: %w", snap.Mode, err)
+		}
+		snap.ModeState = state
+	}
+	for id := range r.playerProfiles {
+		r.saveProfile(id)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// restore заменяет состояние только что созданной комнаты снимком.
+// Вызывается до start, пока у комнаты нет горутин.
+func (r *Room) restore(snap roomSnapshot) error {
+	// Часы комнаты продолжают с момента сохранения
+	r.clock.offset = r.clock.Clock.Now().Sub(snap.SavedAt)
+	if snap.World.Paused {
+		r.clock.pause()
+	}
+	now := r.clock.Now()
+	r.lastUpdateTime = now.Add(-r.stepDuration / 2)
+	r.tick, r.broadcastSeq = snap.Tick, snap.BroadcastSeq
+	r.nextItemID, r.nextProjectileID, r.nextMinionID = snap.NextItemID, snap.NextProjectileID, snap.NextMinionID
+	r.lastItemSpawn = snap.LastItemSpawn
+	r.firstBlood = snap.FirstBlood
+	r.mapVotes = snap.MapVotes
+
+	// Пустые карты gob не пишет, а комната рассчитывает на созданные
+	r.worldState = snap.World
+	if r.worldState.Players == nil {
+		r.worldState.Players = make(map[int]*PlayerState)
+	}
+	if r.worldState.Items == nil {
+		r.worldState.Items = make(map[int]*Item)
+	}
+	if r.worldState.Projectiles == nil {
+		r.worldState.Projectiles = make(map[int]*Projectile)
+	}
+	if r.worldState.Minions == nil {
+		r.worldState.Minions = make(map[int]*Minion)
+	}
+	if r.worldState.Match.MapVote != nil && r.mapVotes == nil {
+		r.mapVotes = make(map[int]string)
+	}
+	if zone := r.worldState.Zone; zone != nil && snap.Zone != nil {
+		zone.initialRadius, zone.fromCenter, zone.fromRadius = snap.Zone.InitialRadius, snap.Zone.FromCenter, snap.Zone.FromRadius
+	}
+	if mode, ok := r.mode.(modeState); ok && snap.Mode == r.mode.Name() && snap.ModeState != nil {
+		if err := mode.restoreState(snap.ModeState); err != nil {
+			return fmt.Errorf("mode %s: %w", snap.Mode, err)
+		}
+	} else if snap.Mode != r.mode.Name() {
+		r.log.Warn("Game mode changed since the snapshot, match state restarts", "saved", snap.Mode, "mode", r.mode.Name())
+	}
+
+	r.seats = make(map[int]bool)
+	r.seatsExpire = time.Now().Add(RestoreReconnectWindow)
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		if player.Bot {
+			saved := snap.Bots[id]
+			r.bots[id] = &Bot{
+				NextDecision: saved.NextDecision,
+				Brain:        botBrains[player.Class],
+				Focus:        saved.Focus,
+				Approach:     saved.Approach,
+				Threat:       saved.Threat,
+			}
+			continue
+		}
+		// До возвращения клиента игрок стоит на месте
+		r.seats[id] = true
+		player.AFK = true
+		player.MovingDirection = Point{}
+		player.Destination = nil
+		player.Sprinting = false
+		r.loadProfile(player)
+	}
+	r.log.Info("Room restored", "tick", r.tick, "phase", r.worldState.Match.Phase, "players", len(r.seats), "bots", len(r.bots))
+	return nil
+}
+
+// newReconnectToken придумывает секрет для init нового входа
+func newReconnectToken() string {
+	buf := make([]byte, ReconnectTokenBytes)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// claimSeat отдает вошедшему игрока, которого он оставил до перезапуска:
+// токен должен совпасть с выданным этому игроку в init. Старый токен
+// заменяется новым, он уйдет в init этого входа. Вызывается в горутине
+// комнаты.
+func (r *Room) claimSeat(token string) (int, bool) {
+	if token == "" {
+		return 0, false
+	}
+	for _, id := range sortedIDs(r.seats) {
+		player, ok := r.worldState.Players[id]
+		if !ok || subtle.ConstantTimeCompare([]byte(player.ReconnectToken), []byte(token)) != 1 {
+			continue
+		}
+		delete(r.seats, id)
+		player.ReconnectToken = newReconnectToken()
+		r.logEvent(r.clock.Now(), EventPlayerJoined, map[string]interface{}{
+			"player_id":   id,
+			"name":        player.Name,
+			"class":       ClassNames[player.Class],
+			"position":    player.Position,
+			"reconnected": true,
+		})
+		r.log.Info("Player reconnected", "player_id", id, "name", player.Name)
+		return id, true
+	}
+	return 0, false
+}
+
+// expireSeats освобождает места, которые так и не заняли. Считает по
+// настоящему времени, как watchConnections. Вызывается в горутине комнаты.
+func (r *Room) expireSeats(now time.Time) {
+	if len(r.seats) == 0 || now.Before(r.seatsExpire) {
+		return
+	}
+	for _, id := range sortedIDs(r.seats) {
+		r.log.Info("Player did not come back after restart", "player_id", id)
+		r.dropPlayer(id)
+	}
+	r.seats = nil
+}
+
+// restoredRoom создает комнату из снимка. Карта ищется в пуле сервера по
+// имени.
+func (s *Server) restoredRoom(data []byte) (*Room, error) {
+	var snap roomSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return nil, err
+	}
+	cfg := s.cfg
+	cfg.WorldWidth, cfg.WorldHeight = snap.WorldWidth, snap.WorldHeight
+	cfg.Map = nil
+	rotation := 0
+	if snap.Map != "" {
+		// Пул могли поменять между запусками, тогда карта ищется по имени
+		if snap.MapIndex < len(cfg.Maps) && cfg.Maps[snap.MapIndex].Name == snap.Map {
+			cfg.Map, rotation = cfg.Maps[snap.MapIndex], snap.MapIndex
+		}
+		for i, m := range cfg.Maps {
+			if cfg.Map == nil && m.Name == snap.Map {
+				cfg.Map, rotation = m, i
+			}
+		}
+		if cfg.Map == nil {
+			return nil, fmt.Errorf("room %s: map %q is not in the map pool", snap.Name, snap.Map)
+		}
+	}
+	r := newRoom(snap.Name, cfg, &s.ids, realClock{}, newRNG(cfg.Seed))
+	r.rotation = rotation
+	r.profiles = s.profiles
+	r.webhooks = s.webhooks
+	r.exporter = s.exporter
+	if err := r.restore(snap); err != nil {
+		return nil, fmt.Errorf("room %s: %w", snap.Name, err)
+	}
+	return r, nil
+}
+
+// restoreState поднимает комнаты из -state-file, если он есть, и удаляет
+// его, чтобы следующий запуск не вернул тот же матч еще раз
+func (s *Server) restoreState() error {
+	data, err := os.ReadFile(s.cfg.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap serverSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	lastID := 0
+	for _, data := range snap.Rooms {
+		room, err := s.restoredRoom(data)
+		if err != nil {
+			return err
+		}
+		for id := range room.worldState.Players {
+			lastID = max(lastID, id)
+		}
+		for _, minion := range room.worldState.Minions {
+			lastID = max(lastID, minion.ID)
+		}
+		s.rooms[room.name] = room
+	}
+	// Новые игроки не должны получить ID восстановленных
+	s.ids.last.Store(int64(lastID))
+	for _, room := range s.rooms {
+		room.start()
+	}
+	if err := os.Remove(s.cfg.StateFile); err != nil {
+		return err
+	}
+	simLog.Info("State restored", "path", s.cfg.StateFile, "rooms", len(snap.Rooms))
+	return nil
+}
+
+// saveState сохраняет все комнаты в -state-file. Файл пишется целиком во
+// временный и переименовывается, чтобы обрыв не оставил половину снимка.
+func (s *Server) saveState() error {
+	s.mu.Lock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, name := range slices.Sorted(maps.Keys(s.rooms)) {
+		rooms = append(rooms, s.rooms[name])
+	}
+	s.mu.Unlock()
+
+	// Пока пишутся другие комнаты, ни одна не должна уйти вперед
+	paused := make(map[*Room]bool, len(rooms))
+	for _, room := range rooms {
+		paused[room] = room.setPaused(true, "shutdown")
+	}
+	snap := serverSnapshot{Version: snapshotVersion}
+	for _, room := range rooms {
+		var data []byte
+		var err error
+		if !room.do(func() { data, err = room.snapshot(paused[room]) }) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("room %s: %w", room.name, err)
+		}
+		snap.Rooms = append(snap.Rooms, data)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snap); err != nil {
+		return err
+	}
+	tmp := s.cfg.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.cfg.StateFile); err != nil {
+		return err
+	}
+	simLog.Info("State saved", "path", s.cfg.StateFile, "rooms", len(snap.Rooms))
+	return nil
+}
+
+// watchShutdown по SIGTERM или SIGINT сохраняет комнаты и останавливает
+// сервер
+func (s *Server) watchShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+	code := 0
+	if err := s.saveState(); err != nil {
+		simLog.Error("Error saving state", "path", s.cfg.StateFile, "err", err)
+		code = 1
+	}
+	s.Close()
+	os.Exit(code)
+}
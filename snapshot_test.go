@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// restoreTestRoom сохраняет комнату и поднимает ее заново, как после
+// перезапуска сервера
+func restoreTestRoom(t *testing.T, r *Room) *Room {
+	t.Helper()
+	data, err := r.snapshot(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{cfg: r.cfg}
+	restored, err := s.restoredRoom(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return restored
+}
+
+// Место после перезапуска отдается только по токену из init, а не по имени
+func TestSnapshotSeatNeedsToken(t *testing.T) {
+	r, _ := newTestRoom(testConfig())
+	id := r.spawnPlayer("alice")
+	token := r.worldState.Players[id].ReconnectToken
+	if token == "" {
+		t.Fatal("no reconnect token for a new player")
+	}
+	restored := restoreTestRoom(t, r)
+
+	for _, wrong := range []string{"", "alice", token[:len(token)-1]} {
+		if _, ok := restored.claimSeat(wrong); ok {
+			t.Fatalf("seat claimed with %q", wrong)
+		}
+	}
+	got, ok := restored.claimSeat(token)
+	if !ok || got != id {
+		t.Fatalf("claimSeat with the token = %d, %v, want %d", got, ok, id)
+	}
+	if fresh := restored.worldState.Players[id].ReconnectToken; fresh == token || fresh == "" {
+		t.Errorf("token not replaced after the claim: %q", fresh)
+	}
+	if _, ok := restored.claimSeat(token); ok {
+		t.Error("seat claimed twice")
+	}
+}
+
+// Ротация продолжается с той же карты, даже если имена в пуле повторяются
+func TestSnapshotKeepsRotation(t *testing.T) {
+	cfg := testConfig()
+	cfg.Maps = []*GameMap{
+		{Name: "arena", Width: 1000, Height: 1000},
+		{Name: "pit", Width: 1200, Height: 800},
+		{Name: "arena", Width: 1000, Height: 1000},
+	}
+	cfg.Map = cfg.Maps[0]
+	r, clock := newTestRoom(cfg)
+	r.rotateMap(clock.Now())
+	r.rotateMap(clock.Now())
+	if r.rotation != 2 || r.cfg.Map != cfg.Maps[2] {
+		t.Fatalf("rotation %d after two maps", r.rotation)
+	}
+
+	restored := restoreTestRoom(t, r)
+	if restored.rotation != 2 || restored.cfg.Map != cfg.Maps[2] {
+		t.Fatalf("restored rotation %d", restored.rotation)
+	}
+	restored.rotateMap(restored.clock.Now())
+	if restored.rotation != 0 {
+		t.Errorf("next map after a restart is %d, want 0", restored.rotation)
+	}
+}
+
+// Перед снимком комнаты встают на паузу, но в файл она не попадает: после
+// перезапуска матч идет дальше. Пауза, поставленная админом, сохраняется.
+func TestSaveStatePausesRooms(t *testing.T) {
+	running := newScenario(t, testConfig()).room
+	held := newScenario(t, testConfig()).room
+	held.setPaused(true, "admin")
+	cfg := testConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.bin")
+	s := &Server{cfg: cfg, rooms: map[string]*Room{"running": running, "held": held}}
+	running.name, held.name = "running", "held"
+
+	if err := s.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	if !running.worldState.Paused {
+		t.Error("room kept running while the state was saved")
+	}
+	data, err := os.ReadFile(cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap serverSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		t.Fatal(err)
+	}
+	paused := make(map[string]bool)
+	for _, data := range snap.Rooms {
+		var room roomSnapshot
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&room); err != nil {
+			t.Fatal(err)
+		}
+		paused[room.Name] = room.World.Paused
+	}
+	if len(paused) != 2 || paused["running"] || !paused["held"] {
+		t.Errorf("saved pause %v, want only held", paused)
+	}
+}
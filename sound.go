@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+const (
+	SampleRate = 44100
+	VolumeStep = 0.1
+	// Звуки дальше этого расстояния от камеры не играем
+	SoundHearingDistance = ScreenWidth
+)
+
+// Звуки
+const (
+	SoundAttack  = "attack"
+	SoundHit     = "hit"
+	SoundDeath   = "death"
+	SoundRespawn = "respawn"
+)
+
+// soundSystem синтезирует звуки при старте клиента, поэтому файлов с
+// ассетами не нужно
+type soundSystem struct {
+	ctx    *audio.Context
+	sounds map[string][]byte // Имя -> PCM, 16 бит, стерео
+	music  *audio.Player
+}
+
+func newSoundSystem(settings Settings) *soundSystem {
+	s := &soundSystem{
+		ctx: audio.NewContext(SampleRate),
+		sounds: map[string][]byte{
+			SoundAttack:  synth(0.12, func(t float64) float64 { return noise() * (1 - t/0.12) * 0.5 }),
+			SoundHit:     synth(0.15, func(t float64) float64 { return tone(90*(1-t*2), t) * math.Exp(-t*25) }),
+			SoundDeath:   synth(0.6, func(t float64) float64 { return tone(330*(1-t/0.9), t) * (1 - t/0.6) * 0.6 }),
+			SoundRespawn: synth(0.4, func(t float64) float64 { return tone(440+880*t, t) * (1 - t/0.4) * 0.4 }),
+		},
+	}
+
+	pcm := synthMusic()
+	music, err := s.ctx.NewPlayer(audio.NewInfiniteLoop(bytes.NewReader(pcm), int64(len(pcm))))
+	if err != nil {
+		clientLog.Error("Error starting music", "err", err)
+		return s
+	}
+	s.music = music
+	s.applySettings(settings)
+	s.music.Play()
+	return s
+}
+
+// applySettings обновляет громкость музыки после изменения настроек
+func (s *soundSystem) applySettings(settings Settings) {
+	if s.music != nil {
+		s.music.SetVolume(effectiveVolume(settings) * settings.MusicVolume)
+	}
+}
+
+func effectiveVolume(settings Settings) float64 {
+	if settings.Muted {
+		return 0
+	}
+	return settings.Volume
+}
+
+// play проигрывает звук с заданной громкостью
+func (s *soundSystem) play(name string, volume float64) {
+	pcm, ok := s.sounds[name]
+	if !ok || volume <= 0 {
+		return
+	}
+	p := s.ctx.NewPlayerFromBytes(pcm)
+	p.SetVolume(volume)
+	p.Play()
+}
+
+// playAt проигрывает звук события в мировой точке pos: чем дальше от центра
+// камеры, тем тише. Вызывается в игровом цикле.
+func (g *Game) playAt(name string, pos Point) {
+	if g.sound == nil {
+		return
+	}
+	center := Point{X: g.camera.X + ScreenWidth/2, Y: g.camera.Y + ScreenHeight/2}
+	dist := math.Hypot(pos.X-center.X, pos.Y-center.Y)
+	if dist >= SoundHearingDistance {
+		return
+	}
+	g.sound.play(name, effectiveVolume(g.settings)*(1-dist/SoundHearingDistance))
+}
+
+// updateVolumeKeys: по умолчанию M - выключить звук, -/= - громкость.
+// Настройки сразу сохраняются. Вызывается в игровом цикле.
+func (g *Game) updateVolumeKeys() {
+	changed := false
+	if g.keys.JustPressed(InputMute) {
+		g.settings.Muted = !g.settings.Muted
+		changed = true
+	}
+	if g.keys.JustPressed(InputVolumeDown) {
+		g.settings.Volume = math.Max(0, g.settings.Volume-VolumeStep)
+		changed = true
+	}
+	if g.keys.JustPressed(InputVolumeUp) {
+		g.settings.Volume = math.Min(1, g.settings.Volume+VolumeStep)
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	if g.sound != nil {
+		g.sound.applySettings(g.settings)
+	}
+	if err := g.settings.Save(); err != nil {
+		clientLog.Error("Error saving settings", "err", err)
+	}
+}
+
+// synth сэмплирует функцию f(t) длительностью seconds в PCM
+func synth(seconds float64, f func(t float64) float64) []byte {
+	n := int(seconds * SampleRate)
+	buf := make([]byte, n*4)
+	for i := 0; i < n; i++ {
+		v := int16(math.Max(-1, math.Min(1, f(float64(i)/SampleRate))) * math.MaxInt16)
+		binary.LittleEndian.PutUint16(buf[i*4:], uint16(v))
+		binary.LittleEndian.PutUint16(buf[i*4+2:], uint16(v))
+	}
+	return buf
+}
+
+func tone(freq, t float64) float64 {
+	return math.Sin(2 * math.Pi * freq * t)
+}
+
+func noise() float64 {
+	return rand.Float64()*2 - 1
+}
+
+// synthMusic - тихое зацикленное арпеджио для фона
+func synthMusic() []byte {
+	notes := []float64{110, 130.81, 164.81, 130.81, 98, 123.47, 146.83, 123.47}
+	noteLength := (250 * time.Millisecond).Seconds()
+	total := noteLength * float64(len(notes)) * 2
+	return synth(total, func(t float64) float64 {
+		i := int(t/noteLength) % len(notes)
+		local := math.Mod(t, noteLength)
+		envelope := math.Exp(-local * 6)
+		return (tone(notes[i], t) + 0.5*tone(notes[i]*2, t)) * envelope * 0.25
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import "math"
+
+// Выбор места возрождения. Кандидаты - точки карты своей команды (или
+// общие), а без них - несколько случайных мест, в командных режимах в
+// полосе своей команды у края мира. Из кандидатов, рядом с которыми нет
+// врагов ближе SafeSpawnDistance, берется случайный, а если безопасных
+// нет - самый далекий от врагов.
+const (
+	SafeSpawnDistance  = 500.0 // Враг ближе этого - угроза для только что возродившегося
+	SpawnCandidates    = 8     // Сколько случайных мест сравнивать, если у карты нет точек
+	TeamSpawnAreaShare = 0.25  // Доля ширины мира под полосу возрождения команды
+)
+
+// spawnPosition - где возродить игрока self. nil - игрок еще не создан,
+// команды у него нет. Вызывается в горутине комнаты.
+func (r *Room) spawnPosition(self *PlayerState) Point {
+	team := 0
+	if self != nil {
+		team = self.Team
+	}
+	candidates := r.mapSpawns(team)
+	if len(candidates) == 0 {
+		from, to := r.teamSpawnArea(team)
+		for i := 0; i < SpawnCandidates; i++ {
+			candidates = append(candidates, r.randomPositionIn(from, to))
+		}
+	}
+	var safe []Point
+	best, bestDist := candidates[0], -1.0
+	for _, p := range candidates {
+		dist := r.nearestEnemyDistance(self, team, p)
+		if dist >= SafeSpawnDistance {
+			safe = append(safe, p)
+		}
+		if dist > bestDist {
+			best, bestDist = p, dist
+		}
+	}
+	if len(safe) > 0 {
+		return safe[r.rng.Intn(len(safe))]
+	}
+	return best
+}
+
+// mapSpawns - точки карты для команды team: свои, а если своих нет -
+// общие. Игроку без команды подходят все.
+func (r *Room) mapSpawns(team int) []Point {
+	if r.cfg.Map == nil {
+		return nil
+	}
+	var own, shared []Point
+	for _, spawn := range r.cfg.Map.Spawns {
+		p := Point{X: spawn.X, Y: spawn.Y}
+		if team == 0 || spawn.Team == team {
+			own = append(own, p)
+		} else if spawn.Team == 0 {
+			shared = append(shared, p)
+		}
+	}
+	if len(own) > 0 {
+		return own
+	}
+	return shared
+}
+
+// teamSpawnArea - полоса мира по X, где возрождается команда: красные у
+// левого края, синие у правого, там же, где базы флагов. Без команды -
+// весь мир.
+func (r *Room) teamSpawnArea(team int) (from, to float64) {
+	width := r.cfg.WorldWidth
+	switch team {
+	case TeamRed:
+		return 0, width * TeamSpawnAreaShare
+	case TeamBlue:
+		return width * (1 - TeamSpawnAreaShare), width
+	}
+	return 0, width
+}
+
+// nearestEnemyDistance - расстояние от p до ближайшего живого противника
+// self из команды team, MaxFloat64 - противников нет
+func (r *Room) nearestEnemyDistance(self *PlayerState, team int, p Point) float64 {
+	nearest := math.MaxFloat64
+	for _, other := range r.worldState.Players {
+		if (self != nil && other.ID == self.ID) || other.Eliminated || (team != 0 && other.Team == team) {
+			continue
+		}
+		nearest = math.Min(nearest, math.Hypot(p.X-other.Position.X, p.Y-other.Position.Y))
+	}
+	return nearest
+}
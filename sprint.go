@@ -0,0 +1,48 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+const (
+	SprintMultiplier  = 1.6 // Во столько раз рывок ускоряет бег
+	sprintTrailGhosts = 4   // Сколько силуэтов тянется за бегущим
+	sprintTrailStep   = 7.0 // Расстояние между силуэтами
+)
+
+// sprintActive сообщает, ускорен ли игрок прямо сейчас: рывок зажат, игрок
+// бежит и ресурс еще не кончился. Одинаково считается на сервере и клиенте.
+func sprintActive(p *PlayerState) bool {
+	moving := p.MovingDirection.X != 0 || p.MovingDirection.Y != 0
+	return p.Sprinting && moving && p.Resource > 0
+}
+
+// drawSprintTrail рисует за бегущим рывком игроком гаснущие силуэты и
+// линии скорости
+func drawSprintTrail(screen *ebiten.Image, player *PlayerState, pos Point) {
+	dir := player.MovingDirection
+	length := math.Hypot(dir.X, dir.Y)
+	if length == 0 {
+		return
+	}
+	dir.X, dir.Y = dir.X/length, dir.Y/length
+	c := ClassColors[player.Class]
+	for i := sprintTrailGhosts; i >= 1; i-- {
+		alpha := uint8(90 / i)
+		x := pos.X - dir.X*sprintTrailStep*float64(i)
+		y := pos.Y - dir.Y*sprintTrailStep*float64(i)
+		ebitenutil.DrawCircle(screen, x, y, PlayerRadius-float64(i), color.RGBA{c.R, c.G, c.B, alpha})
+	}
+	// Линии скорости по бокам
+	side := Point{X: -dir.Y, Y: dir.X}
+	lineColor := color.RGBA{255, 255, 255, 120}
+	for _, offset := range []float64{-PlayerRadius, PlayerRadius} {
+		x := pos.X + side.X*offset - dir.X*PlayerRadius
+		y := pos.Y + side.Y*offset - dir.Y*PlayerRadius
+		ebitenutil.DrawLine(screen, x, y, x-dir.X*25, y-dir.Y*25, lineColor)
+	}
+}
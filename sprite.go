@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"image"
+	_ "image/png"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+//go:embed assets/sprites/*.png
+var spriteFS embed.FS
+
+const (
+	SpriteFrameSize = 48 // Размер кадра в атласе, пикселей
+	CorpseDuration  = 600 * time.Millisecond
+)
+
+// Анимации - строки атласа класса
+const (
+	AnimIdle   = "idle"
+	AnimWalk   = "walk"
+	AnimAttack = "attack"
+	AnimDeath  = "death"
+)
+
+type animation struct {
+	Row    int
+	Frames int
+	FPS    float64
+	Loop   bool
+}
+
+var animations = map[string]animation{
+	AnimIdle:   {Row: 0, Frames: 2, FPS: 2, Loop: true},
+	AnimWalk:   {Row: 1, Frames: 4, FPS: 8, Loop: true},
+	AnimAttack: {Row: 2, Frames: 3, FPS: 10},
+	AnimDeath:  {Row: 3, Frames: 4, FPS: 8},
+}
+
+// animState - текущая анимация игрока на клиенте
+type animState struct {
+	Name    string
+	Started time.Time
+	FlipX   bool // Смотрит влево
+}
+
+// corpse - анимация смерти на месте гибели, игрок к этому времени уже возродился
+type corpse struct {
+	Class    int
+	Position Point
+	Died     time.Time
+}
+
+// loadSprites загружает атласы классов. Класс без атласа рисуется кругом.
+func loadSprites() map[int]*ebiten.Image {
+	sprites := make(map[int]*ebiten.Image)
+	for class, name := range ClassNames {
+		data, err := spriteFS.ReadFile("assets/sprites/" + strings.ToLower(name) + ".png")
+		if err != nil {
+			clientLog.Info("No sprites, using primitives", "class", name, "err", err)
+			continue
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			clientLog.Warn("Invalid sprites, using primitives", "class", name, "err", err)
+			continue
+		}
+		sprites[class] = ebiten.NewImageFromImage(img)
+	}
+	return sprites
+}
+
+// updateAnimations выбирает анимацию по новому состоянию: атака по смене
+// LastAttackTime, после смерти покой, иначе ходьба или покой.
+// Вызывается в игровом цикле до замены worldState.
+func (g *Game) updateAnimations(state WorldState, now time.Time) {
+	for id, player := range state.Players {
+		anim := g.anims[id]
+		if player.MovingDirection.X != 0 {
+			anim.FlipX = player.MovingDirection.X < 0
+		}
+
+		prev, known := g.worldState.Players[id]
+		switch {
+		case known && player.Deaths > prev.Deaths:
+			// Труп рисуется по событию player_died, сам игрок уже возродился
+			anim.Name, anim.Started = AnimIdle, now
+		case known && player.LastAttackTime.After(prev.LastAttackTime):
+			anim.Name, anim.Started = AnimAttack, now
+		case anim.Name == AnimAttack && !animationDone(anim, now):
+			// Доигрываем атаку
+		case player.MovingDirection.X != 0 || player.MovingDirection.Y != 0:
+			if anim.Name != AnimWalk {
+				anim.Name, anim.Started = AnimWalk, now
+			}
+		default:
+			if anim.Name != AnimIdle {
+				anim.Name, anim.Started = AnimIdle, now
+			}
+		}
+		g.anims[id] = anim
+	}
+	for id := range g.anims {
+		if _, ok := state.Players[id]; !ok {
+			delete(g.anims, id)
+		}
+	}
+
+	alive := g.corpses[:0]
+	for _, c := range g.corpses {
+		if now.Sub(c.Died) < CorpseDuration {
+			alive = append(alive, c)
+		}
+	}
+	g.corpses = alive
+}
+
+func animationDone(anim animState, now time.Time) bool {
+	a := animations[anim.Name]
+	return !a.Loop && now.Sub(anim.Started).Seconds()*a.FPS >= float64(a.Frames)
+}
+
+// animationFrame возвращает номер кадра анимации в момент now
+func animationFrame(name string, started, now time.Time) int {
+	a := animations[name]
+	frame := int(now.Sub(started).Seconds() * a.FPS)
+	if a.Loop {
+		return frame % a.Frames
+	}
+	if frame >= a.Frames {
+		return a.Frames - 1
+	}
+	return frame
+}
+
+// drawSprite рисует кадр атласа класса с центром в pos. Возвращает false,
+// если атласа нет и нужно рисовать примитивами.
+func (g *Game) drawSprite(screen *ebiten.Image, class int, name string, started time.Time, flipX bool, pos Point, now time.Time) bool {
+	sheet, ok := g.sprites[class]
+	if !ok {
+		return false
+	}
+	a := animations[name]
+	frame := animationFrame(name, started, now)
+	rect := image.Rect(frame*SpriteFrameSize, a.Row*SpriteFrameSize, (frame+1)*SpriteFrameSize, (a.Row+1)*SpriteFrameSize)
+
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Translate(-SpriteFrameSize/2, -SpriteFrameSize/2)
+	if flipX {
+		op.GeoM.Scale(-1, 1)
+	}
+	op.GeoM.Translate(pos.X, pos.Y)
+	screen.DrawImage(sheet.SubImage(rect).(*ebiten.Image), op)
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Невидимость воина: на StealthDuration секунд игрок пропадает из
+// состояния, которое получают враги, и с их целей. Первая же атака снимает
+// невидимость. Сам игрок и союзники видят его мерцающим силуэтом.
+const (
+	StealthCost     = 30.0 // Выносливости за невидимость
+	StealthCooldown = 15.0 // Секунд между невидимостями
+	StealthDuration = 5.0
+	StealthClass    = WarriorClass
+
+	EffectStealth = "stealth"
+	TargetHidden  = "hidden" // Цель ушла в невидимость, см. clearTarget
+)
+
+// stealthed сообщает, что игрок сейчас невидим для врагов
+func stealthed(p *PlayerState) bool {
+	return findEffect(p, EffectStealth) != nil
+}
+
+// hiddenFrom сообщает, что viewer не видит other: other невидим и не
+// союзник viewer
+func hiddenFrom(viewer, other *PlayerState) bool {
+	if other.ID == viewer.ID || (viewer.Team != 0 && other.Team == viewer.Team) {
+		return false
+	}
+	return stealthed(other)
+}
+
+// anyoneStealthed сообщает, что кто-то в комнате невидим и игрокам нужно
+// рассылать разное. Вызывается в горутине комнаты.
+func (r *Room) anyoneStealthed() bool {
+	for _, player := range r.worldState.Players {
+		if stealthed(player) {
+			return true
+		}
+	}
+	return false
+}
+
+// stealth делает игрока невидимым, если класс, перезарядка и выносливость
+// позволяют. Враги теряют его из целей в currentTarget. Вызывается в
+// горутине комнаты.
+func (r *Room) stealth(player *PlayerState, now time.Time) {
+	if player.Class != StealthClass || player.Eliminated || player.Health <= 0 {
+		return
+	}
+	if now.Sub(player.LastStealthTime).Seconds() < StealthCooldown {
+		return
+	}
+	if !spendResource(player, StealthCost) {
+		return
+	}
+	player.LastStealthTime = now
+	r.addEffect(player, StatusEffect{Type: EffectStealth, Remaining: StealthDuration, SourceID: player.ID}, now)
+	r.log.Debug("Player stealthed", "player_id", player.ID)
+}
+
+// breakStealth снимает невидимость с атакующего. Вызывается в горутине
+// комнаты.
+func (r *Room) breakStealth(player *PlayerState, now time.Time) {
+	if !stealthed(player) {
+		return
+	}
+	active := player.Effects[:0]
+	for _, effect := range player.Effects {
+		if effect.Type != EffectStealth {
+			active = append(active, effect)
+		}
+	}
+	player.Effects = active
+	r.logEvent(now, EventEffectExpired, map[string]interface{}{
+		"player_id": player.ID,
+		"effect":    EffectStealth,
+		"reason":    "attack",
+	})
+	r.log.Debug("Stealth broken", "player_id", player.ID)
+}
+
+// drawStealthShimmer рисует невидимого союзника или себя: полупрозрачный
+// силуэт с бегущей по краю рябью
+func drawStealthShimmer(screen *ebiten.Image, player *PlayerState, pos Point, now time.Time) {
+	c := ClassColors[player.Class]
+	phase := float64(now.UnixMilli()%1000) / 1000 * 2 * math.Pi
+	alpha := uint8(50 + 30*math.Sin(phase))
+	ebitenutil.DrawCircle(screen, pos.X, pos.Y, PlayerRadius, color.RGBA{c.R, c.G, c.B, alpha})
+	for i := 0; i < 3; i++ {
+		angle := phase + float64(i)*2*math.Pi/3
+		x := pos.X + math.Cos(angle)*PlayerRadius
+		y := pos.Y + math.Sin(angle)*PlayerRadius
+		ebitenutil.DrawCircle(screen, x, y, 3, color.RGBA{220, 220, 255, 140})
+	}
+}
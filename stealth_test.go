@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"meatgrinder/protocol"
+)
+
+// Урон по невидимому и по игроку вне обзора не уходит тем, кто их не видит:
+// позиция в сообщении выдала бы, где они стоят
+func TestEventsHiddenFromViewers(t *testing.T) {
+	r, _ := newTestRoom(testConfig())
+	players := map[string]*PlayerState{
+		"viewer":  {ID: 1, Class: MageClass, Position: Point{X: 500, Y: 500}},
+		"stealth": {ID: 2, Class: WarriorClass, Position: Point{X: 550, Y: 500}, Effects: []StatusEffect{{Type: EffectStealth, Remaining: StealthDuration}}},
+		"near":    {ID: 3, Class: WarriorClass, Position: Point{X: 500, Y: 600}},
+		"far":     {ID: 4, Class: WarriorClass, Position: Point{X: 500 + DefaultViewRadius + 100, Y: 500}},
+	}
+	for _, p := range players {
+		p.Health = PlayerMaxHealth
+		r.worldState.Players[p.ID] = p
+	}
+	r.rebuildGrid()
+	for _, name := range []string{"stealth", "near", "far"} {
+		target := players[name]
+		r.push(protocol.MsgDamage, protocol.Damage{AttackerID: players["viewer"].ID, TargetID: target.ID, Position: target.Position.message()}, target.ID)
+	}
+	r.push(protocol.MsgMatchStats, protocol.MatchStats{})
+
+	received := func(name string) []string {
+		client := &clientConnection{playerID: players[name].ID, send: make(chan []byte, 16), done: make(chan struct{})}
+		view, _ := r.visibleView(client)
+		stateFrame{client: client, view: view}.send(r.outbox, nil)
+		close(client.send)
+		var got []string
+		for b := range client.send {
+			msg, err := protocol.NewDecoder(bytes.NewReader(b)).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var damage protocol.Damage
+			if msg.Type == protocol.MsgDamage && msg.Decode(&damage) == nil {
+				for other, p := range players {
+					if p.ID == damage.TargetID {
+						got = append(got, other)
+					}
+				}
+				continue
+			}
+			got = append(got, msg.Type)
+		}
+		return got
+	}
+	for name, want := range map[string][]string{
+		"viewer":  {"near", protocol.MsgMatchStats},
+		"stealth": {"stealth", "near", protocol.MsgMatchStats},
+		"far":     {"far", protocol.MsgMatchStats},
+	} {
+		got := received(name)
+		if len(got) != len(want) {
+			t.Errorf("%s received %v, want %v", name, got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s received %v, want %v", name, got, want)
+				break
+			}
+		}
+	}
+}
+
+// Враг за -reveal-distance уходит клиенту без имени, здоровья и уровня, а
+// контрольная сумма снимка сходится с тем, что клиент получил
+func TestConcealedPlayersStripped(t *testing.T) {
+	cfg := testConfig()
+	cfg.RevealDistance = 200
+	r, _ := newTestRoom(cfg)
+	viewer := &PlayerState{ID: 1, Name: "viewer", Class: MageClass, Position: Point{X: 500, Y: 500}, Health: PlayerMaxHealth, Level: 1}
+	enemy := &PlayerState{ID: 2, Name: "enemy", Class: WarriorClass, Position: Point{X: 800, Y: 500}, Health: 77, Level: 3, Kills: 2,
+		Effects: []StatusEffect{{Type: EffectSlow, Remaining: 1}}}
+	r.worldState.Players[viewer.ID] = viewer
+	r.worldState.Players[enemy.ID] = enemy
+	r.rebuildGrid()
+
+	client := &clientConnection{playerID: viewer.ID}
+	view, _ := r.visibleView(client)
+	if !view.concealed[enemy.ID] {
+		t.Fatalf("enemy at %v is not concealed from %v", enemy.Position, viewer.Position)
+	}
+	snapshot, err := r.snapshotState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := snapshot.encode(view, r.worldState.viewChecksum(view), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := protocol.NewDecoder(bytes.NewReader(data)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state WorldState
+	if err := msg.Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := state.Players[enemy.ID]
+	if !ok {
+		t.Fatal("concealed enemy missing from the snapshot")
+	}
+	want := PlayerState{ID: enemy.ID, Class: enemy.Class, Position: enemy.Position, Concealed: true}
+	if got.ID != want.ID || got.Class != want.Class || got.Position != want.Position || !got.Concealed ||
+		got.Name != "" || got.Health != 0 || got.Level != 0 || got.Kills != 0 || len(got.Effects) != 0 {
+		t.Errorf("concealed enemy = %+v, want only %+v", got, want)
+	}
+	if own := state.Players[viewer.ID]; own.Name != viewer.Name || own.Health != viewer.Health {
+		t.Errorf("viewer sees itself as %+v", own)
+	}
+	if local := state.viewChecksum(nil); local != state.Checksum {
+		t.Errorf("client checksum %d, server %d", local, state.Checksum)
+	}
+}
+
+// События о входе, смерти и возрождении уходят всем, но позицию в них
+// получают только те, кто видит игрока
+func TestEventPositionsHidden(t *testing.T) {
+	r, clock := newTestRoom(testConfig())
+	viewer := &PlayerState{ID: 1, Class: MageClass, Position: Point{X: 500, Y: 500}, Health: PlayerMaxHealth}
+	stealthed := &PlayerState{ID: 2, Class: WarriorClass, Position: Point{X: 550, Y: 500}, Health: PlayerMaxHealth,
+		Effects: []StatusEffect{{Type: EffectStealth, Remaining: StealthDuration}}}
+	r.worldState.Players[viewer.ID] = viewer
+	r.worldState.Players[stealthed.ID] = stealthed
+	r.rebuildGrid()
+	for _, eventType := range []string{EventPlayerJoined, EventPlayerDeath, EventPlayerRespawn} {
+		r.logEvent(clock.Now(), eventType, map[string]interface{}{
+			"player_id": stealthed.ID,
+			"position":  stealthed.Position,
+		})
+	}
+
+	for _, p := range []*PlayerState{viewer, stealthed} {
+		client := &clientConnection{playerID: p.ID, send: make(chan []byte, 16), done: make(chan struct{})}
+		view, _ := r.visibleView(client)
+		stateFrame{client: client, view: view}.send(r.outbox, nil)
+		close(client.send)
+		var events int
+		for b := range client.send {
+			msg, err := protocol.NewDecoder(bytes.NewReader(b)).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var event protocol.Event
+			if msg.Type != protocol.MsgEvent || msg.Decode(&event) != nil {
+				continue
+			}
+			events++
+			var death DeathEvent
+			if err := json.Unmarshal(event.Data, &death); err != nil {
+				t.Fatal(err)
+			}
+			if sees := p == stealthed; (death.Position != nil) != sees {
+				t.Errorf("player %d got %s with position %v", p.ID, event.Type, death.Position)
+			}
+		}
+		if events != 3 {
+			t.Errorf("player %d got %d events, want 3", p.ID, events)
+		}
+	}
+}
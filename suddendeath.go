@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// Правила овертайма, если к концу матча первое место делят несколько
+// игроков или команд (-sudden-death). Овертайм длится, пока ничья не
+// разрешится, но не дольше SuddenDeathDuration.
+const (
+	SuddenDeathOff          = "off"           // Матч кончается ничьей
+	SuddenDeathNoRespawn    = "no-respawn"    // Погибшие не возрождаются
+	SuddenDeathDoubleDamage = "double-damage" // Весь урон удвоен
+
+	SuddenDeathDuration         = 60.0 // Наибольшая длительность овертайма, секунды
+	SuddenDeathDamageMultiplier = 2.0
+	DefaultMatchDuration        = 3 * time.Minute
+
+	EventSuddenDeath = "sudden_death"
+)
+
+var SuddenDeathRules = []string{SuddenDeathOff, SuddenDeathNoRespawn, SuddenDeathDoubleDamage}
+
+// timeUp решает, что делать, когда время матча или овертайма вышло
+func (r *Room) timeUp(now time.Time) {
+	match := &r.worldState.Match
+	if match.SuddenDeath != "" || r.cfg.SuddenDeath == SuddenDeathOff || !r.scoreTied() {
+		r.endMatch(now)
+		return
+	}
+	match.SuddenDeath = r.cfg.SuddenDeath
+	match.Remaining = SuddenDeathDuration
+	match.Duration = SuddenDeathDuration
+	r.logEvent(now, EventSuddenDeath, map[string]interface{}{
+		"rule":     match.SuddenDeath,
+		"duration": SuddenDeathDuration,
+	})
+	r.log.Info("Sudden death", "rule", match.SuddenDeath)
+}
+
+// overtimeDecided сообщает, что овертайм можно заканчивать: ничьей больше
+// нет или без возрождений живые остались только у одной стороны. Сторона -
+// команда, а игрок без команды сам себе сторона.
+func (r *Room) overtimeDecided() bool {
+	if !r.scoreTied() {
+		return true
+	}
+	if r.worldState.Match.SuddenDeath != SuddenDeathNoRespawn {
+		return false
+	}
+	sides := make(map[int]bool)
+	for id, player := range r.worldState.Players {
+		if player.Eliminated {
+			continue
+		}
+		side := player.Team
+		if side == 0 {
+			side = -id
+		}
+		sides[side] = true
+	}
+	return len(sides) <= 1
+}
+
+// scoreTied сообщает, что первое место делят несколько игроков, а в
+// командных режимах - несколько команд
+func (r *Room) scoreTied() bool {
+	var scores []float64
+	if hud := r.mode.HUD(r); hud != nil && len(hud.TeamScores) > 0 {
+		for _, score := range hud.TeamScores {
+			scores = append(scores, float64(score))
+		}
+	} else {
+		for _, player := range r.worldState.Players {
+			scores = append(scores, player.Score)
+		}
+	}
+	if len(scores) < 2 {
+		return false
+	}
+	slices.Sort(scores)
+	return scores[len(scores)-1] == scores[len(scores)-2]
+}
+
+// suddenDeathModifier удваивает урон в овертайме double-damage
+func suddenDeathModifier(hit *Hit) {
+	if hit.Overtime == SuddenDeathDoubleDamage {
+		hit.Amount *= SuddenDeathDamageMultiplier
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "math"
+
+// Цель сбрасывается, когда уходит дальше радиуса атаки в TargetLeashFactor
+// раз: короткий отход не снимает атаку, а через всю карту она не тянется
+const TargetLeashFactor = 1.5
+
+// Почему сброшена цель
+const (
+	TargetGone       = "gone"
+	TargetDied       = "died"
+	TargetOutOfRange = "out_of_range"
+	TargetCancelled  = "cancelled"
+)
+
+// currentTarget возвращает цель игрока, а пропавшую, выбывшую, невидимую
+// или ушедшую далеко цель сбрасывает. Вызывается в горутине комнаты.
+func (r *Room) currentTarget(player *PlayerState) *PlayerState {
+	if player.Target == 0 {
+		return nil
+	}
+	target, ok := r.worldState.Players[player.Target]
+	switch {
+	case !ok || target.Eliminated:
+		r.clearTarget(player, TargetGone)
+	case hiddenFrom(player, target):
+		r.clearTarget(player, TargetHidden)
+	case !withinLeash(player, target):
+		r.clearTarget(player, TargetOutOfRange)
+	default:
+		return target
+	}
+	return nil
+}
+
+// withinLeash проверяет, можно ли держать target целью
+func withinLeash(player, target *PlayerState) bool {
+	leash := balance().Attacks[player.Class].Range * TargetLeashFactor
+	return math.Hypot(player.Position.X-target.Position.X, player.Position.Y-target.Position.Y) <= leash
+}
+
+// clearTarget снимает атаку. Вызывается в горутине комнаты.
+func (r *Room) clearTarget(player *PlayerState, reason string) {
+	if player.Target == 0 {
+		return
+	}
+	r.log.Debug("Target cleared", "player_id", player.ID, "target_id", player.Target, "reason", reason)
+	player.Target = 0
+}
+
+// forgetTarget снимает атаки на погибшего: он возродится в другом месте
+// карты, и гнаться за ним туда никто не просил
+func (r *Room) forgetTarget(id int) {
+	for _, playerID := range sortedIDs(r.worldState.Players) {
+		if player := r.worldState.Players[playerID]; player.Target == id {
+			r.clearTarget(player, TargetDied)
+		}
+	}
+}
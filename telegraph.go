@@ -0,0 +1,80 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Подсказки атаки: кольцо радиуса атаки своего игрока, подсветка цели под
+// курсором и круг взрыва вокруг нее, если атака бьет по области. Радиусы
+// берутся из правил, пришедших с сервера, поэтому меняются вместе с
+// балансом.
+var (
+	rangeRingColor    = color.RGBA{255, 255, 255, 50}
+	rangeRingActive   = color.RGBA{255, 220, 120, 110} // Под курсором есть цель
+	hoverTargetColor  = color.RGBA{255, 80, 80, 220}
+	hoverChaseColor   = color.RGBA{200, 200, 200, 160} // Цель дальше радиуса, к ней придется подойти
+	splashRingColor   = color.RGBA{255, 120, 0, 160}
+	splashFillColor   = color.RGBA{255, 120, 0, 30}
+	splashVictimColor = color.RGBA{255, 160, 0, 200}
+)
+
+// hoveredTarget - игрок, которого выберет атака по клику в текущей точке
+// курсора, 0 - никого
+func (g *Game) hoveredTarget() int {
+	x, y := ebiten.CursorPosition()
+	return g.findClosestPlayer(g.camera.toWorld(x, y))
+}
+
+// drawTelegraphs рисует подсказки атаки под игроками. Вызывается из
+// drawWorld.
+func (g *Game) drawTelegraphs(screen *ebiten.Image) {
+	me, ok := g.worldState.Players[g.playerID]
+	if !ok || me.Eliminated {
+		return
+	}
+	rules := g.rules.Classes[me.Class]
+	if rules.AttackRange <= 0 {
+		return
+	}
+	myPos := g.playerPositions[me.ID]
+	center := g.camera.toScreen(myPos)
+
+	hovered, ok := g.worldState.Players[g.hoveredTarget()]
+	ring := rangeRingColor
+	if ok {
+		ring = rangeRingActive
+	}
+	vector.StrokeCircle(screen, float32(center.X), float32(center.Y), float32(rules.AttackRange), 1, ring, true)
+	if !ok {
+		return
+	}
+
+	targetPos := g.playerPositions[hovered.ID]
+	target := g.camera.toScreen(targetPos)
+	outline := hoverTargetColor
+	if math.Hypot(targetPos.X-myPos.X, targetPos.Y-myPos.Y) > rules.AttackRange {
+		outline = hoverChaseColor
+	}
+	vector.StrokeCircle(screen, float32(target.X), float32(target.Y), PlayerRadius+4, 2, outline, true)
+
+	// Взрыв заденет всех вокруг цели, кроме своих
+	if rules.SplashRadius <= 0 {
+		return
+	}
+	vector.DrawFilledCircle(screen, float32(target.X), float32(target.Y), float32(rules.SplashRadius), splashFillColor, true)
+	vector.StrokeCircle(screen, float32(target.X), float32(target.Y), float32(rules.SplashRadius), 1, splashRingColor, true)
+	for _, other := range g.worldState.Players {
+		if other.ID == me.ID || other.ID == hovered.ID || other.Eliminated || (other.Team != 0 && other.Team == me.Team) {
+			continue
+		}
+		pos := g.playerPositions[other.ID]
+		if math.Hypot(pos.X-targetPos.X, pos.Y-targetPos.Y) < rules.SplashRadius {
+			p := g.camera.toScreen(pos)
+			vector.StrokeCircle(screen, float32(p.X), float32(p.Y), PlayerRadius+3, 1, splashVictimColor, true)
+		}
+	}
+}
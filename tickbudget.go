@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	TickWarnInterval = 5 * time.Second // Не чаще этого предупреждаем о превышении
+
+	// Если средний тик дольше этой доли бюджета, рассылка прореживается,
+	// а если короче BudgetLowWater - возвращается обратно
+	BudgetHighWater = 0.8
+	BudgetLowWater  = 0.4
+	// Сколько ждем после изменения частоты рассылки, прежде чем менять снова
+	BudgetCooldown = 2 * time.Second
+	// Во столько раз реже заданного рассылка замедляться не будет
+	MaxBroadcastInterval = 4
+)
+
+// tickBudget следит за длительностью тиков комнаты и решает, в каких тиках
+// рассылать состояние. Используется только горутиной run.
+type tickBudget struct {
+	room     string
+	budget   time.Duration // Столько может длиться тик, чтобы не отставать
+	period   time.Duration // Период рассылки состояния
+	adaptive bool          // Прореживать рассылку при нехватке времени
+
+	average     float64       // Скользящее среднее длительности тика в долях бюджета
+	interval    int           // Во сколько раз рассылка сейчас реже period
+	accumulated time.Duration // Время симуляции с прошлой рассылки
+	cooldown    time.Time
+
+	overruns    int // Превышения с последнего предупреждения
+	worst       time.Duration
+	lastWarning time.Time
+}
+
+func newTickBudget(room string, tickRate, broadcastRate int, adaptive bool) *tickBudget {
+	return &tickBudget{
+		room:     room,
+		budget:   time.Second / time.Duration(tickRate),
+		period:   time.Second / time.Duration(broadcastRate),
+		adaptive: adaptive,
+		interval: 1,
+	}
+}
+
+// shouldBroadcast сообщает, нужно ли рассылать состояние в этом тике.
+// Время тиков копится, поэтому нецелое отношение частот (60 и 25)
+// в среднем дает ровно заданную частоту рассылки.
+func (b *tickBudget) shouldBroadcast() bool {
+	b.accumulated += b.budget
+	period := b.period * time.Duration(b.interval)
+	if b.accumulated < period {
+		return false
+	}
+	b.accumulated -= period
+	// После долгой паузы не рассылаем несколько раз подряд
+	if b.accumulated > period {
+		b.accumulated = 0
+	}
+	return true
+}
+
+// observe учитывает длительность тика: simulate - шаг симуляции,
+// broadcast - рассылка
+func (b *tickBudget) observe(simulate, broadcast time.Duration, now time.Time) {
+	total := simulate + broadcast
+	metrics.ObserveTickPhases(b.room, simulate, broadcast, total > b.budget)
+
+	if total > b.budget {
+		b.overruns++
+		if total > b.worst {
+			b.worst = total
+		}
+	}
+	if b.overruns > 0 && now.Sub(b.lastWarning) >= TickWarnInterval {
+		simLog.Warn("Tick budget exceeded", "room", b.room, "budget", b.budget, "times", b.overruns,
+			"worst", b.worst, "simulate", simulate, "broadcast", broadcast)
+		b.overruns, b.worst, b.lastWarning = 0, 0, now
+	}
+
+	const smoothing = 0.1
+	b.average += (float64(total)/float64(b.budget) - b.average) * smoothing
+	if !b.adaptive || now.Before(b.cooldown) {
+		return
+	}
+	switch {
+	case b.average > BudgetHighWater && b.interval < MaxBroadcastInterval:
+		b.interval++
+	case b.average < BudgetLowWater && b.interval > 1:
+		b.interval--
+	default:
+		return
+	}
+	simLog.Info("Broadcast interval changed", "room", b.room, "budget_used", fmt.Sprintf("%.0f%%", b.average*100),
+		"interval", b.period*time.Duration(b.interval))
+	b.cooldown = now.Add(BudgetCooldown)
+	metrics.SetBroadcastInterval(b.room, b.interval)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Отладочный журнал тиков (-debug-ticks N): комната помнит сводку последних
+// N проходов цикла тиков - сколько в мире сущностей, хеш их позиций и
+// сколько длился тик. Журнал отдается по GET /admin/rooms/{room}/ticks,
+// поэтому для разбора рассинхронизации или зависания не нужно добавлять
+// логи и перезапускать сервер.
+
+// TickSummary - сводка одного прохода цикла тиков
+type TickSummary struct {
+	Tick        uint64    `json:"tick"` // Последний шаг симуляции прохода
+	Time        time.Time `json:"time"`
+	Steps       int       `json:"steps"` // Шагов симуляции за проход, 0 - часы не дошли до шага
+	Players     int       `json:"players"`
+	Entities    int       `json:"entities"`     // Игроки, предметы, снаряды и прислужники
+	Positions   string    `json:"positions"`    // FNV-1a позиций игроков, снарядов и прислужников
+	SimulateMS  float64   `json:"simulate_ms"`  // Сколько шла симуляция
+	BroadcastMS float64   `json:"broadcast_ms"` // Сколько шла рассылка, 0 - в этом тике ее не было
+	Paused      bool      `json:"paused,omitempty"`
+}
+
+// tickLog - кольцевой буфер последних сводок. Используется только
+// горутиной комнаты.
+type tickLog struct {
+	entries []TickSummary
+	next    int
+	full    bool
+}
+
+// newTickLog создает журнал на size сводок. nil - журнал выключен.
+func newTickLog(size int) *tickLog {
+	if size <= 0 {
+		return nil
+	}
+	return &tickLog{entries: make([]TickSummary, size)}
+}
+
+func (l *tickLog) add(s TickSummary) {
+	l.entries[l.next] = s
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list возвращает копию сводок, от старых к новым
+func (l *tickLog) list() []TickSummary {
+	if !l.full {
+		return append([]TickSummary(nil), l.entries[:l.next]...)
+	}
+	return append(append([]TickSummary(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// recordTick записывает сводку прохода цикла тиков, если журнал включен.
+// fromTick - шаг симуляции перед проходом. Вызывается в горутине комнаты.
+func (r *Room) recordTick(fromTick uint64, simulate, broadcast time.Duration, now time.Time) {
+	if r.ticks == nil {
+		return
+	}
+	w := &r.worldState
+	r.ticks.add(TickSummary{
+		Tick:        r.tick,
+		Time:        now,
+		Steps:       int(r.tick - fromTick),
+		Players:     len(w.Players),
+		Entities:    len(w.Players) + len(w.Items) + len(w.Projectiles) + len(w.Minions),
+		Positions:   r.positionsHash(),
+		SimulateMS:  float64(simulate) / float64(time.Millisecond),
+		BroadcastMS: float64(broadcast) / float64(time.Millisecond),
+		Paused:      w.Paused,
+	})
+}
+
+// positionsHash - хеш позиций движущихся сущностей в порядке ID. Совпадает
+// у двух комнат с одинаковым ходом игры, поэтому по нему видно, с какого
+// тика они разошлись. Вызывается в горутине комнаты.
+func (r *Room) positionsHash() string {
+	h := fnv.New64a()
+	var buf [8]byte
+	write := func(id int, p Point) {
+		binary.LittleEndian.PutUint64(buf[:], uint64(id))
+		h.Write(buf[:])
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(p.X))
+		h.Write(buf[:])
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(p.Y))
+		h.Write(buf[:])
+	}
+	for _, id := range sortedIDs(r.worldState.Players) {
+		write(id, r.worldState.Players[id].Position)
+	}
+	for _, id := range sortedIDs(r.worldState.Projectiles) {
+		write(id, r.worldState.Projectiles[id].Position)
+	}
+	for _, id := range sortedIDs(r.worldState.Minions) {
+		write(id, r.worldState.Minions[id].Position)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// tickSummaries - копия журнала тиков комнаты. false - журнал выключен.
+func (r *Room) tickSummaries() ([]TickSummary, bool) {
+	var summaries []TickSummary
+	enabled := false
+	r.do(func() {
+		if r.ticks != nil {
+			summaries, enabled = r.ticks.list(), true
+		}
+	})
+	return summaries, enabled
+}
+
+// handleTicks - GET /admin/rooms/{room}/ticks
+func (s *Server) handleTicks(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAuthorized(w, r) {
+		return
+	}
+	room, err := s.findRoom(r.PathValue("room"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	summaries, ok := room.tickSummaries()
+	if !ok {
+		http.Error(w, "tick log is disabled, start the server with -debug-ticks", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, summaries)
+}
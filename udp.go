@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Транспорт поверх UDP. Каждый пакет - датаграмма с заголовком из типа
+// пакета и номера. Надежные пакеты (вход, события, действия игрока)
+// подтверждаются, переотправляются до подтверждения и доставляются строго
+// по порядку. Снимки состояния идут без гарантий: потерянный снимок просто
+// пропадает, а опоздавший старее уже полученного отбрасывается - следующий
+// все равно придет через тик.
+//
+// Датаграмма не длиннее MTU, чтобы ее не дробил IP: потеря одного IP-куска
+// теряет всю датаграмму. Надежное сообщение длиннее MaxDatagramSize
+// делится на надежные пакеты подряд, все куски, кроме последнего, идут
+// как packetFragment. Собирать их обратно не нужно: Read отдает поток, и
+// куски приходят в нем по порядку. Снимок делится на куски с номером и
+// числом кусков и собирается на приемнике, без одного куска пропадает
+// весь. Снимок из больше чем MaxStateFragments кусков уходит надежно.
+// Собранный снимок попадает в поток только между надежными сообщениями,
+// чтобы не разорвать строку.
+//
+// Рукопожатия нет: сессию открывает первый надежный пакет с незнакомого
+// адреса, а адрес отправителя UDP легко подделать. Поэтому новых сессий в
+// секунду не больше MaxNewSessions. Лишний первый пакет просто теряется, и
+// настоящий клиент войдет, когда его переотправит.
+
+const (
+	TransportTCP = "tcp"
+	TransportUDP = "udp"
+
+	MaxDatagramSize   = 1200 // Данные в датаграмме: с заголовками UDP и IP влезает в MTU
+	MaxStateFragments = 64   // Снимок длиннее уходит надежно
+	ResendInterval    = 100 * time.Millisecond
+	MaxResends        = 50 // Около 5 секунд без подтверждения - связь потеряна
+	KeepaliveInterval = time.Second
+	SessionTimeout    = 10 * time.Second
+	MaxOutOfOrder     = 256  // Сколько надежных пакетов из будущего держим, пока не придет пропущенный
+	MaxPendingPackets = 1024 // Неподтвержденные надежные пакеты
+	MaxIncoming       = 1024 // Принятые, но еще не прочитанные сообщения
+	MaxNewSessions    = 32   // Новых сессий в секунду на сервере
+
+	udpHeaderSize      = 5 // Тип пакета и номер
+	fragmentHeaderSize = 4 // Номер куска снимка и число кусков
+	maxPacketSize      = udpHeaderSize + fragmentHeaderSize + MaxDatagramSize
+)
+
+// Типы пакетов
+const (
+	packetReliable byte = iota
+	packetUnreliable
+	packetAck
+	packetKeepalive
+	packetClose
+	packetFragment // Надежный пакет, за которым идет продолжение сообщения
+)
+
+var errSessionTimeout = errors.New("udp session timed out")
+
+// unreliableWriter - транспорт, который умеет отправлять снимки состояния
+// без гарантии доставки
+type unreliableWriter interface {
+	WriteUnreliable(b []byte) error
+}
+
+// listen открывает слушающий сокет выбранного транспорта
+func listen(transport, addr string) (net.Listener, error) {
+	switch transport {
+	case TransportTCP:
+		return net.Listen("tcp", addr)
+	case TransportUDP:
+		return listenUDP(addr)
+	}
+	return nil, fmt.Errorf("unknown transport %q", transport)
+}
+
+// dialTransport подключается к серверу по выбранному транспорту
+func dialTransport(transport, addr string) (net.Conn, error) {
+	switch transport {
+	case TransportTCP:
+		return net.DialTimeout("tcp", addr, ConnectTimeout)
+	case TransportUDP:
+		return dialUDP(addr)
+	}
+	return nil, fmt.Errorf("unknown transport %q", transport)
+}
+
+type pendingPacket struct {
+	kind    byte
+	data    []byte
+	sent    time.Time
+	resends int
+}
+
+// earlyPacket - надежный пакет, пришедший раньше пропущенного
+type earlyPacket struct {
+	data []byte
+	last bool // Последний кусок сообщения
+}
+
+// udpSession - соединение с одним собеседником, реализует net.Conn.
+// Read отдает поток сообщений, как TCP, поэтому протокол над ним не меняется.
+type udpSession struct {
+	conn    *net.UDPConn
+	remote  *net.UDPAddr // nil у клиента: сокет подключен к серверу
+	onClose func(*udpSession)
+
+	mu             sync.Mutex
+	nextSeq        uint32 // Номер следующего надежного пакета
+	pending        map[uint32]*pendingPacket
+	nextUnreliable uint32
+	expected       uint32                 // Номер надежного пакета, который доставим следующим
+	early          map[uint32]earlyPacket // Надежные пакеты, пришедшие раньше пропущенного
+	lastUnreliable uint32
+	midMessage     bool     // Надежное сообщение доставлено не целиком
+	heldState      []byte   // Собранный снимок ждет конца надежного сообщения
+	stateSeq       uint32   // Номер собираемого снимка
+	stateParts     [][]byte // Куски собираемого снимка, nil - еще не пришел
+	stateMissing   int
+	lastHeard      time.Time
+	lastSent       time.Time
+	incoming       [][]byte
+	readBuf        []byte
+	readDeadline   time.Time
+	err            error
+
+	readable  chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newUDPSession(conn *net.UDPConn, remote *net.UDPAddr, onClose func(*udpSession)) *udpSession {
+	now := time.Now()
+	s := &udpSession{
+		conn:      conn,
+		remote:    remote,
+		onClose:   onClose,
+		nextSeq:   1,
+		pending:   make(map[uint32]*pendingPacket),
+		expected:  1,
+		early:     make(map[uint32]earlyPacket),
+		lastHeard: now,
+		lastSent:  now,
+		readable:  make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+	go s.maintain()
+	return s
+}
+
+// dialUDP создает клиентскую сессию. Сервер отзовется только на первое
+// сообщение, поэтому недоступность обнаружится по таймауту подтверждения.
+func dialUDP(addr string) (*udpSession, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	s := newUDPSession(conn, nil, func(*udpSession) { conn.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				s.closeWith(err, false)
+				return
+			}
+			s.handlePacket(buf[:n])
+		}
+	}()
+	return s, nil
+}
+
+// send отправляет пакет. Вызывается под s.mu.
+func (s *udpSession) send(kind byte, seq uint32, payload []byte) error {
+	pkt := make([]byte, udpHeaderSize+len(payload))
+	pkt[0] = kind
+	binary.BigEndian.PutUint32(pkt[1:], seq)
+	copy(pkt[udpHeaderSize:], payload)
+	s.lastSent = time.Now()
+	var err error
+	if s.remote != nil {
+		_, err = s.conn.WriteToUDP(pkt, s.remote)
+	} else {
+		_, err = s.conn.Write(pkt)
+	}
+	return err
+}
+
+// Write отправляет сообщение надежно
+func (s *udpSession) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	if err := s.writeReliable(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeReliable делит сообщение на пакеты не длиннее MaxDatagramSize и
+// отправляет их надежно. Последний кусок - packetReliable, остальные -
+// packetFragment. Сообщение, которое не влезает в окно отправки,
+// не отправляется совсем. Вызывается под s.mu.
+func (s *udpSession) writeReliable(b []byte) error {
+	fragments := max((len(b)+MaxDatagramSize-1)/MaxDatagramSize, 1)
+	if len(s.pending)+fragments > MaxPendingPackets {
+		return errors.New("udp send window is full")
+	}
+	now := time.Now()
+	for i := 0; i < fragments; i++ {
+		seq := s.nextSeq
+		s.nextSeq++
+		kind := packetFragment
+		if i == fragments-1 {
+			kind = packetReliable
+		}
+		data := append([]byte(nil), b[i*MaxDatagramSize:min((i+1)*MaxDatagramSize, len(b))]...)
+		s.pending[seq] = &pendingPacket{kind: kind, data: data, sent: now}
+		// Ошибку отправки не возвращаем: пакет уйдет повторно
+		s.send(kind, seq, data)
+	}
+	return nil
+}
+
+// WriteUnreliable отправляет снимок без подтверждения, по кускам не
+// длиннее MaxDatagramSize. Снимок больше MaxStateFragments кусков уходит
+// надежно.
+func (s *udpSession) WriteUnreliable(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	fragments := max((len(b)+MaxDatagramSize-1)/MaxDatagramSize, 1)
+	if fragments > MaxStateFragments {
+		return s.writeReliable(b)
+	}
+	s.nextUnreliable++
+	for i := 0; i < fragments; i++ {
+		chunk := b[i*MaxDatagramSize : min((i+1)*MaxDatagramSize, len(b))]
+		payload := make([]byte, fragmentHeaderSize+len(chunk))
+		binary.BigEndian.PutUint16(payload, uint16(i))
+		binary.BigEndian.PutUint16(payload[2:], uint16(fragments))
+		copy(payload[fragmentHeaderSize:], chunk)
+		s.send(packetUnreliable, s.nextUnreliable, payload)
+	}
+	return nil
+}
+
+// handlePacket разбирает полученную датаграмму
+func (s *udpSession) handlePacket(pkt []byte) {
+	if len(pkt) < udpHeaderSize {
+		return
+	}
+	kind, seq, payload := pkt[0], binary.BigEndian.Uint32(pkt[1:]), pkt[udpHeaderSize:]
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.lastHeard = time.Now()
+	switch kind {
+	case packetReliable, packetFragment:
+		s.send(packetAck, seq, nil)
+		ahead := int32(seq - s.expected)
+		switch {
+		case ahead < 0:
+			// Повтор уже доставленного: ack потерялся
+		case ahead == 0:
+			s.deliverReliable(payload, kind == packetReliable)
+			s.expected++
+			for {
+				next, ok := s.early[s.expected]
+				if !ok {
+					break
+				}
+				delete(s.early, s.expected)
+				s.deliverReliable(next.data, next.last)
+				s.expected++
+			}
+		case ahead < MaxOutOfOrder:
+			s.early[seq] = earlyPacket{data: append([]byte(nil), payload...), last: kind == packetReliable}
+		}
+	case packetUnreliable:
+		s.receiveState(seq, payload)
+	case packetAck:
+		delete(s.pending, seq)
+	case packetClose:
+		s.mu.Unlock()
+		s.closeWith(io.EOF, false)
+		return
+	}
+	s.mu.Unlock()
+}
+
+// deliverReliable доставляет кусок надежного сообщения. Снимок, собранный
+// посреди сообщения, доставляется после его конца. Вызывается под s.mu.
+func (s *udpSession) deliverReliable(payload []byte, last bool) {
+	s.deliver(payload)
+	s.midMessage = !last
+	if last && s.heldState != nil {
+		s.deliver(s.heldState)
+		s.heldState = nil
+	}
+}
+
+// receiveState собирает снимок из кусков. Кусок снимка старее
+// доставленного или собираемого отбрасывается, а недособранный снимок
+// пропадает, как только приходит кусок более нового. Вызывается под s.mu.
+func (s *udpSession) receiveState(seq uint32, payload []byte) {
+	if len(payload) < fragmentHeaderSize || int32(seq-s.lastUnreliable) <= 0 {
+		return
+	}
+	index := int(binary.BigEndian.Uint16(payload))
+	count := int(binary.BigEndian.Uint16(payload[2:]))
+	if count == 0 || count > MaxStateFragments || index >= count {
+		return
+	}
+	if s.stateParts == nil || seq != s.stateSeq {
+		if s.stateParts != nil && int32(seq-s.stateSeq) < 0 {
+			return
+		}
+		s.stateSeq, s.stateParts, s.stateMissing = seq, make([][]byte, count), count
+	}
+	if len(s.stateParts) != count || s.stateParts[index] != nil {
+		return
+	}
+	s.stateParts[index] = append([]byte{}, payload[fragmentHeaderSize:]...)
+	s.stateMissing--
+	if s.stateMissing > 0 {
+		return
+	}
+	state := bytes.Join(s.stateParts, nil)
+	s.lastUnreliable, s.stateParts = seq, nil
+	if s.midMessage {
+		// Более новый снимок заменяет ждущий
+		s.heldState = state
+		return
+	}
+	s.deliver(state)
+}
+
+// deliver кладет сообщение в очередь чтения. Вызывается под s.mu.
+func (s *udpSession) deliver(payload []byte) {
+	if len(s.incoming) >= MaxIncoming {
+		netLog.Warn("UDP receive queue overflow", "remote", s.RemoteAddr().String())
+		go s.closeWith(errors.New("udp receive queue overflow"), true)
+		return
+	}
+	s.incoming = append(s.incoming, append([]byte(nil), payload...))
+	select {
+	case s.readable <- struct{}{}:
+	default:
+	}
+}
+
+// maintain переотправляет неподтвержденные пакеты, шлет keepalive и
+// закрывает сессию, если собеседник пропал
+func (s *udpSession) maintain() {
+	ticker := time.NewTicker(ResendInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.closed:
+			return
+		}
+		now := time.Now()
+		s.mu.Lock()
+		var err error
+		if now.Sub(s.lastHeard) > SessionTimeout {
+			err = errSessionTimeout
+		}
+		for seq, p := range s.pending {
+			if now.Sub(p.sent) < ResendInterval {
+				continue
+			}
+			if p.resends >= MaxResends {
+				err = errSessionTimeout
+				break
+			}
+			p.resends++
+			p.sent = now
+			s.send(p.kind, seq, p.data)
+		}
+		if err == nil && now.Sub(s.lastSent) >= KeepaliveInterval {
+			s.send(packetKeepalive, 0, nil)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			s.closeWith(err, true)
+			return
+		}
+	}
+}
+
+func (s *udpSession) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if len(s.readBuf) == 0 && len(s.incoming) > 0 {
+			s.readBuf = s.incoming[0]
+			s.incoming[0] = nil
+			s.incoming = s.incoming[1:]
+		}
+		if len(s.readBuf) > 0 {
+			n := copy(p, s.readBuf)
+			s.readBuf = s.readBuf[n:]
+			s.mu.Unlock()
+			return n, nil
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		deadline := s.readDeadline
+		s.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-s.readable:
+		case <-s.closed:
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// closeWith закрывает сессию с ошибкой, которую получит Read.
+// notify - сообщить собеседнику, что нас больше нет.
+func (s *udpSession) closeWith(err error, notify bool) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		if notify {
+			s.send(packetClose, 0, nil)
+		}
+		s.err = err
+		s.mu.Unlock()
+		close(s.closed)
+		if s.onClose != nil {
+			s.onClose(s)
+		}
+	})
+}
+
+func (s *udpSession) Close() error {
+	s.closeWith(net.ErrClosed, true)
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	if s.remote != nil {
+		return s.remote
+	}
+	return s.conn.RemoteAddr()
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.mu.Unlock()
+	select {
+	case s.readable <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SetWriteDeadline ничего не делает: запись в UDP не блокируется
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// udpListener раздает датаграммы сессиям по адресу отправителя и
+// реализует net.Listener
+type udpListener struct {
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+	window   time.Time // Начало секунды, в которую считаются новые сессии
+	opened   int       // Сессий, открытых с начала window
+	accept   chan *udpSession
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func listenUDP(addr string) (*udpListener, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	l := &udpListener{
+		conn:     conn,
+		sessions: make(map[string]*udpSession),
+		accept:   make(chan *udpSession, 16),
+		closed:   make(chan struct{}),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+func (l *udpListener) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+			netLog.Error("Error reading UDP", "err", err)
+			continue
+		}
+		key := addr.String()
+		l.mu.Lock()
+		s, ok := l.sessions[key]
+		if !ok {
+			// Сессию открывает только первое надежное сообщение клиента,
+			// остальное от незнакомых адресов игнорируем
+			if n < udpHeaderSize || (buf[0] != packetReliable && buf[0] != packetFragment) || binary.BigEndian.Uint32(buf[1:]) != 1 {
+				l.mu.Unlock()
+				continue
+			}
+			if now := time.Now(); now.Sub(l.window) >= time.Second {
+				l.window, l.opened = now, 0
+			}
+			if l.opened >= MaxNewSessions {
+				l.mu.Unlock()
+				continue
+			}
+			// В accept пишет только этот цикл, поэтому свободное место
+			// проверяется до создания сессии: брошенная сессия закрылась бы
+			// по таймауту и закрыла бы собеседнику настоящую
+			if len(l.accept) == cap(l.accept) {
+				netLog.Warn("UDP accept queue full, dropping", "remote", key)
+				l.mu.Unlock()
+				continue
+			}
+			s = newUDPSession(l.conn, addr, l.forget)
+			l.sessions[key] = s
+			l.opened++
+			l.accept <- s
+		}
+		l.mu.Unlock()
+		s.handlePacket(buf[:n])
+	}
+}
+
+// forget убирает закрытую сессию, если адрес еще не занят новой
+func (l *udpListener) forget(s *udpSession) {
+	key := s.remote.String()
+	l.mu.Lock()
+	if l.sessions[key] == s {
+		delete(l.sessions, key)
+	}
+	l.mu.Unlock()
+}
+
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.accept:
+		return s, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *udpListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.conn.Close()
+	})
+	return nil
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Сообщения длиннее датаграммы доходят целиком и в том же порядке, что
+// и соседние
+func TestUDPOversizedMessages(t *testing.T) {
+	ln, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := dialUDP(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	big := bytes.Repeat([]byte("x"), 3*MaxDatagramSize+17)
+	if _, err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := conn.(*udpSession)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len("hello\n")+len(big))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte("hello\n"), big...); !bytes.Equal(got, want) {
+		t.Fatal("reliable message arrived damaged")
+	}
+
+	// Снимок из нескольких датаграмм собирается обратно, а слишком
+	// длинный уходит надежно
+	huge := bytes.Repeat([]byte("y"), MaxStateFragments*MaxDatagramSize+1)
+	for _, state := range [][]byte{big, huge} {
+		if err := server.WriteUnreliable(state); err != nil {
+			t.Fatalf("oversized state: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got = make([]byte, len(state))
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, state) {
+			t.Fatalf("state of %d bytes arrived damaged", len(state))
+		}
+	}
+}
+
+// startLossyRelay пересылает датаграммы между одним клиентом и сервером,
+// теряя и переставляя часть из них. Возвращает адрес для клиента.
+func startLossyRelay(t *testing.T, server string) string {
+	t.Helper()
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		rng := rand.New(rand.NewSource(1))
+		var client *net.UDPAddr
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			to := serverAddr
+			if from.String() == serverAddr.String() {
+				to = client
+			} else {
+				client = from
+			}
+			if to == nil {
+				continue
+			}
+			pkt := append([]byte(nil), buf[:n]...)
+			switch roll := rng.Float64(); {
+			case roll < 0.15:
+			case roll < 0.3:
+				time.AfterFunc(20*time.Millisecond, func() { conn.WriteToUDP(pkt, to) })
+			default:
+				conn.WriteToUDP(pkt, to)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// Через сеть с потерями и перестановками надежные сообщения из многих
+// датаграмм доходят целиком и по порядку, а снимки не вклиниваются в них
+func TestUDPLossyNetwork(t *testing.T) {
+	ln, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := dialUDP(startLossyRelay(t, ln.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := conn.(*udpSession)
+
+	const messages = 20
+	var reliable []string
+	states := make(map[string]bool)
+	for i := 0; i < messages; i++ {
+		msg := fmt.Sprintf("reliable %d %s\n", i, strings.Repeat("r", 4*MaxDatagramSize))
+		state := fmt.Sprintf("state %d %s\n", i, strings.Repeat("s", 2*MaxDatagramSize))
+		reliable = append(reliable, msg)
+		states[state] = true
+		if _, err := server.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.WriteUnreliable([]byte(state)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	r := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(10 * time.Second))
+	next, gotStates := 0, 0
+	for next < messages {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("after %d reliable messages: %v", next, err)
+		}
+		switch {
+		case line == reliable[next]:
+			next++
+		case states[line]:
+			gotStates++
+		default:
+			t.Fatalf("damaged line after %d reliable messages: %.40q", next, line)
+		}
+	}
+	if gotStates == 0 {
+		t.Error("no state arrived")
+	}
+}
+
+// Первые пакеты с незнакомых адресов открывают не больше MaxNewSessions
+// сессий в секунду, а настоящий клиент входит после переотправки
+func TestUDPNewSessionLimit(t *testing.T) {
+	ln, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 2*MaxNewSessions)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first := []byte{packetReliable, 0, 0, 0, 1, '\n'}
+	for i := 0; i < MaxNewSessions+8; i++ {
+		conn, err := net.Dial("udp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write(first); err != nil {
+			t.Fatal(err)
+		}
+		// Очередь Accept короче MaxNewSessions, даем ей разобраться
+		time.Sleep(2 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := len(accepted); n != MaxNewSessions {
+		t.Fatalf("%d sessions opened, want %d", n, MaxNewSessions)
+	}
+	for len(accepted) > 0 {
+		(<-accepted).Close()
+	}
+
+	client, err := dialUDP(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not let in after the limit window")
+	}
+}
+
+// Первый пакет при полной очереди Accept не оставляет сессии, которая
+// потом закрыла бы настоящую, а закрытая старая сессия не уносит из
+// таблицы новую с тем же адресом
+func TestUDPAcceptQueueFull(t *testing.T) {
+	ln, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	first := []byte{packetReliable, 0, 0, 0, 1, '\n'}
+	for i := 0; i <= cap(ln.accept); i++ {
+		conn, err := net.Dial("udp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write(first); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	ln.mu.Lock()
+	sessions := len(ln.sessions)
+	ln.mu.Unlock()
+	if sessions != cap(ln.accept) {
+		t.Fatalf("%d sessions for a queue of %d", sessions, cap(ln.accept))
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := conn.(*udpSession)
+	replaced := &udpSession{remote: old.remote}
+	ln.mu.Lock()
+	ln.sessions[old.remote.String()] = replaced
+	ln.mu.Unlock()
+	old.Close()
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if ln.sessions[old.remote.String()] != replaced {
+		t.Fatal("closed session removed its replacement")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Мелкие помощники для рисования интерфейса клиента
+
+var barBackground = color.RGBA{0, 0, 0, 160}
+
+// drawBar рисует полоску, заполненную на долю fill
+func drawBar(screen *ebiten.Image, x, y, width, height, fill float64, c color.RGBA) {
+	fill = clamp01(fill)
+	ebitenutil.DrawRect(screen, x, y, width, height, barBackground)
+	ebitenutil.DrawRect(screen, x, y, width*fill, height, c)
+}
+
+// drawFrame рисует рамку толщиной 1 вокруг прямоугольника
+func drawFrame(screen *ebiten.Image, x, y, width, height float64, c color.RGBA) {
+	vector.StrokeRect(screen, float32(x)-0.5, float32(y)-0.5, float32(width)+1, float32(height)+1, 1, c, false)
+}
+
+// lerp - значение между a и b, t от 0 до 1
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*clamp01(t)
+}
+
+// lerpColor смешивает цвета, t от 0 до 1
+func lerpColor(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(lerp(float64(x), float64(y), t)))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"meatgrinder/protocol"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Виды эффектов
+const (
+	VFXSlash        = "slash"
+	VFXProjectile   = "projectile"
+	VFXExplosion    = "explosion"
+	VFXDamageNumber = "damage_number"
+)
+
+const (
+	SlashDuration       = 200 * time.Millisecond
+	SlashArc            = math.Pi * 2 / 3 // Ширина дуги удара
+	ProjectileDuration  = 150 * time.Millisecond
+	ExplosionDuration   = 300 * time.Millisecond
+	DamageNumberTime    = 800 * time.Millisecond
+	DamageNumberRise    = 30.0 // На сколько пикселей всплывает число урона
+	ScreenFlashDuration = 300 * time.Millisecond
+)
+
+// vfx - короткоживущий эффект в мировых координатах
+type vfx struct {
+	Kind     string
+	From, To Point
+	Radius   float64
+	Amount   float64
+	Crit     bool
+	Color    color.RGBA
+	Started  time.Time
+	Duration time.Duration
+}
+
+func (e vfx) progress(now time.Time) float64 {
+	return math.Min(1, float64(now.Sub(e.Started))/float64(e.Duration))
+}
+
+func toPoint(p protocol.Point) Point {
+	return Point{X: p.X, Y: p.Y}
+}
+
+// addAttackVFX показывает атаку: у атак без области - дугу удара, у атак
+// с областью - снаряд и взрыв на месте цели. Настоящие снаряды рисуются
+// из состояния мира, для них эффекта нет. Вызывается в игровом цикле.
+func (g *Game) addAttackVFX(attack protocol.Attack, now time.Time) {
+	if attack.Projectile {
+		return
+	}
+	from, to := toPoint(attack.From), toPoint(attack.To)
+	if attack.SplashRadius <= 0 {
+		g.vfx = append(g.vfx, vfx{Kind: VFXSlash, From: from, To: to, Color: color.RGBA{255, 255, 255, 220}, Started: now, Duration: SlashDuration})
+		return
+	}
+	g.vfx = append(g.vfx,
+		vfx{Kind: VFXProjectile, From: from, To: to, Color: color.RGBA{255, 160, 40, 255}, Started: now, Duration: ProjectileDuration},
+		vfx{Kind: VFXExplosion, To: to, Radius: attack.SplashRadius, Color: color.RGBA{255, 120, 0, 160}, Started: now.Add(ProjectileDuration), Duration: ExplosionDuration},
+	)
+}
+
+// addImpactVFX показывает взрыв снаряда. Вызывается в игровом цикле.
+func (g *Game) addImpactVFX(impact protocol.Impact, now time.Time) {
+	radius := math.Max(impact.SplashRadius, ProjectileRadius*2)
+	g.vfx = append(g.vfx, vfx{Kind: VFXExplosion, To: toPoint(impact.Position), Radius: radius, Color: color.RGBA{255, 120, 0, 160}, Started: now, Duration: ExplosionDuration})
+}
+
+// addDamageVFX показывает число урона над целью и вспышку экрана, если
+// ранили нас. Вызывается в игровом цикле.
+func (g *Game) addDamageVFX(damage protocol.Damage, now time.Time) {
+	if damage.Amount <= 0 {
+		return
+	}
+	numberColor := color.RGBA{255, 255, 255, 255}
+	if damage.TargetID == g.playerID {
+		numberColor = color.RGBA{255, 80, 80, 255}
+		g.screenFlash = now
+	}
+	if damage.Crit && damage.TargetID != g.playerID {
+		numberColor = color.RGBA{255, 220, 0, 255}
+	}
+	g.vfx = append(g.vfx, vfx{Kind: VFXDamageNumber, To: toPoint(damage.Position), Amount: damage.Amount, Color: numberColor, Crit: damage.Crit, Started: now, Duration: DamageNumberTime})
+}
+
+// drawVFX рисует эффекты и убирает закончившиеся
+func (g *Game) drawVFX(screen *ebiten.Image, now time.Time) {
+	alive := g.vfx[:0]
+	for _, e := range g.vfx {
+		if now.Before(e.Started) {
+			alive = append(alive, e)
+			continue
+		}
+		if now.Sub(e.Started) >= e.Duration {
+			continue
+		}
+		alive = append(alive, e)
+
+		t := e.progress(now)
+		from, to := g.camera.toScreen(e.From), g.camera.toScreen(e.To)
+		switch e.Kind {
+		case VFXSlash:
+			// Дуга раскрывается от края к краю в сторону цели
+			facing := math.Atan2(to.Y-from.Y, to.X-from.X)
+			radius := PlayerRadius + 15.0
+			start := facing - SlashArc/2
+			const segments = 8
+			drawn := int(math.Ceil(t * segments))
+			for i := 0; i < drawn; i++ {
+				a0 := start + SlashArc*float64(i)/segments
+				a1 := start + SlashArc*float64(i+1)/segments
+				ebitenutil.DrawLine(screen,
+					from.X+math.Cos(a0)*radius, from.Y+math.Sin(a0)*radius,
+					from.X+math.Cos(a1)*radius, from.Y+math.Sin(a1)*radius, e.Color)
+			}
+		case VFXProjectile:
+			x := from.X + (to.X-from.X)*t
+			y := from.Y + (to.Y-from.Y)*t
+			ebitenutil.DrawCircle(screen, x, y, 5, e.Color)
+		case VFXExplosion:
+			c := e.Color
+			c.A = uint8(float64(c.A) * (1 - t))
+			ebitenutil.DrawCircle(screen, to.X, to.Y, e.Radius*(0.3+0.7*t), c)
+		case VFXDamageNumber:
+			text := fmt.Sprintf("-%.0f", math.Max(1, e.Amount))
+			if e.Crit {
+				text += "!"
+			}
+			drawText(screen, text, int(to.X)-textWidth(text)/2, int(to.Y-PlayerRadius-DamageNumberRise*t))
+		}
+	}
+	g.vfx = alive
+
+	// Красная вспышка по краям экрана, когда ранили нас
+	if since := now.Sub(g.screenFlash); since < ScreenFlashDuration {
+		alpha := uint8(120 * (1 - float64(since)/float64(ScreenFlashDuration)))
+		flash := color.RGBA{255, 0, 0, alpha}
+		const border = 24
+		ebitenutil.DrawRect(screen, 0, 0, ScreenWidth, border, flash)
+		ebitenutil.DrawRect(screen, 0, ScreenHeight-border, ScreenWidth, border, flash)
+		ebitenutil.DrawRect(screen, 0, border, border, ScreenHeight-2*border, flash)
+		ebitenutil.DrawRect(screen, ScreenWidth-border, border, border, ScreenHeight-2*border, flash)
+	}
+}
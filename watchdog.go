@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+
+	"meatgrinder/protocol"
+)
+
+// Сторож соединений. Клиент, который держит TCP-соединение открытым, но
+// ничего не присылает, иначе остался бы в мире навсегда. Сервер раз в
+// PingInterval шлет ping, живой клиент отвечает pong, а по нему сервер
+// заодно меряет задержку. Кто молчит дольше -afk-timeout, помечается AFK и
+// стоит на месте, а дольше -client-timeout - отключается.
+const (
+	PingInterval         = 2 * time.Second
+	DefaultAFKTimeout    = 15 * time.Second
+	DefaultClientTimeout = time.Minute
+
+	EventPlayerAFK = "player_afk"
+)
+
+// watchConnections рассылает ping и проверяет, давно ли слышно клиентов.
+// Считает по настоящему времени, а не по часам комнаты: на паузе
+// соединения тоже должны жить. Вызывается в горутине комнаты.
+func (r *Room) watchConnections(now time.Time) {
+	if now.Sub(r.lastPing) >= PingInterval {
+		r.lastPing = now
+		if msg, err := protocol.Marshal(protocol.MsgPing, protocol.Ping{Sent: now.UnixNano()}); err == nil {
+			for _, id := range sortedIDs(r.playerConnections) {
+				r.playerConnections[id].enqueue(msg)
+			}
+		}
+	}
+	for _, id := range sortedIDs(r.playerConnections) {
+		client := r.playerConnections[id]
+		silent := now.Sub(client.lastHeardAt())
+		if silent >= r.cfg.ClientTimeout {
+			r.log.Info("Player timed out", "player_id", id, "silent", silent)
+			// Игрока удалит serveClient, когда чтение оборвется
+			client.Close()
+			continue
+		}
+		if player, ok := r.worldState.Players[id]; ok {
+			r.setAFK(player, silent >= r.cfg.AFKTimeout)
+			player.Ping = int(client.latency().Milliseconds())
+		}
+	}
+}
+
+// setAFK помечает игрока отошедшим или вернувшимся. Отошедший
+// останавливается, чтобы не брести в стену до отключения.
+func (r *Room) setAFK(player *PlayerState, afk bool) {
+	if player.AFK == afk {
+		return
+	}
+	player.AFK = afk
+	if afk {
+		player.MovingDirection = Point{}
+		player.Destination = nil
+		player.Sprinting = false
+	}
+	r.logEvent(r.clock.Now(), EventPlayerAFK, map[string]interface{}{
+		"player_id": player.ID,
+		"afk":       afk,
+	})
+	r.log.Info("Player AFK", "player_id", player.ID, "afk", afk)
+}
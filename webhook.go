@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// События, о которых сервер сообщает на -webhook
+const (
+	WebhookMatchStart   = "match_start"
+	WebhookMatchEnd     = "match_end"
+	WebhookPlayerJoined = "player_joined"
+	WebhookFirstBlood   = "first_blood"
+
+	WebhookQueueSize = 64
+	WebhookTimeout   = 5 * time.Second
+)
+
+// WebhookPayload - тело POST-запроса. Content и Text повторяют друг друга,
+// чтобы ссылку можно было сразу отдать вебхуку Discord или Slack.
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	Room      string      `json:"room"`
+	Timestamp time.Time   `json:"timestamp"`
+	Content   string      `json:"content"`
+	Text      string      `json:"text"`
+	Data      interface{} `json:"data"`
+
+	// Имена игроков попадают в текст как есть: Discord не должен превращать их в упоминания
+	AllowedMentions map[string][]string `json:"allowed_mentions"`
+}
+
+// webhookNotifier отправляет уведомления в фоне, чтобы медленный адресат не
+// тормозил тики. Если очередь переполнена, уведомление теряется.
+type webhookNotifier struct {
+	urls   []string
+	queue  chan WebhookPayload
+	client *http.Client
+}
+
+// newWebhookNotifier возвращает nil, если адресов нет
+func newWebhookNotifier(urls []string) *webhookNotifier {
+	if len(urls) == 0 {
+		return nil
+	}
+	n := &webhookNotifier{
+		urls:   urls,
+		queue:  make(chan WebhookPayload, WebhookQueueSize),
+		client: &http.Client{Timeout: WebhookTimeout},
+	}
+	go n.run()
+	return n
+}
+
+func (n *webhookNotifier) notify(payload WebhookPayload) {
+	if n == nil {
+		return
+	}
+	payload.Text = payload.Content
+	payload.AllowedMentions = map[string][]string{"parse": {}}
+	select {
+	case n.queue <- payload:
+	default:
+		netLog.Warn("Webhook queue is full, dropping", "event", payload.Event)
+	}
+}
+
+func (n *webhookNotifier) run() {
+	for payload := range n.queue {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			netLog.Error("Error encoding webhook", "event", payload.Event, "err", err)
+			continue
+		}
+		for _, url := range n.urls {
+			if err := n.post(url, body); err != nil {
+				netLog.Warn("Error sending webhook", "event", payload.Event, "err", err)
+			}
+		}
+	}
+}
+
+func (n *webhookNotifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s replied %s", url, resp.Status)
+	}
+	return nil
+}
+
+// notify ставит уведомление комнаты в очередь. Вызывается в горутине комнаты.
+func (r *Room) notify(event, content string, data interface{}) {
+	r.webhooks.notify(WebhookPayload{
+		Event:     event,
+		Room:      r.name,
+		Timestamp: r.clock.Now(),
+		Content:   fmt.Sprintf("[%s] %s", r.name, content),
+		Data:      data,
+	})
+}
+
+// notifyMatchPhase сообщает о начале и конце матча. Вызывается в горутине комнаты.
+func (r *Room) notifyMatchPhase(phase string) {
+	switch phase {
+	case MatchActive:
+		r.notify(WebhookMatchStart, fmt.Sprintf("%s match started with %d players", r.mode.Name(), len(r.worldState.Players)),
+			map[string]interface{}{"mode": r.mode.Name(), "players": len(r.worldState.Players)})
+	case MatchEnded:
+		results := r.worldState.Match.Results
+		lines := make([]string, 0, len(results))
+		for i, result := range results {
+			name := fmt.Sprintf("Player %d", result.PlayerID)
+			if player, ok := r.worldState.Players[result.PlayerID]; ok {
+				name = player.Name
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s - %g points, %d kills, %d deaths", i+1, name, result.Score, result.Kills, result.Deaths))
+		}
+		r.notify(WebhookMatchEnd, "Match over\n"+strings.Join(lines, "\n"),
+			map[string]interface{}{"mode": r.mode.Name(), "results": results})
+	}
+}
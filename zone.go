@@ -0,0 +1,148 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Сужающаяся зона: вне круга игроки теряют здоровье. Круг стоит
+// ZoneHoldTime, затем за ZoneShrinkTime сжимается до следующего.
+const (
+	ZoneHoldTime        = 25.0 // Секунд до начала сжатия
+	ZoneShrinkTime      = 20.0 // Секунд на сжатие до следующего круга
+	ZoneDamagePerSecond = 2.0  // Урон вне зоны на первой стадии
+	ZoneDamageGrowth    = 2.0  // Прибавка урона за каждую стадию
+)
+
+// ZoneStages - радиусы следующих кругов в долях начального
+var ZoneStages = []float64{0.6, 0.35, 0.15, 0.05}
+
+// Zone - безопасная зона. Next* - куда она сожмется, у последней стадии
+// совпадает с текущей.
+type Zone struct {
+	Center          Point   `json:"center"`
+	Radius          float64 `json:"radius"`
+	NextCenter      Point   `json:"next_center"`
+	NextRadius      float64 `json:"next_radius"`
+	Stage           int     `json:"stage"`
+	Shrinking       bool    `json:"shrinking,omitempty"`
+	Remaining       float64 `json:"remaining"` // Секунд до начала или конца сжатия
+	DamagePerSecond float64 `json:"damage_per_second"`
+
+	initialRadius float64
+	fromCenter    Point // Откуда идет сжатие
+	fromRadius    float64
+}
+
+// newZone создает зону, накрывающую весь мир. Вызывается в горутине комнаты.
+func (r *Room) newZone() *Zone {
+	radius := math.Hypot(r.cfg.WorldWidth, r.cfg.WorldHeight) / 2
+	zone := &Zone{
+		Center:          Point{X: r.cfg.WorldWidth / 2, Y: r.cfg.WorldHeight / 2},
+		Radius:          radius,
+		Remaining:       ZoneHoldTime,
+		DamagePerSecond: ZoneDamagePerSecond,
+		initialRadius:   radius,
+	}
+	r.planNextZone(zone)
+	return zone
+}
+
+// planNextZone выбирает следующий круг целиком внутри текущего
+func (r *Room) planNextZone(zone *Zone) {
+	if zone.Stage >= len(ZoneStages) {
+		zone.NextCenter, zone.NextRadius = zone.Center, zone.Radius
+		return
+	}
+	zone.NextRadius = zone.initialRadius * ZoneStages[zone.Stage]
+	angle := r.rng.Float64() * 2 * math.Pi
+	offset := r.rng.Float64() * (zone.Radius - zone.NextRadius)
+	next := Point{X: zone.Center.X + math.Cos(angle)*offset, Y: zone.Center.Y + math.Sin(angle)*offset}
+	// Центр держим в пределах мира, иначе зона уедет туда, где нельзя стоять
+	next.X = math.Max(0, math.Min(r.cfg.WorldWidth, next.X))
+	next.Y = math.Max(0, math.Min(r.cfg.WorldHeight, next.Y))
+	zone.NextCenter = next
+}
+
+// updateZone двигает зону и наносит урон тем, кто вне ее. Вызывается в горутине комнаты.
+func (r *Room) updateZone(deltaTime float64) {
+	zone := r.worldState.Zone
+	if zone == nil {
+		return
+	}
+	if zone.Stage < len(ZoneStages) {
+		zone.Remaining -= deltaTime
+		if zone.Shrinking {
+			t := math.Min(1, 1-zone.Remaining/ZoneShrinkTime)
+			zone.Center.X = zone.fromCenter.X + (zone.NextCenter.X-zone.fromCenter.X)*t
+			zone.Center.Y = zone.fromCenter.Y + (zone.NextCenter.Y-zone.fromCenter.Y)*t
+			zone.Radius = zone.fromRadius + (zone.NextRadius-zone.fromRadius)*t
+		}
+		if zone.Remaining <= 0 {
+			if zone.Shrinking {
+				zone.Center, zone.Radius = zone.NextCenter, zone.NextRadius
+				zone.Shrinking = false
+				zone.Stage++
+				zone.DamagePerSecond = ZoneDamagePerSecond + ZoneDamageGrowth*float64(zone.Stage)
+				zone.Remaining = ZoneHoldTime
+				r.planNextZone(zone)
+			} else {
+				zone.Shrinking = true
+				zone.fromCenter, zone.fromRadius = zone.Center, zone.Radius
+				zone.Remaining = ZoneShrinkTime
+			}
+		}
+	}
+
+	// Зона бьет мимо щитов, убийство засчитывается последнему, кто ранил
+	for _, id := range sortedIDs(r.worldState.Players) {
+		player := r.worldState.Players[id]
+		if player.Eliminated || zone.contains(player.Position) {
+			continue
+		}
+		amount := math.Min(player.Health, zone.DamagePerSecond*deltaTime)
+		player.Health -= amount
+		recordDamage(nil, player, amount)
+	}
+}
+
+func (z *Zone) contains(p Point) bool {
+	return math.Hypot(p.X-z.Center.X, p.Y-z.Center.Y) <= z.Radius
+}
+
+// drawZone рисует границу зоны и следующий круг, а вне зоны краснит экран
+func (g *Game) drawZone(screen *ebiten.Image) {
+	zone := g.worldState.Zone
+	if zone == nil {
+		return
+	}
+	if zone.NextRadius < zone.Radius {
+		next := g.camera.toScreen(zone.NextCenter)
+		vector.StrokeCircle(screen, float32(next.X), float32(next.Y), float32(zone.NextRadius), 1, color.RGBA{255, 255, 255, 120}, true)
+	}
+	center := g.camera.toScreen(zone.Center)
+	vector.StrokeCircle(screen, float32(center.X), float32(center.Y), float32(zone.Radius), 3, color.RGBA{80, 140, 255, 220}, true)
+
+	if me, ok := g.worldState.Players[g.playerID]; ok && !zone.contains(g.playerPositions[me.ID]) {
+		ebitenutil.DrawRect(screen, 0, 0, ScreenWidth, ScreenHeight, color.RGBA{255, 0, 0, 40})
+		text := tr("OUTSIDE THE ZONE")
+		drawText(screen, text, ScreenWidth/2-textWidth(text)/2, ScreenHeight/2-100)
+	}
+}
+
+// drawZoneTimer показывает, когда зона начнет или закончит сжиматься
+func (g *Game) drawZoneTimer(screen *ebiten.Image, hud hudLayout) {
+	zone := g.worldState.Zone
+	if zone == nil || zone.Stage >= len(ZoneStages) {
+		return
+	}
+	text := trf("Zone shrinks in %ds", int(math.Ceil(zone.Remaining)))
+	if zone.Shrinking {
+		text = trf("Zone shrinking: %ds", int(math.Ceil(zone.Remaining)))
+	}
+	drawText(screen, text, hud.centerText(text), HUDMargin+3*HUDLine)
+}